@@ -0,0 +1,225 @@
+package diskv
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// accessFilename is the name of the file, directly in the BasePath, where
+// access statistics are persisted. It's never yielded as a key.
+const accessFilename = ".diskv-access"
+
+// defaultAccessFlushInterval is used if AccessFlushInterval is zero.
+const defaultAccessFlushInterval = time.Minute
+
+// AccessStat describes how often, and how recently, a key has been read.
+type AccessStat struct {
+	Count      uint64
+	LastAccess time.Time
+}
+
+// AccessStats returns the access statistics of the given key. If
+// TrackAccess isn't set, or the key hasn't been read, ok is false.
+func (d *Diskv) AccessStats(key string) (stat AccessStat, ok bool) {
+	key = d.normalizeKey(key)
+	if d.authorize(OpStat, key) != nil {
+		return AccessStat{}, false
+	}
+	d.accessMu.Lock()
+	defer d.accessMu.Unlock()
+	s, ok := d.access[key]
+	return s, ok
+}
+
+// FlushAccessStats persists the access statistics now, rather than waiting
+// for the next read after AccessFlushInterval has elapsed. Call it before
+// the process exits to lose no statistics.
+func (d *Diskv) FlushAccessStats() error {
+	if !d.TrackAccess {
+		return nil
+	}
+	d.accessMu.Lock()
+	defer d.accessMu.Unlock()
+	return d.saveAccessWithLock()
+}
+
+// WarmCache reads up to n of the most frequently read keys into the cache,
+// most frequent first, e.g. right after the process starts. It stops once
+// the cache is full, and returns the number of keys read. Warming doesn't
+// count as access.
+func (d *Diskv) WarmCache(n int) int {
+	if d.CacheSizeMax <= 0 {
+		return 0
+	}
+
+	d.accessMu.Lock()
+	keys := make([]string, 0, len(d.access))
+	for key := range d.access {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := d.access[keys[i]], d.access[keys[j]]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.LastAccess.After(b.LastAccess)
+	})
+	d.accessMu.Unlock()
+
+	var (
+		warmed int
+		size   uint64
+	)
+	for _, key := range keys {
+		if warmed >= n {
+			break
+		}
+		if d.expired(key) {
+			continue
+		}
+		rc, err := d.readStream(key, false, ReadOptions{Priority: Background}, nopSpan{})
+		if err != nil {
+			continue
+		}
+		val, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			continue
+		}
+		warmed++
+		if size += d.cacheEntrySize(key, val); size >= d.CacheSizeMax {
+			break
+		}
+	}
+	return warmed
+}
+
+// loadAccess reads persisted access statistics from disk. A missing or
+// corrupt file is treated as no statistics.
+func (d *Diskv) loadAccess() {
+	d.accessMu.Lock()
+	defer d.accessMu.Unlock()
+
+	d.access = map[string]AccessStat{}
+	d.accessFlushed = d.Clock.Now()
+	buf, err := readFile(d.fs, filepath.Join(d.BasePath, accessFilename))
+	if err != nil {
+		return
+	}
+	var persisted map[string][2]int64 // count, last access in ns
+	if err := json.Unmarshal(buf, &persisted); err != nil {
+		return
+	}
+	for key, p := range persisted {
+		d.access[key] = AccessStat{Count: uint64(p[0]), LastAccess: time.Unix(0, p[1])}
+	}
+}
+
+// saveAccessWithLock atomically persists the access statistics to disk.
+// Callers must hold accessMu.
+func (d *Diskv) saveAccessWithLock() error {
+	d.accessFlushed = d.Clock.Now()
+	if !d.accessDirty {
+		return nil
+	}
+
+	filename := filepath.Join(d.BasePath, accessFilename)
+	if len(d.access) <= 0 {
+		if err := d.fs.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		d.accessDirty = false
+		return nil
+	}
+
+	persisted := make(map[string][2]int64, len(d.access))
+	for key, s := range d.access {
+		persisted[key] = [2]int64{int64(s.Count), s.LastAccess.UnixNano()}
+	}
+	buf, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	if err := d.writeInternalFile(filename, buf); err != nil {
+		return err
+	}
+	d.accessDirty = false
+	return nil
+}
+
+// recordAccess counts a read of the given key, and persists the statistics
+// if they haven't been for AccessFlushInterval. Errors are ignored,
+// and retried at the next flush.
+func (d *Diskv) recordAccess(key string) {
+	if !d.TrackAccess {
+		return
+	}
+
+	d.accessMu.Lock()
+	defer d.accessMu.Unlock()
+
+	now := d.Clock.Now()
+	s := d.access[key]
+	s.Count++
+	s.LastAccess = now
+	d.access[key] = s
+	d.accessDirty = true
+
+	interval := d.AccessFlushInterval
+	if interval == 0 {
+		interval = defaultAccessFlushInterval
+	}
+	if now.Sub(d.accessFlushed) >= interval {
+		d.saveAccessWithLock() // errors ignored
+	}
+}
+
+// forgetAccess removes the statistics of the given key, e.g. when it's
+// erased, or of every key, if all is true.
+func (d *Diskv) forgetAccess(key string, all bool) {
+	if !d.TrackAccess {
+		return
+	}
+
+	d.accessMu.Lock()
+	defer d.accessMu.Unlock()
+
+	if all {
+		d.access = map[string]AccessStat{}
+		d.accessDirty = true
+		return
+	}
+	if _, ok := d.access[key]; ok {
+		delete(d.access, key)
+		d.accessDirty = true
+	}
+}
+
+// evictByAccessWithLock uncaches the least recently read values first,
+// except pinned ones, until done returns true. Callers must hold d.mu and
+// d.cacheMu.
+func (d *Diskv) evictByAccessWithLock(done func() bool) {
+	keys := make([]string, 0, len(d.cache))
+	last := make(map[string]time.Time, len(d.cache))
+	d.accessMu.Lock()
+	for key := range d.cache {
+		keys = append(keys, key)
+		last[key] = d.access[key].LastAccess
+	}
+	d.accessMu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool { return last[keys[i]].Before(last[keys[j]]) })
+	for _, key := range keys {
+		if done() {
+			return
+		}
+		if d.pinned(key) {
+			continue
+		}
+		d.uncacheWithCacheLock(key)
+	}
+}
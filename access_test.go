@@ -0,0 +1,117 @@
+package diskv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessStats(t *testing.T) {
+	d := New(Options{
+		BasePath:    "test-data",
+		TrackAccess: true,
+	})
+	defer d.EraseAll()
+
+	d.WriteString("a", "1")
+	d.WriteString("b", "2")
+	if _, ok := d.AccessStats("a"); ok {
+		t.Fatal("stats for unread key")
+	}
+	for i := 0; i < 3; i++ {
+		d.Read("a")
+	}
+	rc, err := d.ReadStream("b", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	d.Read("missing")
+
+	if s, ok := d.AccessStats("a"); !ok || s.Count != 3 || time.Since(s.LastAccess) > time.Minute {
+		t.Fatalf("bad stats for a: %+v", s)
+	}
+	if s, _ := d.AccessStats("b"); s.Count != 1 {
+		t.Fatalf("bad stats for b: %+v", s)
+	}
+	if _, ok := d.AccessStats("missing"); ok {
+		t.Fatal("stats for failed read")
+	}
+
+	// Statistics survive a restart, but not an erase, and aren't keys.
+	if err := d.FlushAccessStats(); err != nil {
+		t.Fatal(err)
+	}
+	d.Erase("b")
+	d2 := New(Options{
+		BasePath:    "test-data",
+		TrackAccess: true,
+	})
+	if s, _ := d2.AccessStats("a"); s.Count != 3 {
+		t.Fatalf("bad persisted stats for a: %+v", s)
+	}
+	checkKeys(t, d2.Keys(nil), map[string]string{"a": "1"})
+	d.FlushAccessStats()
+	d3 := New(Options{
+		BasePath:    "test-data",
+		TrackAccess: true,
+	})
+	if _, ok := d3.AccessStats("b"); ok {
+		t.Fatal("stats of erased key persisted")
+	}
+}
+
+func TestAccessEviction(t *testing.T) {
+	d := New(Options{
+		BasePath:         "test-data",
+		CacheSizeMax:     3,
+		TrackAccess:      true,
+		SynchronousCache: true,
+	})
+	defer d.EraseAll()
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		d.WriteString(key, "1")
+	}
+	d.Read("a")
+	d.Read("b")
+	d.Read("c")
+	d.Read("a") // b is now the least recently read
+	d.Read("d")
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if have := d.isCached(key); have != want {
+			t.Errorf("%s: want cached %v, have %v", key, want, have)
+		}
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	d := New(Options{
+		BasePath:    "test-data",
+		TrackAccess: true,
+	})
+	defer d.EraseAll()
+
+	for _, key := range []string{"a", "b", "c"} {
+		d.WriteString(key, "1")
+	}
+	d.Read("a")
+	d.Read("c")
+	d.Read("c")
+	d.FlushAccessStats()
+
+	d2 := New(Options{
+		BasePath:     "test-data",
+		CacheSizeMax: 1,
+		TrackAccess:  true,
+	})
+	if n := d2.WarmCache(10); n != 1 {
+		t.Fatalf("expected 1 key warmed, got %d", n)
+	}
+	if !d2.isCached("c") || d2.isCached("a") {
+		t.Fatal("expected only the most frequently read key to be cached")
+	}
+	if s, _ := d2.AccessStats("c"); s.Count != 2 {
+		t.Fatalf("warming counted as access: %+v", s)
+	}
+}
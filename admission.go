@@ -0,0 +1,89 @@
+package diskv
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	errNotAdmitted     = errors.New("not admitted by cache admission policy")
+	errTooLargeToCache = errors.New("larger than CacheMaxValueSize")
+)
+
+// AdmissionPolicy is an interface that Diskv uses to decide whether a value
+// read from disk should be admitted to the cache. Admit is called with the
+// key and size of every value that's a candidate for caching, and may keep
+// its own state. You may define it on your own type, or use one of the
+// NewAdmission helpers.
+type AdmissionPolicy interface {
+	Admit(key string, size uint64) bool
+}
+
+// AdmissionFunc is an adapter to allow the use of ordinary functions as an
+// AdmissionPolicy.
+type AdmissionFunc func(key string, size uint64) bool
+
+// Admit calls f(key, size).
+func (f AdmissionFunc) Admit(key string, size uint64) bool { return f(key, size) }
+
+// NewSizeAdmission returns an AdmissionPolicy which only admits values of
+// at most max bytes, so that a single huge value can't evict many small ones.
+func NewSizeAdmission(max uint64) AdmissionPolicy {
+	return AdmissionFunc(func(key string, size uint64) bool { return size <= max })
+}
+
+// NewFrequencyAdmission returns an AdmissionPolicy which only admits a key
+// once it's been offered for caching at least threshold times. Counts are
+// halved every window offers, so that keys which were only popular in the
+// past age out.
+func NewFrequencyAdmission(threshold, window int) AdmissionPolicy {
+	if window <= 0 {
+		window = 10000
+	}
+	return &frequencyAdmission{
+		threshold: threshold,
+		window:    window,
+		counts:    map[string]int{},
+	}
+}
+
+type frequencyAdmission struct {
+	mu        sync.Mutex
+	threshold int
+	window    int
+	offers    int
+	counts    map[string]int
+}
+
+func (f *frequencyAdmission) Admit(key string, size uint64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.offers++
+	if f.offers >= f.window {
+		f.offers = 0
+		for k, n := range f.counts {
+			if n/2 == 0 {
+				delete(f.counts, k)
+			} else {
+				f.counts[k] = n / 2
+			}
+		}
+	}
+
+	f.counts[key]++
+	return f.counts[key] >= f.threshold
+}
+
+// NewAllAdmission returns an AdmissionPolicy which admits a value only if
+// every one of the given policies admits it.
+func NewAllAdmission(policies ...AdmissionPolicy) AdmissionPolicy {
+	return AdmissionFunc(func(key string, size uint64) bool {
+		for _, p := range policies {
+			if !p.Admit(key, size) {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,93 @@
+package diskv
+
+import (
+	"testing"
+)
+
+func TestSizeAdmission(t *testing.T) {
+	d := New(Options{
+		BasePath:       "test-data",
+		CacheSizeMax:   1024,
+		CacheAdmission: NewSizeAdmission(4),
+	})
+	defer d.EraseAll()
+
+	d.Write("small", []byte("1234"))
+	d.Write("large", []byte("12345"))
+	d.Read("small")
+	d.Read("large")
+
+	if !d.isCached("small") {
+		t.Errorf("small value not cached")
+	}
+	if d.isCached("large") {
+		t.Errorf("large value cached")
+	}
+}
+
+func TestFrequencyAdmission(t *testing.T) {
+	d := New(Options{
+		BasePath:       "test-data",
+		CacheSizeMax:   1024,
+		CacheAdmission: NewFrequencyAdmission(2, 0),
+	})
+	defer d.EraseAll()
+
+	d.Write("a", []byte("1"))
+	d.Read("a")
+	if d.isCached("a") {
+		t.Fatalf("cached after one read")
+	}
+	d.Read("a")
+	if !d.isCached("a") {
+		t.Fatalf("not cached after two reads")
+	}
+}
+
+func TestFrequencyAdmissionAging(t *testing.T) {
+	p := NewFrequencyAdmission(2, 4)
+	p.Admit("a", 1) // a=1
+	p.Admit("b", 1)
+	p.Admit("b", 1)
+	p.Admit("b", 1) // window reached: a=0, b=1
+	if p.Admit("a", 1) {
+		t.Fatalf("aged-out key admitted")
+	}
+	if !p.Admit("b", 1) {
+		t.Fatalf("frequent key not admitted")
+	}
+}
+
+func TestCacheMaxValueSize(t *testing.T) {
+	var cacheErrs []error
+	d := New(Options{
+		BasePath:          "test-data",
+		CacheSizeMax:      1024,
+		CacheMaxValueSize: 4,
+		CacheErrorHandler: func(key string, err error) { cacheErrs = append(cacheErrs, err) },
+		SynchronousCache:  true,
+	})
+	defer d.EraseAll()
+
+	d.Write("small", []byte("1234"))
+	d.Write("large", []byte("12345"))
+	for _, key := range []string{"small", "large"} {
+		if _, err := d.Read(key); err != nil {
+			t.Fatalf("Read(%s): %s", key, err)
+		}
+	}
+
+	if !d.isCached("small") {
+		t.Errorf("small value not cached")
+	}
+	if d.isCached("large") {
+		t.Errorf("large value cached")
+	}
+	if len(cacheErrs) != 0 {
+		t.Errorf("large value offered to the cache: %v", cacheErrs)
+	}
+
+	if _, err := NewWithOptions("test-data", WithCacheMaxValueSize(4)); err == nil {
+		t.Errorf("CacheMaxValueSize without CacheSizeMax: expected validation error")
+	}
+}
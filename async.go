@@ -0,0 +1,176 @@
+package diskv
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+)
+
+// defaultAsyncWriteDelay is used if AsyncWriteDelay is zero.
+const defaultAsyncWriteDelay = 10 * time.Millisecond
+
+// pendingWrite is a value written with AsyncWrites, or through the write
+// log, which isn't in its file yet. Its value is never modified, so it may
+// be shared by readers.
+type pendingWrite struct {
+	val    []byte
+	logged bool // in the write log, so it's synced when it's flushed
+}
+
+// writeAsync checks the write, and buffers a copy of the value until the
+// next flush, which it schedules if necessary.
+func (d *Diskv) writeAsync(key string, val []byte) (err error) {
+	key = d.normalizeKey(key)
+	span := d.startSpan("Write", key)
+	defer func() {
+		span.SetAttribute("bytes", int64(len(val)))
+		span.SetAttribute("async", true)
+		span.End(err)
+	}()
+
+	if _, err := d.checkWriteKey(key); err != nil {
+		return err
+	}
+	if d.MaxValueSize > 0 && int64(len(val)) > d.MaxValueSize {
+		return ErrValueTooLarge
+	}
+
+	d.addPending(key, &pendingWrite{val: append([]byte(nil), val...)})
+	return nil
+}
+
+// addPending buffers the write until the next flush, which it schedules if
+// necessary.
+func (d *Diskv) addPending(key string, p *pendingWrite) {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+	if d.pending == nil {
+		d.pending = map[string]*pendingWrite{}
+	}
+	d.pending[key] = p
+	atomic.AddUint64(&d.gen, 1)
+	if d.pendingTimer == nil {
+		delay := d.AsyncWriteDelay
+		if delay <= 0 {
+			delay = defaultAsyncWriteDelay
+		}
+		d.pendingTimer = d.Clock.AfterFunc(delay, labeled("async-flush", func() {
+			d.pendingMu.Lock()
+			d.pendingTimer = nil
+			d.pendingMu.Unlock()
+			d.Flush() // errors are reported to AsyncWriteErrorHandler
+		}))
+	}
+}
+
+// Flush writes every value buffered by AsyncWrites, or in the write log, to
+// its file, and returns the first error, if any. Values which fail to be
+// written are dropped. Once every value in the write log is in its file,
+// the log is emptied. Flush is a no-op without AsyncWrites or
+// WriteLogThreshold.
+func (d *Diskv) Flush() error {
+	d.pendingMu.Lock()
+	keys := make([]string, 0, len(d.pending))
+	for key := range d.pending {
+		keys = append(keys, key)
+	}
+	d.pendingMu.Unlock()
+
+	var first error
+	for _, key := range keys {
+		if err := d.flushKey(key); err != nil {
+			if d.AsyncWriteErrorHandler != nil {
+				d.AsyncWriteErrorHandler(key, err)
+			}
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	if err := d.truncateWriteLog(); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
+
+// flushKey writes the key's pending value, if it still has one. The value
+// stays pending, and so visible to reads, until it's on disk, unless it's
+// been replaced in the meantime.
+func (d *Diskv) flushKey(key string) error {
+	d.pendingMu.Lock()
+	p, ok := d.pending[key]
+	d.pendingMu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	d.writeThrottle.waitOp(Background)
+	defer d.writeThrottle.waitBytes(int64(len(p.val)), Background)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pendingMu.Lock()
+	p, ok = d.pending[key] // may have changed while throttled
+	d.pendingMu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	err := d.writeStreamWithLock(d.transform(key), bytes.NewReader(p.val), WriteOptions{Sync: p.logged})
+	if err == nil {
+		err = d.setExpiry(key, 0)
+	}
+
+	d.pendingMu.Lock()
+	dropped := d.pending[key] == p
+	if dropped {
+		delete(d.pending, key)
+	}
+	d.pendingMu.Unlock()
+	if err != nil && dropped {
+		d.dropReservedWithLock(key)
+	}
+	return err
+}
+
+// pendingValue returns the key's buffered value, if it has one. The returned
+// slice must not be modified.
+func (d *Diskv) pendingValue(key string) ([]byte, bool) {
+	if !d.AsyncWrites && d.WriteLogThreshold <= 0 {
+		return nil, false
+	}
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+	p, ok := d.pending[key]
+	if !ok {
+		return nil, false
+	}
+	return p.val, true
+}
+
+// cancelPendingWithLock drops the key's buffered value, after it's been
+// superseded by a synchronous write or erased, along with any record of it
+// in the write log, and reports whether it had one. Callers must hold d.mu,
+// so that the value isn't being flushed.
+func (d *Diskv) cancelPendingWithLock(key string) bool {
+	if !d.AsyncWrites && d.WriteLogThreshold <= 0 {
+		return false
+	}
+	d.pendingMu.Lock()
+	_, ok := d.pending[key]
+	delete(d.pending, key)
+	d.pendingMu.Unlock()
+	d.supersedeLoggedWithLock(key)
+	d.dropReservedWithLock(key)
+	return ok
+}
+
+// discardPending drops every buffered value, when the store is cleared.
+// The write log is removed along with everything else.
+func (d *Diskv) discardPending() {
+	d.pendingMu.Lock()
+	d.pending = nil
+	d.pendingMu.Unlock()
+	d.forgetWriteLog()
+}
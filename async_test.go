@@ -0,0 +1,208 @@
+package diskv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAsyncReadYourWrites(t *testing.T) {
+	d, err := NewWithOptions("test-data", WithAsyncWrites(time.Hour, nil), WithCacheSizeMax(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	for i := 0; i < 3; i++ {
+		if err := d.Write("a", []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(d.completeFilename(d.transform("a"))); !os.IsNotExist(err) {
+		t.Fatalf("expected a pending write, not a file: %v", err)
+	}
+
+	if val, err := d.Read("a"); err != nil || string(val) != "v2" {
+		t.Fatalf("Read: want v2, have %q (%v)", val, err)
+	}
+	rc, err := d.ReadStream("a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(val) != "v2" {
+		t.Fatalf("ReadStream: want v2, have %q", val)
+	}
+	if !d.Has("a") {
+		t.Fatal("Has: want true")
+	}
+
+	// A synchronous write supersedes the pending one.
+	if err := d.WriteStream("a", bytes.NewReader([]byte("sync")), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("b", []byte("async")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"a": "sync", "b": "async"} {
+		buf, err := ioutil.ReadFile(d.completeFilename(d.transform(key)))
+		if err != nil || string(buf) != want {
+			t.Fatalf("%s on disk: want %q, have %q (%v)", key, want, buf, err)
+		}
+	}
+
+	if err := d.Write("", []byte("x")); err != ErrEmptyKey {
+		t.Fatalf("want ErrEmptyKey, have %v", err)
+	}
+}
+
+func TestAsyncInterleavings(t *testing.T) {
+	d, err := NewWithOptions("test-data", WithAsyncWrites(time.Millisecond, nil), WithCacheSizeMax(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	// Each writer reads back its latest write, while earlier ones are
+	// flushed, and cached, concurrently.
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", w)
+			for i := 0; i < 200; i++ {
+				want := fmt.Sprintf("%d-%d", w, i)
+				if err := d.Write(key, []byte(want)); err != nil {
+					errs <- err
+					return
+				}
+				if i%10 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+				if have, err := d.Read(key); err != nil || string(have) != want {
+					errs <- fmt.Errorf("%s: want %q, have %q (%v)", key, want, have, err)
+					return
+				}
+				if i%7 == 0 {
+					d.Flush()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for w := 0; w < 4; w++ {
+		key := fmt.Sprintf("key%d", w)
+		d.mu.Lock()
+		d.bustCacheWithLock(key)
+		d.mu.Unlock()
+		if have, err := d.Read(key); err != nil || string(have) != fmt.Sprintf("%d-199", w) {
+			t.Errorf("%s after flush: have %q (%v)", key, have, err)
+		}
+	}
+}
+
+func TestAsyncWriteErrors(t *testing.T) {
+	errFail := errors.New("fail")
+	var reported []string
+	d, err := NewWithOptions("test-data",
+		WithAsyncWrites(time.Hour, func(key string, err error) { reported = append(reported, key) }),
+		WithOnFileCreated(func(string) error { return errFail }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	if err := d.Write("a", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Flush(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(reported) != 1 || reported[0] != "a" {
+		t.Fatalf("expected a to be reported, have %v", reported)
+	}
+	if _, err := d.Read("a"); !os.IsNotExist(err) {
+		t.Fatalf("expected the failed write to be dropped, have %v", err)
+	}
+}
+
+func TestAsyncErase(t *testing.T) {
+	d := NewMem(Options{BasePath: "/async-erase", AsyncWrites: true, AsyncWriteDelay: time.Hour})
+
+	// Erase after write: the buffered value is dropped, and Erase succeeds
+	// even though it was never written.
+	if err := d.Write("a", []byte("buffered")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Erase("a"); err != nil {
+		t.Fatalf("Erase of a buffered value: %s", err)
+	}
+	if d.Has("a") {
+		t.Error("Has: erased value still buffered")
+	}
+
+	// Erase after write, over a value already on disk: both are gone.
+	if err := d.WriteStream("b", bytes.NewReader([]byte("on disk")), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("b", []byte("buffered")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Erase("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Read("b"); !os.IsNotExist(err) {
+		t.Errorf("Read after Erase: want not exist, have %v", err)
+	}
+
+	// Write after erase: the new value is buffered, and written.
+	if err := d.WriteStream("c", bytes.NewReader([]byte("on disk")), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Erase("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("c", []byte("buffered")); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := d.Read("c"); err != nil || string(val) != "buffered" {
+		t.Errorf("Read: want buffered, have %q (%v)", val, err)
+	}
+
+	// Erasing a key which has neither is still an error.
+	if err := d.Erase("d"); !os.IsNotExist(err) {
+		t.Errorf("Erase of a missing key: want not exist, have %v", err)
+	}
+
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"a": "", "b": "", "c": "buffered"} {
+		buf, err := readFile(d.fs, d.completeFilename(d.transform(key)))
+		if want == "" && !os.IsNotExist(err) {
+			t.Errorf("%s on disk after Flush: want nothing, have %q (%v)", key, buf, err)
+		} else if want != "" && string(buf) != want {
+			t.Errorf("%s on disk after Flush: want %q, have %q (%v)", key, want, buf, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package diskv
+
+// Operation is the kind of operation which Options.Authorize is asked to
+// allow.
+type Operation string
+
+const (
+	// OpRead reads a value: Read, ReadWith, ReadStream, ReadStreamWith,
+	// ReadStreamParallel, Open and Prove, with the key; and Scan, with the
+	// prefix.
+	OpRead Operation = "read"
+
+	// OpWrite writes a value, or its metadata: Write, WriteStream,
+	// WriteStreamN, WriteWith, WriteWithN, Import, Expire, Pin, Unpin,
+	// ResumeWriteStream, WriteChunk and CommitChunks.
+	OpWrite Operation = "write"
+
+	// OpErase erases a key: Erase, EraseQuiet and EraseMulti, with the key;
+	// ErasePrefix and PruneTimestamped, with the prefix; and EraseAll, Clear
+	// and their bulk variants, with the empty key.
+	OpErase Operation = "erase"
+
+	// OpStat asks about a key without reading its value: Has, ExistsMulti,
+	// Stat, Pinned, AccessStats and ResumeOffset, with the key; and StatPrefix, with
+	// the prefix.
+	OpStat Operation = "stat"
+
+	// OpList lists keys: Keys and Len, with the empty key; KeysPrefix, with
+	// the prefix; KeysMatching and KeysMatchingRegexp, with the pattern's
+	// literal prefix; and KeysBetween, with the prefix its range is limited
+	// to.
+	OpList Operation = "list"
+)
+
+// authorize asks Authorize, if it's set, whether the operation on the key
+// is allowed.
+func (d *Diskv) authorize(op Operation, key string) error {
+	if d.Authorize == nil {
+		return nil
+	}
+	return d.Authorize(op, key)
+}
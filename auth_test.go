@@ -0,0 +1,58 @@
+package diskv
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	errDenied := errors.New("denied")
+	var calls []string
+	d, err := NewWithOptions("test-data", WithAuthorize(func(op Operation, key string) error {
+		calls = append(calls, string(op)+" "+key)
+		if !strings.HasPrefix(key, "tenant1-") {
+			return errDenied
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		d.Authorize = nil
+		d.EraseAll()
+	}()
+
+	if err := d.WriteString("tenant1-a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteString("tenant2-a", "2"); err != errDenied {
+		t.Fatalf("write: want errDenied, have %v", err)
+	}
+	if have := d.ReadString("tenant1-a"); have != "1" {
+		t.Fatalf("want 1, have %q", have)
+	}
+	if _, err := d.Read("tenant2-a"); err != errDenied {
+		t.Fatalf("read: want errDenied, have %v", err)
+	}
+	if d.Has("tenant2-a") {
+		t.Fatal("Has: denied key reported")
+	}
+	checkKeys(t, d.KeysPrefix("tenant1-", nil), map[string]string{"tenant1-a": "1"})
+	checkKeys(t, d.Keys(nil), map[string]string{})
+	if err := d.Erase("tenant2-a"); err != errDenied {
+		t.Fatalf("erase: want errDenied, have %v", err)
+	}
+	if err := d.EraseAll(); err != errDenied {
+		t.Fatalf("EraseAll: want errDenied, have %v", err)
+	}
+	if _, err := d.Stats(); err != nil {
+		t.Fatalf("Stats isn't authorized, but failed: %v", err)
+	}
+
+	want := "write tenant1-a,write tenant2-a,read tenant1-a,read tenant2-a,stat tenant2-a,list tenant1-,list ,erase tenant2-a,erase "
+	if have := strings.Join(calls, ","); have != want {
+		t.Fatalf("want calls %s, have %s", want, have)
+	}
+}
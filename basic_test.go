@@ -3,9 +3,15 @@ package diskv
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -23,8 +29,8 @@ func cmpBytes(a, b []byte) bool {
 }
 
 func (d *Diskv) isCached(key string) bool {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+	d.cacheMu.RLock()
+	defer d.cacheMu.RUnlock()
 	_, ok := d.cache[key]
 	return ok
 }
@@ -138,6 +144,22 @@ func TestZeroByteCache(t *testing.T) {
 	if d.isCached(k) {
 		t.Fatalf("key cached, expected not-cached")
 	}
+
+	rc, err := d.ReadStream(k, true)
+	if err != nil {
+		t.Fatalf("ReadStream: %s", err)
+	}
+	rc.Close()
+	if !d.Has(k) {
+		t.Fatalf("Has: want true")
+	}
+	s, err := d.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %s", err)
+	}
+	if s.CacheKeys != 0 || s.CacheHits != 0 || s.CacheMisses != 2 {
+		t.Fatalf("Stats: %+v", s)
+	}
 }
 
 func TestOneByteCache(t *testing.T) {
@@ -428,3 +450,545 @@ func TestHybridStore(t *testing.T) {
 	}
 
 }
+
+func TestCacheErrorHandler(t *testing.T) {
+	var gotKey string
+	var gotErr error
+	d := New(Options{
+		BasePath:     "test-data",
+		CacheSizeMax: 1,
+		CacheErrorHandler: func(key string, err error) {
+			gotKey, gotErr = key, err
+		},
+	})
+	defer d.EraseAll()
+
+	if err := d.Write("a", []byte("too big")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Read("a"); err != nil {
+		t.Fatal(err)
+	}
+	if gotKey != "a" || gotErr == nil {
+		t.Fatalf("expected cache error for 'a', got %q: %v", gotKey, gotErr)
+	}
+}
+
+func TestReadWithOptions(t *testing.T) {
+	d := New(Options{
+		BasePath:     "test-data",
+		CacheSizeMax: 1024,
+	})
+	defer d.EraseAll()
+
+	k, v := "a", []byte("123")
+	if err := d.Write(k, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := d.ReadWith(k, ReadOptions{NoFill: true}); err != nil {
+		t.Fatal(err)
+	} else if !cmpBytes(got, v) {
+		t.Fatalf("expected %s, got %s", v, got)
+	}
+	if d.isCached(k) {
+		t.Fatalf("key cached after NoFill read")
+	}
+
+	if _, err := d.Read(k); err != nil {
+		t.Fatal(err)
+	}
+	if !d.isCached(k) {
+		t.Fatalf("key not cached after Read")
+	}
+
+	// Change the file behind the store's back; SkipCache should see it.
+	if err := ioutil.WriteFile(filepath.Join(d.BasePath, k), []byte("456"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := d.Read(k); string(got) != "123" {
+		t.Fatalf("expected cached value, got %s", got)
+	}
+	if got, _ := d.ReadWith(k, ReadOptions{SkipCache: true}); string(got) != "456" {
+		t.Fatalf("expected fresh value, got %s", got)
+	}
+}
+
+func TestCacheEntryOverhead(t *testing.T) {
+	d := New(Options{
+		BasePath:           "test-data",
+		CacheSizeMax:       10,
+		CacheEntryOverhead: 4,
+		CacheCountKeys:     true,
+	})
+	defer d.EraseAll()
+
+	d.Write("a", []byte("12345")) // 1 + 5 + 4 = 10 bytes
+	d.Write("b", []byte("123"))   // 1 + 3 + 4 = 8 bytes
+	d.Read("a")
+	if !d.isCached("a") {
+		t.Fatalf("'a' not cached")
+	}
+	d.Read("b")
+	if d.isCached("a") || !d.isCached("b") {
+		t.Fatalf("expected 'a' evicted in favor of 'b'")
+	}
+	if d.cacheSize != 8 {
+		t.Fatalf("expected cache size 8, got %d", d.cacheSize)
+	}
+}
+
+func TestReadReturnsCopy(t *testing.T) {
+	d := New(Options{
+		BasePath:     "test-data",
+		CacheSizeMax: 1024,
+	})
+	defer d.EraseAll()
+
+	d.Write("a", []byte("123"))
+	d.Read("a") // cache it
+	v, err := d.Read("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v[0] = 'x'
+	if v, _ := d.Read("a"); string(v) != "123" {
+		t.Fatalf("cache corrupted by caller: got %s", v)
+	}
+}
+
+func TestZeroCopyReads(t *testing.T) {
+	d := New(Options{
+		BasePath:      "test-data",
+		CacheSizeMax:  1024,
+		ZeroCopyReads: true,
+	})
+	defer d.EraseAll()
+
+	d.Write("a", []byte("123"))
+	d.Read("a") // cache it
+	v1, _ := d.Read("a")
+	v2, _ := d.Read("a")
+	if &v1[0] != &v2[0] {
+		t.Fatalf("expected zero-copy reads to share the cached slice")
+	}
+}
+
+func TestCacheHitsWithoutLock(t *testing.T) {
+	d := New(Options{
+		BasePath:         "test-data",
+		CacheSizeMax:     1024,
+		SynchronousCache: true,
+	})
+	defer d.EraseAll()
+
+	d.Write("a", []byte("123"))
+	d.Read("a") // cache it
+
+	// Hold the store's lock, as a slow write would.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	done := make(chan string)
+	go func() {
+		v, _ := d.Read("a")
+		done <- string(v)
+	}()
+	select {
+	case v := <-done:
+		if v != "123" {
+			t.Fatalf("want 123, have %q", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cache hit waited for the store's lock")
+	}
+}
+
+func TestEraseQuiet(t *testing.T) {
+	d := New(Options{
+		BasePath:     "test-data",
+		CacheSizeMax: 1024,
+	})
+	defer d.EraseAll()
+
+	if err := d.Erase("missing"); !os.IsNotExist(err) {
+		t.Fatalf("Erase: expected not-exist error, got %v", err)
+	}
+	if err := d.EraseQuiet("missing"); err != nil {
+		t.Fatalf("EraseQuiet: %s", err)
+	}
+
+	// Remove the file behind the store's back; the cache must still be
+	// cleaned up.
+	d.Write("a", []byte("1"))
+	d.Read("a")
+	if err := os.Remove(filepath.Join(d.BasePath, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.EraseQuiet("a"); err != nil {
+		t.Fatalf("EraseQuiet: %s", err)
+	}
+	if d.isCached("a") {
+		t.Fatalf("'a' still cached after EraseQuiet")
+	}
+}
+
+// pruningFS removes the directory of every file it removes, as another
+// process sharing the store's BasePath might.
+type pruningFS struct {
+	FileSystem
+}
+
+func (fs pruningFS) Remove(name string) error {
+	if err := fs.FileSystem.Remove(name); err != nil {
+		return err
+	}
+	fs.FileSystem.Remove(filepath.Dir(name)) // error deliberately ignored
+	return nil
+}
+
+func TestPruneDirs(t *testing.T) {
+	transform := func(s string) []string { return []string{s[:1], s[1:2]} }
+	d := New(Options{BasePath: "test-data", Transform: transform})
+	defer d.EraseAll()
+
+	var keys []string
+	for _, prefix := range []string{"ab", "ac", "bb"} {
+		for i := 0; i < 20; i++ {
+			keys = append(keys, fmt.Sprintf("%s%d", prefix, i))
+		}
+	}
+	for _, k := range keys {
+		if err := d.WriteString(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Siblings erased in parallel race to prune their directories.
+	errs := make(chan error, len(keys))
+	var wg sync.WaitGroup
+	for _, k := range keys {
+		wg.Add(1)
+		go func(k string) {
+			defer wg.Done()
+			errs <- d.Erase(k)
+		}(k)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names, err := readDirNames(d.fs, d.BasePath); err != nil || len(names) > 0 {
+		t.Fatalf("directories left after erasing every key: %v (%v)", names, err)
+	}
+
+	// A directory which is already gone doesn't stop its parents from
+	// being pruned.
+	d.fs = pruningFS{d.fs}
+	if err := d.WriteString("xy", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Erase("xy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(d.BasePath, "x")); !os.IsNotExist(err) {
+		t.Fatalf("parent of a vanished directory not pruned: %v", err)
+	}
+}
+
+func TestClear(t *testing.T) {
+	d := New(Options{
+		BasePath:     "test-data",
+		Transform:    func(s string) []string { return []string{s[:1]} },
+		CacheSizeMax: 1024,
+	})
+	defer d.EraseAll()
+
+	for _, k := range []string{"a1", "a2", "b1"} {
+		d.Write(k, []byte(k))
+	}
+	d.Read("a1")
+	if err := os.Chmod(d.BasePath, 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(d.BasePath)
+	if err != nil {
+		t.Fatalf("BasePath removed: %s", err)
+	}
+	if fi.Mode().Perm() != 0750 {
+		t.Errorf("BasePath permissions changed to %s", fi.Mode().Perm())
+	}
+	if _, ok := <-d.Keys(nil); ok {
+		t.Errorf("store not empty after Clear")
+	}
+	if d.isCached("a1") {
+		t.Errorf("cache not empty after Clear")
+	}
+}
+
+func TestNewWithError(t *testing.T) {
+	if err := ioutil.WriteFile("test-data-file", []byte{}, 0666); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("test-data-file")
+
+	identity := func(s string) *PathKey { return &PathKey{FileName: s} }
+	for name, o := range map[string]Options{
+		"no inverse":         {BasePath: "test-data", AdvancedTransform: identity},
+		"no index less":      {BasePath: "test-data", Index: &BTreeIndex{}},
+		"no index":           {BasePath: "test-data", IndexLess: strLess},
+		"admission no cache": {BasePath: "test-data", CacheAdmission: NewSizeAdmission(1)},
+		"base path is file":  {BasePath: "test-data-file"},
+		"temp dir is base":   {BasePath: "test-data", TempDir: "test-data/"},
+	} {
+		if _, err := NewWithError(o); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	d, err := NewWithError(Options{BasePath: "test-data", Index: &BTreeIndex{}, IndexLess: strLess})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+}
+
+func TestOnFileCreated(t *testing.T) {
+	var created []string
+	d := New(Options{
+		BasePath:  "test-data",
+		TempDir:   "test-data-temp",
+		Transform: func(s string) []string { return []string{s[:1], s[1:2]} },
+		OnFileCreated: func(path string) error {
+			created = append(created, path)
+			if strings.HasSuffix(path, filepath.Join("z", "z")) {
+				return errors.New("refused")
+			}
+			return nil
+		},
+	})
+	defer d.EraseAll()
+
+	if err := d.WriteString("abc", "1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"test-data", filepath.Join("test-data", "a"), filepath.Join("test-data", "a", "b")}
+	if len(created) != 4 || !reflect.DeepEqual(created[:3], want) {
+		t.Fatalf("unexpected hook calls %v", created)
+	}
+	if filepath.Dir(created[3]) != "test-data-temp" {
+		t.Fatalf("expected hook on temp file, got %s", created[3])
+	}
+
+	created = nil
+	if err := d.WriteString("abd", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 1 {
+		t.Fatalf("expected hook on file only, got %v", created)
+	}
+
+	if err := d.WriteString("zz", "1"); err == nil {
+		t.Fatalf("expected hook error to fail the write")
+	}
+	if d.Has("zz") {
+		t.Fatalf("refused key written")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	d := New(Options{
+		BasePath:     "test-data",
+		MaxKeyLen:    3,
+		MaxValueSize: 4,
+	})
+	defer d.EraseAll()
+
+	if err := d.WriteString("abcd", "1"); err != ErrKeyTooLong {
+		t.Errorf("expected ErrKeyTooLong, got %v", err)
+	}
+	if err := d.WriteString("abc", "12345"); err != ErrValueTooLarge {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+	if d.Has("abc") {
+		t.Errorf("oversized value written")
+	}
+	if err := d.WriteString("abc", "1234"); err != nil {
+		t.Errorf("write at limits: %s", err)
+	}
+}
+
+func TestSynchronousCache(t *testing.T) {
+	d := New(Options{
+		BasePath:         "test-data",
+		CacheSizeMax:     1024,
+		SynchronousCache: true,
+	})
+	defer d.EraseAll()
+
+	if err := d.WriteString("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Read("a"); err != nil {
+		t.Fatal(err)
+	}
+	if !d.isCached("a") {
+		t.Fatal("key not cached immediately after Read")
+	}
+
+	rc, err := d.ReadStream("a", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if d.isCached("a") {
+		t.Fatal("key still cached immediately after direct ReadStream")
+	}
+}
+
+func TestKeyPathErrors(t *testing.T) {
+	// "a_b" is stored in the directory "a", which collides with the key "a".
+	transform := func(s string) []string {
+		parts := strings.Split(s, "_")
+		return parts[:len(parts)-1]
+	}
+	d := New(Options{BasePath: "test-data", Transform: transform})
+	defer d.EraseAll()
+
+	if err := d.WriteString("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteString("a_b", "2"); err != ErrKeyPathConflict {
+		t.Errorf("expected ErrKeyPathConflict, got %v", err)
+	}
+	if err := d.Erase("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteString("a_b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteString("a", "1"); err != ErrKeyIsDirectory {
+		t.Errorf("expected ErrKeyIsDirectory, got %v", err)
+	}
+	if err := d.Erase("a"); err != ErrKeyIsDirectory {
+		t.Errorf("expected ErrKeyIsDirectory, got %v", err)
+	}
+	if _, err := d.Read("a"); !os.IsNotExist(err) {
+		t.Errorf("expected a not to exist, got %v", err)
+	}
+
+	// Imports, including renames, report the collisions the same way.
+	if err := d.WriteString("c", "3"); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(os.TempDir(), "diskv-import")
+	for _, move := range []bool{false, true} {
+		if err := ioutil.WriteFile(src, []byte("1"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Import(src, "a", move); err != ErrKeyIsDirectory {
+			t.Errorf("move %v: expected ErrKeyIsDirectory, got %v", move, err)
+		}
+		if err := d.Import(src, "c_d", move); err != ErrKeyPathConflict {
+			t.Errorf("move %v: expected ErrKeyPathConflict, got %v", move, err)
+		}
+	}
+	os.Remove(src)
+}
+
+func TestFileSuffix(t *testing.T) {
+	transform := func(s string) []string {
+		parts := strings.Split(s, "_")
+		return parts[:len(parts)-1]
+	}
+	d, err := NewWithError(Options{BasePath: "test-data", Transform: transform, FileSuffix: ".dv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	want := map[string]string{"a": "1", "a_b": "2", "a_c": "3"}
+	for k, v := range want {
+		if err := d.WriteString(k, v); err != nil {
+			t.Fatalf("%s: %s", k, err)
+		}
+	}
+	for k, v := range want {
+		if have := d.ReadString(k); have != v {
+			t.Errorf("%s: want %q, have %q", k, v, have)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("test-data", "a", "a_b.dv")); err != nil {
+		t.Errorf("expected suffixed file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join("test-data", "foreign"), []byte("x"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, d.Keys(nil), want)
+
+	if err := d.WriteString("a.dv_b", "4"); err != ErrKeyPathConflict {
+		t.Errorf("expected ErrKeyPathConflict, got %v", err)
+	}
+	if _, err := NewWithError(Options{BasePath: "test-data", FileSuffix: "/x"}); err == nil {
+		t.Error("expected error for bad FileSuffix")
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	d := NewMem(Options{BasePath: "/gen", CacheSizeMax: 1024})
+	gen := d.Generation()
+	changed := func(what string) {
+		t.Helper()
+		if now := d.Generation(); now <= gen {
+			t.Errorf("%s: generation %d, want more than %d", what, now, gen)
+		} else {
+			gen = now
+		}
+	}
+	unchanged := func(what string) {
+		t.Helper()
+		if now := d.Generation(); now != gen {
+			t.Errorf("%s: generation %d, want %d", what, now, gen)
+		}
+	}
+
+	if err := d.Write("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	changed("Write")
+	if _, err := d.Read("a"); err != nil {
+		t.Fatal(err)
+	}
+	d.Has("a")
+	for range d.Keys(nil) {
+	}
+	unchanged("reads")
+	if err := d.Erase("a"); err != nil {
+		t.Fatal(err)
+	}
+	changed("Erase")
+	if err := d.Erase("a"); err == nil {
+		t.Fatal("expected error erasing a missing key")
+	}
+	if err := d.Write("b", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	changed("Write")
+	if err := d.EraseAll(); err != nil {
+		t.Fatal(err)
+	}
+	changed("EraseAll")
+
+	d = NewMem(Options{BasePath: "/gen", AsyncWrites: true, AsyncWriteDelay: time.Hour})
+	gen = d.Generation()
+	if err := d.Write("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	changed("buffered Write")
+}
@@ -0,0 +1,255 @@
+package diskv
+
+import (
+	"bytes"
+	"encoding/gob"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// bloomFilename is the name of the file, directly in the BasePath, where the
+// Bloom filter of BloomFilter is persisted. It's never yielded as a key.
+const bloomFilename = ".diskv-bloom"
+
+// bloomFileVersion is incremented whenever the format of the Bloom filter
+// file changes, so that files in an old format are ignored.
+const bloomFileVersion = 1
+
+const (
+	bloomBitsPerKey    = 10 // for a false positive rate of about 1%
+	bloomHashes        = 7
+	bloomMinCapacity   = 1024
+	bloomHeadroomRatio = 2 // capacity, relative to the keys at build time
+)
+
+// bloomFilter is a Bloom filter over keys: it never reports that a key which
+// was added isn't there, but may report that one which wasn't added is.
+type bloomFilter struct {
+	Version  int
+	Bits     []uint64
+	Capacity int // keys it's sized for
+	Count    int // keys added, including rewrites of the same key
+}
+
+func newBloomFilter(keys int) *bloomFilter {
+	capacity := bloomHeadroomRatio * keys
+	if capacity < bloomMinCapacity {
+		capacity = bloomMinCapacity
+	}
+	return &bloomFilter{
+		Version:  bloomFileVersion,
+		Bits:     make([]uint64, (capacity*bloomBitsPerKey+63)/64),
+		Capacity: capacity,
+	}
+}
+
+// bits calls fn with the index of each of the key's bits, by double
+// hashing.
+func (f *bloomFilter) bits(key string, fn func(bit uint64) bool) bool {
+	h := fnv.New64a()
+	h.Write([]byte(key)) // never fails
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	m := uint64(len(f.Bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		if !fn((h1 + i*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(key string) {
+	f.bits(key, func(bit uint64) bool {
+		f.Bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+	f.Count++
+}
+
+func (f *bloomFilter) mayContain(key string) bool {
+	return f.bits(key, func(bit uint64) bool {
+		return f.Bits[bit/64]&(1<<(bit%64)) != 0
+	})
+}
+
+// stale reports whether so many keys have been written since the filter was
+// built that its false positive rate has degraded, and it should be rebuilt.
+func (f *bloomFilter) stale() bool {
+	return f.Count > f.Capacity
+}
+
+// ExistsMulti reports whether each of the keys exists, like Has, but under a
+// single acquisition of the lock. With BloomFilter, most keys which don't
+// exist are reported without touching the filesystem. Each key is authorized
+// as OpStat; keys which aren't allowed are reported as not existing.
+func (d *Diskv) ExistsMulti(keys []string) []bool {
+	exists := make([]bool, len(keys))
+	pathKeys := make([]*PathKey, len(keys))
+	for i, key := range keys {
+		key = d.normalizeKey(key)
+		if d.authorize(OpStat, key) != nil || d.expired(key) {
+			continue
+		}
+		if _, ok := d.pendingValue(key); ok {
+			exists[i] = true
+			continue
+		}
+		pathKeys[i] = d.transform(key)
+	}
+
+	d.checkTombstones()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for i, pathKey := range pathKeys {
+		if pathKey != nil {
+			exists[i] = d.hasWithRLock(pathKey)
+		}
+	}
+	return exists
+}
+
+// RebuildBloom rebuilds the Bloom filter of BloomFilter by walking the
+// store, sized for the keys it finds, which also forgets the keys which have
+// been erased since it was last built. The store remains usable while the
+// filter is rebuilt. It's done by New if no filter was saved, and by
+// RebuildBloomTask once the filter is stale.
+func (d *Diskv) RebuildBloom() error {
+	if !d.BloomFilter {
+		return nil
+	}
+	d.bloomBuildMu.Lock()
+	defer d.bloomBuildMu.Unlock()
+
+	d.mu.Lock()
+	d.bloomWritten = []string{}
+	d.mu.Unlock()
+
+	var keys []string
+	err := d.walkKeys(d.BasePath, "", func(key string, _ os.FileInfo) error {
+		keys = append(keys, key)
+		return nil
+	})
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	written := d.bloomWritten
+	d.bloomWritten = nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	f := newBloomFilter(len(keys))
+	for _, key := range keys {
+		f.add(key)
+	}
+	for _, key := range written {
+		f.add(key)
+	}
+	d.bloom = f
+	d.bloomChangedWithLock()
+	return nil
+}
+
+// RebuildBloomTask returns a MaintenanceTask which calls RebuildBloom if
+// the Bloom filter is stale, and then saves it, so that the next New can
+// load it instead of walking the store.
+func RebuildBloomTask(interval time.Duration) MaintenanceTask {
+	return MaintenanceTask{
+		Name:     "rebuild-bloom",
+		Interval: interval,
+		Run: func(d *Diskv) error {
+			if !d.BloomFilter {
+				return nil
+			}
+			d.mu.RLock()
+			stale := d.bloom == nil || d.bloom.stale()
+			d.mu.RUnlock()
+			if stale {
+				if err := d.RebuildBloom(); err != nil {
+					return err
+				}
+			}
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			return d.saveBloomWithLock()
+		},
+	}
+}
+
+// bloomMayContainWithLock reports whether the key may exist, according to
+// the Bloom filter, if there is one. Callers must hold d.mu, for reading at
+// least.
+func (d *Diskv) bloomMayContainWithLock(key string) bool {
+	return d.bloom == nil || d.bloom.mayContain(key)
+}
+
+// bloomAddWithLock adds the key, which was just written, to the Bloom
+// filter, and to the keys written during a rebuild. Callers must hold d.mu.
+func (d *Diskv) bloomAddWithLock(key string) {
+	if d.bloomWritten != nil {
+		d.bloomWritten = append(d.bloomWritten, key)
+	}
+	if d.bloom != nil {
+		d.bloom.add(key)
+		d.bloomChangedWithLock()
+	}
+}
+
+// resetBloomWithLock empties the Bloom filter, after every key was erased.
+// Callers must hold d.mu.
+func (d *Diskv) resetBloomWithLock() {
+	if d.bloom != nil {
+		d.bloom = newBloomFilter(0)
+		d.bloomChangedWithLock()
+	}
+}
+
+// loadBloom loads the persisted Bloom filter, and reports whether it did.
+// The file is removed once it's loaded, so that a crash before the next
+// save can't leave a stale filter behind, which could miss keys.
+func (d *Diskv) loadBloom() bool {
+	filename := filepath.Join(d.BasePath, bloomFilename)
+	buf, err := readFile(d.fs, filename)
+	if err != nil {
+		return false
+	}
+	var f bloomFilter
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&f); err != nil || f.Version != bloomFileVersion || len(f.Bits) == 0 {
+		return false
+	}
+	if err := d.fs.Remove(filename); err != nil {
+		return false
+	}
+	d.bloom = &f
+	return true
+}
+
+// saveBloomWithLock writes the Bloom filter to its file. The file stays
+// valid until the store is next written to, which removes it. Callers must
+// hold d.mu.
+func (d *Diskv) saveBloomWithLock() error {
+	if d.bloom == nil || d.bloomSaved {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(d.bloom); err != nil {
+		return err
+	}
+	if err := d.writeInternalFile(filepath.Join(d.BasePath, bloomFilename), buf.Bytes()); err != nil {
+		return err
+	}
+	d.bloomSaved = true
+	return nil
+}
+
+// bloomChangedWithLock invalidates the Bloom filter file, if it's been
+// saved, after the filter changed. Callers must hold d.mu.
+func (d *Diskv) bloomChangedWithLock() {
+	if d.bloomSaved {
+		d.fs.Remove(filepath.Join(d.BasePath, bloomFilename)) // error deliberately ignored
+		d.bloomSaved = false
+	}
+}
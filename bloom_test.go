@@ -0,0 +1,106 @@
+package diskv
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+// statCountingFS counts the calls to Stat.
+type statCountingFS struct {
+	FileSystem
+	stats int64
+}
+
+func (fs *statCountingFS) Stat(name string) (os.FileInfo, error) {
+	atomic.AddInt64(&fs.stats, 1)
+	return fs.FileSystem.Stat(name)
+}
+
+func TestExistsMulti(t *testing.T) {
+	d := NewMem(Options{})
+	for _, k := range []string{"a", "b"} {
+		if err := d.Write(k, []byte(k)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+	have := d.ExistsMulti([]string{"a", "missing", "b"})
+	if want := []bool{true, false, true}; fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Fatalf("ExistsMulti: want %v, have %v", want, have)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	fs := &statCountingFS{FileSystem: NewMemFileSystem()}
+	opts := Options{BasePath: "/bloom", FileSystem: fs, BloomFilter: true}
+	d := New(opts)
+
+	var keys []string
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+		if err := d.Write(keys[i], []byte("v")); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+	var missing []string
+	for i := 0; i < 100; i++ {
+		missing = append(missing, fmt.Sprintf("missing-%d", i))
+	}
+
+	for i, ok := range d.ExistsMulti(keys) {
+		if !ok {
+			t.Fatalf("%s: want to exist", keys[i])
+		}
+	}
+	atomic.StoreInt64(&fs.stats, 0)
+	for i, ok := range d.ExistsMulti(missing) {
+		if ok {
+			t.Fatalf("%s: want not to exist", missing[i])
+		}
+	}
+	if stats := atomic.LoadInt64(&fs.stats); stats > 10 {
+		t.Errorf("%d stats for 100 missing keys, want at most 10", stats)
+	}
+
+	// The filter is saved by Close, and loaded by New, rather than rebuilt.
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	d = New(opts)
+	if d.bloom == nil || d.bloom.Count != 100 {
+		t.Fatalf("filter not loaded: %+v", d.bloom)
+	}
+	if !d.Has("key-42") {
+		t.Fatalf("Has: want true after reloading")
+	}
+
+	// The filter goes stale as keys are written, until it's rebuilt.
+	for i := 0; i < d.bloom.Capacity; i++ {
+		if err := d.Write("key-0", []byte("v")); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+	if !d.bloom.stale() {
+		t.Fatalf("filter not stale after %d writes", d.bloom.Capacity)
+	}
+	if err := RebuildBloomTask(0).Run(d); err != nil {
+		t.Fatalf("RebuildBloomTask: %s", err)
+	}
+	if d.bloom.stale() || d.bloom.Count != 100 {
+		t.Fatalf("filter not rebuilt: count %d", d.bloom.Count)
+	}
+
+	if err := d.Clear(); err != nil {
+		t.Fatalf("Clear: %s", err)
+	}
+	if d.Has("key-42") {
+		t.Fatalf("Has: want false after Clear")
+	}
+	if err := d.Write("key-42", []byte("v")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if !d.Has("key-42") {
+		t.Fatalf("Has: want true after writing again")
+	}
+}
@@ -0,0 +1,234 @@
+package diskv
+
+import (
+	"fmt"
+	"os"
+)
+
+// BulkOptions control destructive bulk operations like EraseAllWith,
+// ClearWith and ErasePrefix, which may run over very many keys.
+type BulkOptions struct {
+	// If DryRun is true, nothing is deleted; the returned BulkReport
+	// describes what would have been deleted.
+	DryRun bool
+
+	// If Progress is set, it's called once for every key, with the key's
+	// size on disk, as the key is processed.
+	Progress func(key string, size int64)
+}
+
+// BulkReport summarizes the result of a bulk operation.
+type BulkReport struct {
+	Keys  int   // number of keys processed
+	Bytes int64 // total size on disk of the processed keys
+}
+
+// EraseAllWith is like EraseAll, but it supports a dry run, and progress
+// reporting while the keys are erased one by one.
+func (d *Diskv) EraseAllWith(opts BulkOptions) (BulkReport, error) {
+	return d.bulkErase("", opts, d.EraseAll)
+}
+
+// ClearWith is like Clear, but it supports a dry run, and progress reporting
+// while the keys are erased one by one.
+func (d *Diskv) ClearWith(opts BulkOptions) (BulkReport, error) {
+	return d.bulkErase("", opts, d.Clear)
+}
+
+// ErasePrefix erases every key with the given prefix from the disk, the
+// cache and the index, and prunes any directories left empty.
+func (d *Diskv) ErasePrefix(prefix string, opts BulkOptions) (BulkReport, error) {
+	pruneKeys := map[string]string{} // one key per directory
+	report, err := d.bulkErase(prefix, opts, nil, func(key string, pathKey *PathKey) {
+		pruneKeys[d.pathFor(pathKey)] = key
+	})
+	if err != nil || opts.DryRun {
+		return report, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, key := range pruneKeys {
+		d.pruneDirsWithLock(key)
+	}
+	return report, nil
+}
+
+// EraseMulti erases the keys, like Erase does each of them, but under a
+// single acquisition of the lock, and it prunes the directories left empty
+// once, at the end, so it's much faster for many keys. It returns the keys
+// which weren't erased, with their errors, or nil if every key was. Keys
+// which don't exist are among them, with errors satisfying os.IsNotExist.
+// Each key is authorized as OpErase.
+func (d *Diskv) EraseMulti(keys []string) (failed map[string]error) {
+	span := d.startSpan("Erase", "")
+	defer func() {
+		span.SetAttribute("keys", len(keys))
+		span.SetAttribute("failed", len(failed))
+		span.End(nil)
+	}()
+
+	fail := func(key string, err error) {
+		if failed == nil {
+			failed = map[string]error{}
+		}
+		failed[key] = err
+	}
+
+	var (
+		allowed = make([]string, 0, len(keys))
+		seen    = make(map[string]bool, len(keys))
+	)
+	for _, key := range keys {
+		key = d.normalizeKey(key)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if err := d.authorize(OpErase, key); err != nil {
+			fail(key, err)
+			continue
+		}
+		allowed = append(allowed, key)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pruneKeys := map[string]string{} // one key per directory
+	for _, key := range allowed {
+		pathKey := d.transform(key)
+		if err := d.checkPath(pathKey); err != nil {
+			fail(key, err)
+			continue
+		}
+		if err := d.eraseNoPruneWithLock(key, pathKey); err != nil {
+			fail(key, err)
+			continue
+		}
+		pruneKeys[d.pathFor(pathKey)] = key
+	}
+
+	for _, key := range pruneKeys {
+		d.pruneDirsWithLock(key)
+	}
+	return failed
+}
+
+// bulkErase walks every key with the given prefix, reporting and (unless
+// it's a dry run) erasing each of them without pruning directories. Each
+// erased key is also passed to the optional erased callbacks. If finish is
+// non-nil, it's called at the end of a successful non-dry run.
+func (d *Diskv) bulkErase(prefix string, opts BulkOptions, finish func() error, erased ...func(key string, pathKey *PathKey)) (BulkReport, error) {
+	var report BulkReport
+
+	prefix = d.normalizeKey(prefix)
+	if err := d.authorize(OpErase, prefix); err != nil {
+		return report, err
+	}
+
+	cancel := make(chan struct{})
+	defer close(cancel)
+
+	for key := range d.keysPrefix(prefix, nil, cancel) {
+		pathKey := d.transform(key)
+		size, _ := d.storedSize(pathKey)
+
+		if !opts.DryRun {
+			if err := d.eraseFileOnly(key, pathKey); err != nil {
+				return report, err
+			}
+			for _, f := range erased {
+				f(key, pathKey)
+			}
+		}
+
+		report.Keys++
+		report.Bytes += size
+		if opts.Progress != nil {
+			opts.Progress(key, size)
+		}
+	}
+
+	if !opts.DryRun && finish != nil {
+		if err := finish(); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// eraseFileOnly removes the key from the cache and the index, and removes
+// its file or packed value, but doesn't prune any directories. It's not an
+// error if the key doesn't exist.
+func (d *Diskv) eraseFileOnly(key string, pathKey *PathKey) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.eraseNoPruneWithLock(key, pathKey); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Migrate copies every key in src to dst, which typically has a different
+// BasePath, Transform or Compression, along with its modification time and
+// remaining TTL. Values are streamed, and aren't added to src's cache. src
+// is left intact; erase it once you're satisfied with the result. Keys
+// erased or expired in src while Migrate runs are skipped. With DryRun,
+// Migrate only reports what would be copied. Migrate reads and writes with
+// Background priority.
+func Migrate(src, dst *Diskv, opts BulkOptions) (BulkReport, error) {
+	var report BulkReport
+
+	cancel := make(chan struct{})
+	defer close(cancel)
+
+	for key := range src.Keys(cancel) {
+		size, _ := src.storedSize(src.transform(key))
+
+		if !opts.DryRun {
+			copied, err := copyKey(src, dst, key)
+			if err != nil {
+				return report, fmt.Errorf("%s: %s", key, err)
+			}
+			if !copied {
+				continue
+			}
+		}
+
+		report.Keys++
+		report.Bytes += size
+		if opts.Progress != nil {
+			opts.Progress(key, size)
+		}
+	}
+	return report, nil
+}
+
+// copyKey streams the value of key from src to dst, along with its
+// modification time and TTL, and reports whether it did. It's not an error
+// if the key has been erased or has expired.
+func copyKey(src, dst *Diskv, key string) (bool, error) {
+	wopts := WriteOptions{Priority: Background}
+	var ok bool
+	if wopts.TTL, ok = src.remainingTTL(key); !ok {
+		return false, nil
+	}
+	info, err := src.Stat(key)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	wopts.ModTime = info.ModTime()
+
+	rc, err := src.ReadStreamWith(key, ReadStreamOptions{NoFill: true, Priority: Background})
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	return true, dst.WriteWith(key, rc, wopts)
+}
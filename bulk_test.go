@@ -0,0 +1,182 @@
+package diskv
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErasePrefix(t *testing.T) {
+	d := New(Options{
+		BasePath:     "test-data",
+		Transform:    blockTransform(2),
+		CacheSizeMax: 1024,
+	})
+	defer d.EraseAll()
+
+	for k, v := range keysTestData {
+		d.Write(k, []byte(v))
+	}
+
+	var progress int
+	dry, err := d.ErasePrefix("ab01", BulkOptions{
+		DryRun:   true,
+		Progress: func(string, int64) { progress++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dry.Keys != 3 || progress != 3 {
+		t.Fatalf("dry run: expected 3 keys, got %d (progress %d)", dry.Keys, progress)
+	}
+	if !d.Has("ab01cd01") {
+		t.Fatalf("dry run deleted data")
+	}
+
+	report, err := d.ErasePrefix("ab01", BulkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != dry {
+		t.Fatalf("expected report %+v, got %+v", dry, report)
+	}
+
+	want := map[string]string{}
+	for k, v := range keysTestData {
+		if k[:4] != "ab01" {
+			want[k] = v
+		}
+	}
+	checkKeys(t, d.Keys(nil), want)
+}
+
+func TestEraseMulti(t *testing.T) {
+	d := New(Options{
+		BasePath:     "test-data",
+		Transform:    blockTransform(2),
+		CacheSizeMax: 1024,
+	})
+	defer d.EraseAll()
+
+	for k, v := range keysTestData {
+		d.Write(k, []byte(v))
+		d.Read(k) // cache it
+	}
+
+	failed := d.EraseMulti([]string{"ab01cd01", "ab01cd02", "ab01cd03", "ef01gh04", "ef01gh04", "missing"})
+	if len(failed) != 1 || !os.IsNotExist(failed["missing"]) {
+		t.Fatalf("failed: want only a not-exist error for missing, have %v", failed)
+	}
+	for _, k := range []string{"ab01cd01", "ef01gh04"} {
+		if d.isCached(k) {
+			t.Errorf("%s: still cached", k)
+		}
+	}
+	checkKeys(t, d.Keys(nil), map[string]string{
+		"ef02gh05": keysTestData["ef02gh05"],
+		"xxxxxxxx": keysTestData["xxxxxxxx"],
+	})
+	for _, dir := range []string{"ab", "ef/01"} {
+		if _, err := os.Stat(filepath.Join(d.BasePath, dir)); !os.IsNotExist(err) {
+			t.Errorf("%s: want pruned, have %v", dir, err)
+		}
+	}
+
+	if failed := d.EraseMulti([]string{"ef02gh05", "xxxxxxxx"}); failed != nil {
+		t.Fatalf("failed: want nil, have %v", failed)
+	}
+	checkKeys(t, d.Keys(nil), map[string]string{})
+}
+
+func TestEraseAllWithDryRun(t *testing.T) {
+	d := New(Options{
+		BasePath: "test-data",
+	})
+	defer d.EraseAll()
+
+	var total int64
+	for k, v := range keysTestData {
+		d.Write(k, []byte(v))
+		total += int64(len(v))
+	}
+
+	report, err := d.EraseAllWith(BulkOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Keys != len(keysTestData) || report.Bytes != total {
+		t.Fatalf("expected %d keys/%d bytes, got %+v", len(keysTestData), total, report)
+	}
+
+	if _, err := d.ClearWith(BulkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-d.Keys(nil); ok {
+		t.Fatalf("store not empty after ClearWith")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	src := New(Options{
+		BasePath: "test-data",
+	})
+	defer src.EraseAll()
+	dst := New(Options{
+		BasePath:  "test-data-migrated",
+		Transform: blockTransform(2),
+	})
+	defer dst.EraseAll()
+
+	for k, v := range keysTestData {
+		src.Write(k, []byte(v))
+	}
+
+	report, err := Migrate(src, dst, BulkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Keys != len(keysTestData) {
+		t.Fatalf("expected %d keys migrated, got %d", len(keysTestData), report.Keys)
+	}
+	checkKeys(t, dst.Keys(nil), keysTestData)
+	for k, v := range keysTestData {
+		if got := dst.ReadString(k); got != v {
+			t.Errorf("%s: expected %q, got %q", k, v, got)
+		}
+	}
+}
+
+func TestMigrateMetadata(t *testing.T) {
+	src := NewMem(Options{BasePath: "/src"})
+	dst := NewMem(Options{BasePath: "/dst", Compression: NewGzipCompression()})
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := src.WriteWith("old", strings.NewReader("value"), WriteOptions{ModTime: modTime}); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.WriteWith("ttl", strings.NewReader("expiring"), WriteOptions{TTL: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.WriteWith("expired", strings.NewReader("gone"), WriteOptions{TTL: time.Nanosecond}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	report, err := Migrate(src, dst, BulkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"old": "value", "ttl": "expiring"}
+	if report.Keys != len(want) {
+		t.Fatalf("expected %d keys migrated, got %d", len(want), report.Keys)
+	}
+	checkKeys(t, dst.Keys(nil), want)
+	if fi, err := dst.Stat("old"); err != nil || !fi.ModTime().Equal(modTime) {
+		t.Fatalf("modification time not carried over: %v, %v", fi, err)
+	}
+	if ttl, ok := dst.remainingTTL("ttl"); !ok || ttl < 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("TTL not carried over: %v", ttl)
+	}
+}
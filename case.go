@@ -0,0 +1,38 @@
+package diskv
+
+import (
+	"sort"
+	"strings"
+)
+
+// CaseCollisions walks the store, and returns every group of two or more
+// keys that differ only in case, indexed by their lowercased form. Such keys
+// can't be accessed reliably once CaseInsensitive is set, and should be
+// merged or renamed, e.g. before enabling it on an existing store.
+func (d *Diskv) CaseCollisions() map[string][]string {
+	groups := map[string][]string{}
+	for key := range d.keysWithoutNormalizing() {
+		lower := strings.ToLower(key)
+		groups[lower] = append(groups[lower], key)
+	}
+	for lower, keys := range groups {
+		if len(keys) < 2 {
+			delete(groups, lower)
+			continue
+		}
+		sort.Strings(keys)
+	}
+	return groups
+}
+
+// keysWithoutNormalizing yields every key in the store as it's stored,
+// regardless of CaseInsensitive.
+func (d *Diskv) keysWithoutNormalizing() <-chan string {
+	c := make(chan string)
+	goLabeled("keys", func() {
+		n := 0
+		d.walkKeys(d.BasePath, "", d.sender(c, nil, &n))
+		close(c)
+	})
+	return c
+}
@@ -0,0 +1,49 @@
+package diskv
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCaseInsensitive(t *testing.T) {
+	d := New(Options{
+		BasePath:        "test-data",
+		CacheSizeMax:    1024,
+		CaseInsensitive: true,
+	})
+	defer d.EraseAll()
+
+	if err := d.WriteString("Alice@Example.com", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.ReadString("ALICE@example.COM"); got != "1" {
+		t.Fatalf("expected 1, got %q", got)
+	}
+	if !d.Has("alice@example.com") {
+		t.Fatalf("Has: key not found")
+	}
+	checkKeys(t, d.KeysPrefix("ALICE", nil), map[string]string{"alice@example.com": "1"})
+	if err := d.Erase("ALICE@EXAMPLE.COM"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCaseCollisions(t *testing.T) {
+	d := New(Options{
+		BasePath: "test-data",
+	})
+	defer d.EraseAll()
+
+	for _, k := range []string{"Foo", "foo", "bar"} {
+		d.WriteString(k, k)
+	}
+
+	d = New(Options{
+		BasePath:        "test-data",
+		CaseInsensitive: true,
+	})
+	want := map[string][]string{"foo": {"Foo", "foo"}}
+	if got := d.CaseCollisions(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
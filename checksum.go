@@ -0,0 +1,140 @@
+package diskv
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// ErrChecksumMismatch is returned by reads of values whose checksum, as
+// appended by NewChecksumCompression, doesn't match, i.e. which are corrupt,
+// or weren't written with it.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+const checksumSize = 4 // CRC-32C, big-endian
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// NewChecksumCompression returns a Compression which compresses values with
+// c, if it isn't nil, and appends a CRC-32C checksum of the result to them.
+// Reads verify it as the value streams, and fail with ErrChecksumMismatch
+// rather than return io.EOF if the value is corrupt, so that a value
+// streamed straight to a client never ends cleanly if it's corrupt. The
+// bytes read before the mismatch is detected have already been returned,
+// so nothing should be committed before EOF. Values written without the
+// checksum can't be read with it.
+func NewChecksumCompression(c Compression) Compression {
+	return &checksumCompression{c}
+}
+
+type checksumCompression struct {
+	next Compression
+}
+
+func (c *checksumCompression) Writer(dst io.Writer) (io.WriteCloser, error) {
+	cw := &crcWriter{w: dst, crc: crc32.New(castagnoli)}
+	wc := io.WriteCloser(&nopWriteCloser{cw})
+	if c.next != nil {
+		var err error
+		if wc, err = c.next.Writer(cw); err != nil {
+			return nil, err
+		}
+	}
+	return &checksumWriter{WriteCloser: wc, cw: cw}, nil
+}
+
+func (c *checksumCompression) Reader(src io.Reader) (io.ReadCloser, error) {
+	cr := &checksumReader{r: src, crc: crc32.New(castagnoli)}
+	if c.next == nil {
+		return ioutil.NopCloser(cr), nil
+	}
+	rc, err := c.next.Reader(cr)
+	if err != nil {
+		return nil, err
+	}
+	return &drainingReader{ReadCloser: rc, cr: cr}, nil
+}
+
+// crcWriter checksums the bytes written through it.
+type crcWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+}
+
+func (w *crcWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.crc.Write(p[:n])
+	return n, err
+}
+
+// checksumWriter appends the checksum once it's closed.
+type checksumWriter struct {
+	io.WriteCloser
+	cw *crcWriter
+}
+
+func (w *checksumWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	var footer [checksumSize]byte
+	binary.BigEndian.PutUint32(footer[:], w.cw.crc.Sum32())
+	_, err := w.cw.w.Write(footer[:])
+	return err
+}
+
+// checksumReader verifies the checksum at the end of what it reads. It
+// holds back the last checksumSize bytes it's read, which may be the
+// checksum, and returns ErrChecksumMismatch instead of io.EOF if they
+// don't match.
+type checksumReader struct {
+	r       io.Reader
+	crc     hash.Hash32
+	buf     []byte // read, but not yet returned
+	scratch []byte
+	err     error
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	if c.scratch == nil {
+		c.scratch = make([]byte, 32*1024)
+	}
+	for len(c.buf) <= checksumSize && c.err == nil {
+		n, err := c.r.Read(c.scratch)
+		c.buf = append(c.buf, c.scratch[:n]...)
+		c.err = err
+	}
+	if n := len(c.buf) - checksumSize; n > 0 {
+		n = copy(p, c.buf[:n])
+		c.crc.Write(p[:n])
+		c.buf = c.buf[:copy(c.buf, c.buf[n:])]
+		return n, nil
+	}
+	if c.err == io.EOF {
+		if len(c.buf) < checksumSize || binary.BigEndian.Uint32(c.buf) != c.crc.Sum32() {
+			c.err = ErrChecksumMismatch
+		}
+	}
+	return 0, c.err
+}
+
+// drainingReader reads the rest of the checksummed value once the
+// decompressed one ends, since decompressors may stop before the checksum,
+// and fails with ErrChecksumMismatch instead of io.EOF if it doesn't match.
+type drainingReader struct {
+	io.ReadCloser
+	cr *checksumReader
+}
+
+func (r *drainingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		if _, drainErr := io.Copy(ioutil.Discard, r.cr); drainErr != nil {
+			err = drainErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,78 @@
+package diskv
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksumCompression(t *testing.T) {
+	for name, c := range map[string]Compression{
+		"plain": NewChecksumCompression(nil),
+		"gzip":  NewChecksumCompression(NewGzipCompression()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := New(Options{BasePath: "test-data", Compression: c})
+			defer d.EraseAll()
+
+			val := bytes.Repeat([]byte("0123456789"), 10000)
+			if err := d.Write("k", val); err != nil {
+				t.Fatalf("Write: %s", err)
+			}
+			if have, err := d.Read("k"); err != nil || !bytes.Equal(have, val) {
+				t.Fatalf("Read: %d bytes, %v", len(have), err)
+			}
+
+			path := filepath.Join(d.BasePath, "k")
+			stored, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored[len(stored)/2] ^= 0xff
+			if err := ioutil.WriteFile(path, stored, 0600); err != nil {
+				t.Fatal(err)
+			}
+			rc, err := d.ReadStream("k", true)
+			if err != nil {
+				t.Fatalf("ReadStream: %s", err)
+			}
+			defer rc.Close()
+			if _, err := ioutil.ReadAll(rc); err == nil {
+				t.Fatal("corrupt value read without error")
+			}
+
+			// A truncated value never reaches EOF either.
+			if err := ioutil.WriteFile(path, stored[:len(stored)/2], 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := d.ReadWith("k", ReadOptions{SkipCache: true}); err == nil {
+				t.Fatal("truncated value read without error")
+			}
+		})
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	d := New(Options{BasePath: "test-data", Compression: NewChecksumCompression(nil)})
+	defer d.EraseAll()
+
+	if err := d.Write("k", []byte("hello, world")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	path := filepath.Join(d.BasePath, "k")
+	for _, stored := range []string{"hello, world!!!!", "hi", ""} {
+		if err := ioutil.WriteFile(path, []byte(stored), os.FileMode(0600)); err != nil {
+			t.Fatal(err)
+		}
+		rc, err := d.ReadStream("k", true)
+		if err != nil {
+			t.Fatalf("ReadStream: %s", err)
+		}
+		if _, err := ioutil.ReadAll(rc); err != ErrChecksumMismatch {
+			t.Errorf("%q: want %v, have %v", stored, ErrChecksumMismatch, err)
+		}
+		rc.Close()
+	}
+}
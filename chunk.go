@@ -0,0 +1,728 @@
+package diskv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chunkDirname is the directory, directly in the BasePath, which holds the
+// chunks of chunked values, in a directory per key, named by the SHA-256 of
+// the key. It's never walked for keys.
+const chunkDirname = ".diskv-chunks"
+
+// chunkManifestName is the file in a key's chunk directory which describes
+// its value. It's written last, so a directory without one is incomplete.
+const chunkManifestName = "manifest"
+
+// uploadSuffix marks the chunk directory of a write which hasn't been
+// committed yet, and which ResumeWriteStream may continue.
+const uploadSuffix = ".upload"
+
+var errNoChunks = errors.New("ChunkSize isn't set")
+
+// ErrResumeOffset is returned by ResumeWriteStream for an offset which isn't
+// a multiple of ChunkSize, or which is beyond ResumeOffset.
+var ErrResumeOffset = errors.New("bad resume offset")
+
+// chunkManifest describes a chunked value: Chunks chunks of ChunkSize
+// bytes, except for the last, which may be shorter.
+type chunkManifest struct {
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	ChunkSize int64     `json:"chunk_size"`
+	Chunks    int       `json:"chunks"`
+	ModTime   time.Time `json:"mod_time"`
+}
+
+// chunkDir returns the directory of the key's chunks.
+func (d *Diskv) chunkDir(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.BasePath, chunkDirname, hex.EncodeToString(sum[:]))
+}
+
+// chunkName returns the file name of the i'th chunk.
+func chunkName(i int) string {
+	return fmt.Sprintf("%08d", i)
+}
+
+// loadChunks reads the manifest of every chunked value. A commit which was
+// interrupted after the old value was removed is completed.
+func (d *Diskv) loadChunks() {
+	d.chunkMu.Lock()
+	defer d.chunkMu.Unlock()
+
+	d.chunked = map[string]chunkManifest{}
+	root := filepath.Join(d.BasePath, chunkDirname)
+	names, err := readDirNames(d.fs, root)
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		dir := filepath.Join(root, name)
+		if strings.HasSuffix(name, uploadSuffix) {
+			final := strings.TrimSuffix(dir, uploadSuffix)
+			if _, err := d.fs.Stat(filepath.Join(dir, chunkManifestName)); err != nil {
+				continue // still being written
+			}
+			if _, err := d.fs.Stat(final); !os.IsNotExist(err) {
+				continue
+			}
+			if err := d.fs.Rename(dir, final); err != nil {
+				continue
+			}
+			dir = final
+		}
+		buf, err := readFile(d.fs, filepath.Join(dir, chunkManifestName))
+		if err != nil {
+			continue
+		}
+		var m chunkManifest
+		if err := json.Unmarshal(buf, &m); err != nil {
+			continue
+		}
+		d.chunked[m.Key] = m
+	}
+}
+
+// resetChunksWithLock forgets every chunked value, after the chunks were
+// removed along with everything else. Callers must hold d.mu.
+func (d *Diskv) resetChunksWithLock() {
+	if d.ChunkSize <= 0 {
+		return
+	}
+	d.chunkMu.Lock()
+	defer d.chunkMu.Unlock()
+	d.chunked = map[string]chunkManifest{}
+}
+
+// chunkStat returns the FileInfo of the key's chunked value, if it has one.
+func (d *Diskv) chunkStat(key string) (os.FileInfo, bool) {
+	if d.ChunkSize <= 0 {
+		return nil, false
+	}
+	d.chunkMu.RLock()
+	defer d.chunkMu.RUnlock()
+	m, ok := d.chunked[key]
+	if !ok {
+		return nil, false
+	}
+	return &chunkFileInfo{m: m, mode: d.FilePerm}, true
+}
+
+// writeChunkedWithLock writes the value, which is larger than ChunkSize, as
+// chunks, and commits them. If the write fails, the chunks written so far
+// are kept for ResumeWriteStream. Callers must hold d.mu.
+func (d *Diskv) writeChunkedWithLock(pathKey *PathKey, r io.Reader, opts WriteOptions) error {
+	upload := d.chunkDir(pathKey.originalKey) + uploadSuffix
+	if err := d.fs.RemoveAll(upload); err != nil { // a previous, interrupted write
+		return fmt.Errorf("remove upload: %s", err)
+	}
+	n, err := d.writeChunksWithLock(upload, 0, r, opts.Sync)
+	if err == ErrValueTooLarge {
+		d.fs.RemoveAll(upload) // error deliberately ignored
+		return err
+	} else if err != nil {
+		return err
+	}
+	return d.commitChunksWithLock(pathKey, n, opts)
+}
+
+// writeChunksWithLock writes r to the upload directory as chunks, starting
+// with the first'th, and returns the number of chunks there are then.
+// Callers must hold d.mu.
+func (d *Diskv) writeChunksWithLock(upload string, first int, r io.Reader, sync bool) (int, error) {
+	if err := d.fs.MkdirAll(upload, d.PathPerm); err != nil {
+		return first, fmt.Errorf("ensure path: %s", err)
+	}
+	for i := first; ; i++ {
+		buf, err := ioutil.ReadAll(io.LimitReader(r, d.ChunkSize))
+		if err == ErrValueTooLarge {
+			return i, err
+		} else if err != nil {
+			return i, fmt.Errorf("i/o copy: %s", err)
+		}
+		if len(buf) == 0 {
+			return i, nil
+		}
+		if err := d.writeChunk(upload, i, buf, sync); err != nil {
+			return i, err
+		}
+		if int64(len(buf)) < d.ChunkSize {
+			return i + 1, nil
+		}
+	}
+}
+
+// writeChunk writes the i'th chunk to the upload directory, via a temporary
+// file, so that a chunk is never seen partly written.
+func (d *Diskv) writeChunk(upload string, i int, buf []byte, sync bool) error {
+	f, err := d.fs.TempFile(upload, chunkName(i)+".")
+	if err != nil {
+		return fmt.Errorf("temp file: %s", err)
+	}
+	_, err = f.Write(buf)
+	if err == nil && sync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = d.fs.Chmod(f.Name(), d.FilePerm)
+	}
+	if err == nil && d.OnFileCreated != nil {
+		if err = d.OnFileCreated(f.Name()); err != nil {
+			err = fmt.Errorf("on file created: %s", err)
+		}
+	}
+	if err == nil {
+		err = d.fs.Rename(f.Name(), filepath.Join(upload, chunkName(i)))
+	}
+	if err != nil {
+		d.fs.Remove(f.Name()) // error deliberately ignored
+		return fmt.Errorf("write chunk %d: %s", i, err)
+	}
+	return nil
+}
+
+// uploadedChunks returns the number of consecutive full chunks, from the
+// first, in the upload directory.
+func (d *Diskv) uploadedChunks(upload string) (int, error) {
+	for i := 0; ; i++ {
+		fi, err := d.fs.Stat(filepath.Join(upload, chunkName(i)))
+		if os.IsNotExist(err) {
+			return i, nil
+		} else if err != nil {
+			return i, err
+		}
+		if fi.Size() != d.ChunkSize {
+			return i, nil
+		}
+	}
+}
+
+// openChunkFiles opens the first n chunks in dir. They're opened upfront,
+// so that reading them is unaffected by later writes.
+func (d *Diskv) openChunkFiles(dir string, n int) ([]File, error) {
+	files := make([]File, 0, n)
+	for i := 0; i < n; i++ {
+		f, err := d.fs.Open(filepath.Join(dir, chunkName(i)))
+		if err != nil {
+			for _, f := range files {
+				f.Close() // error deliberately ignored
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// openChunks returns a reader of the first n chunks in dir, in order. Each
+// chunk is closed when it's been read.
+func (d *Diskv) openChunks(dir string, n int) (io.Reader, error) {
+	files, err := d.openChunkFiles(dir, n)
+	if err != nil {
+		return nil, err
+	}
+	readers := make([]io.Reader, len(files))
+	for i, f := range files {
+		readers[i] = closingReader{f}
+	}
+	return io.MultiReader(readers...), nil
+}
+
+// commitChunksWithLock makes the first n chunks in the key's upload
+// directory its value, and does everything writeStreamWithLock does after
+// writing a file. If the key had a file of its own, or a packed value, it's
+// removed. Callers must hold d.mu.
+func (d *Diskv) commitChunksWithLock(pathKey *PathKey, n int, opts WriteOptions) error {
+	key := pathKey.originalKey
+	upload := d.chunkDir(key) + uploadSuffix
+
+	var size int64
+	for i := 0; i < n; i++ {
+		fi, err := d.fs.Stat(filepath.Join(upload, chunkName(i)))
+		if err != nil {
+			return fmt.Errorf("chunk %d: %s", i, err)
+		}
+		if fi.Size() > d.ChunkSize || (i < n-1 && fi.Size() != d.ChunkSize) {
+			return fmt.Errorf("chunk %d: %d bytes, but ChunkSize is %d", i, fi.Size(), d.ChunkSize)
+		}
+		size += fi.Size()
+	}
+	if d.MaxValueSize > 0 && size > d.MaxValueSize {
+		return ErrValueTooLarge
+	}
+
+	oldSize, exists := d.quotaBaseWithLock(pathKey)
+	qw, err := d.quotaWriterWithLock(key, oldSize, exists)
+	if err != nil {
+		return err
+	}
+	if qw.n >= 0 && size > qw.n {
+		return qw.err
+	}
+
+	var sum [sha256.Size]byte
+	if d.merkle != nil || d.Journal {
+		r, err := d.openChunks(upload, n)
+		if err != nil {
+			return fmt.Errorf("open chunks: %s", err)
+		}
+		if d.SnapshotDir != "" {
+			if sum, err = d.storeObject(r); err != nil {
+				return fmt.Errorf("store object: %s", err)
+			}
+		} else {
+			h := sha256.New()
+			if _, err := io.Copy(h, r); err != nil {
+				return fmt.Errorf("hash chunks: %s", err)
+			}
+			copy(sum[:], h.Sum(nil))
+		}
+	}
+
+	// Chunks left over from a longer, earlier attempt are removed.
+	names, err := readDirNames(d.fs, upload)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		var i int
+		if _, err := fmt.Sscanf(name, "%08d", &i); err == nil && name == chunkName(i) && i < n {
+			continue
+		}
+		if err := d.fs.Remove(filepath.Join(upload, name)); err != nil {
+			return err
+		}
+	}
+
+	m := chunkManifest{Key: key, Size: size, ChunkSize: d.ChunkSize, Chunks: n, ModTime: d.modTime(opts)}
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(d.fs, filepath.Join(upload, chunkManifestName), buf, d.FilePerm); err != nil {
+		return fmt.Errorf("write chunk manifest: %s", err)
+	}
+	final := d.chunkDir(key)
+	if err := d.fs.RemoveAll(final); err != nil {
+		return fmt.Errorf("remove old chunks: %s", err)
+	}
+	if err := d.fs.Rename(upload, final); err != nil {
+		return fmt.Errorf("rename: %s", err)
+	}
+	d.chunkMu.Lock()
+	d.chunked[key] = m
+	d.chunkMu.Unlock()
+
+	if err := d.removeKeyFileWithLock(pathKey); err != nil {
+		return err
+	}
+	if _, err := d.unpackWithLock(key); err != nil {
+		return err
+	}
+
+	if d.Index != nil {
+		if ri, ok := d.Index.(RichIndex); ok {
+			ri.InsertEntry(IndexEntry{Key: key, Size: size, ModTime: m.ModTime})
+		} else {
+			d.Index.Insert(key)
+		}
+		d.indexChangedWithLock()
+	}
+
+	if len(d.quotas) > 0 {
+		keys := 1
+		if exists {
+			keys = 0
+		}
+		d.chargeQuotaWithLock(key, size-oldSize, keys)
+		d.absorbReservedWithLock(key)
+	}
+
+	d.invalidateWithLock(key)
+	d.bloomAddWithLock(key)
+
+	if d.merkle != nil || d.Journal {
+		d.merkleSetWithLock(key, &sum)
+		return d.journalWithLock(JournalWrite, key, sum[:])
+	}
+	return nil
+}
+
+// unchunkWithLock removes the key's chunked value, if it has one, and
+// reports whether it had one. Callers must hold d.mu.
+func (d *Diskv) unchunkWithLock(key string) (bool, error) {
+	if d.ChunkSize <= 0 {
+		return false, nil
+	}
+	d.chunkMu.Lock()
+	defer d.chunkMu.Unlock()
+	if _, ok := d.chunked[key]; !ok {
+		return false, nil
+	}
+	if err := d.fs.RemoveAll(d.chunkDir(key)); err != nil {
+		return true, fmt.Errorf("remove chunks: %s", err)
+	}
+	delete(d.chunked, key)
+	return true, nil
+}
+
+// readChunkedWithRLock returns a reader of the key's chunked value, if it
+// has one, and its open chunk files. Callers must hold at least a read lock
+// on d.mu, so that the chunks aren't replaced while they're opened.
+func (d *Diskv) readChunkedWithRLock(key string) (io.Reader, []File, bool, error) {
+	fi, ok := d.chunkStat(key)
+	if !ok {
+		return nil, nil, false, nil
+	}
+	files, err := d.openChunkFiles(d.chunkDir(key), fi.(*chunkFileInfo).m.Chunks)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	readers := make([]io.Reader, len(files))
+	for i, f := range files {
+		readers[i] = closingReader{f}
+	}
+	return io.MultiReader(readers...), files, true, nil
+}
+
+// ResumeOffset returns how much of an interrupted chunked write of the key
+// is stored, and so where ResumeWriteStream should continue from. It's
+// zero if there's nothing to resume.
+func (d *Diskv) ResumeOffset(key string) (int64, error) {
+	key = d.normalizeKey(key)
+	if err := d.authorize(OpStat, key); err != nil {
+		return 0, err
+	}
+	if d.ChunkSize <= 0 {
+		return 0, errNoChunks
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	n, err := d.uploadedChunks(d.chunkDir(key) + uploadSuffix)
+	return int64(n) * d.ChunkSize, err
+}
+
+// ResumeWriteStream continues a chunked write of the key which failed part
+// way, e.g. because its reader did. The offset must be a multiple of
+// ChunkSize, at most ResumeOffset, and r must yield the value from there on.
+// Like WriteStream, the value only replaces the key's current one once all
+// of it is written.
+func (d *Diskv) ResumeWriteStream(key string, r io.Reader, offset int64, sync bool) (err error) {
+	key = d.normalizeKey(key)
+	span := d.startSpan("Write", key)
+	cr := &countingReader{r: r}
+	defer func() {
+		span.SetAttribute("bytes", cr.n)
+		span.SetAttribute("resume_offset", offset)
+		span.End(err)
+	}()
+
+	if d.ChunkSize <= 0 {
+		return errNoChunks
+	}
+	pathKey, err := d.checkWriteKey(key)
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset%d.ChunkSize != 0 {
+		return ErrResumeOffset
+	}
+	if d.MaxValueSize > 0 {
+		cr.r = &maxSizeReader{r: cr.r, n: d.MaxValueSize - offset}
+	}
+
+	d.writeThrottle.waitOp(Foreground)
+	defer func() { d.writeThrottle.waitBytes(cr.n, Foreground) }()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	upload := d.chunkDir(key) + uploadSuffix
+	if have, err := d.uploadedChunks(upload); err != nil {
+		return err
+	} else if int64(have)*d.ChunkSize < offset {
+		return ErrResumeOffset
+	}
+	n, err := d.writeChunksWithLock(upload, int(offset/d.ChunkSize), cr, sync)
+	if err != nil {
+		return err
+	}
+	if err := d.commitChunksWithLock(pathKey, n, WriteOptions{Sync: sync}); err != nil {
+		return err
+	}
+	d.cancelPendingWithLock(key)
+	return d.setExpiry(key, 0)
+}
+
+// WriteChunk writes the i'th chunk of a chunked write of the key, which
+// CommitChunks then commits. Every chunk but the last must be exactly
+// ChunkSize bytes. Chunks may be written concurrently, and in any order,
+// e.g. by parallel uploads; a chunk may be written again, to retry it.
+func (d *Diskv) WriteChunk(key string, i int, r io.Reader) (err error) {
+	key = d.normalizeKey(key)
+	span := d.startSpan("Write", key)
+	var buf []byte
+	defer func() {
+		span.SetAttribute("bytes", int64(len(buf)))
+		span.SetAttribute("chunk", i)
+		span.End(err)
+	}()
+
+	if d.ChunkSize <= 0 {
+		return errNoChunks
+	}
+	if _, err := d.checkWriteKey(key); err != nil {
+		return err
+	}
+	if i < 0 {
+		return fmt.Errorf("bad chunk index %d", i)
+	}
+
+	// The chunk is read before taking the lock, so that a slow reader
+	// doesn't hold up other operations.
+	buf, err = ioutil.ReadAll(io.LimitReader(r, d.ChunkSize+1))
+	if err != nil {
+		return fmt.Errorf("i/o copy: %s", err)
+	}
+	if int64(len(buf)) > d.ChunkSize {
+		return fmt.Errorf("chunk %d is larger than ChunkSize", i)
+	}
+	if d.MaxValueSize > 0 && int64(i)*d.ChunkSize+int64(len(buf)) > d.MaxValueSize {
+		return ErrValueTooLarge
+	}
+
+	d.writeThrottle.waitOp(Foreground)
+	defer d.writeThrottle.waitBytes(int64(len(buf)), Foreground)
+
+	d.mu.RLock() // chunks of other keys, or other chunks, may be written concurrently
+	defer d.mu.RUnlock()
+
+	upload := d.chunkDir(key) + uploadSuffix
+	if err := d.fs.MkdirAll(upload, d.PathPerm); err != nil {
+		return fmt.Errorf("ensure path: %s", err)
+	}
+	return d.writeChunk(upload, i, buf, false)
+}
+
+// CommitChunks makes the first n chunks written by WriteChunk, or by an
+// interrupted write, the key's value, replacing its current one.
+func (d *Diskv) CommitChunks(key string, n int) (err error) {
+	key = d.normalizeKey(key)
+	span := d.startSpan("Write", key)
+	defer func() { span.End(err) }()
+
+	if d.ChunkSize <= 0 {
+		return errNoChunks
+	}
+	pathKey, err := d.checkWriteKey(key)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.commitChunksWithLock(pathKey, n, WriteOptions{}); err != nil {
+		return err
+	}
+	d.cancelPendingWithLock(key)
+	return d.setExpiry(key, 0)
+}
+
+// walkChunked calls fn with every chunked key with the given prefix, in
+// order, and returns the number of keys it was called with.
+func (d *Diskv) walkChunked(prefix string, fn keyFunc) (int, error) {
+	if d.ChunkSize <= 0 {
+		return 0, nil
+	}
+	d.chunkMu.RLock()
+	var infos []*chunkFileInfo
+	for key, m := range d.chunked {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, &chunkFileInfo{m: m, mode: d.FilePerm})
+		}
+	}
+	d.chunkMu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].m.Key < infos[j].m.Key })
+	n := 0
+	for _, fi := range infos {
+		if d.expired(fi.m.Key) {
+			continue
+		}
+		if err := fn(fi.m.Key, fi); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// chunkFileInfo is the FileInfo of a chunked value.
+type chunkFileInfo struct {
+	m    chunkManifest
+	mode os.FileMode
+}
+
+func (fi *chunkFileInfo) Name() string       { return fi.m.Key }
+func (fi *chunkFileInfo) Size() int64        { return fi.m.Size }
+func (fi *chunkFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *chunkFileInfo) ModTime() time.Time { return fi.m.ModTime }
+func (fi *chunkFileInfo) IsDir() bool        { return false }
+func (fi *chunkFileInfo) Sys() interface{}   { return nil }
+
+// ReadStreamParallel is like ReadStream, but it reads up to concurrency
+// chunks of a chunked value at once, and yields them in order, which is
+// faster from storage that serves concurrent reads well, like RAID or NVMe
+// arrays. About concurrency chunks are buffered in memory at once. Values
+// which aren't chunked are read as by ReadStream, without direct. The
+// returned ReadCloser must be closed, to stop the reads ahead.
+func (d *Diskv) ReadStreamParallel(key string, concurrency int) (rc io.ReadCloser, err error) {
+	key = d.normalizeKey(key)
+	span := d.startSpan("ReadStream", key)
+	defer func() {
+		if err == nil {
+			d.recordAccess(key)
+		}
+		span.End(err)
+	}()
+
+	if err := d.authorize(OpRead, key); err != nil {
+		return nil, err
+	}
+
+	if val, ok := d.pendingValue(key); ok {
+		span.SetAttribute("pending", true)
+		return ioutil.NopCloser(bytes.NewReader(val)), nil
+	}
+	if d.expired(key) {
+		return nil, errExpired(key)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	pathKey := d.transform(key)
+	files, err := d.openChunkedFiles(pathKey)
+	if err != nil {
+		return nil, err
+	} else if files == nil {
+		return d.readStream(key, false, ReadOptions{}, span)
+	}
+	span.SetAttribute("concurrency", concurrency)
+
+	pr := newParallelReader(files, concurrency)
+	r := io.Reader(pr)
+	if d.readThrottle != nil {
+		r = &throttledReader{r: r, t: d.readThrottle, priority: Foreground}
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, pr}, nil
+}
+
+// openChunkedFiles opens the chunks of the key's chunked value, or returns
+// nil if it isn't chunked.
+func (d *Diskv) openChunkedFiles(pathKey *PathKey) ([]File, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if err := d.checkPath(pathKey); err != nil {
+		return nil, err
+	}
+	fi, ok := d.chunkStat(pathKey.originalKey)
+	if !ok {
+		return nil, nil
+	}
+	return d.openChunkFiles(d.chunkDir(pathKey.originalKey), fi.(*chunkFileInfo).m.Chunks)
+}
+
+// parallelReader reads chunk files concurrently, and yields them in order.
+// A chunk holds a slot in sem from when it starts being read until it's
+// yielded, which bounds both the reads in flight and the chunks buffered.
+type parallelReader struct {
+	results []chan chunkResult // one per chunk
+	sem     chan struct{}
+	stop    chan struct{}
+	once    sync.Once
+	cur     []byte
+	next    int // chunk to yield after cur
+	err     error
+}
+
+type chunkResult struct {
+	buf []byte
+	err error
+}
+
+func newParallelReader(files []File, concurrency int) *parallelReader {
+	pr := &parallelReader{
+		results: make([]chan chunkResult, len(files)),
+		sem:     make(chan struct{}, concurrency),
+		stop:    make(chan struct{}),
+	}
+	for i := range pr.results {
+		pr.results[i] = make(chan chunkResult, 1)
+	}
+	goLabeled("parallel-read", func() {
+		for i, f := range files {
+			select {
+			case pr.sem <- struct{}{}:
+			case <-pr.stop:
+				for _, f := range files[i:] {
+					f.Close() // error deliberately ignored
+				}
+				return
+			}
+			go func(i int, f File) {
+				buf, err := ioutil.ReadAll(f)
+				f.Close() // error deliberately ignored
+				pr.results[i] <- chunkResult{buf: buf, err: err}
+			}(i, f)
+		}
+	})
+	return pr
+}
+
+func (pr *parallelReader) Read(p []byte) (int, error) {
+	for len(pr.cur) == 0 {
+		if pr.err != nil {
+			return 0, pr.err
+		}
+		if pr.next >= len(pr.results) {
+			pr.err = io.EOF
+			continue
+		}
+		res := <-pr.results[pr.next]
+		<-pr.sem
+		pr.next++
+		pr.cur, pr.err = res.buf, res.err
+	}
+	n := copy(p, pr.cur)
+	pr.cur = pr.cur[n:]
+	return n, nil
+}
+
+// Close stops reading chunks ahead. Chunks already being read are finished
+// in the background.
+func (pr *parallelReader) Close() error {
+	pr.once.Do(func() { close(pr.stop) })
+	return nil
+}
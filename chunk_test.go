@@ -0,0 +1,240 @@
+package diskv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestChunks(t *testing.T) {
+	for name, fs := range map[string]FileSystem{"os": OSFileSystem(), "mem": NewMemFileSystem()} {
+		t.Run(name, func(t *testing.T) {
+			opts := []Option{WithChunkSize(1024), WithFileSystem(fs), WithCacheSizeMax(1 << 20), WithJournal(0, 0)}
+			d, err := NewWithOptions("test-data", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.EraseAll()
+
+			large := bytes.Repeat([]byte("0123456789"), 1000)
+			want := map[string]string{"large": string(large), "small": "small"}
+			for k, v := range want {
+				if err := d.WriteString(k, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := fs.Stat(filepath.Join(d.BasePath, "large")); !os.IsNotExist(err) {
+				t.Fatalf("chunked value stored in its own file: %v", err)
+			}
+			if names, err := readDirNames(fs, d.chunkDir("large")); err != nil || len(names) != 11 {
+				t.Fatalf("expected 10 chunks and a manifest, have %v (%v)", names, err)
+			}
+
+			check := func(d *Diskv) {
+				t.Helper()
+				for k, v := range want {
+					if have, err := d.Read(k); err != nil || string(have) != v {
+						t.Fatalf("%s: want %d bytes, have %d (%v)", k, len(v), len(have), err)
+					}
+				}
+				checkKeys(t, d.Keys(nil), want)
+				if fi, err := d.Stat("large"); err != nil || fi.Size() != int64(len(want["large"])) {
+					t.Fatalf("Stat: %v, %v", fi, err)
+				}
+			}
+			check(d)
+			d2, err := NewWithOptions("test-data", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(d2)
+
+			// Values move between chunks and files as they change size.
+			want["large"], want["small"] = "now small", string(large[:2000])
+			for _, k := range []string{"large", "small"} {
+				if err := d.WriteString(k, want[k]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := fs.Stat(d.chunkDir("large")); !os.IsNotExist(err) {
+				t.Fatalf("chunks of unchunked value not removed: %v", err)
+			}
+			check(d)
+
+			if err := d.Erase("small"); err != nil {
+				t.Fatal(err)
+			}
+			delete(want, "small")
+			if d.Has("small") {
+				t.Fatal("erased key still exists")
+			}
+			if _, err := fs.Stat(d.chunkDir("small")); !os.IsNotExist(err) {
+				t.Fatalf("chunks of erased value not removed: %v", err)
+			}
+		})
+	}
+}
+
+// failingReader yields n bytes of r, and then fails.
+type failingReader struct {
+	r io.Reader
+	n int
+}
+
+var errReaderFailed = errors.New("reader failed")
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	if fr.n <= 0 {
+		return 0, errReaderFailed
+	}
+	if len(p) > fr.n {
+		p = p[:fr.n]
+	}
+	n, err := fr.r.Read(p)
+	fr.n -= n
+	return n, err
+}
+
+func TestResumeWriteStream(t *testing.T) {
+	d, err := NewWithOptions("test-data", WithChunkSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	if err := d.WriteString("a", "old"); err != nil {
+		t.Fatal(err)
+	}
+	val := bytes.Repeat([]byte("abcdefghij"), 100)
+	if err := d.WriteStream("a", &failingReader{r: bytes.NewReader(val), n: 350}, false); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if have := d.ReadString("a"); have != "old" {
+		t.Fatalf("failed write replaced the value with %q", have)
+	}
+
+	offset, err := d.ResumeOffset("a")
+	if err != nil || offset != 300 {
+		t.Fatalf("ResumeOffset: want 300, have %d (%v)", offset, err)
+	}
+	if err := d.ResumeWriteStream("a", bytes.NewReader(val[450:]), 450, false); err != ErrResumeOffset {
+		t.Fatalf("want ErrResumeOffset, have %v", err)
+	}
+	if err := d.ResumeWriteStream("a", bytes.NewReader(val[400:]), 400, false); err != ErrResumeOffset {
+		t.Fatalf("want ErrResumeOffset, have %v", err)
+	}
+	if err := d.ResumeWriteStream("a", bytes.NewReader(val[200:]), 200, true); err != nil {
+		t.Fatal(err)
+	}
+	if have, err := d.Read("a"); err != nil || !bytes.Equal(have, val) {
+		t.Fatalf("want %d bytes, have %d (%v)", len(val), len(have), err)
+	}
+	if offset, err := d.ResumeOffset("a"); err != nil || offset != 0 {
+		t.Fatalf("ResumeOffset after commit: want 0, have %d (%v)", offset, err)
+	}
+}
+
+func TestWriteChunk(t *testing.T) {
+	d, err := NewWithOptions("test-data", WithChunkSize(100), WithIndex(&BTreeIndex{}, strLess))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	val := bytes.Repeat([]byte("0123456789"), 95)
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 9; i >= 0; i-- {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			end := (i + 1) * 100
+			if end > len(val) {
+				end = len(val)
+			}
+			errs <- d.WriteChunk("a", i, bytes.NewReader(val[i*100:end]))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.Has("a") {
+		t.Fatal("uncommitted chunks are visible")
+	}
+
+	if err := d.CommitChunks("a", 11); err == nil {
+		t.Fatal("expected an error committing a missing chunk")
+	}
+	if err := d.CommitChunks("a", 10); err != nil {
+		t.Fatal(err)
+	}
+	if have, err := d.Read("a"); err != nil || !bytes.Equal(have, val) {
+		t.Fatalf("want %d bytes, have %d (%v)", len(val), len(have), err)
+	}
+	if keys := d.Index.Keys("", 10); fmt.Sprint(keys) != "[a]" {
+		t.Fatalf("expected a in the index, have %v", keys)
+	}
+}
+
+func TestReadStreamParallel(t *testing.T) {
+	d, err := NewWithOptions("test-data", WithChunkSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	val := make([]byte, 10050)
+	for i := range val {
+		val[i] = byte(i % 251)
+	}
+	if err := d.Write("large", val); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteString("small", "small"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, concurrency := range []int{0, 1, 4, 200} {
+		rc, err := d.ReadStreamParallel("large", concurrency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || !bytes.Equal(have, val) {
+			t.Fatalf("concurrency %d: want %d bytes in order, have %d (%v)", concurrency, len(val), len(have), err)
+		}
+	}
+
+	// Closing early stops the reads ahead.
+	rc, err := d.ReadStreamParallel("large", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 150)
+	if _, err := io.ReadFull(rc, buf); err != nil || !bytes.Equal(buf, val[:150]) {
+		t.Fatalf("partial read: %v", err)
+	}
+	rc.Close()
+
+	rc, err = d.ReadStreamParallel("small", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := ioutil.ReadAll(rc); string(have) != "small" {
+		t.Fatalf("want small, have %q", have)
+	}
+	if _, err := d.ReadStreamParallel("missing", 4); !os.IsNotExist(err) {
+		t.Fatalf("want a not-exist error, have %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package diskv
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Clock is the source of time for TTLs, Retention, PruneTimestamped,
+// AsyncWrites, PersistIndex and Maintenance, and for the times diskv
+// records, e.g. in the journal. Options.Clock defaults to the system clock;
+// substitute a fake one to test code which depends on time
+// deterministically. Throttles, SyncPolicy latencies and IOTimeout always
+// use the system clock, as do the modification times of files.
+type Clock interface {
+	Now() time.Time
+
+	// AfterFunc calls f in its own goroutine once d has elapsed, unless the
+	// returned Timer is stopped first, like time.AfterFunc.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer started by Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the timer from firing, and returns false if it already
+	// had, or had been stopped, like time.Timer.Stop.
+	Stop() bool
+}
+
+// Rand is the source of randomness for Maintenance jitter and VerifySample.
+// Options.Rand defaults to the top-level functions of math/rand. It's used
+// concurrently, so a *rand.Rand must be guarded by a lock.
+type Rand interface {
+	Float64() float64
+	Intn(n int) int
+}
+
+// systemClock is the default Clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// globalRand is the default Rand.
+type globalRand struct{}
+
+func (globalRand) Float64() float64 { return rand.Float64() }
+func (globalRand) Intn(n int) int   { return rand.Intn(n) }
+
+// sleep waits for the interval to elapse on the Clock, and returns true, or
+// returns false as soon as stop is closed.
+func (d *Diskv) sleep(interval time.Duration, stop <-chan struct{}) bool {
+	fired := make(chan struct{})
+	timer := d.Clock.AfterFunc(interval, func() { close(fired) })
+	select {
+	case <-fired:
+		return true
+	case <-stop:
+		timer.Stop()
+		return false
+	}
+}
@@ -0,0 +1,177 @@
+package diskv
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when it's advanced. Timers
+// fire synchronously, in Advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	c    *fakeClock
+	at   time.Time
+	f    func()
+	done bool // fired or stopped
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	stopped := !t.done
+	t.done = true
+	return stopped
+}
+
+// Advance moves the time forward, and fires the timers which are then due,
+// in order.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due, pending []*fakeTimer
+	for _, t := range c.timers {
+		switch {
+		case t.done:
+		case !t.at.After(c.now):
+			t.done = true
+			due = append(due, t)
+		default:
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, t := range due {
+		t.f()
+	}
+}
+
+// waitForTimers waits until n timers are pending.
+func (c *fakeClock) waitForTimers(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		pending := 0
+		for _, t := range c.timers {
+			if !t.done {
+				pending++
+			}
+		}
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers pending, want %d", pending, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fixedRand is a Rand which always returns the same value.
+type fixedRand float64
+
+func (r fixedRand) Float64() float64 { return float64(r) }
+func (r fixedRand) Intn(n int) int   { return int(float64(r) * float64(n)) }
+
+func TestClockTTL(t *testing.T) {
+	clock := newFakeClock()
+	d, err := NewWithOptions("test-data", WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	if err := d.WriteWith("a", strings.NewReader("1"), WriteOptions{TTL: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour - time.Nanosecond)
+	if !d.Has("a") {
+		t.Fatal("key expired early")
+	}
+	clock.Advance(time.Nanosecond)
+	if d.Has("a") {
+		t.Fatal("key didn't expire")
+	}
+	if next, ok := d.NextExpiry(); !ok || !next.Equal(clock.Now()) {
+		t.Fatalf("NextExpiry: %v, %v", next, ok)
+	}
+}
+
+func TestClockAsyncWrites(t *testing.T) {
+	clock := newFakeClock()
+	d, err := NewWithOptions("test-data", WithClock(clock), WithAsyncWrites(time.Second, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	filename := d.completeFilename(d.transform("a"))
+	d.WriteString("a", "1")
+	clock.Advance(999 * time.Millisecond)
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("flushed early: %v", err)
+	}
+	clock.Advance(time.Millisecond)
+	if _, err := os.Stat(filename); err != nil {
+		t.Fatalf("not flushed: %v", err)
+	}
+}
+
+func TestClockMaintenanceJitter(t *testing.T) {
+	clock := newFakeClock()
+	ran := make(chan time.Time, 1)
+	d, err := NewWithOptions("test-data", WithClock(clock), WithRand(fixedRand(1)), WithMaintenance(Maintenance{
+		Tasks:  []MaintenanceTask{{Name: "t", Interval: time.Minute, Run: func(d *Diskv) error { return nil }}},
+		Jitter: 0.5,
+		OnRun:  func(string, time.Duration, error) { ran <- clock.Now() },
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+	defer d.Close()
+
+	start := clock.Now()
+	clock.waitForTimers(t, 1)
+	clock.Advance(89 * time.Second)
+	select {
+	case <-ran:
+		t.Fatal("task ran before its jittered interval")
+	default:
+	}
+	clock.Advance(time.Second)
+	if at := <-ran; at.Sub(start) != 90*time.Second {
+		t.Fatalf("task ran after %s, want 1m30s", at.Sub(start))
+	}
+}
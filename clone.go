@@ -0,0 +1,112 @@
+package diskv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CloneOptions control CloneTo.
+type CloneOptions struct {
+	// If Prefix is set, only the keys with it are cloned.
+	Prefix string
+
+	// If Progress is set, it's called once for every key, with the size of
+	// its value as stored in the source, once it's been cloned.
+	Progress func(key string, size int64)
+
+	// If Verify is set, the clone is compared with the source by Diff once
+	// every key has been copied, and CloneTo fails if they differ in any
+	// key with Prefix, e.g. because it was written during the clone.
+	Verify bool
+}
+
+// CloneTo copies every key of the store into a new store, opened with the
+// given Options, which may differ in anything but BasePath: e.g. in
+// Transform, Compression or FilePerm. It's the supported way to change
+// those settings, by cloning the store and then switching to the clone.
+// Values are streamed, without being cached by either store, and keep their
+// modification times and TTLs; files are created with the clone's FilePerm.
+// Keys which the clone already has are overwritten. The store is left
+// intact, and remains usable while it's cloned, but keys written during the
+// clone may or may not be copied. Reads and writes have Background
+// priority. It's authorized as OpRead of the prefix.
+func (d *Diskv) CloneTo(dst Options, opts CloneOptions) error {
+	prefix := d.normalizeKey(opts.Prefix)
+	if err := d.authorize(OpRead, prefix); err != nil {
+		return err
+	}
+	if filepath.Clean(dst.BasePath) == filepath.Clean(d.BasePath) {
+		return errors.New("clone: destination BasePath must differ")
+	}
+	clone, err := NewWithError(dst)
+	if err != nil {
+		return fmt.Errorf("clone: %s", err)
+	}
+
+	err = d.walkKeys(d.prefixPath(prefix), prefix, func(key string, info os.FileInfo) error {
+		cloned, err := d.cloneKey(clone, key, info)
+		if err != nil {
+			return fmt.Errorf("clone %s: %s", key, err)
+		}
+		if cloned && opts.Progress != nil {
+			opts.Progress(key, info.Size())
+		}
+		return nil
+	})
+	if err == nil || os.IsNotExist(err) {
+		err = nil
+		if opts.Verify {
+			err = d.verifyClone(clone, prefix)
+		}
+	}
+	if closeErr := clone.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("clone: close: %s", closeErr)
+	}
+	return err
+}
+
+// verifyClone compares the keys with the prefix in the clone with those
+// in the store.
+func (d *Diskv) verifyClone(clone *Diskv, prefix string) error {
+	added, removed, changed, err := d.Diff(clone)
+	if err != nil {
+		return fmt.Errorf("clone: verify: %s", err)
+	}
+	var differ []string
+	for _, keys := range [][]string{added, removed, changed} {
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				differ = append(differ, key)
+			}
+		}
+	}
+	if len(differ) > 0 {
+		return fmt.Errorf("clone: verify: %d keys differ, e.g. %q", len(differ), differ[0])
+	}
+	return nil
+}
+
+// cloneKey streams the value of the key, whose FileInfo is info, into the
+// clone, along with its modification time and TTL, and reports whether it
+// did. It's not an error if the key has been erased or has expired.
+func (d *Diskv) cloneKey(clone *Diskv, key string, info os.FileInfo) (bool, error) {
+	wopts := WriteOptions{Priority: Background, ModTime: info.ModTime()}
+	var ok bool
+	if wopts.TTL, ok = d.remainingTTL(key); !ok {
+		return false, nil
+	}
+
+	d.mu.RLock()
+	rc, err := d.readWithRLock(d.transform(key), ReadOptions{NoFill: true, Priority: Background})
+	d.mu.RUnlock()
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	return true, clone.WriteWith(key, rc, wopts)
+}
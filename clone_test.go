@@ -0,0 +1,70 @@
+package diskv
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCloneTo(t *testing.T) {
+	d, err := NewWithOptions("test-data", WithPack(8, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	want := map[string]string{}
+	for i := 0; i < 20; i++ {
+		key, val := fmt.Sprintf("key%02d", i), fmt.Sprintf("value %d", i)
+		want[key] = val
+		if err := d.WriteString(key, val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.WriteWith("ttl", strings.NewReader("expiring"), WriteOptions{TTL: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	want["ttl"] = "expiring"
+	if err := d.WriteWith("expired", strings.NewReader("gone"), WriteOptions{TTL: time.Nanosecond}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	dst := Options{
+		BasePath:    "test-data-clone",
+		Transform:   func(s string) []string { return []string{s[:3]} },
+		Compression: NewGzipCompression(),
+		FilePerm:    0600,
+	}
+	var progress int
+	if err := d.CloneTo(dst, CloneOptions{Verify: true, Progress: func(string, int64) { progress++ }}); err != nil {
+		t.Fatal(err)
+	}
+	if progress != len(want) {
+		t.Fatalf("Progress called %d times, want %d", progress, len(want))
+	}
+
+	clone, err := NewWithError(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.EraseAll()
+	checkKeys(t, clone.Keys(nil), want)
+	for k, v := range want {
+		if have := clone.ReadString(k); have != v {
+			t.Fatalf("%s: want %q, have %q", k, v, have)
+		}
+	}
+	orig, _ := d.Stat("key01")
+	if fi, err := clone.Stat("key01"); err != nil || !fi.ModTime().Equal(orig.ModTime()) || fi.Mode().Perm() != 0600 {
+		t.Fatalf("metadata not carried over: %v, %v", fi, err)
+	}
+	if ttl, ok := clone.expiry["ttl"]; !ok || ttl.Before(time.Now().Add(59*time.Minute)) {
+		t.Fatalf("TTL not carried over: %v", ttl)
+	}
+
+	if err := d.CloneTo(Options{BasePath: "test-data"}, CloneOptions{}); err == nil {
+		t.Fatal("expected cloning onto the store itself to fail")
+	}
+}
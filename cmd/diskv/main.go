@@ -0,0 +1,315 @@
+// Command diskv inspects and repairs diskv stores from the command line.
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+const usage = `usage: diskv [flags] <command> [args]
+
+commands:
+  get <key>                 write the value of key to stdout
+  put <key> [file]          store the contents of file (or stdin) under key
+  del <key>                 erase key
+  ls [prefix]               list keys, optionally with the given prefix
+  export [file]             write all keys as a tar archive to file (or stdout)
+  import [file]             read keys from a tar archive in file (or stdin)
+  verify                    read every key, and report unreadable ones and
+                            files the transform can't account for
+  fanout <max>              list directories with more than max entries
+  migrate <dir> [transform] copy every key to a new store rooted at dir, and
+                            record the transform (and -suffix) in its manifest
+
+flags:
+`
+
+func main() {
+	fs := flag.NewFlagSet("diskv", flag.ExitOnError)
+	var (
+		basePath    = fs.String("base", "diskv", "store BasePath")
+		transform   = fs.String("transform", "", "store transform, e.g. flat, block:2, prefix:2:2 or hash:sha1:2 (default: from the store's manifest, or flat)")
+		compression = fs.String("compression", "", "store compression: gzip, zlib, or empty for none")
+		dryRun      = fs.Bool("dry-run", false, "for migrate, only report what would be copied")
+		suffix      = fs.String("suffix", "", "for migrate, the FileSuffix of the new store, e.g. "+diskv.DataFileSuffix+" for the collision-free layout")
+		ignore      = fs.String("ignore", "", "comma-separated patterns of foreign files to ignore, e.g. .DS_Store,*.tmp")
+	)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	opts, err := storeOptions(*basePath, *transform, *compression)
+	if err != nil {
+		fatal(err)
+	}
+	if *ignore != "" {
+		opts.IgnorePatterns = strings.Split(*ignore, ",")
+	}
+	d, err := diskv.NewWithError(opts)
+	if err != nil {
+		fatal(err)
+	}
+
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "get":
+		err = get(d, args)
+	case "put":
+		err = put(d, args)
+	case "del":
+		err = del(d, args)
+	case "ls":
+		err = ls(d, args)
+	case "export":
+		err = export(d, args)
+	case "import":
+		err = importTar(d, args)
+	case "verify":
+		err = verify(d)
+	case "fanout":
+		err = fanout(d, args)
+	case "migrate":
+		err = migrate(d, args, *compression, *suffix, *dryRun)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "diskv: %s\n", err)
+	os.Exit(1)
+}
+
+func storeOptions(basePath, transform, compression string) (diskv.Options, error) {
+	opts := diskv.Options{BasePath: basePath}
+
+	m, err := diskv.ReadManifest(basePath)
+	if err != nil && !os.IsNotExist(err) {
+		return opts, err
+	}
+	opts.FileSuffix = m.FileSuffix
+	if transform == "" {
+		transform = m.Transform
+	}
+	if transform == "" {
+		transform = "flat"
+	}
+	t, err := diskv.ParseTransform(transform)
+	if err != nil {
+		return opts, err
+	}
+	opts.NamedTransform = t
+
+	switch compression {
+	case "":
+	case "gzip":
+		opts.Compression = diskv.NewGzipCompression()
+	case "zlib":
+		opts.Compression = diskv.NewZlibCompression()
+	default:
+		return opts, fmt.Errorf("unknown compression %q", compression)
+	}
+
+	return opts, nil
+}
+
+func get(d *diskv.Diskv, args []string) error {
+	if len(args) != 1 {
+		return errors.New("get: need exactly one key")
+	}
+	rc, err := d.ReadStreamWith(args[0], diskv.ReadStreamOptions{Direct: true})
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(os.Stdout, rc)
+	return err
+}
+
+func put(d *diskv.Diskv, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("put: need a key and an optional file")
+	}
+	r := io.Reader(os.Stdin)
+	if len(args) == 2 {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	return d.WriteStream(args[0], r, true)
+}
+
+func del(d *diskv.Diskv, args []string) error {
+	if len(args) != 1 {
+		return errors.New("del: need exactly one key")
+	}
+	return d.Erase(args[0])
+}
+
+func ls(d *diskv.Diskv, args []string) error {
+	var prefix string
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	for key := range d.KeysPrefix(prefix, nil) {
+		fmt.Println(key)
+	}
+	return nil
+}
+
+func export(d *diskv.Diskv, args []string) error {
+	w := io.Writer(os.Stdout)
+	if len(args) > 0 {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	tw := tar.NewWriter(w)
+	for key := range d.Keys(nil) {
+		val, err := d.ReadWith(key, diskv.ReadOptions{SkipCache: true, NoFill: true})
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: key, Mode: 0644, Size: int64(len(val))}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(val); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func importTar(d *diskv.Diskv, args []string) error {
+	r := io.Reader(os.Stdin)
+	if len(args) > 0 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := d.WriteStream(hdr.Name, tr, false); err != nil {
+			return fmt.Errorf("%s: %s", hdr.Name, err)
+		}
+	}
+}
+
+func verify(d *diskv.Diskv) error {
+	var keys, bad int
+	for key := range d.Keys(nil) {
+		keys++
+		rc, err := d.ReadStreamWith(key, diskv.ReadStreamOptions{Direct: true})
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, rc)
+			rc.Close()
+		}
+		if err != nil {
+			bad++
+			fmt.Fprintf(os.Stderr, "%s: %s\n", key, err)
+		}
+	}
+	foreign, err := d.CheckFiles()
+	if err != nil {
+		return err
+	}
+	for _, f := range foreign {
+		fmt.Fprintf(os.Stderr, "%s: foreign file: %s\n", f.Path, f.Err)
+	}
+	fmt.Printf("%d keys, %d unreadable, %d foreign files\n", keys, bad, len(foreign))
+	if bad > 0 {
+		return fmt.Errorf("%d unreadable keys", bad)
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("%d foreign files", len(foreign))
+	}
+	return nil
+}
+
+func fanout(d *diskv.Diskv, args []string) error {
+	if len(args) != 1 {
+		return errors.New("fanout: need a maximum number of entries")
+	}
+	max, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("fanout: %s", err)
+	}
+	over, err := d.FanOut(max)
+	if err != nil {
+		return err
+	}
+	for _, dir := range over {
+		fmt.Printf("%d\t%s\n", dir.Entries, filepath.Join(d.BasePath, dir.Path))
+	}
+	if len(over) > 0 {
+		fmt.Fprintf(os.Stderr, "consider re-sharding, e.g.: diskv -base %s migrate <new-dir> hashpath:sha1:2:2\n", d.BasePath)
+	}
+	return nil
+}
+
+func migrate(d *diskv.Diskv, args []string, compression, suffix string, dryRun bool) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("migrate: need a directory and an optional transform")
+	}
+	transform := "flat"
+	if len(args) == 2 {
+		transform = args[1]
+	}
+	opts, err := storeOptions(args[0], transform, compression)
+	if err != nil {
+		return err
+	}
+	opts.FileSuffix = suffix
+
+	dst, err := diskv.NewWithError(opts)
+	if err != nil {
+		return err
+	}
+	report, err := diskv.Migrate(d, dst, diskv.BulkOptions{DryRun: dryRun})
+	fmt.Printf("%d keys, %d bytes\n", report.Keys, report.Bytes)
+	if err != nil || dryRun {
+		return err
+	}
+	return dst.WriteManifest()
+}
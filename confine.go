@@ -0,0 +1,96 @@
+package diskv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PathCheck determines whether the paths that keys resolve to are checked
+// to be within BasePath, which protects servers that pass user-controlled
+// keys straight to diskv, e.g. from transforms which yield "..".
+type PathCheck int
+
+const (
+	// PathCheckOff, the default, performs no checks.
+	PathCheckOff PathCheck = iota
+
+	// PathCheckAudit reports every key which resolves outside BasePath to
+	// the PathViolationHandler, but lets the operation proceed. Use it to
+	// find out whether enforcing would break anything.
+	PathCheckAudit
+
+	// PathCheckEnforce makes every read, write and erase of a key which
+	// resolves outside BasePath fail with ErrInvalidKey, after reporting it
+	// to the PathViolationHandler.
+	PathCheckEnforce
+)
+
+// ErrInvalidKey is returned with PathCheckEnforce for keys which resolve to
+// a path outside BasePath.
+var ErrInvalidKey = errors.New("invalid key: path is outside BasePath")
+
+// checkPath checks, according to the PathCheck mode, that the file of the
+// given key resolves to a path within BasePath. The path is cleaned, and on
+// the OS filesystem, symbolic links in its existing part are evaluated.
+func (d *Diskv) checkPath(pathKey *PathKey) error {
+	if d.PathCheck == PathCheckOff {
+		return nil
+	}
+
+	filename := d.completeFilename(pathKey)
+	if d.resolvesWithin(filename) {
+		return nil
+	}
+	if d.PathViolationHandler != nil {
+		d.PathViolationHandler(pathKey.originalKey, filename)
+	}
+	if d.PathCheck == PathCheckEnforce {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// resolvesWithin returns true if filename is strictly within BasePath.
+func (d *Diskv) resolvesWithin(filename string) bool {
+	base, clean := filepath.Clean(d.BasePath), filepath.Clean(filename)
+	if !within(base, clean) {
+		return false
+	}
+	if _, ok := d.FileSystem.(osFS); !ok {
+		return true // no symbolic links
+	}
+	base, errBase := filepath.Abs(base)
+	clean, errClean := filepath.Abs(clean)
+	if errBase != nil || errClean != nil {
+		return false
+	}
+
+	resolvedBase, err := filepath.EvalSymlinks(base)
+	if err != nil {
+		return true // nothing below a missing BasePath can be a link
+	}
+
+	// Resolve the longest prefix of the path which exists.
+	existing, rest := clean, ""
+	for existing != base {
+		if resolved, err := filepath.EvalSymlinks(existing); err == nil {
+			return within(resolvedBase, filepath.Join(resolved, rest))
+		} else if !os.IsNotExist(err) {
+			return false
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+	return true
+}
+
+// within returns true if path is strictly below dir. Both must be clean.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,75 @@
+package diskv
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPathCheck(t *testing.T) {
+	defer os.RemoveAll("test-data")
+
+	// Keys are paths, which makes them easy to escape with.
+	transform := func(key string) *PathKey {
+		parts := strings.Split(key, "/")
+		return &PathKey{Path: parts[:len(parts)-1], FileName: parts[len(parts)-1]}
+	}
+	inverse := func(pathKey *PathKey) string {
+		return strings.Join(append(pathKey.Path, pathKey.FileName), "/")
+	}
+
+	var violations []string
+	d := New(Options{
+		BasePath:          filepath.Join("test-data", "base"),
+		AdvancedTransform: transform,
+		InverseTransform:  inverse,
+		PathCheck:         PathCheckEnforce,
+		PathViolationHandler: func(key, path string) {
+			violations = append(violations, key)
+		},
+	})
+
+	if err := d.WriteString("ok/a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.ReadString("ok/a"); got != "1" {
+		t.Fatalf("expected 1, got %q", got)
+	}
+	os.MkdirAll(filepath.Join("test-data", "outside"), 0777)
+	abs, _ := filepath.Abs(filepath.Join("test-data", "outside"))
+	if err := os.Symlink(abs, filepath.Join("test-data", "base", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"../escape", "ok/../../escape", "link/x", "ok/.."} {
+		if err := d.WriteString(key, "1"); err != ErrInvalidKey {
+			t.Errorf("write %q: expected ErrInvalidKey, got %v", key, err)
+		}
+		if _, err := d.Read(key); err != ErrInvalidKey {
+			t.Errorf("read %q: expected ErrInvalidKey, got %v", key, err)
+		}
+		if err := d.Erase(key); err != ErrInvalidKey {
+			t.Errorf("erase %q: expected ErrInvalidKey, got %v", key, err)
+		}
+		if d.Has(key) {
+			t.Errorf("has %q: expected false", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("test-data", "escape")); !os.IsNotExist(err) {
+		t.Fatalf("escaping file was written")
+	}
+	if len(violations) != 16 {
+		t.Fatalf("expected 16 violations reported, got %d", len(violations))
+	}
+
+	// Audit mode only reports.
+	violations = nil
+	d.PathCheck = PathCheckAudit
+	if err := d.WriteString("../escape", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 || violations[0] != "../escape" {
+		t.Fatalf("expected violation reported, got %v", violations)
+	}
+}
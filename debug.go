@@ -0,0 +1,170 @@
+package diskv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// dumpCacheKeys is how many cached keys DumpState lists.
+	dumpCacheKeys = 100
+
+	// dumpIndexKeys is how many keys DumpState lists from the start of
+	// the Index.
+	dumpIndexKeys = 10
+
+	// dumpLockWait is how long DumpState waits for the store's lock,
+	// before reporting it as held.
+	dumpLockWait = 100 * time.Millisecond
+)
+
+// DumpState writes a human-readable dump of the store's internal state to
+// w, to help diagnose problems like latency spikes: the options in effect,
+// the Stats, a summary of the cache contents, a sample of the Index,
+// whether the store's lock is held, and the stacks of the goroutines which
+// are running diskv code. It's meant for people, and its format may change.
+// Like Stats, it walks the store.
+func (d *Diskv) DumpState(w io.Writer) error {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "== options\n")
+	d.dumpOptions(&buf)
+
+	fmt.Fprintf(&buf, "\n== stats\n")
+	if s, err := d.Stats(); err != nil {
+		fmt.Fprintf(&buf, "error: %s\n", err)
+	} else {
+		v := reflect.ValueOf(s)
+		for i := 0; i < v.NumField(); i++ {
+			fmt.Fprintf(&buf, "%s: %+v\n", v.Type().Field(i).Name, v.Field(i).Interface())
+		}
+	}
+
+	fmt.Fprintf(&buf, "\n== lock\n")
+	fmt.Fprintf(&buf, "%s\n", d.lockStatus())
+
+	fmt.Fprintf(&buf, "\n== cache\n")
+	d.dumpCache(&buf)
+
+	fmt.Fprintf(&buf, "\n== index\n")
+	if d.Index == nil || d.IndexLess == nil {
+		fmt.Fprintf(&buf, "none\n")
+	} else {
+		fmt.Fprintf(&buf, "%T, first keys: %q\n", d.Index, d.Index.Keys("", dumpIndexKeys))
+	}
+
+	fmt.Fprintf(&buf, "\n== goroutines\n")
+	for _, g := range diskvGoroutines() {
+		fmt.Fprintf(&buf, "%s\n\n", g)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// dumpOptions writes one line per field of the Options. Functions, and
+// values which don't print usefully, are summarized.
+func (d *Diskv) dumpOptions(w io.Writer) {
+	v := reflect.ValueOf(d.Options)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		var s string
+		switch {
+		case f.Kind() == reflect.Func:
+			s = "unset"
+			if !f.IsNil() {
+				s = "set"
+			}
+		case (f.Kind() == reflect.Ptr || f.Kind() == reflect.Interface) && f.IsNil():
+			s = "nil"
+		case f.Kind() == reflect.Ptr || f.Kind() == reflect.Interface:
+			s = fmt.Sprintf("%T", f.Interface())
+		case f.Type() == reflect.TypeOf(Maintenance{}):
+			var tasks []string
+			for _, task := range d.Maintenance.Tasks {
+				tasks = append(tasks, fmt.Sprintf("%s every %s", task.Name, task.Interval))
+			}
+			s = fmt.Sprintf("tasks [%s], jitter %g", strings.Join(tasks, ", "), d.Maintenance.Jitter)
+		default:
+			s = fmt.Sprintf("%v", f.Interface())
+		}
+		fmt.Fprintf(w, "%s: %s\n", v.Type().Field(i).Name, s)
+	}
+}
+
+// lockStatus reports whether the store's lock can be taken for reading,
+// i.e. isn't held by a write, within dumpLockWait.
+func (d *Diskv) lockStatus() string {
+	began := time.Now()
+	acquired := make(chan struct{})
+	goLabeled("dump-state", func() {
+		d.mu.RLock()
+		d.mu.RUnlock()
+		close(acquired)
+	})
+	select {
+	case <-acquired:
+		return fmt.Sprintf("free: read lock taken in %s", time.Since(began))
+	case <-time.After(dumpLockWait):
+		return fmt.Sprintf("held: no read lock within %s, so a write, erase or maintenance task holds it", dumpLockWait)
+	}
+}
+
+// dumpCache writes the number and size of the cached values, and the keys
+// and sizes of the largest ones.
+func (d *Diskv) dumpCache(w io.Writer) {
+	type entry struct {
+		key  string
+		size int
+	}
+	d.cacheMu.RLock()
+	entries := make([]entry, 0, len(d.cache))
+	for key, val := range d.cache {
+		entries = append(entries, entry{key, len(val)})
+	}
+	size, max := d.cacheSize, d.CacheSizeMax
+	d.cacheMu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].size != entries[j].size {
+			return entries[i].size > entries[j].size
+		}
+		return entries[i].key < entries[j].key
+	})
+	fmt.Fprintf(w, "%d keys, %d of %d bytes\n", len(entries), size, max)
+	for i, e := range entries {
+		if i >= dumpCacheKeys {
+			fmt.Fprintf(w, "... and %d more\n", len(entries)-i)
+			break
+		}
+		fmt.Fprintf(w, "%q: %d bytes\n", e.key, e.size)
+	}
+}
+
+// diskvGoroutines returns the stacks of the goroutines, other than the
+// calling one, which are running code of this package.
+func diskvGoroutines() []string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	pkg := reflect.TypeOf((*Diskv)(nil)).Elem().PkgPath() + "."
+	var stacks []string
+	for i, g := range strings.Split(string(buf), "\n\n") {
+		if i > 0 && strings.Contains(g, pkg) { // the first is the caller's
+			stacks = append(stacks, strings.TrimSpace(g))
+		}
+	}
+	return stacks
+}
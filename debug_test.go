@@ -0,0 +1,43 @@
+package diskv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDumpState(t *testing.T) {
+	d, err := NewWithOptions("test-data", WithCacheSizeMax(1024), WithIndex(&BTreeIndex{}, strLess), WithOnFileCreated(func(string) error { return nil }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	d.WriteString("a", "12345")
+	d.Read("a")
+
+	var buf bytes.Buffer
+	if err := d.DumpState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"BasePath: test-data\n",
+		"OnFileCreated: set\n",
+		"Tracer: nil\n",
+		"Keys: 1\n",
+		"free: read lock taken",
+		"1 keys, 5 of 1024 bytes\n\"a\": 5 bytes\n",
+		`*diskv.BTreeIndex, first keys: ["a"]`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("dump lacks %q:\n%s", want, buf.String())
+		}
+	}
+
+	d.mu.Lock()
+	status := d.lockStatus()
+	d.mu.Unlock()
+	if !strings.HasPrefix(status, "held") {
+		t.Errorf("expected the lock to be reported as held, have %q", status)
+	}
+}
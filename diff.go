@@ -0,0 +1,155 @@
+package diskv
+
+import (
+	"crypto/sha256"
+	"os"
+	"sort"
+)
+
+// Diff compares the keys and values of the store with those of other, e.g.
+// to verify a migration or a replica. It returns the keys which only other
+// has, the keys which only the store has, and the keys whose values differ,
+// each sorted.
+//
+// If both stores have Options.Merkle set, their Merkle trees are compared,
+// and only the buckets whose hashes differ are looked into. Otherwise both
+// stores are walked, and the values of the keys they share are compared by
+// SHA-256, unless they have the same size as stored and the same
+// modification time, which Diff takes to mean that they're the same, like
+// rsync does. Values buffered by AsyncWrites aren't compared until they're
+// written. It's authorized as OpRead of the empty key, in both stores.
+func (d *Diskv) Diff(other *Diskv) (added, removed, changed []string, err error) {
+	if err := d.authorize(OpRead, ""); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := other.authorize(OpRead, ""); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if d.Merkle && other.Merkle {
+		added, removed, changed, err = d.diffMerkle(other)
+	} else {
+		added, removed, changed, err = d.diffWalk(other)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed, nil
+}
+
+// diffMerkle is Diff by Merkle tree.
+func (d *Diskv) diffMerkle(other *Diskv) (added, removed, changed []string, err error) {
+	ours, err := d.merkleCopy()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	theirs, err := other.merkleCopy()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for b := 0; b < merkleBuckets; b++ {
+		if ours.nodes[b+merkleBuckets] == theirs.nodes[b+merkleBuckets] {
+			continue
+		}
+		for key, ourHash := range ours.buckets[b] {
+			if theirHash, ok := theirs.buckets[b][key]; !ok {
+				removed = append(removed, key)
+			} else if theirHash != ourHash {
+				changed = append(changed, key)
+			}
+		}
+		for key := range theirs.buckets[b] {
+			if _, ok := ours.buckets[b][key]; !ok {
+				added = append(added, key)
+			}
+		}
+	}
+	return added, removed, changed, nil
+}
+
+// merkleCopy returns a copy of the store's Merkle tree, building it first
+// if necessary.
+func (d *Diskv) merkleCopy() (*merkleTree, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.buildMerkleWithLock(); err != nil {
+		return nil, err
+	}
+	t := &merkleTree{nodes: d.merkle.nodes}
+	for b, bucket := range d.merkle.buckets {
+		t.buckets[b] = make(map[string][sha256.Size]byte, len(bucket))
+		for key, h := range bucket {
+			t.buckets[b][key] = h
+		}
+	}
+	return t, nil
+}
+
+// diffWalk is Diff by walking both stores.
+func (d *Diskv) diffWalk(other *Diskv) (added, removed, changed []string, err error) {
+	ours, err := d.statAll()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	theirs, err := other.statAll()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for key, ourInfo := range ours {
+		theirInfo, ok := theirs[key]
+		if !ok {
+			removed = append(removed, key)
+			continue
+		}
+		if ourInfo.Size() == theirInfo.Size() && ourInfo.ModTime().Equal(theirInfo.ModTime()) {
+			continue
+		}
+
+		ourHash, ourErr := d.valueHash(key)
+		theirHash, theirErr := other.valueHash(key)
+		switch {
+		case os.IsNotExist(ourErr) && os.IsNotExist(theirErr):
+			// erased from both since the walk
+		case os.IsNotExist(ourErr) && theirErr == nil:
+			added = append(added, key)
+		case os.IsNotExist(theirErr) && ourErr == nil:
+			removed = append(removed, key)
+		case ourErr != nil:
+			return nil, nil, nil, ourErr
+		case theirErr != nil:
+			return nil, nil, nil, theirErr
+		case ourHash != theirHash:
+			changed = append(changed, key)
+		}
+	}
+	for key := range theirs {
+		if _, ok := ours[key]; !ok {
+			added = append(added, key)
+		}
+	}
+	return added, removed, changed, nil
+}
+
+// statAll walks the store, and returns the FileInfo of every key.
+func (d *Diskv) statAll() (map[string]os.FileInfo, error) {
+	infos := map[string]os.FileInfo{}
+	err := d.walkKeys(d.BasePath, "", func(key string, info os.FileInfo) error {
+		infos[key] = info
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// valueHash returns the SHA-256 of the value of the key, read from disk.
+func (d *Diskv) valueHash(key string) ([sha256.Size]byte, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.hashValueWithLock(key)
+}
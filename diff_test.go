@@ -0,0 +1,53 @@
+package diskv
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	for name, opts := range map[string][]Option{"walk": nil, "merkle": {WithMerkle()}} {
+		t.Run(name, func(t *testing.T) {
+			a, err := NewWithOptions("test-data-a", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer a.EraseAll()
+			b, err := NewWithOptions("test-data-b", append(opts, WithCompression(NewZlibCompression()))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.EraseAll()
+
+			for i := 0; i < 10; i++ {
+				key, val := fmt.Sprintf("key%d", i), fmt.Sprintf("value %d", i)
+				for _, d := range []*Diskv{a, b} {
+					if err := d.WriteString(key, val); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := a.WriteString("key2", "changed"); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.Erase("key1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.WriteString("new", "new"); err != nil {
+				t.Fatal(err)
+			}
+
+			added, removed, changed, err := a.Diff(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have := fmt.Sprint(added, removed, changed); have != "[new] [key1] [key2]" {
+				t.Fatalf("want [new] [key1] [key2], have %s", have)
+			}
+			added, removed, changed, err = b.Diff(b)
+			if err != nil || len(added)+len(removed)+len(changed) > 0 {
+				t.Fatalf("a store differs from itself: %v %v %v (%v)", added, removed, changed, err)
+			}
+		})
+	}
+}
@@ -5,15 +5,21 @@ package diskv
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 const (
@@ -35,9 +41,38 @@ var (
 	defaultAdvancedTransform = func(s string) *PathKey { return &PathKey{Path: []string{}, FileName: s} }
 	defaultInverseTransform  = func(pathKey *PathKey) string { return pathKey.FileName }
 	errCanceled              = errors.New("canceled")
-	errEmptyKey              = errors.New("empty key")
-	errBadKey                = errors.New("bad key")
 	errImportDirectory       = errors.New("can't import a directory")
+	errNoNamedTransform      = errors.New("no named transform")
+	errStale                 = errors.New("key changed during read")
+
+	// ErrEmptyKey is returned by writes of the empty key.
+	ErrEmptyKey = errors.New("empty key")
+
+	// ErrBadKey is returned by writes of keys which the transform maps to a
+	// path containing a path separator, or which are otherwise unusable.
+	ErrBadKey = errors.New("bad key")
+
+	// ErrKeyIsDirectory is returned by writes, imports and erases of keys
+	// whose file path is a directory, e.g. because a transform stores other
+	// keys below it; reads, Has and Stat treat such keys as nonexistent.
+	// Use FileSuffix to prevent such collisions.
+	ErrKeyIsDirectory = errors.New("key path is a directory")
+
+	// ErrKeyPathConflict is returned by writes and imports of keys which
+	// would be stored below an existing file, e.g. another key's, or, with
+	// FileSuffix, in a directory whose name ends with it.
+	ErrKeyPathConflict = errors.New("key path conflicts with a file")
+
+	// ErrKeyTooLong is returned by writes of keys longer than MaxKeyLen.
+	ErrKeyTooLong = errors.New("key too long")
+
+	// ErrValueTooLarge is returned by writes of values larger than
+	// MaxValueSize.
+	ErrValueTooLarge = errors.New("value too large")
+
+	// ErrIOTimeout is the Err of the *os.PathError returned by file
+	// operations which take longer than IOTimeout.
+	ErrIOTimeout = errors.New("i/o timeout")
 )
 
 // TransformFunction transforms a key into a slice of strings, with each
@@ -73,9 +108,21 @@ type Options struct {
 	Transform         TransformFunction
 	AdvancedTransform AdvancedTransformFunction
 	InverseTransform  InverseTransformFunction
-	CacheSizeMax      uint64 // bytes
-	PathPerm          os.FileMode
-	FilePerm          os.FileMode
+	// If NamedTransform is set, it overrides Transform, AdvancedTransform
+	// and InverseTransform.
+	NamedTransform *NamedTransform
+	// If CacheSizeMax is 0, caching is disabled entirely: reads go straight
+	// to disk, without consulting the cache or spawning goroutines to
+	// maintain it.
+	CacheSizeMax uint64 // bytes
+	// By default, only value bytes count toward CacheSizeMax. For
+	// workloads with many small values, set CacheEntryOverhead to a fixed
+	// per-entry cost, and CacheCountKeys to also count key bytes, so that
+	// CacheSizeMax better bounds actual memory use.
+	CacheEntryOverhead uint64 // bytes
+	CacheCountKeys     bool
+	PathPerm           os.FileMode
+	FilePerm           os.FileMode
 	// If TempDir is set, it will enable filesystem atomic writes by
 	// writing temporary files to that location before being moved
 	// to BasePath.
@@ -83,29 +130,408 @@ type Options struct {
 	// BasePath.
 	TempDir string
 
+	// If Index is set, it's initialized with IndexLess, which is then
+	// required, and kept up to date by every write and erase. Keys and
+	// KeysPrefix then list the keys in the Index, rather than walking the
+	// store. A MembershipIndex, like HashIndex, also answers Has and Len.
+	// A BTreeIndex with LexicalLess lists a prefix without paging through
+	// the keys before it.
 	Index     Index
 	IndexLess LessFunction
 
+	// If PersistIndex is set, the Index is saved to disk by SaveIndex and
+	// Close, and after IndexSaveInterval without writes, if it's set. New
+	// then loads it, rather than walking the store. Writes and erases
+	// invalidate the saved Index, so it's never stale, unless the store is
+	// modified by something other than this Diskv.
+	PersistIndex      bool
+	IndexSaveInterval time.Duration
+
+	// If BloomFilter is set, Has and ExistsMulti consult a Bloom filter over
+	// the keys first, so that most checks for keys which don't exist don't
+	// touch the filesystem. New builds the filter by walking the store,
+	// unless it was saved by Close or RebuildBloomTask. Writes keep it up
+	// to date, but erased keys stay in it, and it fills up, until it's
+	// rebuilt by RebuildBloom, or lazily by RebuildBloomTask. Like the
+	// Index, it's stale if the store is modified by something other than
+	// this Diskv.
+	BloomFilter bool
+
+	// Retention maps key prefixes to retention policies, which are
+	// enforced by EnforceRetention, and every RetentionInterval in the
+	// background, if it's set, until Close. Overlapping prefixes are
+	// enforced independently.
+	Retention         map[string]Retention
+	RetentionInterval time.Duration
+
+	// Maintenance runs tasks, like PurgeExpiredTask or VerifyTask, in the
+	// background on a schedule, until Close.
+	Maintenance Maintenance
+
 	Compression Compression
+
+	// If Tracer is set, Read, Write, Erase and Keys operations are reported
+	// to it as spans.
+	Tracer Tracer
+
+	// If SlowOpThreshold is positive, OnSlowOp is called after every Read,
+	// Write or Erase which took at least as long, e.g. to investigate tail
+	// latency, with where the time went. With SlowOpHashKeys, keys are
+	// hashed as by NewHashingTracer. OnSlowOp is called synchronously, once
+	// the operation is done, so it should be quick.
+	SlowOpThreshold time.Duration
+	OnSlowOp        func(op SlowOp)
+	SlowOpHashKeys  bool
+
+	// If SharedTombstones is set, every erase is recorded in a tombstone
+	// file in BasePath, which is checked before the cache is consulted, so
+	// that processes sharing a BasePath don't serve values from their
+	// caches which another process has erased. That costs a Stat per read
+	// or Has. Values another process overwrites may still be served stale.
+	SharedTombstones bool
+
+	// If CacheErrorHandler is set, it's called whenever a value read from
+	// disk can't be lazily cached, e.g. because it's larger than
+	// CacheSizeMax.
+	CacheErrorHandler func(key string, err error)
+
+	// By default, Read returns a copy of any cached value, so callers are
+	// free to modify it. If ZeroCopyReads is set and Compression is nil,
+	// Read returns the cached slice itself. Callers must then never modify
+	// the returned slice, or they'll corrupt the cache.
+	ZeroCopyReads bool
+
+	// If WalkConcurrency is greater than 1, Keys and KeysPrefix list up to
+	// that many directories concurrently, which speeds up enumerating huge
+	// stores, especially on high-latency filesystems. InverseTransform must
+	// then be safe for concurrent use.
+	WalkConcurrency int
+
+	// Quotas limits the keys with each prefix, on Write and Import. Writes
+	// which would exceed a Quota fail with a *QuotaError. Usage is computed
+	// when the store is created; use SetQuota to change quotas afterwards.
+	Quotas map[string]Quota
+
+	// ReadThrottle limits reads from disk; values served from the cache
+	// aren't throttled. WriteThrottle limits Write and Import. Both are
+	// shared by every caller of the store.
+	ReadThrottle  Throttle
+	WriteThrottle Throttle
+
+	// SyncPolicy adapts how writes with WriteOptions.Sync are made
+	// durable to the observed latency of syncs; see SyncDecision.
+	SyncPolicy SyncPolicy
+
+	// If TrackAccess is set, every read is counted in per-key AccessStats,
+	// which are persisted at most every AccessFlushInterval (default one
+	// minute). The cache then evicts the least recently read values first,
+	// and WarmCache can refill it after a restart.
+	TrackAccess         bool
+	AccessFlushInterval time.Duration
+
+	// PathCheck determines whether keys are checked to resolve to paths
+	// within BasePath before they're read, written or erased. Violations
+	// are reported to PathViolationHandler, if it's set, along with the
+	// offending path.
+	PathCheck            PathCheck
+	PathViolationHandler func(key, path string)
+
+	// Symlinks determines how symbolic links below BasePath are treated by
+	// reads and walks.
+	Symlinks SymlinkPolicy
+
+	// If Merkle is set, the store maintains a Merkle tree over its keys and
+	// the SHA-256 of their values, for RootHash and Prove. The tree is built
+	// by reading every value on the first call to either, and is then
+	// updated on every write and erase. Expired keys stay in the tree until
+	// they're purged.
+	Merkle bool
+
+	// If Journal is set, every write and erase is appended to a journal,
+	// which TailJournal returns as an ordered change feed. The journal is
+	// kept in segments of JournalSegmentSize bytes (default 16 MiB), and
+	// only the newest JournalSegments are retained, or all if it's zero.
+	Journal            bool
+	JournalSegmentSize int64
+	JournalSegments    int
+
+	// If PackThreshold is positive, values of at most that many bytes,
+	// before compression, are packed: appended to shared segment files of
+	// about PackSegmentSize bytes (default 64 MiB), rather than stored in
+	// files of their own. That saves inodes, partly used blocks and
+	// directory entries in stores of millions of tiny values. Packed values
+	// are located through an in-memory index, which is rebuilt by reading
+	// every segment when the store is created. Segments which are mostly
+	// overwritten or erased values are compacted automatically; see also
+	// Compact and CompactionStats. Larger values are stored in files of
+	// their own, as usual.
+	PackThreshold   int64
+	PackSegmentSize int64
+
+	// If ChunkSize is positive, values larger than it are stored as chunks
+	// of that many bytes, in files of their own, along with a manifest,
+	// rather than in one file. A chunked write which fails part way, e.g.
+	// because its reader does, keeps the chunks written so far, and can be
+	// continued by ResumeWriteStream from ResumeOffset; WriteChunk and
+	// CommitChunks let chunks be uploaded in parallel, and
+	// ReadStreamParallel reads them in parallel. Chunks are buffered in
+	// memory while they're written, and chunked values are never cached.
+	// It's incompatible with Compression, and must be larger than
+	// PackThreshold.
+	ChunkSize int64
+
+	// If SnapshotDir is set, along with Journal, every value written is
+	// also kept there, by hash, for Snapshot and RestoreToTime. It must be
+	// outside BasePath. Values are never removed from it.
+	SnapshotDir string
+
+	// If SecureErase is positive, Erase, EraseAll, Clear and the other ways
+	// of erasing values overwrite their data that many times, syncing after
+	// each pass, before removing it: alternately with zeros, ones and
+	// random bytes. Packed values are overwritten in their segment, if the
+	// FileSystem's files implement io.WriterAt. A failed overwrite fails
+	// the erase, which leaves the value in place. It's best effort: it
+	// doesn't reach the copies which overwrites, compaction, the cache,
+	// TempDir or the filesystem itself leave behind, and it's ineffective
+	// on SSDs and copy-on-write or journaling filesystems. It's
+	// incompatible with SnapshotDir, which keeps every value.
+	SecureErase int
+
+	// If StrictKeys is set, walks check that every file is where the
+	// transform would store its key: that InverseTransform yields a key for
+	// it, and that the key transforms back to the file's path. Files which
+	// fail the check, e.g. because of a misconfigured transform, are
+	// reported to ForeignFileHandler, if it's set, and never yielded as
+	// keys. The handler may be called concurrently with WalkConcurrency.
+	StrictKeys         bool
+	ForeignFileHandler func(path string, err error)
+
+	// IgnorePatterns are filepath.Match patterns, like ".DS_Store", "*.tmp"
+	// or "lost+found", for files and directories which other tools drop
+	// into the store. They're matched against the name of every file and
+	// directory below BasePath; matching files are never yielded as keys,
+	// or counted by e.g. StatPrefix, and matching directories are skipped.
+	IgnorePatterns []string
+
+	// KeysBuffer is the capacity of the channels returned by Keys and
+	// KeysPrefix, i.e. how many keys the walk may run ahead of the
+	// consumer. The walk holds no locks while it waits for the consumer.
+	KeysBuffer int
+
+	// If SortedKeys is set, Keys and KeysPrefix yield keys in lexicographic
+	// order. Keys are then collected in memory before the first one is
+	// yielded.
+	SortedKeys bool
+
+	// If CacheAdmission is set, values are only cached when it admits them.
+	CacheAdmission AdmissionPolicy
+
+	// If CacheMaxValueSize is positive, values larger than it, as stored
+	// on disk, are never cached, however much room there is in the cache.
+	// Reads of them don't buffer them in memory to fill the cache, either.
+	CacheMaxValueSize uint64 // bytes
+
+	// PrefetchConcurrency is the number of keys Prefetch reads at once,
+	// across every call (default 4).
+	PrefetchConcurrency int
+
+	// By default, a direct ReadStream evicts the key from the cache in the
+	// background. If SynchronousCache is set, it does so before returning,
+	// and no goroutines are started on the read path. Every cache change is
+	// then visible as soon as the call which caused it returns, which makes
+	// tests deterministic.
+	SynchronousCache bool
+
+	// If MaxKeyLen is positive, writes of longer keys fail with
+	// ErrKeyTooLong. If MaxValueSize is positive, writes of larger values
+	// fail with ErrValueTooLarge, and nothing is written.
+	MaxKeyLen    int
+	MaxValueSize int64 // bytes
+
+	// If CaseInsensitive is set, keys are lowercased on every write and
+	// lookup, so keys differing only in case refer to the same value. Keys
+	// written before it was set may collide; see CaseCollisions.
+	CaseInsensitive bool
+
+	// If FileSuffix is set, conventionally to DataFileSuffix, it's
+	// appended to the name of every key's file, so that files can never
+	// collide with the directories a transform creates: the layout is
+	// LayoutSuffixed. Files without it aren't keys. It must be set
+	// consistently for the life of the store; NewWithError checks it
+	// against the manifest, if there is one. Use Migrate to convert an
+	// existing store.
+	FileSuffix string
+
+	// If FileSystem is set, it's used instead of the OS filesystem.
+	FileSystem FileSystem
+
+	// If IOTimeout is positive, every operation on the FileSystem, and on
+	// its files, which takes longer fails with ErrIOTimeout, so that e.g. a
+	// hung network mount fails requests, and releases the store's lock,
+	// rather than blocking it forever. The operation itself is abandoned
+	// in a goroutine of its own, not canceled. It costs a goroutine, and
+	// for reads and writes a copy of the data, per operation.
+	IOTimeout time.Duration
+
+	// If AsyncWrites is set, Write returns as soon as the value is buffered
+	// in memory, and it's written to disk in the background, at most
+	// AsyncWriteDelay (default 10ms) later, or by Flush or Close. Repeated
+	// writes of a key within the delay are coalesced into one. Read,
+	// ReadStream and Has see a buffered value as soon as Write returns;
+	// Keys and the Index don't until it's written. Errors writing buffered
+	// values are reported to AsyncWriteErrorHandler, if it's set.
+	// WriteStream and WriteWith remain synchronous. Erase drops a buffered
+	// value, so that it's never written; a later Write buffers anew.
+	AsyncWrites            bool
+	AsyncWriteDelay        time.Duration
+	AsyncWriteErrorHandler func(key string, err error)
+
+	// If WriteLogThreshold is positive, Write and WriteString append values
+	// of at most that many bytes to a write-ahead log in BasePath, rather
+	// than writing them to files of their own. Writes waiting at the same
+	// time share a single append and sync of the log, which makes many
+	// small writes much faster, especially on spinning disks. Write returns
+	// once the log is synced, and fails as a synchronous write would, e.g.
+	// if it would exceed a Quota. Each value is then written to its own
+	// file, and synced, in the background, like with AsyncWrites, and Flush
+	// and Close empty the log once every value in it is. New replays a log
+	// left behind by a crash. It's incompatible with AsyncWrites.
+	WriteLogThreshold int64 // bytes
+
+	// If Authorize is set, it's consulted before every operation on keys,
+	// with the key or prefix, and the operation fails with its error if
+	// it returns one; see Operation. Has reports false, and Keys and
+	// KeysPrefix yield nothing, if it's denied. Operations on the store as
+	// a whole, like Stats, Compact and the maintenance tasks, aren't
+	// authorized. It must be safe for concurrent use.
+	Authorize func(op Operation, key string) error
+
+	// Clock and Rand, if set, replace the system clock and math/rand, e.g.
+	// to test TTLs, Retention and Maintenance deterministically.
+	Clock Clock
+	Rand  Rand
+
+	// If OnFileCreated is set, it's called with the path of every directory
+	// diskv creates, and of every file it writes, e.g. to set SELinux
+	// labels, ACLs or extended attributes. With TempDir, it's called on the
+	// temporary file, before it becomes visible in BasePath. If it returns
+	// an error, the write fails. It's called with the store's lock held, so
+	// it mustn't use the store.
+	OnFileCreated func(path string) error
 }
 
 // Diskv implements the Diskv interface. You shouldn't construct Diskv
 // structures directly; instead, use the New constructor.
 type Diskv struct {
+	cacheHits   uint64 // atomic; first, so that it's 64-bit aligned
+	cacheMisses uint64 // atomic
+	gen         uint64 // atomic; see Generation
+
 	Options
-	mu        sync.RWMutex
+	mu sync.RWMutex
+
+	// The cache has a lock of its own, so that cache hits never wait for
+	// d.mu. It's changed with both held, d.mu first, and read with either.
+	cacheMu   sync.RWMutex
 	cache     map[string][]byte
 	cacheSize uint64
+	fs        FileSystem
+	quotas    map[string]*quotaState
+	lastDir   string // see ensurePathWithLock
+
+	readThrottle  *throttle
+	writeThrottle *throttle
+	syncTuner     *syncTuner
+
+	expiryMu     sync.RWMutex
+	expiry       map[string]time.Time
+	expiryHeap   expiryHeap
+	expiryLogged int // changes in the expiry log
+
+	pinMu sync.RWMutex
+	pins  map[string]struct{} // see Pin
+
+	tombstones *tombstones // see SharedTombstones
+
+	accessMu      sync.Mutex
+	access        map[string]AccessStat
+	accessDirty   bool
+	accessFlushed time.Time
+
+	merkle *merkleTree // nil until built; see buildMerkleWithLock
+
+	journalSeq     uint64 // of the last entry
+	journalSegment uint64 // first sequence number of the current segment
+	journalSize    int64  // of the current segment
+
+	packMu          sync.RWMutex // guards the fields below; see PackThreshold
+	packIndex       map[string]packLoc
+	packSegments    map[uint64]*packSegment
+	packActive      uint64 // the segment appended to
+	packCompactions int
+
+	chunkMu sync.RWMutex // guards the field below; see ChunkSize
+	chunked map[string]chunkManifest
+
+	indexSaved bool  // the index file matches the Index
+	indexTimer Timer // see indexChangedWithLock
+
+	pendingMu    sync.Mutex
+	pending      map[string]*pendingWrite // see AsyncWrites
+	pendingTimer Timer                    // see writeAsync
+
+	writeLog *writeLog // nil unless WriteLogThreshold is positive
+
+	tasksMu sync.Mutex
+	tasks   []TaskStats // of Maintenance.Tasks, in order
+
+	bloom        *bloomFilter // nil until built; see BloomFilter
+	bloomWritten []string     // keys written during a rebuild
+	bloomSaved   bool         // the Bloom filter file matches bloom
+	bloomBuildMu sync.Mutex   // serializes rebuilds
+
+	seqMu sync.Mutex
+	seqs  map[string]uint64 // last values; see NextSequence
+
+	prefetchSlots chan struct{} // of PrefetchConcurrency; see Prefetch
+
+	stop       chan struct{} // closed by Close, to stop background work
+	closed     bool          // by Close; no more background work starts
+	background sync.WaitGroup
 }
 
 // New returns an initialized Diskv structure, ready to use.
 // If the path identified by baseDir already contains data,
 // it will be accessible, but not yet cached.
 func New(o Options) *Diskv {
+	if o.FileSystem == nil {
+		o.FileSystem = osFS{}
+	}
+	return newWithFS(o)
+}
+
+// NewMem returns an initialized Diskv structure, like New, but all of its
+// data is held in memory rather than on disk. It's intended for tests of
+// code that uses diskv, which then don't touch the filesystem. Each store
+// returned by NewMem is independent. It's equivalent to New with a
+// FileSystem from NewMemFileSystem.
+func NewMem(o Options) *Diskv {
+	o.FileSystem = newMemFS()
+	return newWithFS(o)
+}
+
+// newWithFS returns an initialized Diskv structure backed by o.FileSystem.
+func newWithFS(o Options) *Diskv {
 	if o.BasePath == "" {
 		o.BasePath = defaultBasePath
 	}
 
+	if o.NamedTransform != nil {
+		o.Transform = nil
+		o.AdvancedTransform = o.NamedTransform.Transform
+		o.InverseTransform = o.NamedTransform.InverseTransform
+	}
+
 	if o.AdvancedTransform == nil {
 		if o.Transform == nil {
 			o.AdvancedTransform = defaultAdvancedTransform
@@ -127,20 +553,153 @@ func New(o Options) *Diskv {
 	if o.FilePerm == 0 {
 		o.FilePerm = defaultFilePerm
 	}
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+	if o.Rand == nil {
+		o.Rand = globalRand{}
+	}
+	if o.PrefetchConcurrency <= 0 {
+		o.PrefetchConcurrency = defaultPrefetchConcurrency
+	}
 
 	d := &Diskv{
 		Options:   o,
 		cache:     map[string][]byte{},
 		cacheSize: 0,
+		fs:        newTimeoutFS(o.FileSystem, o.IOTimeout),
+
+		readThrottle:  newThrottle(o.ReadThrottle),
+		writeThrottle: newThrottle(o.WriteThrottle),
+		syncTuner:     newSyncTuner(o.SyncPolicy, o.Clock),
+		prefetchSlots: make(chan struct{}, o.PrefetchConcurrency),
+	}
+
+	d.loadExpiry()
+	d.loadPins()
+	d.loadTombstones()
+	if d.TrackAccess {
+		d.loadAccess()
+	}
+	if d.Journal {
+		d.loadJournal()
+	}
+	if d.PackThreshold > 0 {
+		d.loadPack()
+	}
+	if d.ChunkSize > 0 {
+		d.loadChunks()
+	}
+	if d.WriteLogThreshold > 0 {
+		d.writeLog = newWriteLog()
+		d.mu.Lock()
+		d.replayWriteLogWithLock() // error deliberately ignored; replayed again next time
+		d.mu.Unlock()
+	}
+	d.initQuotas()
+	if d.BloomFilter && !d.loadBloom() {
+		d.RebuildBloom() // error deliberately ignored; Has starts without a filter
 	}
 
 	if d.Index != nil && d.IndexLess != nil {
-		d.Index.Initialize(d.IndexLess, d.Keys(nil))
+		if !d.PersistIndex || !d.loadIndex() {
+			d.initializeIndex()
+		}
 	}
+	d.startRetention()
+	d.startMaintenance()
 
 	return d
 }
 
+// NewWithError is like New, but it validates the options first, and returns
+// an error instead of panicking or misbehaving later on. It rejects
+// incompatible options, and a BasePath that exists but isn't an accessible
+// directory.
+func NewWithError(o Options) (*Diskv, error) {
+	if err := validateOptions(o); err != nil {
+		return nil, err
+	}
+	return New(o), nil
+}
+
+// validateOptions checks the options for conflicts New would either panic on
+// or silently accept.
+func validateOptions(o Options) error {
+	switch {
+	case o.AdvancedTransform != nil && o.InverseTransform == nil && o.NamedTransform == nil:
+		return errors.New("AdvancedTransform requires InverseTransform")
+	case o.Transform != nil && o.AdvancedTransform != nil:
+		return errors.New("Transform and AdvancedTransform are mutually exclusive")
+	case o.NamedTransform != nil && (o.NamedTransform.Transform == nil || o.NamedTransform.InverseTransform == nil):
+		return errors.New("NamedTransform requires Transform and InverseTransform")
+	case o.Index != nil && o.IndexLess == nil:
+		return errors.New("Index requires IndexLess")
+	case o.Index == nil && o.IndexLess != nil:
+		return errors.New("IndexLess requires Index")
+	case o.CacheAdmission != nil && o.CacheSizeMax == 0:
+		return errors.New("CacheAdmission requires CacheSizeMax")
+	case o.CacheMaxValueSize > 0 && o.CacheSizeMax == 0:
+		return errors.New("CacheMaxValueSize requires CacheSizeMax")
+	case o.ZeroCopyReads && o.CacheSizeMax == 0:
+		return errors.New("ZeroCopyReads requires CacheSizeMax")
+	case o.SharedTombstones && o.CacheSizeMax == 0:
+		return errors.New("SharedTombstones requires CacheSizeMax")
+	case o.ZeroCopyReads && o.Compression != nil:
+		return errors.New("ZeroCopyReads is incompatible with Compression")
+	case o.WriteLogThreshold > 0 && o.AsyncWrites:
+		return errors.New("WriteLogThreshold is incompatible with AsyncWrites")
+	case o.ChunkSize > 0 && o.Compression != nil:
+		return errors.New("ChunkSize is incompatible with Compression")
+	case o.ChunkSize > 0 && o.PackThreshold >= o.ChunkSize:
+		return errors.New("ChunkSize must be larger than PackThreshold")
+	case o.SnapshotDir != "" && !o.Journal:
+		return errors.New("SnapshotDir requires Journal")
+	case o.SecureErase > 0 && o.SnapshotDir != "":
+		return errors.New("SecureErase is incompatible with SnapshotDir")
+	case strings.ContainsRune(o.FileSuffix, os.PathSeparator):
+		return errors.New("FileSuffix must not contain a path separator")
+	}
+
+	for _, pattern := range o.IgnorePatterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("IgnorePatterns: %q: %s", pattern, err)
+		}
+	}
+
+	basePath := o.BasePath
+	if basePath == "" {
+		basePath = defaultBasePath
+	}
+	if o.TempDir != "" && filepath.Clean(o.TempDir) == filepath.Clean(basePath) {
+		return errors.New("TempDir must not be BasePath")
+	}
+	if o.SnapshotDir != "" && (filepath.Clean(o.SnapshotDir) == filepath.Clean(basePath) || within(basePath, o.SnapshotDir)) {
+		return errors.New("SnapshotDir must be outside BasePath")
+	}
+	fs := o.FileSystem
+	if fs == nil {
+		fs = osFS{}
+	}
+	fi, err := fs.Stat(basePath)
+	if os.IsNotExist(err) {
+		return nil // created on first write
+	} else if err != nil {
+		return fmt.Errorf("BasePath: %s", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("BasePath: %s is not a directory", basePath)
+	}
+	f, err := fs.Open(basePath)
+	if err != nil {
+		return fmt.Errorf("BasePath: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return checkManifest(fs, basePath, o)
+}
+
 // convertToAdvancedTransform takes a classic Transform function and
 // converts it to the new AdvancedTransform
 func convertToAdvancedTransform(oldFunc func(s string) []string) AdvancedTransformFunction {
@@ -152,7 +711,15 @@ func convertToAdvancedTransform(oldFunc func(s string) []string) AdvancedTransfo
 // Write synchronously writes the key-value pair to disk, making it immediately
 // available for reads. Write relies on the filesystem to perform an eventual
 // sync to physical media. If you need stronger guarantees, see WriteStream.
+// With AsyncWrites, Write only buffers the value; see Options.AsyncWrites.
+// With WriteLogThreshold, it may only log it; see Options.WriteLogThreshold.
 func (d *Diskv) Write(key string, val []byte) error {
+	if d.AsyncWrites {
+		return d.writeAsync(key, val)
+	}
+	if d.WriteLogThreshold > 0 && int64(len(val)) <= d.WriteLogThreshold {
+		return d.writeLogged(key, val)
+	}
 	return d.WriteStream(key, bytes.NewReader(val), false)
 }
 
@@ -161,6 +728,14 @@ func (d *Diskv) WriteString(key string, val string) error {
 	return d.Write(key, []byte(val))
 }
 
+// normalizeKey returns the canonical form of the given key.
+func (d *Diskv) normalizeKey(key string) string {
+	if d.CaseInsensitive {
+		return strings.ToLower(key)
+	}
+	return key
+}
+
 func (d *Diskv) transform(key string) (pathKey *PathKey) {
 	pathKey = d.AdvancedTransform(key)
 	pathKey.originalKey = key
@@ -173,8 +748,123 @@ func (d *Diskv) transform(key string) (pathKey *PathKey) {
 //
 // bytes.Buffer provides io.Reader semantics for basic data types.
 func (d *Diskv) WriteStream(key string, r io.Reader, sync bool) error {
+	return d.WriteWith(key, r, WriteOptions{Sync: sync})
+}
+
+// WriteStreamN is like WriteStream, but it also returns the number of bytes
+// of the value which were written; see WriteWithN.
+func (d *Diskv) WriteStreamN(key string, r io.Reader, sync bool) (int64, error) {
+	return d.WriteWithN(key, r, WriteOptions{Sync: sync})
+}
+
+// WriteOptions override the store's configuration for a single write.
+type WriteOptions struct {
+	// FilePerm overrides Options.FilePerm, if it's non-zero.
+	FilePerm os.FileMode
+
+	// If Sync is true, the file is explicitly synced as soon as it's
+	// written, or as directed by Options.SyncPolicy.
+	Sync bool
+
+	// If TTL is positive, the key expires once it has elapsed: reads,
+	// Has and Keys treat it as nonexistent, and PurgeExpired erases it.
+	// Writing the key again without a TTL clears its expiry.
+	TTL time.Duration
+
+	// Priority is the class of the write, for WriteThrottle.
+	Priority Priority
+
+	// If ModTime isn't zero, it's the value's modification time, rather
+	// than the time of the write, e.g. to preserve it when copying values.
+	// For values stored in files of their own, it requires a FileSystem
+	// which implements Chtimes.
+	ModTime time.Time
+
+	phases     *phaseTimer // see OnSlowOp
+	groupSync  *string     // set to the file to sync with SyncGroup, if any
+	copyBuffer []byte      // for copying the value, if set; see WriteFrom
+}
+
+// modTime returns the modification time of a value written with opts.
+func (d *Diskv) modTime(opts WriteOptions) time.Time {
+	if !opts.ModTime.IsZero() {
+		return opts.ModTime
+	}
+	return d.Clock.Now()
+}
+
+// WriteWith writes the data represented by the io.Reader to the disk, under
+// the provided key, as directed by the given WriteOptions.
+func (d *Diskv) WriteWith(key string, r io.Reader, opts WriteOptions) error {
+	_, err := d.WriteWithN(key, r, opts)
+	return err
+}
+
+// WriteWithN is like WriteWith, but it also returns the number of bytes of
+// the value which were written, i.e. read from r, before compression. If the
+// write fails, nothing is written, and it returns 0. Comparing the count
+// with the expected length detects readers which were cut short.
+func (d *Diskv) WriteWithN(key string, r io.Reader, opts WriteOptions) (n int64, err error) {
+	key = d.normalizeKey(key)
+	span := d.startSpan("Write", key)
+	cr := &countingReader{r: r}
+	defer func() {
+		span.SetAttribute("bytes", cr.n)
+		span.End(err)
+	}()
+
+	pathKey, err := d.checkWriteKey(key)
+	if err != nil {
+		return 0, err
+	}
+	if d.MaxValueSize > 0 {
+		cr.r = &maxSizeReader{r: cr.r, n: d.MaxValueSize}
+	}
+
+	// Bytes are charged once the lock is released, so that a throttled
+	// write doesn't hold up other operations.
+	opts.phases = phases(span)
+	d.writeThrottle.waitOp(opts.Priority)
+	defer func() { d.writeThrottle.waitBytes(cr.n, opts.Priority) }()
+	opts.phases.mark("throttle")
+
+	if opts.Sync && d.syncTuner != nil {
+		opts.groupSync = new(string)
+	}
+	err = func() error {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		opts.phases.mark("lock")
+
+		if err := d.writeStreamWithLock(pathKey, cr, opts); err != nil {
+			return err
+		}
+		d.cancelPendingWithLock(key)
+		return d.setExpiry(key, opts.TTL)
+	}()
+
+	// A group commit happens once the lock is released, so that other
+	// operations can proceed while it waits.
+	if err == nil && opts.groupSync != nil && *opts.groupSync != "" {
+		err = d.syncTuner.groupSync(d.fs, *opts.groupSync)
+		opts.phases.mark("sync")
+	}
+	if err != nil {
+		return 0, err
+	}
+	return cr.n, nil
+}
+
+// checkWriteKey checks that the key may be written, and returns its PathKey.
+func (d *Diskv) checkWriteKey(key string) (*PathKey, error) {
+	if err := d.authorize(OpWrite, key); err != nil {
+		return nil, err
+	}
 	if len(key) <= 0 {
-		return errEmptyKey
+		return nil, ErrEmptyKey
+	}
+	if d.MaxKeyLen > 0 && len(key) > d.MaxKeyLen {
+		return nil, ErrKeyTooLong
 	}
 
 	pathKey := d.transform(key)
@@ -182,107 +872,259 @@ func (d *Diskv) WriteStream(key string, r io.Reader, sync bool) error {
 	// Ensure keys cannot evaluate to paths that would not exist
 	for _, pathPart := range pathKey.Path {
 		if strings.ContainsRune(pathPart, os.PathSeparator) {
-			return errBadKey
+			return nil, ErrBadKey
 		}
 	}
 
 	if strings.ContainsRune(pathKey.FileName, os.PathSeparator) {
-		return errBadKey
+		return nil, ErrBadKey
 	}
-
-	d.mu.Lock()
-	defer d.mu.Unlock()
-
-	return d.writeStreamWithLock(pathKey, r, sync)
+	if d.FileSuffix != "" {
+		for _, pathPart := range pathKey.Path {
+			if strings.HasSuffix(pathPart, d.FileSuffix) {
+				return nil, ErrKeyPathConflict
+			}
+		}
+	}
+	if err := d.checkPath(pathKey); err != nil {
+		return nil, err
+	}
+	return pathKey, nil
 }
 
 // createKeyFileWithLock either creates the key file directly, or
 // creates a temporary file in TempDir if it is set.
-func (d *Diskv) createKeyFileWithLock(pathKey *PathKey) (*os.File, error) {
+func (d *Diskv) createKeyFileWithLock(pathKey *PathKey, perm os.FileMode) (File, error) {
 	if d.TempDir != "" {
-		if err := os.MkdirAll(d.TempDir, d.PathPerm); err != nil {
+		if err := d.fs.MkdirAll(d.TempDir, d.PathPerm); err != nil {
 			return nil, fmt.Errorf("temp mkdir: %s", err)
 		}
-		f, err := ioutil.TempFile(d.TempDir, "")
+		f, err := d.fs.TempFile(d.TempDir, "")
 		if err != nil {
 			return nil, fmt.Errorf("temp file: %s", err)
 		}
 
-		if err := os.Chmod(f.Name(), d.FilePerm); err != nil {
-			f.Close()           // error deliberately ignored
-			os.Remove(f.Name()) // error deliberately ignored
+		if err := d.fs.Chmod(f.Name(), perm); err != nil {
+			f.Close()             // error deliberately ignored
+			d.fs.Remove(f.Name()) // error deliberately ignored
 			return nil, fmt.Errorf("chmod: %s", err)
 		}
 		return f, nil
 	}
 
 	mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC // overwrite if exists
-	f, err := os.OpenFile(d.completeFilename(pathKey), mode, d.FilePerm)
+	f, err := d.fs.OpenFile(d.completeFilename(pathKey), mode, perm)
+	if os.IsNotExist(err) && d.forgetLastDirWithLock() {
+		if err = d.ensurePathWithLock(pathKey); err == nil {
+			f, err = d.fs.OpenFile(d.completeFilename(pathKey), mode, perm)
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("open file: %s", err)
 	}
+	if perm != d.FilePerm {
+		// OpenFile doesn't change the mode of an existing file.
+		if err := d.fs.Chmod(f.Name(), perm); err != nil {
+			f.Close() // error deliberately ignored
+			return nil, fmt.Errorf("chmod: %s", err)
+		}
+	}
 	return f, nil
 }
 
 // writeStream does no input validation checking.
-func (d *Diskv) writeStreamWithLock(pathKey *PathKey, r io.Reader, sync bool) error {
+func (d *Diskv) writeStreamWithLock(pathKey *PathKey, r io.Reader, opts WriteOptions) error {
+	if d.PackThreshold > 0 || d.ChunkSize > 0 {
+		peek := d.PackThreshold
+		if d.ChunkSize > 0 {
+			peek = d.ChunkSize
+		}
+		val, err := ioutil.ReadAll(io.LimitReader(r, peek+1))
+		if err == ErrValueTooLarge {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("i/o copy: %s", err)
+		}
+		r = io.MultiReader(bytes.NewReader(val), r)
+		switch {
+		case d.PackThreshold > 0 && int64(len(val)) <= d.PackThreshold:
+			return d.writePackedWithLock(pathKey, val, opts)
+		case d.ChunkSize > 0 && int64(len(val)) > d.ChunkSize:
+			return d.writeChunkedWithLock(pathKey, r, opts)
+		}
+	}
+
 	if err := d.ensurePathWithLock(pathKey); err != nil {
+		if keyErr := d.keyPathError(pathKey); keyErr != nil {
+			return keyErr
+		}
 		return fmt.Errorf("ensure path: %s", err)
 	}
+	opts.phases.mark("mkdir")
+
+	var (
+		oldSize int64
+		exists  bool
+	)
+	if len(d.quotas) > 0 {
+		oldSize, exists = d.quotaBaseWithLock(pathKey)
+	}
+	qw, err := d.quotaWriterWithLock(pathKey.originalKey, oldSize, exists)
+	if err != nil {
+		return err
+	}
+
+	var (
+		h   hash.Hash
+		obj File
+	)
+	if d.merkle != nil || d.Journal {
+		h = sha256.New()
+		w := io.Writer(h)
+		if d.SnapshotDir != "" {
+			if obj, err = d.createObjectFile(); err != nil {
+				return fmt.Errorf("create object file: %s", err)
+			}
+			defer d.discardObjectFile(obj)
+			w = io.MultiWriter(h, obj)
+		}
+		r = io.TeeReader(r, w)
+	}
 
-	f, err := d.createKeyFileWithLock(pathKey)
+	perm := d.FilePerm
+	if opts.FilePerm != 0 {
+		perm = opts.FilePerm
+	}
+	f, err := d.createKeyFileWithLock(pathKey, perm)
 	if err != nil {
+		if keyErr := d.keyPathError(pathKey); keyErr != nil {
+			return keyErr
+		}
 		return fmt.Errorf("create key file: %s", err)
 	}
+	qw.w = f
+	opts.phases.mark("open")
 
-	wc := io.WriteCloser(&nopWriteCloser{f})
+	wc := io.WriteCloser(&nopWriteCloser{qw})
 	if d.Compression != nil {
-		wc, err = d.Compression.Writer(f)
+		wc, err = d.Compression.Writer(qw)
 		if err != nil {
-			f.Close()           // error deliberately ignored
-			os.Remove(f.Name()) // error deliberately ignored
+			f.Close()             // error deliberately ignored
+			d.fs.Remove(f.Name()) // error deliberately ignored
 			return fmt.Errorf("compression writer: %s", err)
 		}
 	}
 
-	if _, err := io.Copy(wc, r); err != nil {
-		f.Close()           // error deliberately ignored
-		os.Remove(f.Name()) // error deliberately ignored
+	if _, err := io.CopyBuffer(wc, r, opts.copyBuffer); err != nil {
+		f.Close()             // error deliberately ignored
+		d.fs.Remove(f.Name()) // error deliberately ignored
+		if err == ErrValueTooLarge || IsQuotaExceeded(err) {
+			return err
+		}
 		return fmt.Errorf("i/o copy: %s", err)
 	}
 
 	if err := wc.Close(); err != nil {
-		f.Close()           // error deliberately ignored
-		os.Remove(f.Name()) // error deliberately ignored
+		f.Close()             // error deliberately ignored
+		d.fs.Remove(f.Name()) // error deliberately ignored
+		if IsQuotaExceeded(err) {
+			return err
+		}
 		return fmt.Errorf("compression close: %s", err)
 	}
+	opts.phases.mark("copy")
 
-	if sync {
-		if err := f.Sync(); err != nil {
-			f.Close()           // error deliberately ignored
-			os.Remove(f.Name()) // error deliberately ignored
+	syncMode := SyncNone
+	if opts.Sync {
+		syncMode = d.syncTuner.mode()
+		if syncMode == SyncGroup && opts.groupSync == nil {
+			syncMode = SyncEach
+		}
+	}
+	if syncMode == SyncEach {
+		if err := d.syncTuner.sync(f); err != nil {
+			f.Close()             // error deliberately ignored
+			d.fs.Remove(f.Name()) // error deliberately ignored
 			return fmt.Errorf("file sync: %s", err)
 		}
+		opts.phases.mark("sync")
+	}
+
+	if d.OnFileCreated != nil {
+		if err := d.OnFileCreated(f.Name()); err != nil {
+			f.Close()             // error deliberately ignored
+			d.fs.Remove(f.Name()) // error deliberately ignored
+			return fmt.Errorf("on file created: %s", err)
+		}
 	}
 
 	if err := f.Close(); err != nil {
 		return fmt.Errorf("file close: %s", err)
 	}
+	if !opts.ModTime.IsZero() {
+		if err := chtimes(d.fs, f.Name(), opts.ModTime); err != nil {
+			d.fs.Remove(f.Name()) // error deliberately ignored
+			return fmt.Errorf("chtimes: %s", err)
+		}
+	}
 
 	fullPath := d.completeFilename(pathKey)
 	if f.Name() != fullPath {
-		if err := os.Rename(f.Name(), fullPath); err != nil {
-			os.Remove(f.Name()) // error deliberately ignored
+		err := d.fs.Rename(f.Name(), fullPath)
+		if os.IsNotExist(err) && d.forgetLastDirWithLock() {
+			if err = d.ensurePathWithLock(pathKey); err == nil {
+				err = d.fs.Rename(f.Name(), fullPath)
+			}
+		}
+		if err != nil {
+			d.fs.Remove(f.Name()) // error deliberately ignored
+			if keyErr := d.keyPathError(pathKey); keyErr != nil {
+				return keyErr
+			}
 			return fmt.Errorf("rename: %s", err)
 		}
 	}
+	opts.phases.mark("rename")
+	if syncMode == SyncGroup {
+		*opts.groupSync = fullPath
+	}
+
+	// A packed or chunked value is superseded by the file.
+	if _, err := d.unpackWithLock(pathKey.originalKey); err != nil {
+		return err
+	}
+	if _, err := d.unchunkWithLock(pathKey.originalKey); err != nil {
+		return err
+	}
 
 	if d.Index != nil {
-		d.Index.Insert(pathKey.originalKey)
+		d.indexInsertWithLock(pathKey.originalKey, fullPath)
+		d.indexChangedWithLock()
 	}
 
-	d.bustCacheWithLock(pathKey.originalKey) // cache only on read
+	if len(d.quotas) > 0 {
+		keys := 1
+		if exists {
+			keys = 0
+		}
+		d.chargeQuotaWithLock(pathKey.originalKey, qw.written-oldSize, keys)
+		d.absorbReservedWithLock(pathKey.originalKey)
+	}
 
+	d.invalidateWithLock(pathKey.originalKey) // cache only on read
+	d.bloomAddWithLock(pathKey.originalKey)
+
+	if h != nil {
+		var sum [sha256.Size]byte
+		copy(sum[:], h.Sum(nil))
+		if obj != nil {
+			if err := d.commitObjectFile(obj, sum[:]); err != nil {
+				return fmt.Errorf("commit object file: %s", err)
+			}
+		}
+		d.merkleSetWithLock(pathKey.originalKey, &sum)
+		return d.journalWithLock(JournalWrite, pathKey.originalKey, sum[:])
+	}
 	return nil
 }
 
@@ -290,31 +1132,53 @@ func (d *Diskv) writeStreamWithLock(pathKey *PathKey, r io.Reader, sync bool) er
 // destination key already exists, it's overwritten. If move is true, the
 // source file is removed after a successful import.
 func (d *Diskv) Import(srcFilename, dstKey string, move bool) (err error) {
-	if dstKey == "" {
-		return errEmptyKey
+	dstKey = d.normalizeKey(dstKey)
+	dstPathKey, err := d.checkWriteKey(dstKey)
+	if err != nil {
+		return err
 	}
 
-	if fi, err := os.Stat(srcFilename); err != nil {
+	fi, err := os.Stat(srcFilename)
+	if err != nil {
 		return err
 	} else if fi.IsDir() {
 		return errImportDirectory
+	} else if d.MaxValueSize > 0 && fi.Size() > d.MaxValueSize {
+		return ErrValueTooLarge
 	}
 
-	dstPathKey := d.transform(dstKey)
+	d.writeThrottle.waitOp(Foreground)
+	defer d.writeThrottle.waitBytes(fi.Size(), Foreground)
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	d.forgetLastDirWithLock() // the rename below can't retry
+
 	if err := d.ensurePathWithLock(dstPathKey); err != nil {
+		if keyErr := d.keyPathError(dstPathKey); keyErr != nil {
+			return keyErr
+		}
 		return fmt.Errorf("ensure path: %s", err)
 	}
 
-	if move {
+	// The rename skips everything writeStreamWithLock does with the value
+	// as it's written, so it's only taken when there's none of it to do.
+	if _, ok := d.fs.(osFS); ok && move && d.renameImportable() {
 		if err := syscall.Rename(srcFilename, d.completeFilename(dstPathKey)); err == nil {
-			d.bustCacheWithLock(dstPathKey.originalKey)
-			return nil
+			if d.Index != nil {
+				d.indexInsertWithLock(dstKey, d.completeFilename(dstPathKey))
+				d.indexChangedWithLock()
+			}
+			d.invalidateWithLock(dstPathKey.originalKey)
+			d.bloomAddWithLock(dstPathKey.originalKey)
+			d.cancelPendingWithLock(dstKey)
+			return d.setExpiry(dstKey, 0)
 		} else if err != syscall.EXDEV {
 			// If it failed due to being on a different device, fall back to copying
+			if keyErr := d.keyPathError(dstPathKey); keyErr != nil {
+				return keyErr
+			}
 			return err
 		}
 	}
@@ -324,24 +1188,35 @@ func (d *Diskv) Import(srcFilename, dstKey string, move bool) (err error) {
 		return err
 	}
 	defer f.Close()
-	err = d.writeStreamWithLock(dstPathKey, f, false)
-	if err == nil && move {
-		err = os.Remove(srcFilename)
+	if err := d.writeStreamWithLock(dstPathKey, f, WriteOptions{}); err != nil {
+		return err
 	}
-	return err
+	d.cancelPendingWithLock(dstKey)
+	if err := d.setExpiry(dstKey, 0); err != nil {
+		return err
+	}
+	if move {
+		return os.Remove(srcFilename)
+	}
+	return nil
+}
+
+// renameImportable returns true if Import may move a file into place with a
+// rename, rather than writing its contents: that is, if nothing needs to see
+// the value, or transform it, on its way to the key's file.
+func (d *Diskv) renameImportable() bool {
+	return len(d.quotas) <= 0 && d.merkle == nil && !d.Journal && d.SnapshotDir == "" &&
+		d.OnFileCreated == nil && d.Compression == nil && d.PackThreshold <= 0 && d.ChunkSize <= 0
 }
 
 // Read reads the key and returns the value.
 // If the key is available in the cache, Read won't touch the disk.
 // If the key is not in the cache, Read will have the side-effect of
 // lazily caching the value.
+//
+// The returned slice is owned by the caller, unless ZeroCopyReads is set.
 func (d *Diskv) Read(key string) ([]byte, error) {
-	rc, err := d.ReadStream(key, false)
-	if err != nil {
-		return []byte{}, err
-	}
-	defer rc.Close()
-	return ioutil.ReadAll(rc)
+	return d.ReadWith(key, ReadOptions{})
 }
 
 // ReadString reads the key and returns a string value
@@ -351,6 +1226,66 @@ func (d *Diskv) ReadString(key string) string {
 	return string(value)
 }
 
+// ReadOptions control how ReadWith interacts with the cache.
+type ReadOptions struct {
+	// SkipCache ignores any cached value, and always reads from disk.
+	// A cached value is left in place, unless it's refreshed by the read.
+	SkipCache bool
+
+	// NoFill prevents a value read from disk from being added to the cache.
+	// Use it for large one-off scans, to avoid evicting the working set.
+	NoFill bool
+
+	// Priority is the class of the read, for ReadThrottle.
+	Priority Priority
+
+	verify bool // see ReadStreamOptions.VerifyChecksum
+}
+
+// ReadWith reads the key and returns the value, using the cache as directed
+// by the given ReadOptions. ReadWith with zero ReadOptions is equivalent to
+// Read.
+func (d *Diskv) ReadWith(key string, opts ReadOptions) (val []byte, err error) {
+	key = d.normalizeKey(key)
+	span := d.startSpan("Read", key)
+	defer func() {
+		if err == nil {
+			d.recordAccess(key)
+		}
+		span.SetAttribute("bytes", int64(len(val)))
+		span.End(err)
+	}()
+
+	if err := d.authorize(OpRead, key); err != nil {
+		return []byte{}, err
+	}
+
+	if val, ok := d.pendingValue(key); ok {
+		span.SetAttribute("pending", true)
+		return append([]byte(nil), val...), nil
+	}
+	if d.expired(key) {
+		return []byte{}, errExpired(key)
+	}
+
+	if d.ZeroCopyReads && d.Compression == nil && !opts.SkipCache {
+		d.checkTombstones()
+		if val, ok := d.cacheLookup(key); ok {
+			span.SetAttribute("cache_hit", true)
+			atomic.AddUint64(&d.cacheHits, 1)
+			return val, nil
+		}
+	}
+
+	rc, err := d.readStream(key, false, opts, span)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer rc.Close()
+	defer phases(span).mark("copy")
+	return ioutil.ReadAll(rc)
+}
+
 // ReadStream reads the key and returns the value (data) as an io.ReadCloser.
 // If the value is cached from a previous read, and direct is false,
 // ReadStream will use the cached value. Otherwise, it will return a handle to
@@ -361,72 +1296,269 @@ func (d *Diskv) ReadString(key string) string {
 //
 // If compression is enabled, ReadStream taps into the io.Reader stream prior
 // to decompression, and caches the compressed data.
+//
+// Once a reader of a cached value is closed, reading or closing it again
+// fails with os.ErrClosed.
+//
+// ReadStream is ReadStreamWith, with only ReadStreamOptions.Direct.
 func (d *Diskv) ReadStream(key string, direct bool) (io.ReadCloser, error) {
+	return d.ReadStreamWith(key, ReadStreamOptions{Direct: direct})
+}
 
-	pathKey := d.transform(key)
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+// ReadStreamOptions control how ReadStreamWith interacts with the cache.
+type ReadStreamOptions struct {
+	// Direct ignores any cached value, and evicts it, lazily unless
+	// SynchronousCache is set, so that the value is read from disk.
+	Direct bool
 
-	if val, ok := d.cache[key]; ok {
-		if !direct {
-			buf := bytes.NewReader(val)
-			if d.Compression != nil {
-				return d.Compression.Reader(buf)
-			}
-			return ioutil.NopCloser(buf), nil
-		}
+	// NoFill prevents the value from being cached as it's read from disk.
+	NoFill bool
 
-		go func() {
-			d.mu.Lock()
-			defer d.mu.Unlock()
-			d.uncacheWithLock(key, uint64(len(val)))
-		}()
-	}
+	// If VerifyChecksum is set, and the Merkle tree is built, the value is
+	// checked against the SHA-256 recorded in it as it streams, and the
+	// reader fails with ErrChecksumMismatch rather than return io.EOF if it
+	// doesn't match. Values written with NewChecksumCompression are always
+	// verified.
+	VerifyChecksum bool
 
-	return d.readWithRLock(pathKey)
+	// Priority is the class of the read, for ReadThrottle.
+	Priority Priority
 }
 
-// read ignores the cache, and returns an io.ReadCloser representing the
-// decompressed data for the given key, streamed from the disk. Clients should
-// acquire a read lock on the Diskv and check the cache themselves before
-// calling read.
-func (d *Diskv) readWithRLock(pathKey *PathKey) (io.ReadCloser, error) {
-	filename := d.completeFilename(pathKey)
+// ReadStreamWith reads the key and returns the value as an io.ReadCloser,
+// using the cache as directed by the given ReadStreamOptions. Like
+// ReadStream, it caches the data on read, and, if compression is enabled,
+// caches the compressed data.
+func (d *Diskv) ReadStreamWith(key string, opts ReadStreamOptions) (rc io.ReadCloser, err error) {
+	key = d.normalizeKey(key)
+	span := d.startSpan("ReadStream", key)
+	defer func() {
+		if err == nil {
+			d.recordAccess(key)
+		}
+		span.End(err)
+	}()
 
-	fi, err := os.Stat(filename)
-	if err != nil {
+	if err := d.authorize(OpRead, key); err != nil {
 		return nil, err
 	}
-	if fi.IsDir() {
-		return nil, os.ErrNotExist
+
+	if val, ok := d.pendingValue(key); ok {
+		span.SetAttribute("pending", true)
+		return ioutil.NopCloser(bytes.NewReader(val)), nil
+	}
+	if d.expired(key) {
+		return nil, errExpired(key)
 	}
 
-	f, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+	readOpts := ReadOptions{NoFill: opts.NoFill, Priority: opts.Priority, verify: opts.VerifyChecksum}
+	return d.readStream(key, opts.Direct, readOpts, span)
+}
+
+// readStream implements ReadStreamWith and ReadWith, annotating the given
+// span with whether the value was served from the cache.
+func (d *Diskv) readStream(key string, direct bool, opts ReadOptions, span Span) (io.ReadCloser, error) {
+	p := phases(span)
+	if d.CacheSizeMax == 0 {
+		span.SetAttribute("cache_hit", false)
+		atomic.AddUint64(&d.cacheMisses, 1)
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+		p.mark("lock")
+		rc, err := d.readWithRLock(d.transform(key), opts)
+		p.mark("open")
+		return d.verifiedWithRLock(key, rc, err, opts)
+	}
+	d.checkTombstones()
+	if d.SynchronousCache && (direct || opts.SkipCache) {
+		d.mu.Lock()
+		d.bustCacheWithLock(key)
+		d.mu.Unlock()
+	}
+
+	// Cache hits which needn't be verified don't take d.mu at all.
+	if !direct && !opts.SkipCache && !opts.verify {
+		if val, ok := d.cacheLookup(key); ok {
+			span.SetAttribute("cache_hit", true)
+			atomic.AddUint64(&d.cacheHits, 1)
+			return d.cachedReader(val)
+		}
 	}
 
-	var r io.Reader
-	if d.CacheSizeMax > 0 {
-		r = newSiphon(f, d, pathKey.originalKey)
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	p.mark("lock")
+
+	val, ok := d.cacheLookup(key)
+	hit := ok && !direct && !opts.SkipCache
+	span.SetAttribute("cache_hit", hit)
+	if hit {
+		atomic.AddUint64(&d.cacheHits, 1)
 	} else {
-		r = &closingReader{f}
+		atomic.AddUint64(&d.cacheMisses, 1)
 	}
+	if ok {
+		if hit {
+			rc, err := d.cachedReader(val)
+			return d.verifiedWithRLock(key, rc, err, opts)
+		}
 
-	var rc = io.ReadCloser(ioutil.NopCloser(r))
-	if d.Compression != nil {
-		rc, err = d.Compression.Reader(r)
-		if err != nil {
-			return nil, err
+		if !d.SynchronousCache {
+			goLabeled("cache-evict", func() {
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				d.bustCacheWithLock(key)
+			})
 		}
 	}
 
-	return rc, nil
+	rc, err := d.readWithRLock(d.transform(key), opts)
+	p.mark("open")
+	return d.verifiedWithRLock(key, rc, err, opts)
 }
 
-// closingReader provides a Reader that automatically closes the
-// embedded ReadCloser when it reaches EOF
-type closingReader struct {
+// cachedReader returns an io.ReadCloser of the decompressed data of the
+// cached value. Without Compression, it reads the cached value in place,
+// through a pooled bytes.Reader, so that cache hits allocate only the small
+// handle which holds it.
+func (d *Diskv) cachedReader(val []byte) (io.ReadCloser, error) {
+	if d.Compression != nil {
+		return d.Compression.Reader(bytes.NewReader(val))
+	}
+	r := cachedValueReaders.Get().(*bytes.Reader)
+	r.Reset(val)
+	return &cachedValueReader{r: r}, nil
+}
+
+// cachedValueReaders are the bytes.Readers of cachedValueReader, reused
+// across reads.
+var cachedValueReaders = sync.Pool{
+	New: func() interface{} { return &bytes.Reader{} },
+}
+
+// cachedValueReader is an io.ReadCloser of a cached value. Its pooled
+// bytes.Reader is detached and returned to cachedValueReaders by Close, so
+// that the handle can't read or reset it once another read has taken it.
+type cachedValueReader struct {
+	r *bytes.Reader // nil once closed
+}
+
+func (c *cachedValueReader) Read(p []byte) (int, error) {
+	if c.r == nil {
+		return 0, os.ErrClosed
+	}
+	return c.r.Read(p)
+}
+
+func (c *cachedValueReader) Close() error {
+	if c.r == nil {
+		return os.ErrClosed
+	}
+	c.r.Reset(nil) // so that the pool doesn't keep the value alive
+	cachedValueReaders.Put(c.r)
+	c.r = nil
+	return nil
+}
+
+// read ignores the cache, and returns an io.ReadCloser representing the
+// decompressed data for the given key, streamed from the disk. Clients should
+// acquire a read lock on the Diskv and check the cache themselves before
+// calling read. Unless opts.NoFill is set, the data is cached as it's read.
+func (d *Diskv) readWithRLock(pathKey *PathKey, opts ReadOptions) (io.ReadCloser, error) {
+	if err := d.checkPath(pathKey); err != nil {
+		return nil, err
+	}
+	if err := d.checkSymlinks(pathKey); err != nil {
+		return nil, err
+	}
+
+	var (
+		r     io.Reader
+		files []File // closed at EOF, or by Close
+	)
+	if cr, cfiles, ok, err := d.readChunkedWithRLock(pathKey.originalKey); err != nil {
+		return nil, err
+	} else if ok {
+		r, files = cr, cfiles // never cached
+	} else if stored, pfi, err := d.readPackedWithRLock(pathKey.originalKey); err != nil {
+		return nil, err
+	} else if pfi != nil {
+		r = bytes.NewReader(stored)
+		if !opts.NoFill && d.CacheSizeMax > 0 {
+			key, gen := pathKey.originalKey, atomic.LoadUint64(&d.gen)
+			r = &eofFunc{r: r, fn: func() {
+				if err := d.fillCache(key, stored, gen, pfi); err != nil {
+					d.cacheError(key, err) // cache may fail
+				}
+			}}
+		}
+	} else {
+		filename := d.completeFilename(pathKey)
+
+		fi, err := d.fs.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			return nil, os.ErrNotExist
+		}
+
+		f, err := d.fs.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		files = []File{f}
+
+		if !opts.NoFill && d.CacheSizeMax > 0 && !d.tooLargeToCache(uint64(fi.Size())) {
+			if r, err = newSiphon(f, d, pathKey.originalKey); err != nil {
+				f.Close() // error deliberately ignored
+				return nil, err
+			}
+		} else {
+			r = &closingReader{f}
+		}
+	}
+	if d.readThrottle != nil {
+		r = &throttledReader{r: r, t: d.readThrottle, priority: opts.Priority}
+	}
+
+	rc := ioutil.NopCloser(r)
+	if d.Compression != nil {
+		var err error
+		if rc, err = d.Compression.Reader(r); err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+	}
+	if len(files) > 0 {
+		rc = &filesCloser{ReadCloser: rc, files: files}
+	}
+	return rc, nil
+}
+
+// filesCloser closes the files a ReadCloser reads from along with it, so
+// that they're closed even if the reader is abandoned before EOF.
+type filesCloser struct {
+	io.ReadCloser
+	files []File
+}
+
+func (fc *filesCloser) Close() error {
+	err := fc.ReadCloser.Close()
+	closeFiles(fc.files)
+	return err
+}
+
+// closeFiles closes the files, which may have been closed already.
+func closeFiles(files []File) {
+	for _, f := range files {
+		f.Close() // error deliberately ignored
+	}
+}
+
+// closingReader provides a Reader that automatically closes the
+// embedded ReadCloser when it reaches EOF
+type closingReader struct {
 	rc io.ReadCloser
 }
 
@@ -443,7 +1575,9 @@ func (cr closingReader) Read(p []byte) (int, error) {
 // siphon is like a TeeReader: it copies all data read through it to an
 // internal buffer, and moves that buffer to the cache at EOF.
 type siphon struct {
-	f   *os.File
+	f   File
+	fi  os.FileInfo
+	gen uint64
 	d   *Diskv
 	key string
 	buf *bytes.Buffer
@@ -451,14 +1585,21 @@ type siphon struct {
 
 // newSiphon constructs a siphoning reader that represents the passed file.
 // When a successful series of reads ends in an EOF, the siphon will write
-// the buffered data to Diskv's cache under the given key.
-func newSiphon(f *os.File, d *Diskv, key string) io.Reader {
+// the buffered data to Diskv's cache under the given key, unless the key was
+// rewritten in the meantime. Callers must hold at least a read lock.
+func newSiphon(f File, d *Diskv, key string) (io.Reader, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
 	return &siphon{
 		f:   f,
+		fi:  fi,
+		gen: atomic.LoadUint64(&d.gen),
 		d:   d,
 		key: key,
 		buf: &bytes.Buffer{},
-	}
+	}, nil
 }
 
 // Read implements the io.Reader interface for siphon.
@@ -470,7 +1611,9 @@ func (s *siphon) Read(p []byte) (int, error) {
 	}
 
 	if err == io.EOF {
-		s.d.cacheWithoutLock(s.key, s.buf.Bytes()) // cache may fail
+		if err := s.d.fillCache(s.key, s.buf.Bytes(), s.gen, s.fi); err != nil {
+			s.d.cacheError(s.key, err) // cache may fail
+		}
 		if closeErr := s.f.Close(); closeErr != nil {
 			return n, closeErr // close must succeed for Read to succeed
 		}
@@ -481,64 +1624,303 @@ func (s *siphon) Read(p []byte) (int, error) {
 }
 
 // Erase synchronously erases the given key from the disk and the cache.
+// Writes and erases of a key are serialized, so whichever comes last wins,
+// and reads in flight never cache the value which was erased. If the value
+// can't be removed, Erase fails, and the key is left as it was: its value,
+// cache and index entries and TTL are unchanged.
 func (d *Diskv) Erase(key string) error {
-	pathKey := d.transform(key)
+	key = d.normalizeKey(key)
+	if err := d.authorize(OpErase, key); err != nil {
+		return err
+	}
+	return d.erase(key)
+}
+
+// erase is Erase, without authorization.
+func (d *Diskv) erase(key string) (err error) {
+	span := d.startSpan("Erase", key)
+	defer func() { span.End(err) }()
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	phases(span).mark("lock")
 
-	d.bustCacheWithLock(key)
+	return d.eraseWithLock(key, phases(span))
+}
 
-	// erase from index
-	if d.Index != nil {
-		d.Index.Delete(key)
+// EraseQuiet is like Erase, but it's not an error if the key doesn't exist.
+// Any cache and index entries for the key are removed regardless.
+func (d *Diskv) EraseQuiet(key string) (err error) {
+	key = d.normalizeKey(key)
+	span := d.startSpan("Erase", key)
+	defer func() { span.End(err) }()
+
+	if err := d.authorize(OpErase, key); err != nil {
+		return err
 	}
 
-	// erase from disk
-	filename := d.completeFilename(pathKey)
-	if s, err := os.Stat(filename); err == nil {
-		if s.IsDir() {
-			return errBadKey
-		}
-		if err = os.Remove(filename); err != nil {
-			return err
-		}
-	} else {
-		// Return err as-is so caller can do os.IsNotExist(err).
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	phases(span).mark("lock")
+
+	if err := d.eraseWithLock(key, phases(span)); err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	return nil
+}
 
-	// clean up and return
+// eraseWithLock erases the given key from the disk, the cache and the
+// index, and prunes its directories. Its phases are marked on p, which may
+// be nil.
+func (d *Diskv) eraseWithLock(key string, p *phaseTimer) error {
+	pathKey := d.transform(key)
+	if err := d.checkPath(pathKey); err != nil {
+		return err
+	}
+	err := d.eraseNoPruneWithLock(key, pathKey)
+	p.mark("remove")
+	if err != nil {
+		return err
+	}
 	d.pruneDirsWithLock(key)
+	p.mark("prune")
 	return nil
 }
 
+// eraseNoPruneWithLock erases the key's value from the disk, and then
+// forgets the key: its cache and index entries, its expiry and so on. If
+// the value can't be removed, the key is left as it was, so that a failed
+// erase leaves no partial state behind. If the value doesn't exist, the key
+// is forgotten anyway, and the error satisfies os.IsNotExist, unless the
+// key had a value buffered by AsyncWrites or the write log, which is
+// dropped, so that it's never written. Callers must hold d.mu.
+func (d *Diskv) eraseNoPruneWithLock(key string, pathKey *PathKey) error {
+	if err := d.wipeValueWithLock(key, pathKey); err != nil {
+		return err
+	}
+	size, err := d.removeValueWithLock(key, pathKey)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	buffered := d.cancelPendingWithLock(key)
+
+	d.invalidateWithLock(key)
+	d.forgetAccess(key, false)
+	d.forgetPin(key, false)
+	if d.Index != nil {
+		d.Index.Delete(key)
+		d.indexChangedWithLock()
+	}
+	d.merkleSetWithLock(key, nil)
+	if expiryErr := d.setExpiry(key, 0); expiryErr != nil {
+		return expiryErr
+	}
+	if err != nil && buffered {
+		return nil // never written, so there's nothing else to undo
+	} else if err != nil {
+		// Return err as-is so caller can do os.IsNotExist(err).
+		return err
+	}
+
+	d.chargeQuotaWithLock(key, -size, -1)
+	if err := d.tombstoneWithLock(strconv.Quote(key)); err != nil {
+		return err
+	}
+	return d.journalWithLock(JournalErase, key, nil)
+}
+
+// removeValueWithLock removes the key's value, wherever it's stored, and
+// returns its size as stored. Callers must hold d.mu.
+func (d *Diskv) removeValueWithLock(key string, pathKey *PathKey) (int64, error) {
+	if fi, ok := d.virtualStat(key); ok {
+		if _, err := d.unpackWithLock(key); err != nil {
+			return 0, err
+		}
+		if _, err := d.unchunkWithLock(key); err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+	filename := d.completeFilename(pathKey)
+	s, err := d.fs.Stat(filename)
+	if err != nil {
+		return 0, err
+	}
+	if s.IsDir() {
+		return 0, ErrKeyIsDirectory
+	}
+	if err := d.fs.Remove(filename); err != nil {
+		return 0, err
+	}
+	return s.Size(), nil
+}
+
 // EraseAll will delete all of the data from the store, both in the cache and on
 // the disk. Note that EraseAll doesn't distinguish diskv-related data from non-
 // diskv-related data. Care should be taken to always specify a diskv base
-// directory that is exclusively for diskv data.
+// directory that is exclusively for diskv data. EraseAll removes the journal
+// too, although its sequence numbers carry on.
 func (d *Diskv) EraseAll() error {
+	if err := d.authorize(OpErase, ""); err != nil {
+		return err
+	}
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	d.cache = make(map[string][]byte)
-	d.cacheSize = 0
+	d.resetCacheWithLock()
+	atomic.AddUint64(&d.gen, 1)
+	d.resetExpiry()
+	d.resetQuotasWithLock()
+	d.forgetLastDirWithLock()
+	d.forgetAccess("", true)
+	d.forgetPin("", true)
+	d.discardPending()
+	if d.merkle != nil {
+		d.merkle = newMerkleTree()
+	}
+	if d.Index != nil && d.IndexLess != nil {
+		d.Index.Initialize(d.IndexLess, closedKeys())
+	}
+	d.indexSaved = false
+	d.resetBloomWithLock()
+	if err := d.wipeTree(d.BasePath, nil); err != nil {
+		return err
+	}
+	d.resetPackWithLock()
+	d.resetChunksWithLock()
 	if d.TempDir != "" {
-		os.RemoveAll(d.TempDir) // errors ignored
+		d.fs.RemoveAll(d.TempDir) // errors ignored
+	}
+	if err := d.fs.RemoveAll(d.BasePath); err != nil {
+		return err
 	}
-	return os.RemoveAll(d.BasePath)
+	return d.resetJournalWithLock()
 }
 
-// Has returns true if the given key exists.
-func (d *Diskv) Has(key string) bool {
-	pathKey := d.transform(key)
+// Clear deletes all of the data from the store, both in the cache and on the
+// disk, like EraseAll. Unlike EraseAll, Clear keeps the BasePath directory
+// itself, along with its permissions and ownership, which makes it suitable
+// for a BasePath that's a mount point or was provisioned externally. Clear
+// also keeps the store's manifest, journal, sequences and SharedTombstones,
+// if any; the clear is journaled, so RestoreToTime can undo it. Like
+// EraseAll, Clear doesn't distinguish diskv-related data from
+// non-diskv-related data.
+func (d *Diskv) Clear() error {
+	if err := d.authorize(OpErase, ""); err != nil {
+		return err
+	}
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	return d.clearWithLock()
+}
+
+// clearWithLock is Clear. Callers must hold d.mu.
+func (d *Diskv) clearWithLock() error {
+	d.resetCacheWithLock()
+	atomic.AddUint64(&d.gen, 1)
+	d.resetExpiry()
+	d.resetQuotasWithLock()
+	d.forgetLastDirWithLock()
+	d.forgetAccess("", true)
+	d.forgetPin("", true)
+	d.discardPending()
+	if d.merkle != nil {
+		d.merkle = newMerkleTree()
+	}
+	if d.Index != nil && d.IndexLess != nil {
+		d.Index.Initialize(d.IndexLess, closedKeys())
+		d.indexChangedWithLock()
+	}
+	d.resetBloomWithLock()
+	keep := func(name string) bool {
+		return name == ManifestFilename || name == seqDirname || name == tombstonesFilename ||
+			strings.HasPrefix(name, journalPrefix)
+	}
+	if err := d.wipeTree(d.BasePath, keep); err != nil {
+		return err
+	}
+	d.resetPackWithLock()
+	d.resetChunksWithLock()
+	if d.TempDir != "" {
+		removeContents(d.fs, d.TempDir, nil) // errors ignored
+	}
+	if err := removeContents(d.fs, d.BasePath, keep); err != nil {
+		return err
+	}
+	if err := d.tombstoneWithLock(tombstoneAll); err != nil {
+		return err
+	}
+	return d.journalWithLock(JournalClear, "", nil)
+}
+
+// removeContents removes everything inside dir, except for the entries keep
+// returns true for, but not dir itself. It's not an error if dir doesn't exist.
+func removeContents(fs FileSystem, dir string, keep func(name string) bool) error {
+	names, err := readDirNames(fs, dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if keep != nil && keep(name) {
+			continue
+		}
+		if err := fs.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closedKeys returns a closed channel of keys, for (re)initializing an empty
+// Index.
+func closedKeys() <-chan string {
+	c := make(chan string)
+	close(c)
+	return c
+}
+
+// Has returns true if the given key exists.
+func (d *Diskv) Has(key string) bool {
+	key = d.normalizeKey(key)
+	if d.authorize(OpStat, key) != nil {
+		return false
+	}
+	if _, ok := d.pendingValue(key); ok {
+		return true
+	}
+	if d.expired(key) {
+		return false
+	}
 
-	if _, ok := d.cache[key]; ok {
+	d.checkTombstones()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.hasWithRLock(d.transform(key))
+}
+
+// hasWithRLock is Has, without authorization, AsyncWrites or expiry.
+// Callers must hold d.mu, for reading at least.
+func (d *Diskv) hasWithRLock(pathKey *PathKey) bool {
+	key := pathKey.originalKey
+	if !d.bloomMayContainWithLock(key) {
+		return false
+	}
+	if mi, ok := d.Index.(MembershipIndex); ok {
+		return mi.Has(key)
+	}
+	if _, ok := d.cacheLookup(key); ok {
+		return true
+	}
+	if d.checkPath(pathKey) != nil || d.checkSymlinks(pathKey) != nil {
+		return false
+	}
+	if _, ok := d.virtualStat(key); ok {
 		return true
 	}
 
 	filename := d.completeFilename(pathKey)
-	s, err := os.Stat(filename)
+	s, err := d.fs.Stat(filename)
 	if err != nil {
 		return false
 	}
@@ -549,65 +1931,286 @@ func (d *Diskv) Has(key string) bool {
 	return true
 }
 
+// Len returns the number of keys in the store. With a MembershipIndex, it's
+// answered by the Index; otherwise, it walks the store, like Keys.
+func (d *Diskv) Len() int {
+	if d.authorize(OpList, "") != nil {
+		return 0
+	}
+	if mi, ok := d.Index.(MembershipIndex); ok {
+		return mi.Len()
+	}
+	n := 0
+	for range d.keysPrefix("", nil, nil) {
+		n++
+	}
+	return n
+}
+
+// Generation returns the store's generation, which every write and erase
+// increments, including writes buffered by AsyncWrites or the write log,
+// and Clear and EraseAll. Comparing it with an earlier value cheaply tells
+// whether anything may have changed since, without taking the store's
+// lock: it never stays the same across a change, but it may change when
+// nothing visible did, e.g. when a buffered value is written to its file.
+// Keys expiring doesn't change it. It starts from 0 with every New, and
+// only counts changes made through this Diskv.
+func (d *Diskv) Generation() uint64 {
+	return atomic.LoadUint64(&d.gen)
+}
+
 // Keys returns a channel that will yield every key accessible by the store,
-// in undefined order. If a cancel channel is provided, closing it will
-// terminate and close the keys channel.
+// in undefined order unless SortedKeys is set. If a cancel channel is
+// provided, closing it will terminate and close the keys channel.
+//
+// Keys are yielded by a goroutine which walks the store, or, with an Index,
+// pages through the Index instead, and which blocks while the channel is
+// full; set KeysBuffer to let it run ahead of a slow consumer. A consumer
+// which stops reading before the channel is closed must close cancel, or
+// the goroutine leaks.
+//
+// The walk holds no locks while it waits for the consumer, so the consumer
+// may read, write and erase keys as it goes, without deadlock. Keys erased
+// before the walk reaches them aren't yielded; keys written during the walk
+// may or may not be. The same goes for Scan and Iterate.
 func (d *Diskv) Keys(cancel <-chan struct{}) <-chan string {
 	return d.KeysPrefix("", cancel)
 }
 
 // KeysPrefix returns a channel that will yield every key accessible by the
-// store with the given prefix, in undefined order. If a cancel channel is
-// provided, closing it will terminate and close the keys channel. If the
-// provided prefix is the empty string, all keys will be yielded.
+// store with the given prefix, in undefined order unless SortedKeys is set.
+// If a cancel channel is provided, closing it will terminate and close the
+// keys channel. If the provided prefix is the empty string, all keys will be
+// yielded. See Keys about slow consumers.
 func (d *Diskv) KeysPrefix(prefix string, cancel <-chan struct{}) <-chan string {
-	var prepath string
-	if prefix == "" {
-		prepath = d.BasePath
-	} else {
-		prefixKey := d.transform(prefix)
-		prepath = d.pathFor(prefixKey)
+	prefix = d.normalizeKey(prefix)
+	if d.authorize(OpList, prefix) != nil {
+		return closedKeys()
 	}
-	c := make(chan string)
-	go func() {
-		filepath.Walk(prepath, d.walker(c, prefix, cancel))
+	return d.keysPrefix(prefix, nil, cancel)
+}
+
+// keysPrefix is KeysPrefix, without authorization, for a normalized prefix.
+// If match isn't nil, only the keys it returns true for are yielded.
+func (d *Diskv) keysPrefix(prefix string, match func(key string) bool, cancel <-chan struct{}) <-chan string {
+	prepath := d.prefixPath(prefix)
+	span := d.startSpan("Keys", prefix)
+	c := make(chan string, d.KeysBuffer)
+	goLabeled("keys", func() {
+		var (
+			n   = 0
+			err error
+		)
+		if d.Index != nil && d.IndexLess != nil {
+			err = d.indexKeys(c, prefix, match, cancel, &n)
+		} else if d.SortedKeys {
+			err = d.walkSorted(c, prepath, prefix, match, cancel, &n)
+		} else {
+			send := d.sender(c, cancel, &n)
+			err = d.walkKeys(prepath, prefix, func(key string, info os.FileInfo) error {
+				if match != nil && !match(key) {
+					return nil
+				}
+				return send(key, info)
+			})
+		}
 		close(c)
-	}()
+		span.SetAttribute("keys", n)
+		span.End(err)
+	})
 	return c
 }
 
-// walker returns a function which satisfies the filepath.WalkFunc interface.
-// It sends every non-directory file entry down the channel c.
-func (d *Diskv) walker(c chan<- string, prefix string, cancel <-chan struct{}) filepath.WalkFunc {
+// prefixPath returns the directory which contains every key with the given
+// prefix.
+func (d *Diskv) prefixPath(prefix string) string {
+	if prefix == "" {
+		return d.BasePath
+	}
+	return d.pathFor(d.transform(prefix))
+}
+
+// sender returns a function which sends every key it's called with down
+// the channel c, until cancel is closed. The count n is incremented for every
+// key sent.
+func (d *Diskv) sender(c chan<- string, cancel <-chan struct{}, n *int) keyFunc {
+	return func(key string, _ os.FileInfo) error {
+		select {
+		case c <- key:
+			*n++
+		case <-cancel:
+			return errCanceled
+		}
+		return nil
+	}
+}
+
+// ignored returns true if the given file or directory name matches any of
+// the IgnorePatterns.
+func (d *Diskv) ignored(name string) bool {
+	for _, pattern := range d.IgnorePatterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// keyFunc is called by walkKeys for every key, with the FileInfo of its file.
+type keyFunc func(key string, info os.FileInfo) error
+
+// walkKeys calls fn with the key of every file under prepath with the given
+// prefix, and then of every packed and chunked value with the prefix. If
+// WalkConcurrency is set, directories are listed concurrently, but calls to
+// fn are still serialized.
+func (d *Diskv) walkKeys(prepath, prefix string, fn keyFunc) error {
+	err := d.walkFiles(prepath, prefix, fn)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	n, packErr := d.walkPacked(prefix, fn)
+	if packErr != nil {
+		return packErr
+	}
+	m, chunkErr := d.walkChunked(prefix, fn)
+	if chunkErr != nil {
+		return chunkErr
+	}
+	if n+m > 0 {
+		return nil // there are keys after all
+	}
+	return err
+}
+
+// walkFiles calls fn with the key of every file under prepath with the
+// given prefix.
+func (d *Diskv) walkFiles(prepath, prefix string, fn keyFunc) error {
+	if d.WalkConcurrency <= 1 {
+		return walk(d.fs, prepath, d.keyWalker(prepath, prefix, fn))
+	}
+	var mu sync.Mutex
+	return walkParallel(d.fs, prepath, d.WalkConcurrency, d.keyWalker(prepath, prefix, func(key string, info os.FileInfo) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return fn(key, info)
+	}))
+}
+
+// walkSorted walks prepath like walkKeys, but collects the keys first, and
+// sends them down the channel c in lexicographic order. If match isn't nil,
+// only the keys it returns true for are sent.
+func (d *Diskv) walkSorted(c chan<- string, prepath, prefix string, match func(key string) bool, cancel <-chan struct{}, n *int) error {
+	var keys []string
+	if err := d.walkKeys(prepath, prefix, func(key string, _ os.FileInfo) error {
+		select {
+		case <-cancel:
+			return errCanceled
+		default:
+		}
+		if match != nil && !match(key) {
+			return nil
+		}
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		select {
+		case c <- key:
+			*n++
+		case <-cancel:
+			return errCanceled
+		}
+	}
+	return nil
+}
+
+// keyWalker returns a function which satisfies the filepath.WalkFunc
+// interface. It calls fn with the key of every non-directory file entry
+// with the given prefix, and stops the walk if fn returns an error. Files
+// and directories below root which vanish during the walk, e.g. because
+// the consumer of the keys erased them, are skipped.
+func (d *Diskv) keyWalker(root, prefix string, fn keyFunc) filepath.WalkFunc {
 	return func(path string, info os.FileInfo, err error) error {
-		if err != nil {
+		if os.IsNotExist(err) && path != root {
+			return nil
+		} else if err != nil {
 			return err
 		}
 
 		relPath, _ := filepath.Rel(d.BasePath, path)
-		dir, file := filepath.Split(relPath)
-		pathSplit := strings.Split(dir, string(filepath.Separator))
-		pathSplit = pathSplit[:len(pathSplit)-1]
+		if relPath != "." && d.ignored(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if (relPath == packDirname || relPath == chunkDirname) && info.IsDir() {
+			return filepath.SkipDir // walked by walkPacked and walkChunked
+		}
 
-		pathKey := &PathKey{
-			Path:     pathSplit,
-			FileName: file,
+		if info.Mode()&os.ModeSymlink != 0 {
+			if skip, err := d.walkSymlink(path); err != nil {
+				return err
+			} else if skip {
+				return nil
+			}
 		}
 
-		key := d.InverseTransform(pathKey)
+		key := d.inverseTransformPath(relPath)
 
-		if info.IsDir() || !strings.HasPrefix(key, prefix) {
+		if d.StrictKeys && !info.IsDir() && !isInternalFile(relPath) {
+			if err := d.checkKeyPath(key, path); err != nil {
+				d.foreignFile(path, err)
+				return nil
+			}
+		}
+
+		if info.IsDir() || key == "" || isInternalFile(relPath) || !strings.HasPrefix(key, prefix) || d.expired(key) {
 			return nil // "pass"
 		}
 
-		select {
-		case c <- key:
-		case <-cancel:
-			return errCanceled
+		return fn(key, info)
+	}
+}
+
+// inverseTransformPath returns the key for the file at the given path,
+// relative to BasePath, according to InverseTransform.
+func (d *Diskv) inverseTransformPath(relPath string) string {
+	dir, file := filepath.Split(relPath)
+	if d.FileSuffix != "" {
+		if !strings.HasSuffix(file, d.FileSuffix) {
+			return ""
 		}
+		file = strings.TrimSuffix(file, d.FileSuffix)
+	}
+	pathSplit := strings.Split(dir, string(filepath.Separator))
+	pathSplit = pathSplit[:len(pathSplit)-1]
 
-		return nil
+	pathKey := &PathKey{
+		Path:     pathSplit,
+		FileName: file,
+	}
+
+	return d.InverseTransform(pathKey)
+}
+
+// isInternalFile returns true if the file at the given path, relative to
+// BasePath, holds diskv's own data rather than a key.
+func isInternalFile(relPath string) bool {
+	switch relPath {
+	case ManifestFilename, expiryFilename, expiryFilename + ".tmp", expiryLogFilename, accessFilename, accessFilename + ".tmp",
+		indexFilename, indexFilename + ".tmp", bloomFilename, bloomFilename + ".tmp",
+		pinsFilename, pinsFilename + ".tmp", writeLogFilename:
+		return true
 	}
+	return strings.HasPrefix(relPath, journalPrefix) || strings.HasPrefix(relPath, packDirname+string(filepath.Separator)) ||
+		strings.HasPrefix(relPath, chunkDirname+string(filepath.Separator)) ||
+		strings.HasPrefix(relPath, seqDirname+string(filepath.Separator)) ||
+		strings.HasPrefix(relPath, tombstonesFilename)
 }
 
 // pathFor returns the absolute path for location on the filesystem where the
@@ -618,22 +2221,97 @@ func (d *Diskv) pathFor(pathKey *PathKey) string {
 
 // ensurePathWithLock is a helper function that generates all necessary
 // directories on the filesystem for the given key.
+//
+// The last directory ensured is remembered, and not checked again for the
+// next key stored in it, which saves a MkdirAll for runs of keys in the same
+// directory, e.g. with SequentialTransform.
 func (d *Diskv) ensurePathWithLock(pathKey *PathKey) error {
-	return os.MkdirAll(d.pathFor(pathKey), d.PathPerm)
+	dir := d.pathFor(pathKey)
+	if dir == d.lastDir {
+		return nil
+	}
+	d.lastDir = ""
+
+	if d.OnFileCreated == nil {
+		if err := d.fs.MkdirAll(dir, d.PathPerm); err != nil {
+			return err
+		}
+		d.lastDir = dir
+		return nil
+	}
+
+	// Create each directory individually, so the hook sees every new one.
+	dirs := append([]string{d.BasePath}, pathKey.Path...)
+	for i := range dirs {
+		sub := filepath.Join(dirs[:i+1]...)
+		if i == 0 {
+			if err := d.fs.MkdirAll(filepath.Dir(sub), d.PathPerm); err != nil {
+				return err
+			}
+		}
+		if err := d.fs.Mkdir(sub, d.PathPerm); os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := d.OnFileCreated(sub); err != nil {
+			return fmt.Errorf("on file created: %s", err)
+		}
+	}
+	d.lastDir = dir
+	return nil
+}
+
+// forgetLastDirWithLock forgets the directory remembered by
+// ensurePathWithLock, e.g. after it may have been removed, and reports
+// whether there was one. A caller that failed because the directory is
+// missing can then ensure it again and retry.
+func (d *Diskv) forgetLastDirWithLock() bool {
+	had := d.lastDir != ""
+	d.lastDir = ""
+	return had
 }
 
 // completeFilename returns the absolute path to the file for the given key.
 func (d *Diskv) completeFilename(pathKey *PathKey) string {
-	return filepath.Join(d.pathFor(pathKey), pathKey.FileName)
+	return filepath.Join(d.pathFor(pathKey), pathKey.FileName+d.FileSuffix)
+}
+
+// keyPathError explains why the key's file couldn't be created, once it
+// failed: ErrKeyIsDirectory if its path is a directory, ErrKeyPathConflict
+// if one of its directories is a file, or nil if neither.
+func (d *Diskv) keyPathError(pathKey *PathKey) error {
+	if fi, err := d.fs.Stat(d.completeFilename(pathKey)); err == nil && fi.IsDir() {
+		return ErrKeyIsDirectory
+	}
+	dir := d.BasePath
+	for _, pathPart := range pathKey.Path {
+		dir = filepath.Join(dir, pathPart)
+		if fi, err := d.fs.Stat(dir); err != nil {
+			return nil
+		} else if !fi.IsDir() {
+			return ErrKeyPathConflict
+		}
+	}
+	return nil
 }
 
 // cacheWithLock attempts to cache the given key-value pair in the store's
 // cache. It can fail if the value is larger than the cache's maximum size.
 func (d *Diskv) cacheWithLock(key string, val []byte) error {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+
 	// If the key already exists, delete it.
-	d.bustCacheWithLock(key)
+	d.uncacheWithCacheLock(key)
 
-	valueSize := uint64(len(val))
+	if d.tooLargeToCache(uint64(len(val))) {
+		return errTooLargeToCache
+	}
+	if d.CacheAdmission != nil && !d.CacheAdmission.Admit(key, uint64(len(val))) {
+		return errNotAdmitted
+	}
+	valueSize := d.cacheEntrySize(key, val)
 	if err := d.ensureCacheSpaceWithLock(valueSize); err != nil {
 		return fmt.Errorf("%s; not caching", err)
 	}
@@ -648,55 +2326,125 @@ func (d *Diskv) cacheWithLock(key string, val []byte) error {
 	return nil
 }
 
-// cacheWithoutLock acquires the store's (write) mutex and calls cacheWithLock.
-func (d *Diskv) cacheWithoutLock(key string, val []byte) error {
+// fillCache caches a value read from disk, unless the key may have been
+// rewritten since the read began: that is, unless nothing at all was written
+// since generation gen, or the key's file is unchanged from fi.
+func (d *Diskv) fillCache(key string, val []byte, gen uint64, fi os.FileInfo) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+
+	if pfi, ok := fi.(*packFileInfo); ok && atomic.LoadUint64(&d.gen) != gen {
+		if cur, ok := d.packStat(key); !ok || cur.(*packFileInfo).loc != pfi.loc {
+			return errStale
+		}
+	} else if atomic.LoadUint64(&d.gen) != gen {
+		cur, err := d.fs.Stat(d.completeFilename(d.transform(key)))
+		if err != nil || !sameFile(cur, fi) || !cur.ModTime().Equal(fi.ModTime()) || cur.Size() != fi.Size() {
+			return errStale
+		}
+	}
 	return d.cacheWithLock(key, val)
 }
 
+// invalidateWithLock removes the key from the cache, and prevents any reads
+// already in flight from caching a stale value.
+func (d *Diskv) invalidateWithLock(key string) {
+	atomic.AddUint64(&d.gen, 1)
+	d.bustCacheWithLock(key)
+}
+
+// tooLargeToCache returns true if values of the given size are never cached,
+// per CacheMaxValueSize.
+func (d *Diskv) tooLargeToCache(size uint64) bool {
+	return d.CacheMaxValueSize > 0 && size > d.CacheMaxValueSize
+}
+
+// cacheError reports a failure to cache the given key to the
+// CacheErrorHandler, if one is set.
+func (d *Diskv) cacheError(key string, err error) {
+	if d.CacheErrorHandler != nil {
+		d.CacheErrorHandler(key, err)
+	}
+}
+
+// cacheEntrySize returns the number of bytes the given key-value pair counts
+// toward CacheSizeMax.
+func (d *Diskv) cacheEntrySize(key string, val []byte) uint64 {
+	sz := uint64(len(val)) + d.CacheEntryOverhead
+	if d.CacheCountKeys {
+		sz += uint64(len(key))
+	}
+	return sz
+}
+
+// cacheLookup returns the cached value of the key, if any. If caching is
+// disabled, it doesn't look. Callers needn't hold d.mu.
+func (d *Diskv) cacheLookup(key string) ([]byte, bool) {
+	if d.CacheSizeMax == 0 {
+		return nil, false
+	}
+	d.cacheMu.RLock()
+	defer d.cacheMu.RUnlock()
+	val, ok := d.cache[key]
+	return val, ok
+}
+
+// bustCacheWithLock uncaches the key. Callers must hold d.mu.
 func (d *Diskv) bustCacheWithLock(key string) {
-	if val, ok := d.cache[key]; ok {
-		d.uncacheWithLock(key, uint64(len(val)))
+	if d.CacheSizeMax == 0 {
+		return // nothing is ever cached
 	}
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	d.uncacheWithCacheLock(key)
 }
 
-func (d *Diskv) uncacheWithLock(key string, sz uint64) {
-	d.cacheSize -= sz
-	delete(d.cache, key)
+// resetCacheWithLock uncaches every key. Callers must hold d.mu.
+func (d *Diskv) resetCacheWithLock() {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	d.cache = make(map[string][]byte)
+	d.cacheSize = 0
 }
 
-// pruneDirsWithLock deletes empty directories in the path walk leading to the
-// key k. Typically this function is called after an Erase is made.
-func (d *Diskv) pruneDirsWithLock(key string) error {
+// uncacheWithCacheLock uncaches the key, if it's cached. Callers must hold
+// d.cacheMu.
+func (d *Diskv) uncacheWithCacheLock(key string) {
+	if val, ok := d.cache[key]; ok {
+		d.cacheSize -= d.cacheEntrySize(key, val)
+		delete(d.cache, key)
+	}
+}
+
+// pruneDirsWithLock removes the empty directories in the path walk leading
+// to the key's file, deepest first. It's best effort: a directory which is
+// already gone, e.g. because another process sharing BasePath pruned it, is
+// skipped, and pruning stops at the first directory which isn't empty, or
+// isn't a directory, or can't be removed. Callers must hold d.mu.
+func (d *Diskv) pruneDirsWithLock(key string) {
 	pathlist := d.transform(key).Path
 	for i := range pathlist {
 		dir := filepath.Join(d.BasePath, filepath.Join(pathlist[:len(pathlist)-i]...))
 
-		// thanks to Steven Blenkinsop for this snippet
-		switch fi, err := os.Stat(dir); true {
-		case err != nil:
-			return err
-		case !fi.IsDir():
-			panic(fmt.Sprintf("corrupt dirstate at %s", dir))
+		names, err := readDirNames(d.fs, dir)
+		if os.IsNotExist(err) {
+			d.forgetLastDirWithLock()
+			continue
+		} else if err != nil || len(names) > 0 {
+			return // not empty, or not a directory -- do not prune
 		}
-
-		nlinks, err := filepath.Glob(filepath.Join(dir, "*"))
-		if err != nil {
-			return err
-		} else if len(nlinks) > 0 {
-			return nil // has subdirs -- do not prune
-		}
-		if err = os.Remove(dir); err != nil {
-			return err
+		if err := d.fs.Remove(dir); err != nil && !os.IsNotExist(err) {
+			return
 		}
+		d.forgetLastDirWithLock()
 	}
-
-	return nil
 }
 
-// ensureCacheSpaceWithLock deletes entries from the cache in arbitrary order
-// until the cache has at least valueSize bytes available.
+// ensureCacheSpaceWithLock deletes entries from the cache in arbitrary order,
+// or least recently read first with TrackAccess, until the cache has at
+// least valueSize bytes available. Pinned entries are never deleted, so it
+// fails if they leave too little room. Callers must hold d.mu and
+// d.cacheMu.
 func (d *Diskv) ensureCacheSpaceWithLock(valueSize uint64) error {
 	if valueSize > d.CacheSizeMax {
 		return fmt.Errorf("value size (%d bytes) too large for cache (%d bytes)", valueSize, d.CacheSizeMax)
@@ -704,21 +2452,44 @@ func (d *Diskv) ensureCacheSpaceWithLock(valueSize uint64) error {
 
 	safe := func() bool { return (d.cacheSize + valueSize) <= d.CacheSizeMax }
 
-	for key, val := range d.cache {
+	if d.TrackAccess {
+		d.evictByAccessWithLock(safe)
+	}
+
+	for key := range d.cache {
 		if safe() {
 			break
 		}
+		if d.pinned(key) {
+			continue
+		}
 
-		d.uncacheWithLock(key, uint64(len(val)))
+		d.uncacheWithCacheLock(key)
 	}
 
 	if !safe() {
-		panic(fmt.Sprintf("%d bytes still won't fit in the cache! (max %d bytes)", valueSize, d.CacheSizeMax))
+		return fmt.Errorf("value size (%d bytes) won't fit in the cache beside pinned values", valueSize)
 	}
 
 	return nil
 }
 
+// maxSizeReader reads from r, but fails with ErrValueTooLarge once more than
+// n bytes have been read.
+type maxSizeReader struct {
+	r io.Reader
+	n int64
+}
+
+func (mr *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := mr.r.Read(p)
+	mr.n -= int64(n)
+	if mr.n < 0 {
+		return n, ErrValueTooLarge
+	}
+	return n, err
+}
+
 // nopWriteCloser wraps an io.Writer and provides a no-op Close method to
 // satisfy the io.WriteCloser interface.
 type nopWriteCloser struct {
@@ -0,0 +1,79 @@
+// Package diskvhttpcache adapts a diskv store to the Cache interface of
+// github.com/gregjones/httpcache, for caching HTTP responses on disk:
+//
+//	t := httpcache.NewTransport(diskvhttpcache.New("/var/cache/http"))
+//	client := t.Client()
+package diskvhttpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+const (
+	// DefaultCacheSizeMax is the in-memory cache size of stores created by
+	// New.
+	DefaultCacheSizeMax = 100 << 20 // bytes
+
+	// DefaultMaxValueSize is the size of the largest response stores
+	// created by New keep. Larger responses aren't cached.
+	DefaultMaxValueSize = 64 << 20 // bytes
+)
+
+// Cache implements httpcache.Cache on top of a Diskv. Cache keys, which
+// are typically URLs, are hashed with SHA-256 to get diskv keys, so they
+// may be of any length and contain any character.
+type Cache struct {
+	d *diskv.Diskv
+}
+
+// New returns a Cache which stores responses under basePath, with
+// DefaultOptions.
+func New(basePath string) *Cache {
+	return NewWithDiskv(diskv.New(DefaultOptions(basePath)))
+}
+
+// NewWithDiskv returns a Cache backed by the given store. It should have a
+// transform which bounds the fan-out of directories of hex keys, like the
+// one of DefaultOptions.
+func NewWithDiskv(d *diskv.Diskv) *Cache {
+	return &Cache{d: d}
+}
+
+// DefaultOptions returns the options New uses: two levels of directories
+// named by the first four hex characters of the hashed key, so that no
+// directory has more than 256 entries for up to some 16 million responses,
+// and the default size limits.
+func DefaultOptions(basePath string) diskv.Options {
+	return diskv.Options{
+		BasePath:       basePath,
+		NamedTransform: diskv.PrefixTransform(2, 2),
+		CacheSizeMax:   DefaultCacheSizeMax,
+		MaxValueSize:   DefaultMaxValueSize,
+	}
+}
+
+// Get returns the response stored under the key, if any.
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	resp, err := c.d.Read(keyToFilename(key))
+	return resp, err == nil
+}
+
+// Set stores the response under the key. httpcache has no way to report
+// errors, so a response which can't be stored, e.g. because it's larger
+// than MaxValueSize, isn't cached.
+func (c *Cache) Set(key string, resp []byte) {
+	c.d.Write(keyToFilename(key), resp) // error deliberately ignored
+}
+
+// Delete removes the response stored under the key, if any.
+func (c *Cache) Delete(key string) {
+	c.d.Erase(keyToFilename(key)) // error deliberately ignored
+}
+
+func keyToFilename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,45 @@
+package diskvhttpcache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// httpcacheCache is httpcache.Cache.
+type httpcacheCache interface {
+	Get(key string) (responseBytes []byte, ok bool)
+	Set(key string, responseBytes []byte)
+	Delete(key string)
+}
+
+var _ httpcacheCache = (*Cache)(nil)
+
+func TestCache(t *testing.T) {
+	defer os.RemoveAll("test-data")
+	c := New("test-data")
+
+	key := "https://example.com/" + strings.Repeat("long/path/", 100) + "?q=1"
+	if _, ok := c.Get(key); ok {
+		t.Fatal("Get on empty cache succeeded")
+	}
+	c.Set(key, []byte("HTTP/1.1 200 OK\r\n\r\nhello"))
+	resp, ok := c.Get(key)
+	if !ok || !bytes.HasSuffix(resp, []byte("hello")) {
+		t.Fatalf("Get: %q, %v", resp, ok)
+	}
+
+	// Stored two directories deep, named after the hashed key.
+	matches, _ := filepath.Glob(filepath.Join("test-data", "*", "*", keyToFilename(key)))
+	if len(matches) != 1 {
+		t.Fatalf("want 1 file two levels deep, have %v", matches)
+	}
+
+	c.Delete(key)
+	if _, ok := c.Get(key); ok {
+		t.Fatal("Get after Delete succeeded")
+	}
+	c.Delete(key) // no panic on missing key
+}
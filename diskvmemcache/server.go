@@ -0,0 +1,274 @@
+// Package diskvmemcache serves a diskv store over the memcached text
+// protocol, so that it can stand in for memcached where cached objects are
+// large, or should survive restarts.
+//
+// The supported commands are get, set, delete, touch and quit, with
+// expiration times. Each item's client flags are stored with its value, as
+// a 4-byte big-endian header, so items written through the server should be
+// read through it too.
+package diskvmemcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+const (
+	// defaultMaxItemSize is used if Server.MaxItemSize is zero.
+	defaultMaxItemSize = 64 << 20 // bytes
+
+	// maxKeyLen is memcached's limit on key length.
+	maxKeyLen = 250
+
+	// maxRelativeExptime is the largest expiration time which memcached
+	// treats as relative; larger ones are Unix times.
+	maxRelativeExptime = 60 * 60 * 24 * 30
+)
+
+// Server serves a Diskv over the memcached text protocol.
+type Server struct {
+	// MaxItemSize is the size of the largest value clients may set.
+	MaxItemSize int64
+
+	d *diskv.Diskv
+}
+
+// NewServer returns a Server for the given store.
+func NewServer(d *diskv.Diskv) *Server {
+	return &Server{d: d}
+}
+
+// Serve accepts connections on the listener and serves each of them in its
+// own goroutine, until the listener is closed. It always returns a non-nil
+// error.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single connection until the client quits or
+// disconnects, or sends a malformed command, and then closes it.
+func (s *Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		r = bufio.NewReader(conn)
+		w = bufio.NewWriter(conn)
+	)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) <= 0 {
+			fmt.Fprint(w, "ERROR\r\n")
+		} else if fields[0] == "quit" {
+			w.Flush()
+			return
+		} else if !s.exec(w, r, fields) {
+			w.Flush()
+			return
+		}
+		// Flush once the client has no more pipelined commands.
+		if r.Buffered() <= 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// exec runs a single command, and writes its reply. It returns false if the
+// connection can't continue, e.g. because a data block was malformed.
+func (s *Server) exec(w *bufio.Writer, r *bufio.Reader, fields []string) bool {
+	cmd, args := fields[0], fields[1:]
+
+	// noreply suppresses the reply to storage and deletion commands.
+	noreply := false
+	if len(args) > 0 && args[len(args)-1] == "noreply" && cmd != "get" {
+		noreply, args = true, args[:len(args)-1]
+	}
+	reply := func(format string, a ...interface{}) {
+		if !noreply {
+			fmt.Fprintf(w, format+"\r\n", a...)
+		}
+	}
+
+	switch cmd {
+	case "get":
+		if len(args) <= 0 {
+			fmt.Fprint(w, "ERROR\r\n")
+			return true
+		}
+		for _, key := range args {
+			if !validKey(key) {
+				fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+				return true
+			}
+		}
+		for _, key := range args {
+			val, err := s.d.Read(key)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				fmt.Fprintf(w, "SERVER_ERROR %s\r\n", oneLine(err))
+				return true
+			}
+			if len(val) < 4 {
+				continue // not written through the server
+			}
+			fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, binary.BigEndian.Uint32(val), len(val)-4)
+			w.Write(val[4:])
+			fmt.Fprint(w, "\r\n")
+		}
+		fmt.Fprint(w, "END\r\n")
+
+	case "set":
+		if len(args) != 4 || !validKey(args[0]) {
+			fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+			return true
+		}
+		flags, err1 := strconv.ParseUint(args[1], 10, 32)
+		exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+		size, err3 := strconv.ParseInt(args[3], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil || size < 0 {
+			fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+			return true
+		}
+		maxItemSize := s.MaxItemSize
+		if maxItemSize <= 0 {
+			maxItemSize = defaultMaxItemSize
+		}
+		if size > maxItemSize {
+			// Like memcached, swallow the data block, then report the error.
+			if _, err := io.CopyN(ioutil.Discard, r, size+2); err != nil {
+				return false
+			}
+			reply("SERVER_ERROR object too large for cache")
+			return true
+		}
+
+		data := make([]byte, 4+size+2)
+		binary.BigEndian.PutUint32(data, uint32(flags))
+		if _, err := io.ReadFull(r, data[4:]); err != nil {
+			return false
+		}
+		if !bytes.HasSuffix(data, []byte("\r\n")) {
+			fmt.Fprint(w, "CLIENT_ERROR bad data chunk\r\n")
+			return false
+		}
+		data = data[:4+size]
+
+		ttl, expired := expiry(exptime)
+		if expired {
+			// An item which expires immediately is never visible.
+			if err := s.d.Erase(args[0]); err != nil && !os.IsNotExist(err) {
+				reply("SERVER_ERROR %s", oneLine(err))
+				return true
+			}
+			reply("STORED")
+			return true
+		}
+		if err := s.d.WriteWith(args[0], bytes.NewReader(data), diskv.WriteOptions{TTL: ttl}); err != nil {
+			reply("SERVER_ERROR %s", oneLine(err))
+			return true
+		}
+		reply("STORED")
+
+	case "delete":
+		if len(args) != 1 || !validKey(args[0]) {
+			fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+			return true
+		}
+		err := s.d.Erase(args[0])
+		switch {
+		case err == nil:
+			reply("DELETED")
+		case os.IsNotExist(err):
+			reply("NOT_FOUND")
+		default:
+			reply("SERVER_ERROR %s", oneLine(err))
+		}
+
+	case "touch":
+		if len(args) != 2 || !validKey(args[0]) {
+			fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+			return true
+		}
+		exptime, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+			return true
+		}
+		ttl, expired := expiry(exptime)
+		if expired {
+			err = s.d.Erase(args[0])
+		} else {
+			err = s.d.Expire(args[0], ttl)
+		}
+		switch {
+		case err == nil:
+			reply("TOUCHED")
+		case os.IsNotExist(err):
+			reply("NOT_FOUND")
+		default:
+			reply("SERVER_ERROR %s", oneLine(err))
+		}
+
+	default:
+		fmt.Fprint(w, "ERROR\r\n")
+	}
+	return true
+}
+
+// expiry converts a memcached expiration time to a TTL, which is zero for
+// none, or reports that the item has already expired.
+func expiry(exptime int64) (ttl time.Duration, expired bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= maxRelativeExptime:
+		return time.Duration(exptime) * time.Second, false
+	}
+	ttl = time.Until(time.Unix(exptime, 0))
+	return ttl, ttl <= 0
+}
+
+// validKey reports whether the key is a valid memcached key. Whitespace is
+// ruled out by the parsing of the command line.
+func validKey(key string) bool {
+	if len(key) > maxKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// oneLine replaces line breaks in the error message, which would corrupt
+// the stream.
+func oneLine(err error) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+}
@@ -0,0 +1,73 @@
+package diskvmemcache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+func TestServer(t *testing.T) {
+	d := diskv.New(diskv.Options{BasePath: "test-data"})
+	defer os.RemoveAll("test-data")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go (&Server{d: d, MaxItemSize: 16}).ServeConn(server)
+	r := bufio.NewReader(client)
+
+	for _, tc := range []struct {
+		send string
+		want string
+	}{
+		{"get a\r\n", "END\r\n"},
+		{"set a 42 0 12\r\nhello\r\nworld\r\n", "STORED\r\n"},
+		{"get a b\r\n", "VALUE a 42 12\r\nhello\r\nworld\r\nEND\r\n"},
+		{"set b 0 1 1\r\nx\r\n", "STORED\r\n"},
+		{"set c 0 0 1 noreply\r\ny\r\n", ""},
+		{"get a b c\r\n", "VALUE a 42 12\r\nhello\r\nworld\r\nVALUE b 0 1\r\nx\r\nVALUE c 0 1\r\ny\r\nEND\r\n"},
+		{"set big 0 0 17\r\n01234567890123456\r\n", "SERVER_ERROR object too large for cache\r\n"},
+		{"set gone 0 -1 1\r\nz\r\n", "STORED\r\n"},
+		{"get big gone\r\n", "END\r\n"},
+		{"touch c 1\r\n", "TOUCHED\r\n"},
+		{"touch x 1\r\n", "NOT_FOUND\r\n"},
+		{"delete a\r\n", "DELETED\r\n"},
+		{"delete a\r\n", "NOT_FOUND\r\n"},
+		{"set a 0 0 zz\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"incr a 1\r\n", "ERROR\r\n"},
+	} {
+		fmt.Fprint(client, tc.send)
+		if have := readReply(t, r, len(tc.want)); have != tc.want {
+			t.Errorf("%q: want %q, have %q", tc.send, tc.want, have)
+		}
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	fmt.Fprint(client, "get b c\r\n")
+	if have, want := readReply(t, r, 5), "END\r\n"; have != want {
+		t.Errorf("after expiry: want %q, have %q", want, have)
+	}
+
+	fmt.Fprint(client, "quit\r\n")
+	if _, err := r.ReadByte(); err == nil {
+		t.Error("connection still open after quit")
+	}
+}
+
+func readReply(t *testing.T, r *bufio.Reader, n int) string {
+	t.Helper()
+	var b strings.Builder
+	for b.Len() < n {
+		c, err := r.ReadByte()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,314 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package diskvpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// DiskvClient is the client API for Diskv service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DiskvClient interface {
+	// Get returns the value of a key. It fails with NOT_FOUND if the key
+	// doesn't exist.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// Put writes a value under a key.
+	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
+	// Delete erases a key. It fails with NOT_FOUND if the key doesn't exist.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// List streams the keys with a prefix.
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (Diskv_ListClient, error)
+	// Watch streams the store's journal, starting after a sequence number,
+	// until the client cancels. The store must have a journal.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Diskv_WatchClient, error)
+}
+
+type diskvClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDiskvClient(cc grpc.ClientConnInterface) DiskvClient {
+	return &diskvClient{cc}
+}
+
+func (c *diskvClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, "/diskv.Diskv/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *diskvClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error) {
+	out := new(PutResponse)
+	err := c.cc.Invoke(ctx, "/diskv.Diskv/Put", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *diskvClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/diskv.Diskv/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *diskvClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (Diskv_ListClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Diskv_ServiceDesc.Streams[0], "/diskv.Diskv/List", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &diskvListClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Diskv_ListClient interface {
+	Recv() (*ListResponse, error)
+	grpc.ClientStream
+}
+
+type diskvListClient struct {
+	grpc.ClientStream
+}
+
+func (x *diskvListClient) Recv() (*ListResponse, error) {
+	m := new(ListResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *diskvClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Diskv_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Diskv_ServiceDesc.Streams[1], "/diskv.Diskv/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &diskvWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Diskv_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type diskvWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *diskvWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DiskvServer is the server API for Diskv service.
+// All implementations must embed UnimplementedDiskvServer
+// for forward compatibility
+type DiskvServer interface {
+	// Get returns the value of a key. It fails with NOT_FOUND if the key
+	// doesn't exist.
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// Put writes a value under a key.
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+	// Delete erases a key. It fails with NOT_FOUND if the key doesn't exist.
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// List streams the keys with a prefix.
+	List(*ListRequest, Diskv_ListServer) error
+	// Watch streams the store's journal, starting after a sequence number,
+	// until the client cancels. The store must have a journal.
+	Watch(*WatchRequest, Diskv_WatchServer) error
+	mustEmbedUnimplementedDiskvServer()
+}
+
+// UnimplementedDiskvServer must be embedded to have forward compatible implementations.
+type UnimplementedDiskvServer struct {
+}
+
+func (UnimplementedDiskvServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedDiskvServer) Put(context.Context, *PutRequest) (*PutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
+}
+func (UnimplementedDiskvServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedDiskvServer) List(*ListRequest, Diskv_ListServer) error {
+	return status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedDiskvServer) Watch(*WatchRequest, Diskv_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedDiskvServer) mustEmbedUnimplementedDiskvServer() {}
+
+// UnsafeDiskvServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DiskvServer will
+// result in compilation errors.
+type UnsafeDiskvServer interface {
+	mustEmbedUnimplementedDiskvServer()
+}
+
+func RegisterDiskvServer(s grpc.ServiceRegistrar, srv DiskvServer) {
+	s.RegisterService(&Diskv_ServiceDesc, srv)
+}
+
+func _Diskv_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DiskvServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/diskv.Diskv/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DiskvServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Diskv_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DiskvServer).Put(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/diskv.Diskv/Put",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DiskvServer).Put(ctx, req.(*PutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Diskv_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DiskvServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/diskv.Diskv/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DiskvServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Diskv_List_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DiskvServer).List(m, &diskvListServer{stream})
+}
+
+type Diskv_ListServer interface {
+	Send(*ListResponse) error
+	grpc.ServerStream
+}
+
+type diskvListServer struct {
+	grpc.ServerStream
+}
+
+func (x *diskvListServer) Send(m *ListResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Diskv_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DiskvServer).Watch(m, &diskvWatchServer{stream})
+}
+
+type Diskv_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type diskvWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *diskvWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Diskv_ServiceDesc is the grpc.ServiceDesc for Diskv service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Diskv_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "diskv.Diskv",
+	HandlerType: (*DiskvServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _Diskv_Get_Handler,
+		},
+		{
+			MethodName: "Put",
+			Handler:    _Diskv_Put_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _Diskv_Delete_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "List",
+			Handler:       _Diskv_List_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _Diskv_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "diskv.proto",
+}
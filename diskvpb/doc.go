@@ -0,0 +1,16 @@
+// Package diskvpb serves a diskv store over gRPC, so that clients in any
+// language can use it. The service is defined in diskv.proto.
+//
+// It's a module of its own, so that google.golang.org/grpc and
+// google.golang.org/protobuf, which it depends on, aren't requirements of
+// every program that uses diskv. The generated code, diskv.pb.go and
+// diskv_grpc.pb.go, is committed; run go generate in this directory after
+// changing diskv.proto. To serve a store:
+//
+//	lis, _ := net.Listen("tcp", ":8080")
+//	s := grpc.NewServer()
+//	diskvpb.RegisterDiskvServer(s, diskvpb.NewServer(d))
+//	s.Serve(lis)
+package diskvpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative diskv.proto
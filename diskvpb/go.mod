@@ -0,0 +1,11 @@
+module github.com/peterbourgon/diskv/v3/diskvpb
+
+go 1.12
+
+require (
+	github.com/peterbourgon/diskv/v3 v3.0.0
+	google.golang.org/grpc v1.43.0
+	google.golang.org/protobuf v1.27.1
+)
+
+replace github.com/peterbourgon/diskv/v3 => ../
@@ -0,0 +1,123 @@
+package diskvpb
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+// defaultPollInterval is used if Server.PollInterval is zero.
+const defaultPollInterval = 100 * time.Millisecond
+
+// Server implements DiskvServer, backed by a Diskv.
+type Server struct {
+	UnimplementedDiskvServer
+
+	// PollInterval is how often Watch checks the journal for new entries.
+	PollInterval time.Duration
+
+	d *diskv.Diskv
+}
+
+// NewServer returns a Server for the given store.
+func NewServer(d *diskv.Diskv) *Server {
+	return &Server{d: d}
+}
+
+// Get implements DiskvServer.
+func (s *Server) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	val, err := s.d.Read(req.Key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &GetResponse{Value: val}, nil
+}
+
+// Put implements DiskvServer.
+func (s *Server) Put(ctx context.Context, req *PutRequest) (*PutResponse, error) {
+	opts := diskv.WriteOptions{
+		Sync: req.Sync,
+		TTL:  time.Duration(req.TtlMs) * time.Millisecond,
+	}
+	if err := s.d.WriteWith(req.Key, bytes.NewReader(req.Value), opts); err != nil {
+		return nil, toStatus(err)
+	}
+	return &PutResponse{}, nil
+}
+
+// Delete implements DiskvServer.
+func (s *Server) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	if err := s.d.Erase(req.Key); err != nil {
+		return nil, toStatus(err)
+	}
+	return &DeleteResponse{}, nil
+}
+
+// List implements DiskvServer.
+func (s *Server) List(req *ListRequest, stream Diskv_ListServer) error {
+	cancel := make(chan struct{})
+	defer close(cancel)
+	for key := range s.d.KeysPrefix(req.Prefix, cancel) {
+		if err := stream.Send(&ListResponse{Key: key}); err != nil {
+			return err
+		}
+	}
+	return stream.Context().Err()
+}
+
+// Watch implements DiskvServer.
+func (s *Server) Watch(req *WatchRequest, stream Diskv_WatchServer) error {
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	since := req.Since
+	for {
+		entries, err := s.d.TailJournal(since)
+		if err != nil {
+			return toStatus(err)
+		}
+		for _, e := range entries {
+			if err := stream.Send(&WatchEvent{
+				Seq:          e.Seq,
+				Op:           string(e.Op),
+				Key:          e.Key,
+				TimeUnixNano: e.Time.UnixNano(),
+				Hash:         e.Hash,
+			}); err != nil {
+				return err
+			}
+			since = e.Seq
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// toStatus maps store errors to gRPC status errors.
+func toStatus(err error) error {
+	switch {
+	case os.IsNotExist(err):
+		return status.Error(codes.NotFound, err.Error())
+	case err == diskv.ErrKeyTooLong, err == diskv.ErrValueTooLarge, err == diskv.ErrInvalidKey:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case diskv.IsQuotaExceeded(err):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case err == diskv.ErrJournalTruncated:
+		return status.Error(codes.OutOfRange, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
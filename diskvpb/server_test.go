@@ -0,0 +1,102 @@
+package diskvpb
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+// newTestClient serves the store over an in-memory connection, and returns
+// a client for it, and a function which stops both.
+func newTestClient(t *testing.T, d *diskv.Diskv) (DiskvClient, func()) {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	srv := NewServer(d)
+	srv.PollInterval = time.Millisecond
+	RegisterDiskvServer(s, srv)
+	go s.Serve(lis)
+
+	dial := func(context.Context, string) (net.Conn, error) { return lis.Dial() }
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dial), grpc.WithInsecure())
+	if err != nil {
+		s.Stop()
+		t.Fatal(err)
+	}
+	return NewDiskvClient(conn), func() { conn.Close(); s.Stop() }
+}
+
+func TestServer(t *testing.T) {
+	d := diskv.NewMem(diskv.Options{BasePath: "/diskvpb", Journal: true})
+	c, stop := newTestClient(t, d)
+	defer stop()
+	ctx := context.Background()
+
+	for _, key := range []string{"a1", "a2", "b1"} {
+		if _, err := c.Put(ctx, &PutRequest{Key: key, Value: []byte("v-" + key)}); err != nil {
+			t.Fatalf("Put %s: %s", key, err)
+		}
+	}
+	resp, err := c.Get(ctx, &GetRequest{Key: "a2"})
+	if err != nil || string(resp.Value) != "v-a2" {
+		t.Fatalf("Get: want v-a2, have %v, %v", resp, err)
+	}
+
+	list, err := c.List(ctx, &ListRequest{Prefix: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for {
+		r, err := list.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, r.Key)
+	}
+	sort.Strings(keys)
+	if want := []string{"a1", "a2"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("List: want %v, have %v", want, keys)
+	}
+
+	if _, err := c.Delete(ctx, &DeleteRequest{Key: "a1"}); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if _, err := c.Get(ctx, &GetRequest{Key: "a1"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("Get after Delete: want NotFound, have %v", err)
+	}
+	if _, err := c.Delete(ctx, &DeleteRequest{Key: "a1"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("Delete twice: want NotFound, have %v", err)
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watch, err := c.Watch(wctx, &WatchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for len(ops) < 4 {
+		e, err := watch.Recv()
+		if err != nil {
+			t.Fatalf("Watch: %s", err)
+		}
+		ops = append(ops, e.Op+" "+e.Key)
+	}
+	if want := []string{"write a1", "write a2", "write b1", "erase a1"}; !reflect.DeepEqual(ops, want) {
+		t.Fatalf("Watch: want %v, have %v", want, ops)
+	}
+}
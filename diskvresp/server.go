@@ -0,0 +1,377 @@
+// Package diskvresp serves a diskv store over a subset of the Redis
+// protocol (RESP), so that existing Redis clients and tools can use it.
+//
+// The supported commands are GET, SET (with EX or PX), DEL, EXISTS, EXPIRE,
+// SCAN (with MATCH and COUNT), PING and QUIT. Everything else is answered
+// with an error.
+package diskvresp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+// defaultMaxBulkLen is used if Server.MaxBulkLen is zero. It's Redis's
+// default proto-max-bulk-len.
+const defaultMaxBulkLen = 512 << 20 // bytes
+
+// defaultMaxArgs is used if Server.MaxArgs is zero. It's enough for SCAN
+// with both MATCH and COUNT, and for DEL and EXISTS of a few keys.
+const defaultMaxArgs = 16
+
+var errProtocol = errors.New("protocol error")
+
+// Server serves a Diskv over RESP.
+type Server struct {
+	// MaxBulkLen is the size of the largest argument, e.g. a value, that
+	// clients may send.
+	MaxBulkLen int64
+
+	// MaxArgs is the largest number of elements, including the command
+	// name, that a command sent as an array may have. It's checked before
+	// any are read, so that a client can't make the server allocate room
+	// for millions of them.
+	MaxArgs int
+
+	d *diskv.Diskv
+}
+
+// NewServer returns a Server for the given store.
+func NewServer(d *diskv.Diskv) *Server {
+	return &Server{d: d}
+}
+
+// Serve accepts connections on the listener and serves each of them in its
+// own goroutine, until the listener is closed. It always returns a non-nil
+// error.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single connection until the client quits or
+// disconnects, or sends a malformed command, and then closes it.
+func (s *Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		r = bufio.NewReader(conn)
+		w = bufio.NewWriter(conn)
+	)
+	for {
+		args, err := s.readCommand(r)
+		if err != nil {
+			if err != io.EOF {
+				writeError(w, "ERR "+err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) <= 0 {
+			continue
+		}
+
+		quit := strings.ToUpper(string(args[0])) == "QUIT"
+		if quit {
+			writeSimple(w, "OK")
+		} else {
+			s.exec(w, args)
+		}
+		// Flush once the client has no more pipelined commands.
+		if r.Buffered() <= 0 || quit {
+			if err := w.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// exec runs a single command, and writes its reply.
+func (s *Server) exec(w *bufio.Writer, args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	args = args[1:]
+
+	switch name {
+	case "PING":
+		switch len(args) {
+		case 0:
+			writeSimple(w, "PONG")
+		case 1:
+			writeBulk(w, args[0])
+		default:
+			writeArity(w, name)
+		}
+
+	case "GET":
+		if len(args) != 1 {
+			writeArity(w, name)
+			return
+		}
+		val, err := s.d.Read(string(args[0]))
+		if os.IsNotExist(err) {
+			writeNull(w)
+		} else if err != nil {
+			writeError(w, "ERR "+err.Error())
+		} else {
+			writeBulk(w, val)
+		}
+
+	case "SET":
+		if len(args) != 2 && len(args) != 4 {
+			writeError(w, "ERR syntax error")
+			return
+		}
+		var opts diskv.WriteOptions
+		if len(args) == 4 {
+			n, err := strconv.ParseInt(string(args[3]), 10, 64)
+			if err != nil || n <= 0 {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				return
+			}
+			switch strings.ToUpper(string(args[2])) {
+			case "EX":
+				opts.TTL = time.Duration(n) * time.Second
+			case "PX":
+				opts.TTL = time.Duration(n) * time.Millisecond
+			default:
+				writeError(w, "ERR syntax error")
+				return
+			}
+		}
+		if err := s.d.WriteWith(string(args[0]), bytes.NewReader(args[1]), opts); err != nil {
+			writeError(w, "ERR "+err.Error())
+			return
+		}
+		writeSimple(w, "OK")
+
+	case "DEL", "EXISTS":
+		if len(args) < 1 {
+			writeArity(w, name)
+			return
+		}
+		n := 0
+		for _, key := range args {
+			if name == "EXISTS" {
+				if s.d.Has(string(key)) {
+					n++
+				}
+				continue
+			}
+			if err := s.d.Erase(string(key)); err == nil {
+				n++
+			} else if !os.IsNotExist(err) {
+				writeError(w, "ERR "+err.Error())
+				return
+			}
+		}
+		writeInt(w, int64(n))
+
+	case "EXPIRE":
+		if len(args) != 2 {
+			writeArity(w, name)
+			return
+		}
+		n, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			writeError(w, "ERR value is not an integer or out of range")
+			return
+		}
+		key := string(args[0])
+		if n <= 0 {
+			// Like Redis, a non-positive TTL deletes the key.
+			err = s.d.Erase(key)
+		} else {
+			err = s.d.Expire(key, time.Duration(n)*time.Second)
+		}
+		if os.IsNotExist(err) {
+			writeInt(w, 0)
+		} else if err != nil {
+			writeError(w, "ERR "+err.Error())
+		} else {
+			writeInt(w, 1)
+		}
+
+	case "SCAN":
+		s.scan(w, args)
+
+	default:
+		writeError(w, fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(name)))
+	}
+}
+
+// scan implements SCAN. The cursor is an offset into the sorted matching
+// keys, so keys written or erased between calls may be skipped or
+// returned twice, as Redis allows.
+func (s *Server) scan(w *bufio.Writer, args [][]byte) {
+	if len(args) < 1 || len(args)%2 != 1 {
+		writeError(w, "ERR syntax error")
+		return
+	}
+	cursor, err := strconv.Atoi(string(args[0]))
+	if err != nil || cursor < 0 {
+		writeError(w, "ERR invalid cursor")
+		return
+	}
+	var (
+		pattern = "*"
+		count   = 10
+	)
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+			if _, err := path.Match(pattern, ""); err != nil {
+				writeError(w, "ERR invalid pattern")
+				return
+			}
+		case "COUNT":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil || count < 1 {
+				writeError(w, "ERR syntax error")
+				return
+			}
+		default:
+			writeError(w, "ERR syntax error")
+			return
+		}
+	}
+
+	// Walk only the keys with the pattern's literal prefix.
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:i]
+	}
+	var keys []string
+	for key := range s.d.KeysPrefix(prefix, nil) {
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	next := cursor + count
+	if cursor > len(keys) {
+		cursor = len(keys)
+	}
+	if next >= len(keys) {
+		next = 0
+		keys = keys[cursor:]
+	} else {
+		keys = keys[cursor:next]
+	}
+
+	fmt.Fprintf(w, "*2\r\n")
+	writeBulk(w, []byte(strconv.Itoa(next)))
+	fmt.Fprintf(w, "*%d\r\n", len(keys))
+	for _, key := range keys {
+		writeBulk(w, []byte(key))
+	}
+}
+
+// readCommand reads a command, either as an array of bulk strings, as sent
+// by clients, or inline, as typed into e.g. telnet.
+func (s *Server) readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) <= 0 || line[0] != '*' {
+		var args [][]byte
+		for _, field := range strings.Fields(string(line)) {
+			args = append(args, []byte(field))
+		}
+		return args, nil
+	}
+
+	maxArgs := s.MaxArgs
+	if maxArgs <= 0 {
+		maxArgs = defaultMaxArgs
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxArgs {
+		return nil, errProtocol
+	}
+	maxBulkLen := s.MaxBulkLen
+	if maxBulkLen <= 0 {
+		maxBulkLen = defaultMaxBulkLen
+	}
+	args := make([][]byte, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) <= 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		size, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, errProtocol
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, errProtocol
+		}
+		args[i] = buf[:size]
+	}
+	return args, nil
+}
+
+// readLine reads a line terminated by CRLF, or by LF alone.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errProtocol
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+func writeSimple(w *bufio.Writer, s string) { fmt.Fprintf(w, "+%s\r\n", s) }
+func writeInt(w *bufio.Writer, n int64)     { fmt.Fprintf(w, ":%d\r\n", n) }
+func writeNull(w *bufio.Writer)             { w.WriteString("$-1\r\n") }
+
+// writeError writes an error reply. Line breaks, which would corrupt the
+// stream, are replaced by spaces.
+func writeError(w *bufio.Writer, msg string) {
+	fmt.Fprintf(w, "-%s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(msg))
+}
+
+func writeArity(w *bufio.Writer, name string) {
+	writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+func writeBulk(w *bufio.Writer, b []byte) {
+	fmt.Fprintf(w, "$%d\r\n", len(b))
+	w.Write(b)
+	w.WriteString("\r\n")
+}
@@ -0,0 +1,100 @@
+package diskvresp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+func TestServer(t *testing.T) {
+	d := diskv.New(diskv.Options{BasePath: "test-data"})
+	defer os.RemoveAll("test-data")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go NewServer(d).ServeConn(server)
+	r := bufio.NewReader(client)
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PING"}, "+PONG\r\n"},
+		{[]string{"GET", "a"}, "$-1\r\n"},
+		{[]string{"SET", "a", "hello\r\nworld"}, "+OK\r\n"},
+		{[]string{"GET", "a"}, "$12\r\nhello\r\nworld\r\n"},
+		{[]string{"SET", "b", "2", "PX", "20"}, "+OK\r\n"},
+		{[]string{"SET", "c", "3", "NX"}, "-ERR syntax error\r\n"},
+		{[]string{"set", "c", "3"}, "+OK\r\n"},
+		{[]string{"EXISTS", "a", "b", "x"}, ":2\r\n"},
+		{[]string{"SCAN", "0", "COUNT", "2"}, "*2\r\n$1\r\n2\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"SCAN", "2", "COUNT", "2"}, "*2\r\n$1\r\n0\r\n*1\r\n$1\r\nc\r\n"},
+		{[]string{"SCAN", "0", "MATCH", "[ac]"}, "*2\r\n$1\r\n0\r\n*2\r\n$1\r\na\r\n$1\r\nc\r\n"},
+		{[]string{"EXPIRE", "c", "100"}, ":1\r\n"},
+		{[]string{"EXPIRE", "x", "100"}, ":0\r\n"},
+		{[]string{"DEL", "a", "x"}, ":1\r\n"},
+		{[]string{"GET"}, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{[]string{"FLUSHALL"}, "-ERR unknown command 'flushall'\r\n"},
+	} {
+		fmt.Fprintf(client, "*%d\r\n", len(tc.args))
+		for _, arg := range tc.args {
+			fmt.Fprintf(client, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+		if have := readReply(t, r, len(tc.want)); have != tc.want {
+			t.Errorf("%v: want %q, have %q", tc.args, tc.want, have)
+		}
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	fmt.Fprintf(client, "EXISTS b\r\n") // inline
+	if have, want := readReply(t, r, 4), ":0\r\n"; have != want {
+		t.Errorf("after expiry: want %q, have %q", want, have)
+	}
+
+	fmt.Fprintf(client, "QUIT\r\n")
+	if have, want := readReply(t, r, 5), "+OK\r\n"; have != want {
+		t.Errorf("QUIT: want %q, have %q", want, have)
+	}
+	if _, err := r.ReadByte(); err == nil {
+		t.Error("connection still open after QUIT")
+	}
+}
+
+func TestServerMaxArgs(t *testing.T) {
+	d := diskv.New(diskv.Options{BasePath: "test-data"})
+	defer os.RemoveAll("test-data")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go (&Server{MaxArgs: 3, d: d}).ServeConn(server)
+	r := bufio.NewReader(client)
+
+	// Rejected on the header alone, before any argument is read.
+	go fmt.Fprintf(client, "*1000000\r\n")
+	want := "-ERR protocol error\r\n"
+	if have := readReply(t, r, len(want)); have != want {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if _, err := r.ReadByte(); err == nil {
+		t.Error("connection still open after protocol error")
+	}
+}
+
+func readReply(t *testing.T, r *bufio.Reader, n int) string {
+	t.Helper()
+	var b strings.Builder
+	for b.Len() < n {
+		c, err := r.ReadByte()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,352 @@
+// Package diskvs3 serves diskv stores over a minimal subset of the S3 API:
+// PutObject, GetObject, HeadObject, DeleteObject and ListObjectsV2, with
+// path-style addressing, i.e. http://host/bucket/key. Each bucket is a
+// separate store.
+//
+// Requests aren't authenticated: signatures are accepted, but not checked.
+// Put the handler behind something which does, or only expose it to
+// trusted networks.
+package diskvs3
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+// defaultMaxKeys is the default, and maximum, number of keys per
+// ListObjectsV2 response, as in S3.
+const defaultMaxKeys = 1000
+
+// Handler is an http.Handler which serves the S3 API subset.
+type Handler struct {
+	buckets map[string]*diskv.Diskv
+}
+
+// NewHandler returns a Handler serving the given stores, by bucket name.
+// Object keys may contain slashes, which diskv keys can't, so keys are
+// escaped on the way in and out; see EscapeKey.
+//
+// Give the stores a TempDir, so that a PUT which fails, e.g. because its
+// Content-MD5 doesn't match, leaves the object it would have replaced
+// intact, as in S3. Without one, the object is lost.
+func NewHandler(buckets map[string]*diskv.Diskv) *Handler {
+	return &Handler{buckets: buckets}
+}
+
+// EscapeKey returns the diskv key under which an object key is stored:
+// "%" and "/" are percent-encoded. Use it to access objects written through
+// the handler directly.
+func EscapeKey(objectKey string) string {
+	return keyEscaper.Replace(objectKey)
+}
+
+// UnescapeKey returns the object key of a diskv key, reversing EscapeKey.
+func UnescapeKey(key string) string {
+	return keyUnescaper.Replace(key)
+}
+
+var (
+	keyEscaper   = strings.NewReplacer("%", "%25", "/", "%2F")
+	keyUnescaper = strings.NewReplacer("%25", "%", "%2F", "/")
+)
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, objectKey := splitPath(r.URL.Path)
+	if bucket == "" {
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "ListBuckets is not supported")
+		return
+	}
+	d, ok := h.buckets[bucket]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	switch {
+	case objectKey == "" && r.Method == "GET" && r.URL.Query().Get("list-type") == "2":
+		h.listObjects(w, r, d, bucket)
+	case objectKey == "":
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "Only ListObjectsV2 is supported on buckets")
+	case r.Method == "PUT":
+		h.putObject(w, r, d, objectKey)
+	case r.Method == "GET" || r.Method == "HEAD":
+		h.getObject(w, r, d, objectKey)
+	case r.Method == "DELETE":
+		// Like S3, deleting a missing object succeeds.
+		if err := d.Erase(EscapeKey(objectKey)); err != nil && !os.IsNotExist(err) {
+			writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource")
+	}
+}
+
+func (h *Handler) putObject(w http.ResponseWriter, r *http.Request, d *diskv.Diskv, objectKey string) {
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "CopyObject is not supported")
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body = &chunkedReader{r: bufio.NewReader(r.Body)}
+	}
+	mr := &md5Reader{r: body, hash: md5.New(), want: r.Header.Get("Content-Md5")}
+	if err := d.WriteStream(EscapeKey(objectKey), mr, false); err != nil {
+		switch {
+		case mr.bad:
+			writeError(w, http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received")
+		case err == diskv.ErrValueTooLarge:
+			writeError(w, http.StatusBadRequest, "EntityTooLarge", err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		}
+		return
+	}
+	w.Header().Set("ETag", `"`+hex.EncodeToString(mr.hash.Sum(nil))+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, d *diskv.Diskv, objectKey string) {
+	rc, err := d.ReadStreamWith(EscapeKey(objectKey), diskv.ReadStreamOptions{})
+	if os.IsNotExist(err) {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	defer rc.Close()
+
+	// The size of a (possibly compressed) value is only known once it's
+	// read, so HEAD reads it too.
+	if r.Method == "HEAD" {
+		n, err := io.Copy(ioutil.Discard, rc)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	io.Copy(w, rc) // errors can't be reported once the body has started
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []object       `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (h *Handler) listObjects(w http.ResponseWriter, r *http.Request, d *diskv.Diskv, bucket string) {
+	q := r.URL.Query()
+	result := listBucketResult{
+		Name:              bucket,
+		Prefix:            q.Get("prefix"),
+		Delimiter:         q.Get("delimiter"),
+		StartAfter:        q.Get("start-after"),
+		ContinuationToken: q.Get("continuation-token"),
+		MaxKeys:           defaultMaxKeys,
+	}
+	if s := q.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys")
+			return
+		}
+		if n < result.MaxKeys {
+			result.MaxKeys = n
+		}
+	}
+	after := result.StartAfter
+	if result.ContinuationToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "Invalid continuation token")
+			return
+		}
+		after = string(token)
+	}
+
+	var keys []string
+	for key := range d.KeysPrefix(EscapeKey(result.Prefix), nil) {
+		if objectKey := UnescapeKey(key); objectKey > after {
+			keys = append(keys, objectKey)
+		}
+	}
+	sort.Strings(keys)
+
+	var last string
+	for _, objectKey := range keys {
+		var p string // common prefix, if any
+		if result.Delimiter != "" {
+			rest := objectKey[len(result.Prefix):]
+			if i := strings.Index(rest, result.Delimiter); i >= 0 {
+				p = objectKey[:len(result.Prefix)+i+len(result.Delimiter)]
+			}
+		}
+		if p != "" && strings.HasPrefix(last, p) {
+			continue // rolled up into the last common prefix
+		}
+		if result.KeyCount >= result.MaxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+			break
+		}
+		if p != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: p})
+			result.KeyCount++
+			last = p + "\U0010FFFF" // resume after every key with the prefix
+			continue
+		}
+		fi, err := d.Stat(EscapeKey(objectKey))
+		if os.IsNotExist(err) {
+			continue // erased since it was listed
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		result.Contents = append(result.Contents, object{
+			Key:          objectKey,
+			LastModified: fi.ModTime().UTC().Format("2006-01-02T15:04:05.000Z"),
+			Size:         fi.Size(), // after compression, if any
+			StorageClass: "STANDARD",
+		})
+		result.KeyCount++
+		last = objectKey
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(result) // errors can't be reported once the body has started
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(errorResponse{Code: code, Message: message})
+}
+
+// splitPath splits a path-style request path into the bucket and the
+// object key.
+func splitPath(p string) (bucket, objectKey string) {
+	p = strings.TrimPrefix(p, "/")
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+var errBadDigest = errors.New("Content-MD5 mismatch")
+
+// md5Reader hashes the body of a PUT as it's read, and fails at EOF if its
+// MD5 doesn't match the Content-MD5 header, so that the write is aborted
+// before the value replaces the object's old one.
+type md5Reader struct {
+	r    io.Reader
+	hash hash.Hash
+	want string // base64, or empty to accept any body
+	bad  bool
+}
+
+func (m *md5Reader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.hash.Write(p[:n])
+	if err == io.EOF && m.want != "" && m.want != base64.StdEncoding.EncodeToString(m.hash.Sum(nil)) {
+		m.bad = true
+		return n, errBadDigest
+	}
+	return n, err
+}
+
+var errChunk = errors.New("malformed aws-chunked body")
+
+// chunkedReader decodes the aws-chunked encoding which SDKs use for
+// streaming uploads: each chunk is preceded by its size in hex and a
+// signature, which is ignored.
+type chunkedReader struct {
+	r    *bufio.Reader
+	n    int64 // left in the current chunk
+	done bool
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	for cr.n <= 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		line, err := cr.r.ReadString('\n')
+		if err != nil {
+			return 0, errChunk
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue // the CRLF after the previous chunk
+		}
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		n, err := strconv.ParseInt(line, 16, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%s: chunk size %q", errChunk, line)
+		}
+		cr.n, cr.done = n, n == 0
+	}
+	if int64(len(p)) > cr.n {
+		p = p[:cr.n]
+	}
+	n, err := cr.r.Read(p)
+	cr.n -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
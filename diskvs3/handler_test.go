@@ -0,0 +1,160 @@
+package diskvs3
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+func TestHandler(t *testing.T) {
+	d := diskv.New(diskv.Options{BasePath: "test-data"})
+	defer os.RemoveAll("test-data")
+	s := httptest.NewServer(NewHandler(map[string]*diskv.Diskv{"bucket": d}))
+	defer s.Close()
+
+	for _, key := range []string{"a/1", "a/2", "a/b/3", "b", "c%d"} {
+		resp := do(t, "PUT", s.URL+"/bucket/"+strings.Replace(key, "%", "%25", -1), "value of "+key, nil)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == "" {
+			t.Fatalf("PUT %s: %s", key, resp.Status)
+		}
+	}
+	if !d.Has("a%2F1") || !d.Has("c%25d") {
+		t.Fatal("keys not escaped")
+	}
+
+	resp := do(t, "GET", s.URL+"/bucket/a/b/3", "", nil)
+	if body := readBody(t, resp); resp.StatusCode != http.StatusOK || body != "value of a/b/3" {
+		t.Fatalf("GET: %s %q", resp.Status, body)
+	}
+	if resp := do(t, "HEAD", s.URL+"/bucket/b", "", nil); resp.ContentLength != int64(len("value of b")) {
+		t.Fatalf("HEAD: want length %d, have %d", len("value of b"), resp.ContentLength)
+	}
+	if resp := do(t, "GET", s.URL+"/bucket/x", "", nil); resp.StatusCode != http.StatusNotFound || !strings.Contains(readBody(t, resp), "NoSuchKey") {
+		t.Fatalf("GET missing: %s", resp.Status)
+	}
+	if resp := do(t, "GET", s.URL+"/nobucket/x", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET missing bucket: %s", resp.Status)
+	}
+
+	for _, tc := range []struct {
+		query    string
+		keys     []string
+		prefixes []string
+		more     bool
+	}{
+		{"", []string{"a/1", "a/2", "a/b/3", "b", "c%d"}, nil, false},
+		{"prefix=a/", []string{"a/1", "a/2", "a/b/3"}, nil, false},
+		{"delimiter=/", []string{"b", "c%d"}, []string{"a/"}, false},
+		{"prefix=a/&delimiter=/", []string{"a/1", "a/2"}, []string{"a/b/"}, false},
+		{"max-keys=2", []string{"a/1", "a/2"}, nil, true},
+		{"start-after=a/2", []string{"a/b/3", "b", "c%d"}, nil, false},
+	} {
+		result := list(t, s.URL+"/bucket?list-type=2&"+tc.query)
+		if keys := objectKeys(result); !reflect.DeepEqual(keys, tc.keys) || result.IsTruncated != tc.more {
+			t.Errorf("%q: want %v (truncated %v), have %v (truncated %v)", tc.query, tc.keys, tc.more, keys, result.IsTruncated)
+		}
+		var prefixes []string
+		for _, p := range result.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		if !reflect.DeepEqual(prefixes, tc.prefixes) {
+			t.Errorf("%q: want prefixes %v, have %v", tc.query, tc.prefixes, prefixes)
+		}
+	}
+
+	result := list(t, s.URL+"/bucket?list-type=2&max-keys=2")
+	result = list(t, s.URL+"/bucket?list-type=2&continuation-token="+result.NextContinuationToken)
+	if keys, want := objectKeys(result), []string{"a/b/3", "b", "c%d"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("continuation: want %v, have %v", want, keys)
+	}
+
+	if resp := do(t, "DELETE", s.URL+"/bucket/a/1", "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: %s", resp.Status)
+	}
+	if d.Has("a%2F1") {
+		t.Fatal("DELETE didn't erase the key")
+	}
+
+	// Streaming uploads from SDKs use aws-chunked encoding.
+	chunked := "5;chunk-signature=abc\r\nhello\r\n6;chunk-signature=def\r\n world\r\n0;chunk-signature=ghi\r\n\r\n"
+	do(t, "PUT", s.URL+"/bucket/chunked", chunked, map[string]string{"X-Amz-Content-Sha256": "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"})
+	if have := d.ReadString("chunked"); have != "hello world" {
+		t.Fatalf("chunked PUT: want %q, have %q", "hello world", have)
+	}
+
+	resp = do(t, "PUT", s.URL+"/bucket/bad", "abc", map[string]string{"Content-MD5": "AAAAAAAAAAAAAAAAAAAAAA=="})
+	if resp.StatusCode != http.StatusBadRequest || d.Has("bad") {
+		t.Fatalf("PUT with bad Content-MD5: %s", resp.Status)
+	}
+}
+
+func TestPutBadDigestKeepsObject(t *testing.T) {
+	d := diskv.New(diskv.Options{BasePath: "test-data", TempDir: "test-data-tmp"})
+	defer os.RemoveAll("test-data")
+	defer os.RemoveAll("test-data-tmp")
+	s := httptest.NewServer(NewHandler(map[string]*diskv.Diskv{"bucket": d}))
+	defer s.Close()
+
+	if resp := do(t, "PUT", s.URL+"/bucket/k", "original", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT: %s", resp.Status)
+	}
+	resp := do(t, "PUT", s.URL+"/bucket/k", "replacement", map[string]string{"Content-MD5": "AAAAAAAAAAAAAAAAAAAAAA=="})
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(readBody(t, resp), "BadDigest") {
+		t.Fatalf("PUT with bad Content-MD5: %s", resp.Status)
+	}
+	resp = do(t, "GET", s.URL+"/bucket/k", "", nil)
+	if body := readBody(t, resp); resp.StatusCode != http.StatusOK || body != "original" {
+		t.Fatalf("GET after bad PUT: want original, have %s %q", resp.Status, body)
+	}
+}
+
+func do(t *testing.T, method, url, body string, header map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func list(t *testing.T, url string) listBucketResult {
+	t.Helper()
+	resp := do(t, "GET", url, "", nil)
+	var result listBucketResult
+	if err := xml.Unmarshal([]byte(readBody(t, resp)), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func objectKeys(result listBucketResult) []string {
+	var keys []string
+	for _, o := range result.Contents {
+		keys = append(keys, o.Key)
+	}
+	return keys
+}
@@ -0,0 +1,63 @@
+package diskvtest
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+// Benchmark runs write, cached read and uncached read benchmarks against
+// stores created from o, for each of the given value sizes. Call it from
+// your own benchmark function. The store at o.BasePath is erased.
+func Benchmark(b *testing.B, o diskv.Options, sizes ...int) {
+	if len(sizes) <= 0 {
+		sizes = []int{32, 1024, 32768}
+	}
+	const keyCount = 1000
+	keys := make([]string, keyCount)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("bench-%06d", i)
+	}
+
+	for _, size := range sizes {
+		val := make([]byte, size)
+		rand.Read(val)
+
+		b.Run(fmt.Sprintf("Write/%d", size), func(b *testing.B) {
+			d := diskv.New(o)
+			defer d.EraseAll()
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				if err := d.Write(keys[i%keyCount], val); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		for _, cached := range []bool{true, false} {
+			name := fmt.Sprintf("ReadUncached/%d", size)
+			if cached {
+				name = fmt.Sprintf("ReadCached/%d", size)
+			}
+			b.Run(name, func(b *testing.B) {
+				d := diskv.New(o)
+				defer d.EraseAll()
+				for _, key := range keys {
+					if err := d.Write(key, val); err != nil {
+						b.Fatal(err)
+					}
+				}
+				opts := diskv.ReadOptions{SkipCache: !cached, NoFill: !cached}
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := d.ReadWith(keys[i%keyCount], opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
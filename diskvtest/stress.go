@@ -0,0 +1,198 @@
+// Package diskvtest provides a concurrent stress harness and benchmarks for
+// diskv stores, so that users can validate their Options and filesystems.
+package diskvtest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+// StressConfig describes a stress run. Zero values are replaced with
+// reasonable defaults.
+type StressConfig struct {
+	Workers int // concurrent goroutines
+	Ops     int // operations per worker
+	Keys    int // size of the key space
+
+	// Relative weights of reads, writes and erases.
+	ReadWeight, WriteWeight, EraseWeight int
+
+	MinValueSize, MaxValueSize int // bytes
+
+	Seed int64
+}
+
+// StressResult summarizes a stress run.
+type StressResult struct {
+	Reads, Writes, Erases int
+
+	// Latency percentiles (p50, p99, max) per operation, keyed by "read",
+	// "write" and "erase".
+	Latency map[string][3]time.Duration
+
+	// Violations describes every invariant violation that was observed.
+	Violations []string
+}
+
+// Stress runs a concurrent mix of reads, writes and erases against d, and
+// checks that every read returns a complete value written for that key, and
+// that the store's final contents match the last write or erase of every
+// key. Each key is written and erased by a single worker, but read by all
+// of them. Stress erases the store first; don't point it at real data.
+func Stress(d *diskv.Diskv, cfg StressConfig) (StressResult, error) {
+	cfg = withDefaults(cfg)
+	result := StressResult{Latency: map[string][3]time.Duration{}}
+
+	if err := d.EraseAll(); err != nil {
+		return result, err
+	}
+
+	var (
+		mu        sync.Mutex
+		latencies = map[string][]time.Duration{}
+		final     = make([][]byte, cfg.Keys) // nil means erased
+		wg        sync.WaitGroup
+	)
+	violation := func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		result.Violations = append(result.Violations, fmt.Sprintf(format, args...))
+	}
+	record := func(op string, took time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		latencies[op] = append(latencies[op], took)
+	}
+
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(cfg.Seed + int64(w)))
+			total := cfg.ReadWeight + cfg.WriteWeight + cfg.EraseWeight
+
+			for i := 0; i < cfg.Ops; i++ {
+				n := rng.Intn(total)
+				switch {
+				case n < cfg.ReadWeight:
+					k := rng.Intn(cfg.Keys)
+					began := time.Now()
+					val, err := d.Read(keyName(k))
+					record("read", time.Since(began))
+					if err != nil && !os.IsNotExist(err) {
+						violation("read %s: %s", keyName(k), err)
+					} else if err == nil && !checkValue(keyName(k), val) {
+						violation("read %s: corrupt value (%d bytes)", keyName(k), len(val))
+					}
+
+				case n < cfg.ReadWeight+cfg.WriteWeight:
+					k := ownedKey(rng, w, cfg)
+					val := makeValue(rng, keyName(k), cfg)
+					began := time.Now()
+					err := d.Write(keyName(k), val)
+					record("write", time.Since(began))
+					if err != nil {
+						violation("write %s: %s", keyName(k), err)
+						continue
+					}
+					final[k] = val
+
+				default:
+					k := ownedKey(rng, w, cfg)
+					began := time.Now()
+					err := d.Erase(keyName(k))
+					record("erase", time.Since(began))
+					if err != nil && !os.IsNotExist(err) {
+						violation("erase %s: %s", keyName(k), err)
+						continue
+					}
+					final[k] = nil
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for k, want := range final {
+		got, err := d.Read(keyName(k))
+		switch {
+		case want == nil && err == nil:
+			violation("final %s: erased key present", keyName(k))
+		case want != nil && err != nil:
+			violation("final %s: %s", keyName(k), err)
+		case want != nil && !bytes.Equal(want, got):
+			violation("final %s: stale value", keyName(k))
+		}
+	}
+
+	result.Reads = len(latencies["read"])
+	result.Writes = len(latencies["write"])
+	result.Erases = len(latencies["erase"])
+	for op, l := range latencies {
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		result.Latency[op] = [3]time.Duration{l[len(l)/2], l[len(l)*99/100], l[len(l)-1]}
+	}
+	return result, nil
+}
+
+func withDefaults(cfg StressConfig) StressConfig {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 8
+	}
+	if cfg.Ops <= 0 {
+		cfg.Ops = 1000
+	}
+	if cfg.Keys < cfg.Workers {
+		cfg.Keys = 100 * cfg.Workers
+	}
+	if cfg.ReadWeight+cfg.WriteWeight+cfg.EraseWeight <= 0 {
+		cfg.ReadWeight, cfg.WriteWeight, cfg.EraseWeight = 8, 3, 1
+	}
+	if cfg.MinValueSize <= 0 {
+		cfg.MinValueSize = 1
+	}
+	if cfg.MaxValueSize < cfg.MinValueSize {
+		cfg.MaxValueSize = cfg.MinValueSize + 4096
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	return cfg
+}
+
+// ownedKey picks a key that only worker w writes or erases.
+func ownedKey(rng *rand.Rand, w int, cfg StressConfig) int {
+	perWorker := cfg.Keys / cfg.Workers
+	return w*perWorker + rng.Intn(perWorker)
+}
+
+func keyName(k int) string {
+	return fmt.Sprintf("stress-%08d", k)
+}
+
+// makeValue returns a self-checking value for key: a random payload,
+// followed by the SHA1 of the key and payload.
+func makeValue(rng *rand.Rand, key string, cfg StressConfig) []byte {
+	payload := make([]byte, cfg.MinValueSize+rng.Intn(cfg.MaxValueSize-cfg.MinValueSize+1))
+	rng.Read(payload)
+	sum := sha1.Sum(append([]byte(key), payload...))
+	return append(payload, sum[:]...)
+}
+
+// checkValue verifies a value produced by makeValue for key.
+func checkValue(key string, val []byte) bool {
+	if len(val) < sha1.Size {
+		return false
+	}
+	payload, sum := val[:len(val)-sha1.Size], val[len(val)-sha1.Size:]
+	want := sha1.Sum(append([]byte(key), payload...))
+	return bytes.Equal(sum, want[:])
+}
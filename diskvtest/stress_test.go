@@ -0,0 +1,47 @@
+package diskvtest
+
+import (
+	"testing"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+func TestStress(t *testing.T) {
+	for name, o := range map[string]diskv.Options{
+		"uncached": {BasePath: "test-data"},
+		"cached":   {BasePath: "test-data", CacheSizeMax: 64 * 1024},
+		"atomic":   {BasePath: "test-data", TempDir: "test-data-temp", CacheSizeMax: 64 * 1024},
+		"nested":   {BasePath: "test-data", NamedTransform: diskv.BlockTransform(3), CacheSizeMax: 64 * 1024},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := diskv.New(o)
+			defer d.EraseAll()
+
+			result, err := Stress(d, StressConfig{Workers: 4, Ops: 200, Keys: 40, MaxValueSize: 512})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range result.Violations {
+				t.Error(v)
+			}
+			if result.Reads+result.Writes+result.Erases != 800 {
+				t.Errorf("expected 800 operations, got %+v", result)
+			}
+		})
+	}
+}
+
+func BenchmarkFlat(b *testing.B) {
+	Benchmark(b, diskv.Options{BasePath: "bench-data", CacheSizeMax: 1 << 20}, 1024)
+}
+
+func TestStressMem(t *testing.T) {
+	d := diskv.NewMem(diskv.Options{BasePath: "test-data", TempDir: "test-data-temp", CacheSizeMax: 64 * 1024})
+	result, err := Stress(d, StressConfig{Workers: 4, Ops: 500, Keys: 40, MaxValueSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range result.Violations {
+		t.Error(v)
+	}
+}
@@ -0,0 +1,46 @@
+package diskv
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DirFanOut reports the number of entries in a directory of the store.
+type DirFanOut struct {
+	Path    string // relative to BasePath
+	Entries int
+}
+
+// FanOut walks the store, and returns every directory with more than max
+// entries, largest first. Very large directories are the usual cause of
+// latency cliffs on common filesystems; the fix is to Migrate the store to a
+// transform with a bounded fan-out, like HashPathTransform.
+func (d *Diskv) FanOut(max int) ([]DirFanOut, error) {
+	var over []DirFanOut
+	err := walk(d.fs, d.BasePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == d.BasePath && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+
+		names, err := readDirNames(d.fs, path)
+		if err != nil {
+			return err
+		}
+
+		if len(names) > max {
+			rel, _ := filepath.Rel(d.BasePath, path)
+			over = append(over, DirFanOut{Path: rel, Entries: len(names)})
+		}
+		return nil
+	})
+
+	sort.Slice(over, func(i, j int) bool { return over[i].Entries > over[j].Entries })
+	return over, err
+}
@@ -0,0 +1,29 @@
+package diskv
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFanOut(t *testing.T) {
+	d := New(Options{
+		BasePath:  "test-data",
+		Transform: func(s string) []string { return []string{s[:1]} },
+	})
+	defer d.EraseAll()
+
+	for i := 0; i < 10; i++ {
+		d.WriteString(fmt.Sprintf("a%d", i), "1")
+	}
+	for i := 0; i < 3; i++ {
+		d.WriteString(fmt.Sprintf("b%d", i), "1")
+	}
+
+	over, err := d.FanOut(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(over) != 1 || over[0].Path != "a" || over[0].Entries != 10 {
+		t.Fatalf("unexpected result %+v", over)
+	}
+}
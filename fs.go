@@ -0,0 +1,300 @@
+package diskv
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileSystem is an interface that Diskv uses for all of its filesystem
+// operations on BasePath and TempDir. The methods have the same semantics as
+// their counterparts in package os (and ioutil, for TempFile); in
+// particular, errors must satisfy os.IsNotExist and os.IsExist as
+// appropriate. Set Options.FileSystem to run a store against something
+// other than the OS filesystem, e.g. memory (NewMemFileSystem), an afero.Fs
+// adapter, or a wrapper which injects faults in tests. A FileSystem may also
+// implement Chtimes(name string, atime, mtime time.Time) error, as in
+// package os, for WriteOptions.ModTime; the OS and memory ones do.
+type FileSystem interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	TempFile(dir, pattern string) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Remove(name string) error
+	RemoveAll(path string) error
+	Rename(oldpath, newpath string) error
+	Mkdir(name string, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	Chmod(name string, mode os.FileMode) error
+}
+
+// File is an open file in a FileSystem. *os.File satisfies it.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Readdirnames(n int) ([]string, error)
+}
+
+// OSFileSystem returns the FileSystem of the operating system, which is the
+// default.
+func OSFileSystem() FileSystem {
+	return osFS{}
+}
+
+// NewMemFileSystem returns a new, empty FileSystem held entirely in memory.
+func NewMemFileSystem() FileSystem {
+	return newMemFS()
+}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) { return os.Open(name) }
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+func (osFS) TempFile(dir, pattern string) (File, error)   { return ioutil.TempFile(dir, pattern) }
+func (osFS) Stat(name string) (os.FileInfo, error)        { return os.Stat(name) }
+func (osFS) Lstat(name string) (os.FileInfo, error)       { return os.Lstat(name) }
+func (osFS) Remove(name string) error                     { return os.Remove(name) }
+func (osFS) RemoveAll(path string) error                  { return os.RemoveAll(path) }
+func (osFS) Rename(oldpath, newpath string) error         { return os.Rename(oldpath, newpath) }
+func (osFS) Mkdir(name string, perm os.FileMode) error    { return os.Mkdir(name, perm) }
+func (osFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+func (osFS) Chmod(name string, mode os.FileMode) error    { return os.Chmod(name, mode) }
+
+func (osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// chtimes sets the access and modification times of the named file to t,
+// if fs implements Chtimes.
+func chtimes(fs FileSystem, name string, t time.Time) error {
+	c, ok := fs.(interface {
+		Chtimes(name string, atime, mtime time.Time) error
+	})
+	if !ok {
+		return errors.New("FileSystem doesn't implement Chtimes")
+	}
+	return c.Chtimes(name, t, t)
+}
+
+// readDirNames returns the sorted names of the entries in the directory.
+func readDirNames(fs FileSystem, dir string) ([]string, error) {
+	f, err := fs.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close() // error deliberately ignored
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readFile is like ioutil.ReadFile.
+func readFile(fs FileSystem, name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// writeFile is like ioutil.WriteFile.
+func writeFile(fs FileSystem, name string, data []byte, perm os.FileMode) error {
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close() // error deliberately ignored
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir syncs the named directory, so that files created in it, renamed
+// into it or removed from it stay that way after a crash. Windows can't
+// sync directories, nor does it need to.
+func syncDir(fs FileSystem, dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	f, err := fs.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close() // error deliberately ignored
+		return err
+	}
+	return f.Close()
+}
+
+// writeInternalFile atomically replaces the named file, directly in the
+// BasePath, via a temporary file with the suffix ".tmp".
+func (d *Diskv) writeInternalFile(filename string, data []byte) error {
+	if err := d.fs.MkdirAll(d.BasePath, d.PathPerm); err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := writeFile(d.fs, tmp, data, d.FilePerm); err != nil {
+		return err
+	}
+	return d.fs.Rename(tmp, filename)
+}
+
+// walk is like filepath.Walk, but over the given FileSystem.
+func walk(fs FileSystem, root string, fn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(fs, root, info, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkDir(fs FileSystem, path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	names, err := readDirNames(fs, path)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fileInfo, err := fs.Lstat(filename)
+		if err != nil {
+			if err := fn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		err = walkDir(fs, filename, fileInfo, fn)
+		if err != nil && (!fileInfo.IsDir() || err != filepath.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkParallel is like walk, but lists up to workers directories
+// concurrently. fn must be safe for concurrent use. It's called for a
+// directory before any of its contents, but in no particular order
+// otherwise. If fn returns filepath.SkipDir for a file, it's ignored.
+func walkParallel(fs FileSystem, root string, workers int, fn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil || !info.IsDir() {
+		if err := fn(root, info, err); err != nil && err != filepath.SkipDir {
+			return err
+		}
+		return nil
+	}
+
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		queue   = []walkEntry{{root, info}}
+		pending = 1 // directories queued or being listed
+		first   error
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		goLabeled("walk", func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				for len(queue) == 0 && pending > 0 && first == nil {
+					cond.Wait()
+				}
+				if pending == 0 || first != nil {
+					mu.Unlock()
+					return
+				}
+				e := queue[len(queue)-1]
+				queue = queue[:len(queue)-1]
+				mu.Unlock()
+
+				subdirs, err := walkParallelDir(fs, e.path, e.info, fn)
+
+				mu.Lock()
+				if err != nil && first == nil {
+					first = err
+				}
+				queue = append(queue, subdirs...)
+				pending += len(subdirs) - 1
+				mu.Unlock()
+				cond.Broadcast()
+			}
+		})
+	}
+	wg.Wait()
+	return first
+}
+
+type walkEntry struct {
+	path string
+	info os.FileInfo
+}
+
+// walkParallelDir calls fn for the directory at path and the files in it,
+// and returns its subdirectories, which it doesn't descend into.
+func walkParallelDir(fs FileSystem, path string, info os.FileInfo, fn filepath.WalkFunc) ([]walkEntry, error) {
+	names, err := readDirNames(fs, path)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		if err1 == filepath.SkipDir {
+			return nil, nil
+		}
+		return nil, err1
+	}
+
+	var subdirs []walkEntry
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fileInfo, err := fs.Lstat(filename)
+		if err == nil && fileInfo.IsDir() {
+			subdirs = append(subdirs, walkEntry{filename, fileInfo})
+			continue
+		}
+		if err := fn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+			return nil, err
+		}
+	}
+	return subdirs, nil
+}
+
+// sameFile reports whether two FileInfos, returned by the same FileSystem,
+// describe the same file. For FileSystems other than the built-in ones, it
+// conservatively returns false.
+func sameFile(a, b os.FileInfo) bool {
+	if os.SameFile(a, b) {
+		return true
+	}
+	an, aok := a.Sys().(*memNode)
+	bn, bok := b.Sys().(*memNode)
+	return aok && bok && an == bn
+}
@@ -0,0 +1,57 @@
+package diskv
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// faultyFS fails every Rename, to simulate e.g. a full disk at the last step
+// of an atomic write.
+type faultyFS struct {
+	FileSystem
+}
+
+func (faultyFS) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.New("injected fault")}
+}
+
+func TestFileSystemFaultInjection(t *testing.T) {
+	fs := NewMemFileSystem()
+	d := New(Options{
+		BasePath:     "fault-data",
+		TempDir:      "fault-data-temp",
+		CacheSizeMax: 1024,
+		FileSystem:   faultyFS{fs},
+	})
+
+	if err := d.WriteString("a", "1"); err == nil {
+		t.Fatal("expected error from failed rename")
+	}
+	if d.Has("a") {
+		t.Fatal("failed write is visible")
+	}
+	if _, err := d.Read("a"); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error, got %v", err)
+	}
+	if _, err := fs.Stat("fault-data/a"); !os.IsNotExist(err) {
+		t.Fatalf("file exists in underlying FileSystem: %v", err)
+	}
+}
+
+func TestWithFileSystem(t *testing.T) {
+	fs := NewMemFileSystem()
+	d, err := NewWithOptions("fs-data", WithFileSystem(fs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteString("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("fs-data/a"); err != nil {
+		t.Fatalf("expected file in FileSystem: %s", err)
+	}
+	if _, err := os.Stat("fs-data"); !os.IsNotExist(err) {
+		t.Fatalf("OS filesystem was touched: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package diskv
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// hashIndexShards is the number of shards of a HashIndex, each with its
+// own lock, so that concurrent writers rarely contend.
+const hashIndexShards = 64
+
+// MembershipIndex is an Index which can answer whether it holds a key, and
+// how many keys it holds, without walking the store. If the Index in Options
+// is a MembershipIndex, Has and ExistsMulti consult it instead of statting
+// files, and Len counts its keys. Like BloomFilter, it assumes the store
+// isn't modified by anything other than this Diskv.
+type MembershipIndex interface {
+	Index
+
+	// Has returns true if the key is in the index.
+	Has(key string) bool
+
+	// Len returns the number of keys in the index.
+	Len() int
+}
+
+// HashIndex is an implementation of the MembershipIndex interface using a
+// sharded hash set. It doesn't order its keys: it ignores the LessFunction
+// it's initialized with, and Keys yields them in an order which is stable
+// between calls, but otherwise undefined. Its zero value is an empty index.
+type HashIndex struct {
+	shards [hashIndexShards]hashShard
+}
+
+type hashShard struct {
+	sync.RWMutex
+	keys map[string]struct{}
+}
+
+func hashShardOf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key)) // error deliberately ignored; never fails
+	return int(h.Sum32() % hashIndexShards)
+}
+
+// Initialize populates the index with data from the keys channel. It's
+// destructive to the HashIndex.
+func (i *HashIndex) Initialize(_ LessFunction, keys <-chan string) {
+	for s := range i.shards {
+		i.shards[s].Lock()
+	}
+	defer func() {
+		for s := range i.shards {
+			i.shards[s].Unlock()
+		}
+	}()
+	for s := range i.shards {
+		i.shards[s].keys = map[string]struct{}{}
+	}
+	for key := range keys {
+		i.shards[hashShardOf(key)].keys[key] = struct{}{}
+	}
+}
+
+// Insert inserts the given key (only) into the index.
+func (i *HashIndex) Insert(key string) {
+	s := &i.shards[hashShardOf(key)]
+	s.Lock()
+	defer s.Unlock()
+	if s.keys == nil {
+		s.keys = map[string]struct{}{}
+	}
+	s.keys[key] = struct{}{}
+}
+
+// Delete removes the given key (only) from the index.
+func (i *HashIndex) Delete(key string) {
+	s := &i.shards[hashShardOf(key)]
+	s.Lock()
+	defer s.Unlock()
+	delete(s.keys, key)
+}
+
+// Has returns true if the key is in the index.
+func (i *HashIndex) Has(key string) bool {
+	s := &i.shards[hashShardOf(key)]
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.keys[key]
+	return ok
+}
+
+// Len returns the number of keys in the index.
+func (i *HashIndex) Len() int {
+	n := 0
+	for s := range i.shards {
+		i.shards[s].RLock()
+		n += len(i.shards[s].keys)
+		i.shards[s].RUnlock()
+	}
+	return n
+}
+
+// Keys yields a maximum of n keys, shard by shard, and in lexicographic
+// order within each shard. If the passed 'from' key is non-empty, the first
+// key in the returned slice is the one that follows it in that order,
+// whether or not it's still in the index, so that Keys can be paged through
+// like those of a BTreeIndex.
+func (i *HashIndex) Keys(from string, n int) []string {
+	keys := []string{}
+	if n <= 0 {
+		return keys
+	}
+	first := 0
+	if from != "" {
+		first = hashShardOf(from)
+	}
+	for s := first; s < hashIndexShards && len(keys) < n; s++ {
+		shard := &i.shards[s]
+		shard.RLock()
+		sorted := make([]string, 0, len(shard.keys))
+		for key := range shard.keys {
+			if s != first || key > from {
+				sorted = append(sorted, key)
+			}
+		}
+		shard.RUnlock()
+		sort.Strings(sorted)
+		if len(sorted) > n-len(keys) {
+			sorted = sorted[:n-len(keys)]
+		}
+		keys = append(keys, sorted...)
+	}
+	return keys
+}
@@ -0,0 +1,109 @@
+package diskv
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHashIndexKeys(t *testing.T) {
+	var i HashIndex
+	if have := i.Keys("", 10); len(have) != 0 {
+		t.Fatalf("zero HashIndex: want no keys, have %v", have)
+	}
+
+	want := []string{}
+	keys := make(chan string)
+	go func() {
+		defer close(keys)
+		for n := 0; n < 1000; n++ {
+			keys <- fmt.Sprint(n)
+		}
+	}()
+	i.Initialize(nil, keys)
+	for n := 0; n < 1000; n++ {
+		want = append(want, fmt.Sprint(n))
+	}
+	i.Insert("extra")
+	want = append(want, "extra")
+	i.Delete("0")
+	want = want[1:]
+	if i.Len() != len(want) || !i.Has("extra") || i.Has("0") {
+		t.Fatalf("Len %d, Has(extra) %t, Has(0) %t", i.Len(), i.Has("extra"), i.Has("0"))
+	}
+
+	// Paging through the keys yields each of them once.
+	var have []string
+	for from := ""; ; {
+		page := i.Keys(from, 7)
+		have = append(have, page...)
+		if len(page) < 7 {
+			break
+		}
+		from = page[len(page)-1]
+	}
+	sort.Strings(want)
+	sort.Strings(have)
+	if !cmpStrings(have, want) {
+		t.Fatalf("paged keys: want %d keys, have %d", len(want), len(have))
+	}
+}
+
+func TestHashIndexMembership(t *testing.T) {
+	fs := &statCountingFS{FileSystem: NewMemFileSystem()}
+	opts := Options{
+		BasePath:     "/hash",
+		FileSystem:   fs,
+		Index:        &HashIndex{},
+		IndexLess:    strLess,
+		PersistIndex: true,
+	}
+	d := New(opts)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := d.Write(key, []byte(key)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+	if err := d.Erase("b"); err != nil {
+		t.Fatalf("Erase: %s", err)
+	}
+
+	before := atomic.LoadInt64(&fs.stats)
+	if have := d.ExistsMulti([]string{"a", "b", "c", "d"}); fmt.Sprint(have) != "[true false true false]" {
+		t.Errorf("ExistsMulti: have %v", have)
+	}
+	if !d.Has("a") || d.Has("b") {
+		t.Error("Has: wrong answer")
+	}
+	if n := d.Len(); n != 2 {
+		t.Errorf("Len: want 2, have %d", n)
+	}
+	if n := atomic.LoadInt64(&fs.stats) - before; n != 0 {
+		t.Errorf("want no stats, have %d", n)
+	}
+
+	// Persisted like any other Index.
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	opts.Index = &HashIndex{}
+	d = New(opts)
+	have := d.Index.Keys("", 10)
+	sort.Strings(have)
+	if !cmpStrings(have, []string{"a", "c"}) {
+		t.Errorf("loaded index: want [a c], have %v", have)
+	}
+}
+
+func TestLenWithoutIndex(t *testing.T) {
+	d := NewMem(Options{})
+	for _, key := range []string{"a", "b", "c"} {
+		if err := d.Write(key, []byte(key)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+	if n := d.Len(); n != 3 {
+		t.Errorf("Len: want 3, have %d", n)
+	}
+}
@@ -74,3 +74,39 @@ func TestImportCopy(t *testing.T) {
 		t.Errorf("expected temp file to remain, but got err = %v", err)
 	}
 }
+
+func TestImportMoveJournaled(t *testing.T) {
+	f, err := ioutil.TempFile("", "temp-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(`0123456789`))
+	f.Close()
+
+	var created []string
+	d := diskv.New(diskv.Options{
+		BasePath:      "test-import-move-journaled",
+		Journal:       true,
+		OnFileCreated: func(path string) error { created = append(created, path); return nil },
+	})
+	defer os.RemoveAll("test-import-move-journaled") // EraseAll leaves the journal
+	defer d.EraseAll()
+
+	seq := d.JournalSeq()
+	if err := d.Import(f.Name(), "key", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Errorf("expected temp file to be gone, but err = %v", err)
+	}
+	entries, err := d.TailJournal(seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Key != "key" || entries[0].Op != diskv.JournalWrite {
+		t.Errorf("want a journaled write of key, have %+v", entries)
+	}
+	if len(created) == 0 {
+		t.Error("OnFileCreated not called")
+	}
+}
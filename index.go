@@ -1,13 +1,21 @@
 package diskv
 
 import (
+	"reflect"
 	"sync"
+	"time"
 
 	"github.com/google/btree"
 )
 
 // Index is a generic interface for things that can
 // provide an ordered list of keys.
+//
+// Keys pages through them: its 'from' key is an exclusive cursor, and Keys
+// yields the keys which follow it, whether or not it's in the index. Paging
+// through an index which changes between pages yields every key that's in
+// it throughout exactly once, and keys inserted or deleted in the meantime
+// at most once.
 type Index interface {
 	Initialize(less LessFunction, keys <-chan string)
 	Insert(key string)
@@ -30,7 +38,15 @@ func (s btreeString) Less(i btree.Item) bool {
 	return s.l(s.s, i.(btreeString).s)
 }
 
+// LexicalLess orders keys lexicographically, byte by byte. Keys with a
+// prefix are then contiguous, so KeysPrefix on a BTreeIndex initialized
+// with it starts at the prefix, rather than paging through every key
+// before it.
+func LexicalLess(a, b string) bool { return a < b }
+
 // BTreeIndex is an implementation of the Index interface using google/btree.
+// The zero BTreeIndex is an empty index, which orders keys lexicographically
+// until it's initialized with another LessFunction.
 type BTreeIndex struct {
 	sync.RWMutex
 	LessFunction
@@ -50,57 +66,83 @@ func (i *BTreeIndex) Initialize(less LessFunction, keys <-chan string) {
 func (i *BTreeIndex) Insert(key string) {
 	i.Lock()
 	defer i.Unlock()
-	if i.BTree == nil || i.LessFunction == nil {
-		panic("uninitialized index")
-	}
+	i.initWithLock()
 	i.BTree.ReplaceOrInsert(btreeString{s: key, l: i.LessFunction})
 }
 
+// initWithLock makes an uninitialized index an empty one, in lexicographic
+// order.
+func (i *BTreeIndex) initWithLock() {
+	if i.LessFunction == nil {
+		i.LessFunction = LexicalLess
+	}
+	if i.BTree == nil {
+		i.BTree = btree.New(2)
+	}
+}
+
 // Delete removes the given key (only) from the BTree tree.
 func (i *BTreeIndex) Delete(key string) {
 	i.Lock()
 	defer i.Unlock()
 	if i.BTree == nil || i.LessFunction == nil {
-		panic("uninitialized index")
+		return // uninitialized, so empty
 	}
 	i.BTree.Delete(btreeString{s: key, l: i.LessFunction})
 }
 
-// Keys yields a maximum of n keys in order. If the passed 'from' key is empty,
-// Keys will return the first n keys. If the passed 'from' key is non-empty, the
-// first key in the returned slice will be the key that immediately follows the
-// passed key, in key order.
-func (i *BTreeIndex) Keys(from string, n int) []string {
+// lexical reports whether the index orders its keys with LexicalLess, and
+// whether it has the given key.
+func (i *BTreeIndex) lexical(key string) (lexical, has bool) {
 	i.RLock()
 	defer i.RUnlock()
-
 	if i.BTree == nil || i.LessFunction == nil {
-		panic("uninitialized index")
+		return false, false
 	}
-
-	if i.BTree.Len() <= 0 {
-		return []string{}
+	if reflect.ValueOf(i.LessFunction).Pointer() != reflect.ValueOf(LexicalLess).Pointer() {
+		return false, false
 	}
+	return true, i.BTree.Has(btreeString{s: key, l: i.LessFunction})
+}
 
-	btreeFrom := btreeString{s: from, l: i.LessFunction}
-	skipFirst := true
-	if len(from) <= 0 || !i.BTree.Has(btreeFrom) {
-		// no such key, so fabricate an always-smallest item
-		btreeFrom = btreeString{s: "", l: func(string, string) bool { return true }}
-		skipFirst = false
+// Len returns the number of keys in the index.
+func (i *BTreeIndex) Len() int {
+	i.RLock()
+	defer i.RUnlock()
+	if i.BTree == nil {
+		return 0
 	}
+	return i.BTree.Len()
+}
+
+// Keys yields a maximum of n keys in order. If the passed 'from' key is empty,
+// Keys will return the first n keys. If the passed 'from' key is non-empty, the
+// first key in the returned slice will be the key that immediately follows the
+// passed key, in key order, whether or not the passed key is still in the
+// index. So the last key of a page is a cursor for the next, even if it's
+// deleted in between.
+func (i *BTreeIndex) Keys(from string, n int) []string {
+	i.RLock()
+	defer i.RUnlock()
 
 	keys := []string{}
-	iterator := func(i btree.Item) bool {
-		keys = append(keys, i.(btreeString).s)
-		return len(keys) < n
+	if n <= 0 || i.BTree == nil || i.LessFunction == nil {
+		return keys
 	}
-	i.BTree.AscendGreaterOrEqual(btreeFrom, iterator)
 
-	if skipFirst && len(keys) > 0 {
-		keys = keys[1:]
+	iterator := func(item btree.Item) bool {
+		key := item.(btreeString).s
+		if from != "" && !i.LessFunction(from, key) {
+			return true // from itself
+		}
+		keys = append(keys, key)
+		return len(keys) < n
+	}
+	if from == "" {
+		i.BTree.Ascend(iterator)
+	} else {
+		i.BTree.AscendGreaterOrEqual(btreeString{s: from, l: i.LessFunction}, iterator)
 	}
-
 	return keys
 }
 
@@ -113,3 +155,183 @@ func rebuild(less LessFunction, keys <-chan string) *btree.BTree {
 	}
 	return tree
 }
+
+// IndexEntry is a key in a RichIndex, along with the size and modification
+// time of its file. The size is after compression, if any.
+type IndexEntry struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// RichIndex is an Index whose entries carry the size and modification time
+// of their files, for ordered queries like "oldest N keys" or "keys larger
+// than X", e.g. for eviction and retention policies, without statting every
+// file. If the Index in Options is a RichIndex, Diskv initializes it with
+// InitializeEntries and updates it with InsertEntry instead.
+type RichIndex interface {
+	Index
+
+	// InitializeEntries is Initialize, with metadata.
+	InitializeEntries(less LessFunction, entries <-chan IndexEntry)
+
+	// InsertEntry is Insert, with metadata.
+	InsertEntry(e IndexEntry)
+
+	// Entries is Keys, with metadata.
+	Entries(from string, n int) []IndexEntry
+
+	// Oldest returns at most n entries, least recently modified first.
+	Oldest(n int) []IndexEntry
+
+	// LargerThan returns at most n entries larger than size bytes,
+	// smallest first.
+	LargerThan(size int64, n int) []IndexEntry
+}
+
+// RichBTreeIndex is an implementation of the RichIndex interface using
+// google/btree. Its entries are kept in three trees, by key, modification
+// time and size. Like BTreeIndex, its zero value is an empty index.
+type RichBTreeIndex struct {
+	BTreeIndex
+	entries map[string]IndexEntry
+	byTime  *btree.BTree // of timeItem
+	bySize  *btree.BTree // of sizeItem
+}
+
+type timeItem IndexEntry
+
+func (a timeItem) Less(i btree.Item) bool {
+	b := i.(timeItem)
+	if !a.ModTime.Equal(b.ModTime) {
+		return a.ModTime.Before(b.ModTime)
+	}
+	return a.Key < b.Key
+}
+
+type sizeItem IndexEntry
+
+func (a sizeItem) Less(i btree.Item) bool {
+	b := i.(sizeItem)
+	if a.Size != b.Size {
+		return a.Size < b.Size
+	}
+	return a.Key < b.Key
+}
+
+// Initialize populates the index with keys without metadata. It's
+// destructive to the RichBTreeIndex.
+func (i *RichBTreeIndex) Initialize(less LessFunction, keys <-chan string) {
+	entries := make(chan IndexEntry)
+	goLabeled("index-init", func() {
+		defer close(entries)
+		for key := range keys {
+			entries <- IndexEntry{Key: key}
+		}
+	})
+	i.InitializeEntries(less, entries)
+}
+
+// InitializeEntries populates the index with the entries, according to the
+// passed less function. It's destructive to the RichBTreeIndex.
+func (i *RichBTreeIndex) InitializeEntries(less LessFunction, entries <-chan IndexEntry) {
+	i.Lock()
+	defer i.Unlock()
+	i.LessFunction = less
+	i.BTree = btree.New(2)
+	i.entries = map[string]IndexEntry{}
+	i.byTime = btree.New(2)
+	i.bySize = btree.New(2)
+	for e := range entries {
+		i.insertWithLock(e)
+	}
+}
+
+// Insert inserts the given key, without metadata.
+func (i *RichBTreeIndex) Insert(key string) {
+	i.InsertEntry(IndexEntry{Key: key})
+}
+
+// InsertEntry inserts the given entry, replacing any entry for its key.
+func (i *RichBTreeIndex) InsertEntry(e IndexEntry) {
+	i.Lock()
+	defer i.Unlock()
+	i.initWithLock()
+	if i.entries == nil {
+		i.entries = map[string]IndexEntry{}
+		i.byTime = btree.New(2)
+		i.bySize = btree.New(2)
+	}
+	i.insertWithLock(e)
+}
+
+func (i *RichBTreeIndex) insertWithLock(e IndexEntry) {
+	i.deleteWithLock(e.Key)
+	i.entries[e.Key] = e
+	i.BTree.ReplaceOrInsert(btreeString{s: e.Key, l: i.LessFunction})
+	i.byTime.ReplaceOrInsert(timeItem(e))
+	i.bySize.ReplaceOrInsert(sizeItem(e))
+}
+
+// Delete removes the given key from the index.
+func (i *RichBTreeIndex) Delete(key string) {
+	i.Lock()
+	defer i.Unlock()
+	i.deleteWithLock(key)
+}
+
+func (i *RichBTreeIndex) deleteWithLock(key string) {
+	e, ok := i.entries[key]
+	if !ok {
+		return
+	}
+	delete(i.entries, key)
+	i.BTree.Delete(btreeString{s: key, l: i.LessFunction})
+	i.byTime.Delete(timeItem(e))
+	i.bySize.Delete(sizeItem(e))
+}
+
+// Entries yields a maximum of n entries in key order, like Keys.
+func (i *RichBTreeIndex) Entries(from string, n int) []IndexEntry {
+	keys := i.Keys(from, n)
+	i.RLock()
+	defer i.RUnlock()
+	entries := make([]IndexEntry, 0, len(keys))
+	for _, key := range keys {
+		if e, ok := i.entries[key]; ok {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Oldest returns at most n entries, least recently modified first.
+func (i *RichBTreeIndex) Oldest(n int) []IndexEntry {
+	i.RLock()
+	defer i.RUnlock()
+	entries := []IndexEntry{}
+	if n <= 0 || i.byTime == nil {
+		return entries
+	}
+	i.byTime.Ascend(func(item btree.Item) bool {
+		entries = append(entries, IndexEntry(item.(timeItem)))
+		return len(entries) < n
+	})
+	return entries
+}
+
+// LargerThan returns at most n entries larger than size bytes, smallest
+// first.
+func (i *RichBTreeIndex) LargerThan(size int64, n int) []IndexEntry {
+	i.RLock()
+	defer i.RUnlock()
+	entries := []IndexEntry{}
+	if n <= 0 || i.bySize == nil {
+		return entries
+	}
+	i.bySize.AscendGreaterOrEqual(sizeItem{Size: size + 1}, func(item btree.Item) bool {
+		entries = append(entries, IndexEntry(item.(sizeItem)))
+		return len(entries) < n
+	})
+	return entries
+}
@@ -2,7 +2,13 @@ package diskv
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -154,8 +160,357 @@ func TestBadKeys(t *testing.T) {
 
 	for _, k := range []string{"a/a"} {
 		err := d.Write(k, []byte("1"))
-		if err != errBadKey {
+		if err != ErrBadKey {
 			t.Errorf("Expected bad key error, got: %v", err)
 		}
 	}
 }
+
+func TestPersistIndex(t *testing.T) {
+	opts := Options{
+		BasePath:     "index-test",
+		Index:        &BTreeIndex{},
+		IndexLess:    strLess,
+		PersistIndex: true,
+	}
+	d := New(opts)
+	defer d.EraseAll()
+
+	for _, k := range []string{"b", "a", "c"} {
+		d.WriteString(k, k)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A key written behind the store's back shows that the saved index is
+	// loaded rather than rebuilt by walking.
+	d2 := New(Options{BasePath: "index-test"})
+	d2.WriteString("z", "z")
+
+	opts.Index = &BTreeIndex{}
+	d = New(opts)
+	if have, want := d.Index.Keys("", 10), []string{"a", "b", "c"}; !reflect.DeepEqual(want, have) {
+		t.Fatalf("loaded index: want %v, have %v", want, have)
+	}
+	if fileExists(d, indexFilename) {
+		t.Fatal("index file not consumed on load")
+	}
+
+	// Without a save, the next New walks the store.
+	opts.Index = &BTreeIndex{}
+	d = New(opts)
+	if have, want := d.Index.Keys("", 10), []string{"a", "b", "c", "z"}; !reflect.DeepEqual(want, have) {
+		t.Fatalf("rebuilt index: want %v, have %v", want, have)
+	}
+
+	// A write after a save invalidates it.
+	d.SaveIndex()
+	d.Erase("z")
+	if fileExists(d, indexFilename) {
+		t.Fatal("index file not removed by erase")
+	}
+
+	// Saved after IndexSaveInterval without writes.
+	opts.Index = &BTreeIndex{}
+	opts.IndexSaveInterval = 10 * time.Millisecond
+	d = New(opts)
+	d.WriteString("d", "d")
+	time.Sleep(50 * time.Millisecond)
+	if !fileExists(d, indexFilename) {
+		t.Fatal("index not saved after IndexSaveInterval")
+	}
+	checkKeys(t, d.Keys(nil), map[string]string{"a": "", "b": "", "c": "", "d": ""})
+	d.Close()
+}
+
+func fileExists(d *Diskv, name string) bool {
+	_, err := d.fs.Stat(filepath.Join(d.BasePath, name))
+	return err == nil
+}
+
+func TestRichIndex(t *testing.T) {
+	opts := Options{
+		BasePath:     "index-test",
+		Index:        &RichBTreeIndex{},
+		IndexLess:    strLess,
+		PersistIndex: true,
+	}
+	d := New(opts)
+	defer d.EraseAll()
+
+	d.WriteString("b", "22")
+	d.WriteString("a", "1")
+	d.WriteString("c", "333")
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join("index-test", "c"), old, old)
+
+	checkRichIndex := func(ri RichIndex) {
+		t.Helper()
+		if have := entryKeys(ri.LargerThan(1, 10)); !reflect.DeepEqual(have, []string{"b", "c"}) {
+			t.Errorf("LargerThan(1): want [b c], have %v", have)
+		}
+		if have := entryKeys(ri.Entries("", 10)); !reflect.DeepEqual(have, []string{"a", "b", "c"}) {
+			t.Errorf("Entries: want [a b c], have %v", have)
+		}
+	}
+	ri := d.Index.(RichIndex)
+	checkRichIndex(ri)
+
+	// Metadata is read from the files when the store is walked...
+	opts.Index = &RichBTreeIndex{}
+	d = New(opts)
+	ri = d.Index.(RichIndex)
+	checkRichIndex(ri)
+	if have := entryKeys(ri.Oldest(1)); !reflect.DeepEqual(have, []string{"c"}) {
+		t.Errorf("walked Oldest(1): want [c], have %v", have)
+	}
+
+	// ... and survives saving and loading.
+	d.Close()
+	opts.Index = &RichBTreeIndex{}
+	d = New(opts)
+	ri = d.Index.(RichIndex)
+	checkRichIndex(ri)
+	if have := entryKeys(ri.Oldest(1)); !reflect.DeepEqual(have, []string{"c"}) {
+		t.Errorf("loaded Oldest(1): want [c], have %v", have)
+	}
+
+	d.Erase("c")
+	if have := entryKeys(ri.LargerThan(0, 10)); !reflect.DeepEqual(have, []string{"a", "b"}) {
+		t.Errorf("after erase: want [a b], have %v", have)
+	}
+}
+
+func entryKeys(entries []IndexEntry) []string {
+	keys := []string{}
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
+
+func TestIndexUninitialized(t *testing.T) {
+	for name, index := range map[string]Index{
+		"btree": &BTreeIndex{},
+		"rich":  &RichBTreeIndex{},
+	} {
+		if keys := index.Keys("", 10); len(keys) != 0 {
+			t.Errorf("%s: Keys: want none, have %v", name, keys)
+		}
+		index.Delete("a")
+		for _, key := range []string{"c", "a", "b"} {
+			index.Insert(key)
+		}
+		if keys, want := index.Keys("", 10), []string{"a", "b", "c"}; !cmpStrings(keys, want) {
+			t.Errorf("%s: Keys: want %v, have %v", name, want, keys)
+		}
+	}
+
+	var rich RichBTreeIndex
+	if n := len(rich.Oldest(1)) + len(rich.LargerThan(0, 1)); n != 0 {
+		t.Errorf("want no entries, have %d", n)
+	}
+	rich.InsertEntry(IndexEntry{Key: "a", Size: 2})
+	if e := rich.LargerThan(1, 1); len(e) != 1 || e[0].Key != "a" {
+		t.Errorf("LargerThan: have %v", e)
+	}
+}
+
+func TestIndexBulkOperations(t *testing.T) {
+	d := New(Options{
+		BasePath:  "index-test",
+		Index:     &BTreeIndex{},
+		IndexLess: strLess,
+	})
+	defer d.EraseAll()
+
+	write := func(keys ...string) {
+		t.Helper()
+		for _, key := range keys {
+			if err := d.Write(key, []byte(key)); err != nil {
+				t.Fatalf("Write: %s", err)
+			}
+		}
+	}
+	check := func(op string, want ...string) {
+		t.Helper()
+		if have := d.Index.Keys("", 1000); !cmpStrings(have, want) {
+			t.Errorf("after %s: want index %v, have %v", op, want, have)
+		}
+	}
+
+	write("a", "b")
+	if err := d.EraseAll(); err != nil {
+		t.Fatalf("EraseAll: %s", err)
+	}
+	check("EraseAll")
+
+	write("a", "b")
+	if err := d.Clear(); err != nil {
+		t.Fatalf("Clear: %s", err)
+	}
+	check("Clear")
+
+	write("a1", "a2", "b1")
+	if _, err := d.ErasePrefix("a", BulkOptions{}); err != nil {
+		t.Fatalf("ErasePrefix: %s", err)
+	}
+	check("ErasePrefix", "b1")
+
+	for _, move := range []bool{false, true} {
+		src, err := ioutil.TempFile("", "diskv-index")
+		if err != nil {
+			t.Fatal(err)
+		}
+		src.Write([]byte("imported")) // error deliberately ignored
+		src.Close()
+		defer os.Remove(src.Name())
+		key := fmt.Sprintf("imported-%t", move)
+		if err := d.Import(src.Name(), key, move); err != nil {
+			t.Fatalf("Import: %s", err)
+		}
+		if !d.isIndexed(key) {
+			t.Errorf("Import(move=%t): %s not indexed", move, key)
+		}
+	}
+}
+
+func TestIndexPagingUnderMutation(t *testing.T) {
+	for name, index := range map[string]Index{
+		"btree": &BTreeIndex{},
+		"rich":  &RichBTreeIndex{},
+		"hash":  &HashIndex{},
+	} {
+		keys := make(chan string)
+		go func() {
+			defer close(keys)
+			for n := 0; n < 100; n++ {
+				keys <- fmt.Sprintf("%03d", n)
+			}
+		}()
+		index.Initialize(strLess, keys)
+
+		// Delete each page's cursor before asking for the next page, and
+		// the key after it, which nobody has seen yet.
+		seen, unseen := map[string]int{}, 0
+		for from := ""; ; {
+			page := index.Keys(from, 7)
+			for _, key := range page {
+				seen[key]++
+			}
+			if len(page) < 7 {
+				break
+			}
+			from = page[len(page)-1]
+			index.Delete(from)
+			if next := index.Keys(from, 1); len(next) > 0 {
+				index.Delete(next[0])
+				unseen++
+			}
+		}
+
+		remaining := index.Keys("", 1000)
+		for _, key := range remaining {
+			if seen[key] != 1 {
+				t.Errorf("%s: %s seen %d times", name, key, seen[key])
+			}
+		}
+		for key, n := range seen {
+			if n != 1 {
+				t.Errorf("%s: %s seen %d times", name, key, n)
+			}
+		}
+		if len(seen)+unseen != 100 {
+			t.Errorf("%s: saw %d keys, and deleted %d unseen, of 100", name, len(seen), unseen)
+		}
+	}
+}
+
+func TestKeysFromIndex(t *testing.T) {
+	for name, index := range map[string]Index{
+		"btree": &BTreeIndex{},
+		"hash":  &HashIndex{},
+	} {
+		fs := NewMemFileSystem()
+		d := New(Options{
+			BasePath:   "/index-keys",
+			FileSystem: fs,
+			Index:      index,
+			IndexLess:  strLess,
+			SortedKeys: true,
+		})
+		for _, key := range []string{"b2", "a1", "b1", "c1"} {
+			if err := d.Write(key, []byte(key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Keys come from the Index, so a file removed behind the store's
+		// back is still listed.
+		if err := fs.Remove(d.completeFilename(d.transform("c1"))); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for key := range d.Keys(nil) {
+			keys = append(keys, key)
+		}
+		if want := "[a1 b1 b2 c1]"; fmt.Sprint(keys) != want {
+			t.Errorf("%s: Keys: want %s, have %v", name, want, keys)
+		}
+
+		keys = keys[:0]
+		for key := range d.KeysPrefix("b", nil) {
+			keys = append(keys, key)
+		}
+		if want := "[b1 b2]"; fmt.Sprint(keys) != want {
+			t.Errorf("%s: KeysPrefix: want %s, have %v", name, want, keys)
+		}
+	}
+}
+
+func TestKeysPrefixLexicalIndex(t *testing.T) {
+	// Keys spanning several pages, and a key equal to a prefix.
+	var all []string
+	for i := 0; i < 3*indexPageSize; i++ {
+		all = append(all, fmt.Sprintf("%c%05d", 'a'+i%3, i))
+	}
+	all = append(all, "b")
+	sort.Strings(all)
+
+	for name, less := range map[string]LessFunction{
+		"lexical": LexicalLess,
+		"other":   strLess,
+	} {
+		d := NewMem(Options{BasePath: "/lexical", Index: &BTreeIndex{}, IndexLess: less})
+		for _, key := range all {
+			d.Index.Insert(key) // no files needed, as keys come from the Index
+		}
+		for _, prefix := range []string{"", "a", "b", "b0", "b0123", "c1", "bz", "z"} {
+			var want, have []string
+			for _, key := range all {
+				if strings.HasPrefix(key, prefix) {
+					want = append(want, key)
+				}
+			}
+			for key := range d.KeysPrefix(prefix, nil) {
+				have = append(have, key)
+			}
+			if !reflect.DeepEqual(want, have) {
+				t.Errorf("%s: KeysPrefix(%q): want %d keys, have %d", name, prefix, len(want), len(have))
+			}
+		}
+	}
+}
+
+func BenchmarkKeysPrefixLexicalIndex(b *testing.B) {
+	d := NewMem(Options{BasePath: "/lexical", Index: &BTreeIndex{}, IndexLess: LexicalLess})
+	for i := 0; i < 100000; i++ {
+		d.Index.Insert(fmt.Sprintf("%06d", i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for range d.KeysPrefix("99999", nil) {
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package diskv
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// indexFilename is the name of the file, directly in the BasePath, where
+// the Index is persisted with PersistIndex. It's never yielded as a key.
+const indexFilename = ".diskv-index"
+
+// indexFileVersion is incremented whenever the format of the index file
+// changes, so that files in an old format are ignored.
+const indexFileVersion = 2
+
+// indexPageSize is the number of keys requested from the Index at a time
+// when saving it.
+const indexPageSize = 4096
+
+type indexFile struct {
+	Version int
+	Keys    []string     // in index order
+	Entries []IndexEntry // instead of Keys, for a RichIndex
+}
+
+// SaveIndex persists the Index, so that the next New can load it instead of
+// walking the store. The file stays valid until the store is next written
+// to or erased from, which removes it. Close calls SaveIndex. It requires
+// PersistIndex.
+func (d *Diskv) SaveIndex() error {
+	if !d.PersistIndex || d.Index == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.saveIndexWithLock()
+}
+
+// loadIndex initializes the Index from the persisted file, and reports
+// whether it did. The file is removed once it's loaded, so that a crash
+// before the next save can't leave a stale index behind.
+func (d *Diskv) loadIndex() bool {
+	filename := filepath.Join(d.BasePath, indexFilename)
+	buf, err := readFile(d.fs, filename)
+	if err != nil {
+		return false
+	}
+	var f indexFile
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&f); err != nil || f.Version != indexFileVersion {
+		return false
+	}
+	if err := d.fs.Remove(filename); err != nil {
+		return false
+	}
+
+	if ri, ok := d.Index.(RichIndex); ok {
+		entries := make(chan IndexEntry)
+		goLabeled("index-load", func() {
+			defer close(entries)
+			for _, e := range f.Entries {
+				entries <- e
+			}
+		})
+		ri.InitializeEntries(d.IndexLess, entries)
+		return true
+	}
+
+	keys := make(chan string)
+	goLabeled("index-load", func() {
+		defer close(keys)
+		for _, key := range f.Keys {
+			keys <- key
+		}
+	})
+	d.Index.Initialize(d.IndexLess, keys)
+	return true
+}
+
+// initializeIndex initializes the Index by walking the store.
+func (d *Diskv) initializeIndex() {
+	ri, ok := d.Index.(RichIndex)
+	if !ok {
+		// Not keysPrefix, which would list the keys of the Index itself.
+		keys := make(chan string)
+		goLabeled("index-init", func() {
+			defer close(keys)
+			n := 0
+			d.walkKeys(d.BasePath, "", d.sender(keys, nil, &n)) // errors deliberately ignored, as by Keys
+		})
+		d.Index.Initialize(d.IndexLess, keys)
+		return
+	}
+	entries := make(chan IndexEntry)
+	goLabeled("index-init", func() {
+		defer close(entries)
+		d.walkKeys(d.BasePath, "", func(key string, info os.FileInfo) error {
+			entries <- IndexEntry{Key: key, Size: info.Size(), ModTime: info.ModTime()}
+			return nil
+		}) // errors deliberately ignored, as by Keys
+	})
+	ri.InitializeEntries(d.IndexLess, entries)
+}
+
+// indexKeys sends the keys in the Index with the given prefix down the
+// channel c, like walkKeys, or walkSorted if SortedKeys is set, but without
+// touching the filesystem. If match isn't nil, only the keys it returns
+// true for are sent. It pages through the Index without holding d.mu, so
+// the consumer may write and erase keys as it goes. A BTreeIndex ordered by
+// LexicalLess is paged through from the prefix, and only as far as the keys
+// with it go.
+func (d *Diskv) indexKeys(c chan<- string, prefix string, match func(key string) bool, cancel <-chan struct{}, n *int) error {
+	var (
+		send   = d.sender(c, cancel, n)
+		sorted []string
+	)
+	visit := func(key string) error {
+		if (match != nil && !match(key)) || d.expired(key) {
+			return nil
+		}
+		if d.SortedKeys {
+			sorted = append(sorted, key)
+			return nil
+		}
+		return send(key, nil)
+	}
+
+	from, seek := "", false
+	if bi, ok := d.Index.(*BTreeIndex); ok && prefix != "" {
+		var has bool
+		if seek, has = bi.lexical(prefix); seek {
+			// The cursor is exclusive, so the prefix itself comes first.
+			from = prefix
+			if has {
+				if err := visit(prefix); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+pages:
+	for {
+		select {
+		case <-cancel:
+			return errCanceled
+		default:
+		}
+		keys := d.Index.Keys(from, indexPageSize)
+		for _, key := range keys {
+			if !strings.HasPrefix(key, prefix) {
+				if seek {
+					break pages // past the keys with the prefix
+				}
+				continue
+			}
+			if err := visit(key); err != nil {
+				return err
+			}
+		}
+		if len(keys) < indexPageSize {
+			break
+		}
+		from = keys[len(keys)-1]
+	}
+
+	sort.Strings(sorted)
+	for _, key := range sorted {
+		if err := send(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexInsertWithLock inserts the key, whose file was just written, into
+// the Index, along with its metadata for a RichIndex. Callers must hold
+// d.mu.
+func (d *Diskv) indexInsertWithLock(key, filename string) {
+	ri, ok := d.Index.(RichIndex)
+	if !ok {
+		d.Index.Insert(key)
+		return
+	}
+	e := IndexEntry{Key: key}
+	if fi, err := d.fs.Stat(filename); err == nil {
+		e.Size, e.ModTime = fi.Size(), fi.ModTime()
+	}
+	ri.InsertEntry(e)
+}
+
+// saveIndexWithLock writes the keys of the Index to the index file.
+// Callers must hold d.mu.
+func (d *Diskv) saveIndexWithLock() error {
+	if d.indexSaved {
+		return nil
+	}
+	f := indexFile{Version: indexFileVersion}
+	if ri, ok := d.Index.(RichIndex); ok {
+		for from := ""; ; {
+			entries := ri.Entries(from, indexPageSize)
+			f.Entries = append(f.Entries, entries...)
+			if len(entries) < indexPageSize {
+				break
+			}
+			from = entries[len(entries)-1].Key
+		}
+	} else {
+		for from := ""; ; {
+			keys := d.Index.Keys(from, indexPageSize)
+			f.Keys = append(f.Keys, keys...)
+			if len(keys) < indexPageSize {
+				break
+			}
+			from = keys[len(keys)-1]
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(f); err != nil {
+		return err
+	}
+	if err := d.writeInternalFile(filepath.Join(d.BasePath, indexFilename), buf.Bytes()); err != nil {
+		return err
+	}
+	d.indexSaved = true
+	return nil
+}
+
+// indexChangedWithLock invalidates the index file, if it's been saved, after
+// the Index changed, and schedules the next save after IndexSaveInterval, if
+// it's set. Callers must hold d.mu.
+func (d *Diskv) indexChangedWithLock() {
+	if !d.PersistIndex {
+		return
+	}
+	if d.indexSaved {
+		d.fs.Remove(filepath.Join(d.BasePath, indexFilename)) // error deliberately ignored
+		d.indexSaved = false
+	}
+	if d.IndexSaveInterval > 0 && d.indexTimer == nil {
+		d.indexTimer = d.Clock.AfterFunc(d.IndexSaveInterval, labeled("index-save", func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.indexTimer = nil
+			d.saveIndexWithLock() // error deliberately ignored; retried by Close
+		}))
+	}
+}
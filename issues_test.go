@@ -2,6 +2,8 @@ package diskv
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"sync"
@@ -189,3 +191,121 @@ func TestIssue40(t *testing.T) {
 	// is no room in the cache for this entry and it panics.
 	d.Read(k2)
 }
+
+// A read in flight while its key is rewritten must not cache the old value
+// once it completes.
+func TestStaleSiphon(t *testing.T) {
+	for _, tempDir := range []string{"", "test-data-temp"} {
+		d := New(Options{
+			BasePath:     "test-data",
+			TempDir:      tempDir,
+			CacheSizeMax: 1024,
+		})
+		defer d.EraseAll()
+
+		d.WriteString("a", "old")
+		rc, err := d.ReadStream("a", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.WriteString("a", "new value")
+		ioutil.ReadAll(rc)
+		rc.Close()
+
+		if got := d.ReadString("a"); got != "new value" {
+			t.Fatalf("TempDir=%q: expected 'new value', got %q", tempDir, got)
+		}
+	}
+}
+
+func TestConcurrentEraseWrite(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"file": nil,
+		"pack": {WithPack(16, 0)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts = append(opts, WithCacheSizeMax(1<<20), WithIndex(&BTreeIndex{}, strLess))
+			d, err := NewWithOptions("test-data", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.EraseAll()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 200; j++ {
+						switch (i + j) % 3 {
+						case 0:
+							d.WriteString("a", fmt.Sprintf("value %d/%d", i, j)) // error deliberately ignored
+						case 1:
+							d.EraseQuiet("a") // error deliberately ignored
+						default:
+							d.Read("a") // fills the cache, maybe racing with the others
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			// Whichever operation came last, the cache, the index and the
+			// disk agree on it.
+			check := func() {
+				t.Helper()
+				onDisk, err := d.ReadWith("a", ReadOptions{SkipCache: true, NoFill: true})
+				exists := err == nil
+				if d.Has("a") != exists {
+					t.Fatalf("Has: %v, on disk: %v", d.Has("a"), exists)
+				}
+				if cached, err := d.Read("a"); exists && (err != nil || !bytes.Equal(cached, onDisk)) {
+					t.Fatalf("cached %q, on disk %q (%v)", cached, onDisk, err)
+				}
+				if indexed := len(d.Index.Keys("", 10)) > 0; indexed != exists {
+					t.Fatalf("indexed: %v, on disk: %v", indexed, exists)
+				}
+			}
+			check()
+			if err := d.WriteString("a", "last"); err != nil {
+				t.Fatal(err)
+			}
+			check()
+			if err := d.Erase("a"); err != nil {
+				t.Fatal(err)
+			}
+			check()
+		})
+	}
+}
+
+// failingRemoveFS fails every Remove.
+type failingRemoveFS struct {
+	FileSystem
+}
+
+var errRemoveFailed = errors.New("remove failed")
+
+func (failingRemoveFS) Remove(string) error { return errRemoveFailed }
+
+func TestEraseFailureKeepsKey(t *testing.T) {
+	d, err := NewWithOptions("test-data", WithCacheSizeMax(1024), WithIndex(&BTreeIndex{}, strLess))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	if err := d.WriteWith("a", bytes.NewReader([]byte("1")), WriteOptions{TTL: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	d.Read("a")
+	d.fs = failingRemoveFS{d.fs}
+	if err := d.Erase("a"); err != errRemoveFailed {
+		t.Fatalf("want errRemoveFailed, have %v", err)
+	}
+	d.fs = d.fs.(failingRemoveFS).FileSystem
+
+	if !d.isCached("a") || len(d.Index.Keys("", 10)) != 1 || d.expiry["a"].IsZero() {
+		t.Fatal("failed erase changed the key's state")
+	}
+}
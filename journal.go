@@ -0,0 +1,258 @@
+package diskv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journalPrefix is the prefix of the names of the journal's segment files,
+// directly in the BasePath. Each segment is named after the sequence number
+// of its first entry. They're never yielded as keys.
+const journalPrefix = ".diskv-journal."
+
+// defaultJournalSegmentSize is used if JournalSegmentSize is zero.
+const defaultJournalSegmentSize = 16 << 20 // bytes
+
+// ErrJournalTruncated is returned by TailJournal when entries after the
+// given sequence number have been dropped by rotation. The consumer must
+// resynchronize, e.g. by walking Keys, and tail from JournalSeq.
+var ErrJournalTruncated = errors.New("journal truncated")
+
+// JournalOp is the kind of mutation a JournalEntry records.
+type JournalOp string
+
+const (
+	// JournalWrite records that a key was written.
+	JournalWrite JournalOp = "write"
+
+	// JournalErase records that a key was erased, explicitly or because it
+	// expired.
+	JournalErase JournalOp = "erase"
+
+	// JournalClear records that every key was erased, by EraseAll or Clear.
+	// Its Key is empty.
+	JournalClear JournalOp = "clear"
+)
+
+// JournalEntry is one mutation in the journal. See Options.Journal.
+type JournalEntry struct {
+	Seq  uint64    `json:"seq"`
+	Op   JournalOp `json:"op"`
+	Key  string    `json:"key,omitempty"`
+	Time time.Time `json:"time"`
+	Hash []byte    `json:"hash,omitempty"` // SHA-256 of the value written
+}
+
+// TailJournal returns the journal entries with sequence numbers greater than
+// since, in order. Pass 0 for every retained entry, and then the Seq of the
+// last entry received to resume. If entries after since have been dropped
+// by rotation, TailJournal returns ErrJournalTruncated.
+func (d *Diskv) TailJournal(since uint64) ([]JournalEntry, error) {
+	if !d.Journal {
+		return nil, errNoJournal
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.tailJournalWithLock(since)
+}
+
+// tailJournalWithLock is TailJournal. Callers must hold d.mu.
+func (d *Diskv) tailJournalWithLock(since uint64) ([]JournalEntry, error) {
+	segments, err := d.journalSegments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 && segments[0] > since+1 {
+		return nil, ErrJournalTruncated
+	}
+	if len(segments) <= 0 && since < d.journalSeq {
+		return nil, ErrJournalTruncated
+	}
+
+	var entries []JournalEntry
+	for i, first := range segments {
+		if i+1 < len(segments) && segments[i+1] <= since+1 {
+			continue // every entry is at or before since
+		}
+		segment, err := d.readJournalSegment(first)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range segment {
+			if e.Seq > since {
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// JournalSeq returns the sequence number of the last journal entry, or 0 if
+// there is none.
+func (d *Diskv) JournalSeq() uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.journalSeq
+}
+
+var errNoJournal = errors.New("journal not enabled")
+
+// loadJournal finds the last sequence number, and the size of the segment
+// it's in, from disk.
+func (d *Diskv) loadJournal() {
+	segments, err := d.journalSegments()
+	if err != nil || len(segments) <= 0 {
+		return
+	}
+	last := segments[len(segments)-1]
+	d.journalSeq = last - 1
+	d.journalSegment = last
+	buf, err := readFile(d.fs, d.journalFilename(last))
+	if err != nil {
+		return
+	}
+	for _, e := range parseJournal(buf) {
+		d.journalSeq = e.Seq
+	}
+	d.journalSize = int64(len(buf))
+	if len(buf) > 0 && buf[len(buf)-1] != '\n' {
+		d.journalSegment = 0 // don't append to a torn entry
+	}
+}
+
+// journalWithLock appends an entry to the journal, if it's enabled, and
+// rotates it if the current segment is full. Callers must hold d.mu.
+func (d *Diskv) journalWithLock(op JournalOp, key string, hash []byte) error {
+	if !d.Journal {
+		return nil
+	}
+
+	e := JournalEntry{
+		Seq:  d.journalSeq + 1,
+		Op:   op,
+		Key:  key,
+		Time: d.Clock.Now(),
+		Hash: hash,
+	}
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+
+	segmentSize := d.JournalSegmentSize
+	if segmentSize <= 0 {
+		segmentSize = defaultJournalSegmentSize
+	}
+	rotated := d.journalSegment == 0 || d.journalSize >= segmentSize
+	if rotated {
+		d.journalSegment, d.journalSize = e.Seq, 0
+	}
+
+	if err := d.fs.MkdirAll(d.BasePath, d.PathPerm); err != nil {
+		return fmt.Errorf("journal: %s", err)
+	}
+	f, err := d.fs.OpenFile(d.journalFilename(d.journalSegment), os.O_WRONLY|os.O_CREATE|os.O_APPEND, d.FilePerm)
+	if err != nil {
+		return fmt.Errorf("journal: %s", err)
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close() // error deliberately ignored
+		return fmt.Errorf("journal: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("journal: %s", err)
+	}
+	d.journalSeq = e.Seq
+	d.journalSize += int64(len(buf))
+
+	if rotated && d.JournalSegments > 0 {
+		d.dropJournalSegmentsWithLock()
+	}
+	return nil
+}
+
+// dropJournalSegmentsWithLock removes the oldest segments beyond
+// JournalSegments. Callers must hold d.mu.
+func (d *Diskv) dropJournalSegmentsWithLock() {
+	segments, err := d.journalSegments()
+	if err != nil {
+		return
+	}
+	for len(segments) > d.JournalSegments {
+		d.fs.Remove(d.journalFilename(segments[0])) // error deliberately ignored
+		segments = segments[1:]
+	}
+}
+
+// resetJournalWithLock forgets the current segment, after the journal was
+// removed along with everything else by EraseAll, and records that.
+// Sequence numbers carry on. Callers must hold d.mu.
+func (d *Diskv) resetJournalWithLock() error {
+	if !d.Journal {
+		return nil
+	}
+	d.journalSegment, d.journalSize = 0, 0
+	return d.journalWithLock(JournalClear, "", nil)
+}
+
+// journalSegments returns the first sequence numbers of the segments on
+// disk, in order.
+func (d *Diskv) journalSegments() ([]uint64, error) {
+	names, err := readDirNames(d.fs, d.BasePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var segments []uint64
+	for _, name := range names {
+		if !strings.HasPrefix(name, journalPrefix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimPrefix(name, journalPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, first)
+	}
+	return segments, nil // names are zero-padded, so already in order
+}
+
+func (d *Diskv) journalFilename(first uint64) string {
+	return filepath.Join(d.BasePath, fmt.Sprintf("%s%020d", journalPrefix, first))
+}
+
+// readJournalSegment returns the entries of the segment. Incomplete lines,
+// left by a crash mid-append, are ignored.
+func (d *Diskv) readJournalSegment(first uint64) ([]JournalEntry, error) {
+	buf, err := readFile(d.fs, d.journalFilename(first))
+	if err != nil {
+		return nil, err
+	}
+	return parseJournal(buf), nil
+}
+
+func parseJournal(buf []byte) []JournalEntry {
+	var entries []JournalEntry
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	s.Buffer(nil, len(buf)+1)
+	for s.Scan() {
+		var e JournalEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
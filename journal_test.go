@@ -0,0 +1,90 @@
+package diskv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	opts := Options{
+		BasePath:           "test-data",
+		Transform:          blockTransform(2),
+		Journal:            true,
+		JournalSegmentSize: 512,
+		JournalSegments:    2,
+	}
+	d := New(opts)
+	defer d.EraseAll()
+
+	d.WriteString("ab01cd01", "foo")
+	d.WriteString("xxxxxxxx", "bar")
+	d.Erase("ab01cd01")
+	d.Erase("missing") // not journaled
+
+	entries, err := d.TailJournal(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []JournalEntry{
+		{Seq: 1, Op: JournalWrite, Key: "ab01cd01"},
+		{Seq: 2, Op: JournalWrite, Key: "xxxxxxxx"},
+		{Seq: 3, Op: JournalErase, Key: "ab01cd01"},
+	}
+	checkJournal(t, entries, want)
+	if sum := sha256.Sum256([]byte("foo")); !bytes.Equal(entries[0].Hash, sum[:]) {
+		t.Errorf("want hash of value, have %x", entries[0].Hash)
+	}
+	checkKeys(t, d.Keys(nil), map[string]string{"xxxxxxxx": ""})
+
+	// Resuming, and across reopening.
+	d = New(opts)
+	d.WriteString("ef01gh04", "baz")
+	entries, err = d.TailJournal(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJournal(t, entries, []JournalEntry{
+		{Seq: 3, Op: JournalErase, Key: "ab01cd01"},
+		{Seq: 4, Op: JournalWrite, Key: "ef01gh04"},
+	})
+
+	// Rotation drops old segments.
+	for i := 0; i < 20; i++ {
+		d.WriteString("ef01gh04", "baz")
+	}
+	if _, err := d.TailJournal(0); err != ErrJournalTruncated {
+		t.Fatalf("want ErrJournalTruncated, have %v", err)
+	}
+	seq := d.JournalSeq()
+	if seq != 24 {
+		t.Fatalf("want seq 24, have %d", seq)
+	}
+	entries, err = d.TailJournal(seq - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJournal(t, entries, []JournalEntry{{Seq: 24, Op: JournalWrite, Key: "ef01gh04"}})
+
+	// Clearing the store is journaled, and sequence numbers carry on.
+	if err := d.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = d.TailJournal(seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJournal(t, entries, []JournalEntry{{Seq: 25, Op: JournalClear}})
+}
+
+func checkJournal(t *testing.T, have, want []JournalEntry) {
+	t.Helper()
+	if len(have) != len(want) {
+		t.Fatalf("want %d entries, have %d: %+v", len(want), len(have), have)
+	}
+	for i := range want {
+		if have[i].Seq != want[i].Seq || have[i].Op != want[i].Op || have[i].Key != want[i].Key || have[i].Time.IsZero() {
+			t.Errorf("entry %d: want %+v, have %+v", i, want[i], have[i])
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package diskv
 
 import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -137,7 +143,12 @@ func TestKeysCancel(t *testing.T) {
 	}
 }
 
-func checkKeys(t *testing.T, c <-chan string, want map[string]string) {
+func checkKeys(t *testing.T, c <-chan string, wantKeys map[string]string) {
+	want := map[string]string{}
+	for k, v := range wantKeys {
+		want[k] = v
+	}
+
 	for k := range c {
 		if _, ok := want[k]; !ok {
 			t.Errorf("%q yielded but not expected", k)
@@ -227,3 +238,240 @@ func flattenKeys(m map[string]string) []string {
 	}
 	return a
 }
+
+func TestKeysSorted(t *testing.T) {
+	for _, transform := range []func(string) []string{
+		blockTransform(2),
+		// Walk order differs from key order: "xxxxxxxx" is walked first.
+		func(s string) []string {
+			if strings.HasPrefix(s, "x") {
+				return []string{"a"}
+			}
+			return []string{"b"}
+		},
+	} {
+		d := New(Options{
+			BasePath:   "test-data",
+			Transform:  transform,
+			SortedKeys: true,
+		})
+
+		for k, v := range keysTestData {
+			d.Write(k, []byte(v))
+		}
+
+		want := flattenKeys(keysTestData)
+		sort.Strings(want)
+		checkSortedKeys(t, "", d.Keys(nil), want)
+		d.EraseAll()
+	}
+
+	d := New(Options{
+		BasePath:   "test-data",
+		Transform:  blockTransform(2),
+		SortedKeys: true,
+	})
+	defer d.EraseAll()
+
+	for k, v := range keysTestData {
+		d.Write(k, []byte(v))
+	}
+
+	for _, prefix := range prefixes {
+		want := flattenKeys(filterPrefix(keysTestData, prefix))
+		sort.Strings(want)
+		checkSortedKeys(t, prefix, d.KeysPrefix(prefix, nil), want)
+	}
+}
+
+func checkSortedKeys(t *testing.T, prefix string, c <-chan string, want []string) {
+	have := []string{}
+	for k := range c {
+		have = append(have, k)
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("%q: want %v, have %v", prefix, want, have)
+	}
+}
+
+func TestKeysParallel(t *testing.T) {
+	d := New(Options{
+		BasePath:        "test-data",
+		Transform:       blockTransform(2),
+		WalkConcurrency: 4,
+	})
+	defer d.EraseAll()
+
+	for k, v := range keysTestData {
+		d.Write(k, []byte(v))
+	}
+
+	for _, prefix := range prefixes {
+		checkKeys(t, d.KeysPrefix(prefix, nil), filterPrefix(keysTestData, prefix))
+	}
+
+	cancel := make(chan struct{})
+	received := 0
+	for range d.Keys(cancel) {
+		if received++; received == 2 {
+			close(cancel)
+		}
+	}
+	if received < 2 || received > len(keysTestData) {
+		t.Errorf("received %d keys after cancel", received)
+	}
+}
+
+func TestKeysBuffer(t *testing.T) {
+	d := New(Options{
+		BasePath:   "test-data",
+		KeysBuffer: 4,
+	})
+	defer d.EraseAll()
+
+	for k, v := range keysTestData {
+		d.Write(k, []byte(v))
+	}
+
+	// The walk runs ahead of a consumer that isn't reading yet.
+	cancel := make(chan struct{})
+	c := d.Keys(cancel)
+	for i := 0; i < 100 && len(c) < 4; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if len(c) != 4 {
+		t.Fatalf("expected 4 buffered keys, got %d", len(c))
+	}
+	close(cancel)
+	for range c {
+	}
+
+	checkKeys(t, d.Keys(nil), keysTestData)
+}
+
+func TestKeysIgnorePatterns(t *testing.T) {
+	d, err := NewWithError(Options{
+		BasePath:       "test-data",
+		Transform:      blockTransform(2),
+		IgnorePatterns: []string{".DS_Store", "*.tmp", "lost+found"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	for k, v := range keysTestData {
+		d.Write(k, []byte(v))
+	}
+	for _, name := range []string{
+		".DS_Store",
+		filepath.Join("ab", ".DS_Store"),
+		filepath.Join("ab", "01", "x.tmp"),
+		filepath.Join("lost+found", "abcd"),
+	} {
+		filename := filepath.Join(d.BasePath, name)
+		os.MkdirAll(filepath.Dir(filename), 0777)
+		if err := ioutil.WriteFile(filename, []byte("foreign"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkKeys(t, d.Keys(nil), keysTestData)
+	if count, _, _ := d.StatPrefix(""); count != len(keysTestData) {
+		t.Fatalf("expected %d keys counted, got %d", len(keysTestData), count)
+	}
+
+	if _, err := NewWithError(Options{BasePath: "test-data", IgnorePatterns: []string{"["}}); err == nil {
+		t.Fatal("expected error for bad pattern")
+	}
+}
+
+func TestKeysWhileMutating(t *testing.T) {
+	for _, concurrency := range []int{1, 4} {
+		d := New(Options{
+			BasePath:        "test-data",
+			Transform:       blockTransform(2),
+			WalkConcurrency: concurrency,
+		})
+		for _, key := range []string{"aa11", "aa22", "bb11", "bb22", "cc11"} {
+			if err := d.Write(key, []byte(key)); err != nil {
+				t.Fatalf("Write: %s", err)
+			}
+		}
+
+		// Erasing keys ahead of the walk prunes their directories.
+		yielded := map[string]bool{}
+		withTimeout(t, func() {
+			for key := range d.Keys(nil) {
+				yielded[key] = true
+				if key != "aa11" && key != "aa22" {
+					continue
+				}
+				for _, erase := range []string{"bb11", "bb22"} {
+					if err := d.Erase(erase); err != nil && !os.IsNotExist(err) {
+						t.Errorf("Erase: %s", err)
+					}
+				}
+				if err := d.Write("dd11", []byte("dd11")); err != nil {
+					t.Errorf("Write: %s", err)
+				}
+			}
+		})
+		for _, key := range []string{"aa11", "aa22", "cc11"} {
+			if !yielded[key] {
+				t.Errorf("WalkConcurrency %d: %s not yielded, have %v", concurrency, key, yielded)
+			}
+		}
+		if concurrency == 1 && (yielded["bb11"] || yielded["bb22"]) {
+			t.Errorf("erased keys yielded: %v", yielded)
+		}
+		d.EraseAll()
+	}
+}
+
+func TestScanIterateWhileMutating(t *testing.T) {
+	d := New(Options{BasePath: "test-data", CacheSizeMax: 1024})
+	defer d.EraseAll()
+	for k, v := range keysTestData {
+		if err := d.Write(k, []byte(v)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+
+	withTimeout(t, func() {
+		c, err := d.Scan("", func(key string, r io.Reader) (bool, error) {
+			return true, d.Write(key+"-scanned", []byte(key))
+		}, nil)
+		if err != nil {
+			t.Fatalf("Scan: %s", err)
+		}
+		for range c {
+		}
+
+		err = d.Iterate("", func(key string, _ []byte) error {
+			return d.Erase(key)
+		})
+		if err != nil {
+			t.Fatalf("Iterate: %s", err)
+		}
+	})
+	if key, ok := <-d.Keys(nil); ok {
+		t.Errorf("Iterate didn't erase %s", key)
+	}
+}
+
+// withTimeout fails the test if f doesn't return within a few seconds, e.g.
+// because it deadlocked.
+func withTimeout(t *testing.T, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock")
+	}
+}
@@ -0,0 +1,38 @@
+// Package kv defines a minimal key-value store interface, so that
+// applications can swap storage engines behind it. *diskv.Diskv implements
+// it, and packages kvbolt and kvbadger adapt bbolt and Badger.
+package kv
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrNotFound is returned by stores for missing keys. Use IsNotFound to
+// check for it, as some stores return their own errors instead.
+var ErrNotFound = errors.New("key not found")
+
+// Store is a key-value store. Implementations must be safe for concurrent
+// use.
+type Store interface {
+	// Get returns the value of the key. If the key doesn't exist, the error
+	// satisfies IsNotFound. The returned slice is owned by the caller.
+	Get(key string) ([]byte, error)
+
+	// Set writes the value under the key, replacing any existing value.
+	Set(key string, value []byte) error
+
+	// Delete removes the key. If the key doesn't exist, the error satisfies
+	// IsNotFound.
+	Delete(key string) error
+
+	// Iterate calls fn for every key with the given prefix, and its value,
+	// in no particular order. If fn returns an error, Iterate stops, and
+	// returns it.
+	Iterate(prefix string, fn func(key string, value []byte) error) error
+}
+
+// IsNotFound reports whether the error means a key doesn't exist.
+func IsNotFound(err error) bool {
+	return err == ErrNotFound || os.IsNotExist(err)
+}
@@ -0,0 +1,61 @@
+package kv_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/peterbourgon/diskv/v3"
+	"github.com/peterbourgon/diskv/v3/kv"
+)
+
+var _ kv.Store = (*diskv.Diskv)(nil)
+
+func TestDiskvStore(t *testing.T) {
+	d := diskv.New(diskv.Options{BasePath: "test-data"})
+	defer os.RemoveAll("test-data")
+	testStore(t, d)
+}
+
+// testStore checks the Store contract.
+func testStore(t *testing.T, s kv.Store) {
+	if _, err := s.Get("a"); !kv.IsNotFound(err) {
+		t.Fatalf("Get missing: expected not-found error, got %v", err)
+	}
+	if err := s.Delete("a"); !kv.IsNotFound(err) {
+		t.Fatalf("Delete missing: expected not-found error, got %v", err)
+	}
+
+	for k, v := range map[string]string{"ab": "1", "ac": "2", "b": "3"} {
+		if err := s.Set(k, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := s.Get("ab"); err != nil || string(v) != "1" {
+		t.Fatalf("Get: want %q, have %q, %v", "1", v, err)
+	}
+
+	have := map[string]string{}
+	if err := s.Iterate("a", func(key string, value []byte) error {
+		have[key] = string(value)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"ab": "1", "ac": "2"}; !reflect.DeepEqual(want, have) {
+		t.Fatalf("Iterate: want %v, have %v", want, have)
+	}
+
+	stop := kv.ErrNotFound
+	n := 0
+	if err := s.Iterate("", func(string, []byte) error { n++; return stop }); err != stop || n != 1 {
+		t.Fatalf("Iterate: expected to stop after 1 key with %v, got %d keys, %v", stop, n, err)
+	}
+
+	if err := s.Delete("ab"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("ab"); !kv.IsNotFound(err) {
+		t.Fatalf("Get deleted: expected not-found error, got %v", err)
+	}
+}
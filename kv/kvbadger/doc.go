@@ -0,0 +1,6 @@
+// Package kvbadger adapts a Badger database to kv.Store.
+//
+// It depends on github.com/dgraph-io/badger/v4, which the diskv module
+// itself doesn't require, so it's built only with the badger build tag. Add
+// the module to your own go.mod, and build with -tags badger.
+package kvbadger
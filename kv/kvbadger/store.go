@@ -0,0 +1,76 @@
+//go:build badger
+// +build badger
+
+package kvbadger
+
+import (
+	badger "github.com/dgraph-io/badger/v4"
+
+	"github.com/peterbourgon/diskv/v3/kv"
+)
+
+// Store is a kv.Store backed by a Badger database.
+type Store struct {
+	db *badger.DB
+}
+
+// New returns a Store backed by the database.
+func New(db *badger.DB) *Store {
+	return &Store{db: db}
+}
+
+// Get implements kv.Store.
+func (s *Store) Get(key string) ([]byte, error) {
+	var val []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err == badger.ErrKeyNotFound {
+			return kv.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		val, err = item.ValueCopy(nil)
+		return err
+	})
+	return val, err
+}
+
+// Set implements kv.Store.
+func (s *Store) Set(key string, value []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), value)
+	})
+}
+
+// Delete implements kv.Store.
+func (s *Store) Delete(key string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte(key)); err == badger.ErrKeyNotFound {
+			return kv.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		return txn.Delete([]byte(key))
+	})
+}
+
+// Iterate implements kv.Store. Keys are visited in byte order, within a
+// single read transaction.
+func (s *Store) Iterate(prefix string, fn func(key string, value []byte) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		p := []byte(prefix)
+		for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(string(item.Key()), val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
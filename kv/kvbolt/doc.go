@@ -0,0 +1,6 @@
+// Package kvbolt adapts a bbolt database to kv.Store.
+//
+// It depends on go.etcd.io/bbolt, which the diskv module itself doesn't
+// require, so it's built only with the bbolt build tag. Add the module to
+// your own go.mod, and build with -tags bbolt.
+package kvbolt
@@ -0,0 +1,78 @@
+//go:build bbolt
+// +build bbolt
+
+package kvbolt
+
+import (
+	"bytes"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/peterbourgon/diskv/v3/kv"
+)
+
+// Store is a kv.Store backed by a single bucket of a bbolt database.
+type Store struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// New returns a Store which keeps its keys in the named bucket of the
+// database, creating the bucket if necessary.
+func New(db *bolt.DB, bucket string) (*Store, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db, bucket: []byte(bucket)}, nil
+}
+
+// Get implements kv.Store.
+func (s *Store) Get(key string) ([]byte, error) {
+	var val []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(s.bucket).Get([]byte(key))
+		if v == nil {
+			return kv.ErrNotFound
+		}
+		val = append([]byte(nil), v...) // only valid during the transaction
+		return nil
+	})
+	return val, err
+}
+
+// Set implements kv.Store.
+func (s *Store) Set(key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(key), value)
+	})
+}
+
+// Delete implements kv.Store.
+func (s *Store) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		if b.Get([]byte(key)) == nil {
+			return kv.ErrNotFound
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+// Iterate implements kv.Store. Keys are visited in byte order, within a
+// single read transaction.
+func (s *Store) Iterate(prefix string, fn func(key string, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(s.bucket).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			if err := fn(string(k), append([]byte(nil), v...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,41 @@
+package diskv
+
+import (
+	"os"
+)
+
+// Get is Read. Together with Set, Delete and Iterate, it implements the
+// kv.Store interface.
+func (d *Diskv) Get(key string) ([]byte, error) {
+	return d.Read(key)
+}
+
+// Set is Write.
+func (d *Diskv) Set(key string, value []byte) error {
+	return d.Write(key, value)
+}
+
+// Delete is Erase.
+func (d *Diskv) Delete(key string) error {
+	return d.Erase(key)
+}
+
+// Iterate calls fn for every key with the given prefix, and its value, in
+// the order KeysPrefix yields them. Keys erased during the iteration are
+// skipped. If fn returns an error, Iterate stops, and returns it.
+func (d *Diskv) Iterate(prefix string, fn func(key string, value []byte) error) error {
+	cancel := make(chan struct{})
+	defer close(cancel)
+	for key := range d.KeysPrefix(prefix, cancel) {
+		val, err := d.Read(key)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := fn(key, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package diskv
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// PprofLabel is the pprof label carried by every goroutine diskv starts,
+// so that CPU profiles and goroutine dumps attribute their load to diskv.
+// Its value names the activity:
+//
+//	async-flush      writing values buffered by AsyncWrites
+//	cache-evict      evicting a value after a direct ReadStream
+//	dump-state       checking the lock for DumpState
+//	index-init       initializing the Index
+//	index-load       loading the Index from the index file
+//	index-save       saving the Index, with PersistIndex
+//	keys             walking the store for Keys, KeysPrefix and KeysMatching
+//	maintenance/...  running the named Maintenance task
+//	parallel-read    reading chunks for ReadStreamParallel
+//	retention        enforcing Retention
+//	scan             walking the store and reading values for Scan
+//	tier-demote      demoting keys every TierPolicy.Interval
+//	tier-keys        walking both tiers for Tiered.KeysPrefix
+//	walk             listing directories, with WalkConcurrency
+//
+// For example, `go tool pprof -tagfocus diskv=keys` shows only the time
+// spent walking the store for keys.
+const PprofLabel = "diskv"
+
+// goLabeled runs fn in a new goroutine, labeled with the activity.
+func goLabeled(activity string, fn func()) {
+	go labeled(activity, fn)()
+}
+
+// labeled returns a function which runs fn with the calling goroutine
+// labeled with the activity, e.g. for time.AfterFunc.
+func labeled(activity string, fn func()) func() {
+	return func() {
+		pprof.Do(context.Background(), pprof.Labels(PprofLabel, activity), func(context.Context) { fn() })
+	}
+}
@@ -0,0 +1,41 @@
+package diskv
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPprofLabels(t *testing.T) {
+	d, err := NewWithOptions("test-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+	if err := d.WriteString("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The walk blocks sending the key, until it's canceled.
+	cancel := make(chan struct{})
+	d.Keys(cancel)
+	defer close(cancel)
+
+	want := `"diskv":"keys"`
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var buf bytes.Buffer
+		if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(buf.String(), want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutine profile lacks %s:\n%s", want, buf.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,329 @@
+package diskv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Maintenance is a schedule of background tasks; see Options.Maintenance.
+type Maintenance struct {
+	Tasks []MaintenanceTask
+
+	// Jitter randomizes each interval by up to the given fraction of it,
+	// e.g. 0.1 for ±10%, so that stores opened together don't run their
+	// tasks in lockstep.
+	Jitter float64
+
+	// If OnRun is set, it's called after every run of a task, with how long
+	// it took and the error it returned, if any.
+	OnRun func(task string, took time.Duration, err error)
+}
+
+// MaintenanceTask is a task Maintenance runs every Interval.
+type MaintenanceTask struct {
+	Name     string
+	Interval time.Duration
+	Run      func(d *Diskv) error
+}
+
+// TaskStats describes the runs of a Maintenance task so far.
+type TaskStats struct {
+	Name         string
+	Runs         int
+	Running      bool
+	LastRun      time.Time // when the last run began
+	LastDuration time.Duration
+	LastError    error // of the last run
+}
+
+// PruneDirsTask returns a MaintenanceTask which calls PruneEmptyDirs.
+func PruneDirsTask(interval time.Duration) MaintenanceTask {
+	return MaintenanceTask{
+		Name:     "prune-dirs",
+		Interval: interval,
+		Run: func(d *Diskv) error {
+			_, err := d.PruneEmptyDirs()
+			return err
+		},
+	}
+}
+
+// PurgeExpiredTask returns a MaintenanceTask which calls PurgeExpired.
+func PurgeExpiredTask(interval time.Duration) MaintenanceTask {
+	return MaintenanceTask{
+		Name:     "purge-expired",
+		Interval: interval,
+		Run: func(d *Diskv) error {
+			_, err := d.PurgeExpired()
+			return err
+		},
+	}
+}
+
+// RetentionTask returns a MaintenanceTask which calls EnforceRetention.
+func RetentionTask(interval time.Duration) MaintenanceTask {
+	return MaintenanceTask{
+		Name:     "retention",
+		Interval: interval,
+		Run: func(d *Diskv) error {
+			_, err := d.EnforceRetention()
+			return err
+		},
+	}
+}
+
+// VerifyTask returns a MaintenanceTask which calls VerifySample with the
+// given sample size, and fails if any key fails verification.
+func VerifyTask(interval time.Duration, sample int) MaintenanceTask {
+	return MaintenanceTask{
+		Name:     "verify",
+		Interval: interval,
+		Run: func(d *Diskv) error {
+			failed, err := d.VerifySample(sample)
+			if err != nil {
+				return err
+			}
+			if len(failed) > 0 {
+				return fmt.Errorf("%d key(s) failed verification: %s", len(failed), strings.Join(failed, ", "))
+			}
+			return nil
+		},
+	}
+}
+
+// SaveIndexTask returns a MaintenanceTask which calls SaveIndex.
+func SaveIndexTask(interval time.Duration) MaintenanceTask {
+	return MaintenanceTask{
+		Name:     "save-index",
+		Interval: interval,
+		Run:      func(d *Diskv) error { return d.SaveIndex() },
+	}
+}
+
+// CompactTask returns a MaintenanceTask which calls Compact with the given
+// target garbage ratio, e.g. to compact packed values during off-peak hours
+// with a long interval. Each run is limited to the interval.
+func CompactTask(interval time.Duration, targetGarbageRatio float64) MaintenanceTask {
+	return MaintenanceTask{
+		Name:     "compact",
+		Interval: interval,
+		Run: func(d *Diskv) error {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			defer cancel()
+			return d.Compact(ctx, targetGarbageRatio)
+		},
+	}
+}
+
+// Close stops background work, like Maintenance and RetentionInterval, and
+// waits for it to finish. It then persists state which is otherwise only
+// persisted periodically: values buffered by AsyncWrites, the Index with
+// PersistIndex, and the access statistics with TrackAccess. The store
+// remains usable.
+func (d *Diskv) Close() error {
+	d.mu.Lock()
+	if d.indexTimer != nil {
+		d.indexTimer.Stop()
+		d.indexTimer = nil
+	}
+	stop := d.stop
+	d.stop = nil
+	d.closed = true
+	d.mu.Unlock()
+
+	// Background work may need the lock to finish.
+	if stop != nil {
+		close(stop)
+	}
+	d.background.Wait()
+
+	d.pendingMu.Lock()
+	if d.pendingTimer != nil {
+		d.pendingTimer.Stop()
+		d.pendingTimer = nil
+	}
+	d.pendingMu.Unlock()
+	err := d.Flush()
+
+	if d.PersistIndex && d.Index != nil {
+		if saveErr := d.SaveIndex(); err == nil {
+			err = saveErr
+		}
+	}
+	if d.BloomFilter {
+		d.mu.Lock()
+		if saveErr := d.saveBloomWithLock(); err == nil {
+			err = saveErr
+		}
+		d.mu.Unlock()
+	}
+	if flushErr := d.FlushAccessStats(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// runInBackground runs fn in a goroutine labeled with the activity, which
+// Close stops by closing the channel passed to fn, and then waits for. Once
+// the store is closed, it doesn't run fn, and returns false.
+func (d *Diskv) runInBackground(activity string, fn func(stop <-chan struct{})) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false
+	}
+	if d.stop == nil {
+		d.stop = make(chan struct{})
+	}
+	stop := d.stop
+
+	// Added with the lock held, so that Close, which takes it first, never
+	// waits while it's added.
+	d.background.Add(1)
+	goLabeled(activity, func() {
+		defer d.background.Done()
+		fn(stop)
+	})
+	return true
+}
+
+// startMaintenance starts a goroutine for every Maintenance task.
+func (d *Diskv) startMaintenance() {
+	d.tasks = make([]TaskStats, len(d.Maintenance.Tasks))
+	for i, task := range d.Maintenance.Tasks {
+		d.tasks[i].Name = task.Name
+		if task.Interval <= 0 || task.Run == nil {
+			continue
+		}
+		i, task := i, task
+		d.runInBackground("maintenance/"+task.Name, func(stop <-chan struct{}) {
+			for d.sleep(d.jittered(task.Interval), stop) {
+				began := d.Clock.Now()
+				d.tasksMu.Lock()
+				d.tasks[i].Running, d.tasks[i].LastRun = true, began
+				d.tasksMu.Unlock()
+
+				err := task.Run(d)
+				took := d.Clock.Now().Sub(began)
+
+				d.tasksMu.Lock()
+				d.tasks[i].Running, d.tasks[i].LastDuration, d.tasks[i].LastError = false, took, err
+				d.tasks[i].Runs++
+				d.tasksMu.Unlock()
+				if d.Maintenance.OnRun != nil {
+					d.Maintenance.OnRun(task.Name, took, err)
+				}
+			}
+		})
+	}
+}
+
+// jittered randomizes the interval by up to Maintenance.Jitter.
+func (d *Diskv) jittered(interval time.Duration) time.Duration {
+	if d.Maintenance.Jitter <= 0 {
+		return interval
+	}
+	f := 1 + d.Maintenance.Jitter*(2*d.Rand.Float64()-1)
+	if f <= 0 {
+		return time.Millisecond
+	}
+	return time.Duration(float64(interval) * f)
+}
+
+// PruneEmptyDirs removes every empty directory below BasePath, e.g. those
+// left behind by crashes or by other tools, and returns the number removed.
+// Erase already prunes the directories of the keys it erases.
+func (d *Diskv) PruneEmptyDirs() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var dirs []string
+	err := walk(d.fs, d.BasePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != d.BasePath {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	// Children are walked after their parents, so are removed before them.
+	n := 0
+	for i := len(dirs) - 1; i >= 0; i-- {
+		names, err := readDirNames(d.fs, dirs[i])
+		if err != nil {
+			return n, err
+		}
+		if len(names) > 0 {
+			continue
+		}
+		if err := d.fs.Remove(dirs[i]); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if n > 0 {
+		d.forgetLastDirWithLock()
+	}
+	return n, nil
+}
+
+var errVerify = errors.New("value doesn't match its recorded hash")
+
+// VerifySample reads up to n keys chosen at random, and returns those which
+// can't be read, e.g. because their compressed data is corrupt, or, with
+// Merkle, whose values no longer match the hashes recorded when they were
+// written. It bypasses the cache.
+func (d *Diskv) VerifySample(n int) ([]string, error) {
+	// Reservoir sampling, so the keys needn't be held in memory.
+	var (
+		sample []string
+		seen   int
+	)
+	err := d.walkKeys(d.BasePath, "", func(key string, _ os.FileInfo) error {
+		seen++
+		if len(sample) < n {
+			sample = append(sample, key)
+		} else if i := d.Rand.Intn(seen); i < n {
+			sample[i] = key
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var failed []string
+	for _, key := range sample {
+		if err := d.verifyKey(key); err != nil && !os.IsNotExist(err) {
+			failed = append(failed, key)
+		}
+	}
+	return failed, nil
+}
+
+// verifyKey reads the key from disk, and checks its value against the
+// Merkle tree, if it's built.
+func (d *Diskv) verifyKey(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sum, err := d.hashValueWithLock(key)
+	if err != nil {
+		return err
+	}
+	if want, ok := d.merkleHashWithLock(key); ok && want != sum {
+		return errVerify
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package diskv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaintenance(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		runs = map[string]int{}
+	)
+	d := New(Options{
+		BasePath: "test-data",
+		Maintenance: Maintenance{
+			Tasks: []MaintenanceTask{
+				PurgeExpiredTask(5 * time.Millisecond),
+				PruneDirsTask(5 * time.Millisecond),
+			},
+			Jitter: 0.5,
+			OnRun: func(task string, took time.Duration, err error) {
+				if err != nil {
+					t.Errorf("%s: %s", task, err)
+				}
+				mu.Lock()
+				runs[task]++
+				mu.Unlock()
+			},
+		},
+	})
+	defer d.EraseAll()
+
+	d.WriteWith("a", strings.NewReader("1"), WriteOptions{TTL: time.Millisecond})
+	os.MkdirAll(filepath.Join("test-data", "x", "y"), 0777)
+	time.Sleep(50 * time.Millisecond)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	ran := runs["purge-expired"] > 0 && runs["prune-dirs"] > 0
+	n := runs["purge-expired"]
+	mu.Unlock()
+	if !ran {
+		t.Fatalf("tasks didn't run: %v", runs)
+	}
+	if _, err := os.Stat(filepath.Join("test-data", "a")); !os.IsNotExist(err) {
+		t.Fatal("expired key not purged")
+	}
+	if _, err := os.Stat(filepath.Join("test-data", "x")); !os.IsNotExist(err) {
+		t.Fatal("empty directories not pruned")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if runs["purge-expired"] != n {
+		t.Fatal("tasks still running after Close")
+	}
+}
+
+func TestVerifySample(t *testing.T) {
+	d := New(Options{
+		BasePath: "test-data",
+		Merkle:   true,
+	})
+	defer d.EraseAll()
+
+	for k, v := range keysTestData {
+		d.WriteString(k, v)
+	}
+	if _, err := d.RootHash(); err != nil { // builds the tree
+		t.Fatal(err)
+	}
+	if failed, err := d.VerifySample(100); err != nil || len(failed) > 0 {
+		t.Fatalf("want no failures, have %v, %v", failed, err)
+	}
+
+	ioutil.WriteFile(filepath.Join("test-data", "xxxxxxxx"), []byte("bit rot"), 0666)
+	failed, err := d.VerifySample(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0] != "xxxxxxxx" {
+		t.Fatalf("want [xxxxxxxx] failed, have %v", failed)
+	}
+}
@@ -0,0 +1,101 @@
+package diskv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// ManifestFilename is the name of the file, directly in the BasePath, where
+// WriteManifest records how the store is laid out. It's never yielded as a
+// key.
+const ManifestFilename = ".diskv-manifest"
+
+// The layout versions recorded in a Manifest. In version 1, each key's file
+// is named by the transform alone, so a file may collide with a directory
+// the transform creates for longer keys. In version 2, every file name ends
+// with the FileSuffix, so files and directories can't collide.
+const (
+	LayoutPlain    = 1
+	LayoutSuffixed = 2
+
+	latestLayout = LayoutSuffixed
+)
+
+// DataFileSuffix is the conventional FileSuffix of LayoutSuffixed stores.
+const DataFileSuffix = ".dkv"
+
+// Manifest describes how a store is laid out on disk, so that tools can
+// reconstruct compatible Options without out-of-band knowledge.
+type Manifest struct {
+	Version    int    `json:"version,omitempty"`     // layout version; 0 means LayoutPlain
+	Transform  string `json:"transform"`             // name of a NamedTransform
+	FileSuffix string `json:"file_suffix,omitempty"` // see Options.FileSuffix
+}
+
+// WriteManifest records the store's layout version, NamedTransform and
+// FileSuffix in its BasePath. It's an error if the store doesn't use a
+// NamedTransform.
+func (d *Diskv) WriteManifest() error {
+	if d.NamedTransform == nil {
+		return errNoNamedTransform
+	}
+	m := Manifest{Version: LayoutPlain, Transform: d.NamedTransform.Name}
+	if d.FileSuffix != "" {
+		m.Version, m.FileSuffix = LayoutSuffixed, d.FileSuffix
+	}
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := d.fs.MkdirAll(d.BasePath, d.PathPerm); err != nil {
+		return err
+	}
+	return writeFile(d.fs, filepath.Join(d.BasePath, ManifestFilename), buf, d.FilePerm)
+}
+
+// ReadManifest reads the manifest written by WriteManifest from the given
+// BasePath. If there's no manifest, the returned error satisfies
+// os.IsNotExist. It's an error if the manifest records a layout version
+// newer than this package supports.
+func ReadManifest(basePath string) (Manifest, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(basePath, ManifestFilename))
+	if err != nil {
+		return Manifest{}, err
+	}
+	return parseManifest(buf)
+}
+
+// parseManifest decodes and checks a manifest.
+func parseManifest(buf []byte) (Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return m, err
+	}
+	if m.Version == 0 {
+		m.Version = LayoutPlain
+	}
+	if m.Version > latestLayout {
+		return m, fmt.Errorf("manifest: unsupported layout version %d", m.Version)
+	}
+	return m, nil
+}
+
+// checkManifest returns an error if the store at basePath has a manifest
+// whose layout doesn't match the given options, which would otherwise hide
+// every key.
+func checkManifest(fs FileSystem, basePath string, o Options) error {
+	buf, err := readFile(fs, filepath.Join(basePath, ManifestFilename))
+	if err != nil {
+		return nil // no manifest, nothing to check
+	}
+	m, err := parseManifest(buf)
+	if err != nil {
+		return err
+	}
+	if m.FileSuffix != o.FileSuffix {
+		return fmt.Errorf("FileSuffix %q doesn't match the manifest's %q", o.FileSuffix, m.FileSuffix)
+	}
+	return nil
+}
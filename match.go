@@ -0,0 +1,56 @@
+package diskv
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// KeysMatching is like KeysPrefix, but it yields the keys which match the
+// glob pattern, as by path.Match: '*' matches any run of characters other
+// than '/', '?' any one of them, and '[...]' a class. The walk is limited
+// to the keys with the pattern's literal prefix, i.e. everything before its
+// first metacharacter, so patterns which start with a literal are cheaper.
+// With CaseInsensitive, the pattern is lowercased, like keys. It returns
+// path.ErrBadPattern if the pattern is malformed.
+func (d *Diskv) KeysMatching(pattern string, cancel <-chan struct{}) (<-chan string, error) {
+	pattern = d.normalizeKey(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return d.keysMatching(globPrefix(pattern), func(key string) bool {
+		ok, _ := path.Match(pattern, key) // the pattern is valid
+		return ok
+	}, cancel), nil
+}
+
+// KeysMatchingRegexp is like KeysMatching, but it yields the keys which
+// match the regular expression, in the syntax of package regexp. The
+// expression must match the whole key, as if it were anchored by ^ and $.
+// The walk is limited to the keys with the expression's literal prefix.
+func (d *Diskv) KeysMatchingRegexp(expr string, cancel <-chan struct{}) (<-chan string, error) {
+	re, err := regexp.Compile(`^(?:` + d.normalizeKey(expr) + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	prefix, _ := re.LiteralPrefix()
+	return d.keysMatching(prefix, re.MatchString, cancel), nil
+}
+
+// keysMatching yields the keys with the prefix which match returns true for,
+// if listing the prefix is authorized.
+func (d *Diskv) keysMatching(prefix string, match func(key string) bool, cancel <-chan struct{}) <-chan string {
+	if d.authorize(OpList, prefix) != nil {
+		return closedKeys()
+	}
+	return d.keysPrefix(prefix, match, cancel)
+}
+
+// globPrefix returns the literal prefix of the glob pattern, before its
+// first metacharacter.
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
@@ -0,0 +1,76 @@
+package diskv
+
+import (
+	"path"
+	"testing"
+)
+
+func TestKeysMatching(t *testing.T) {
+	d, err := NewWithOptions("test-data", WithTransform(func(s string) []string { return []string{s[:2]} }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	for _, k := range []string{"user-1-avatar", "user-1-profile", "user-22-avatar", "group-1-avatar", "us"} {
+		if err := d.WriteString(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for pattern, want := range map[string][]string{
+		"user-*-avatar": {"user-1-avatar", "user-22-avatar"},
+		"user-?-*":      {"user-1-avatar", "user-1-profile"},
+		"*-avatar":      {"user-1-avatar", "user-22-avatar", "group-1-avatar"},
+		"[gu]r*":        {"group-1-avatar"},
+		"us":            {"us"},
+		"nomatch*":      {},
+	} {
+		c, err := d.KeysMatching(pattern, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkKeys(t, c, keySet(want))
+	}
+	if _, err := d.KeysMatching("[", nil); err != path.ErrBadPattern {
+		t.Fatalf("want ErrBadPattern, have %v", err)
+	}
+
+	c, err := d.KeysMatchingRegexp(`user-\d{2,}-.*`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, c, keySet([]string{"user-22-avatar"}))
+	c, err = d.KeysMatchingRegexp(`avatar`, nil) // anchored, so no key matches
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, c, keySet(nil))
+	if _, err := d.KeysMatchingRegexp(`(`, nil); err == nil {
+		t.Fatal("expected an error for a bad expression")
+	}
+}
+
+func TestGlobPrefix(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"":         "",
+		"abc":      "abc",
+		"ab*c":     "ab",
+		"a?c":      "a",
+		"[ab]c":    "",
+		`a\*b`:     "a",
+		"user-*-x": "user-",
+	} {
+		if have := globPrefix(pattern); have != want {
+			t.Errorf("%q: want %q, have %q", pattern, want, have)
+		}
+	}
+}
+
+func keySet(keys []string) map[string]string {
+	m := map[string]string{}
+	for _, k := range keys {
+		m[k] = k
+	}
+	return m
+}
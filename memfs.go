@@ -0,0 +1,363 @@
+package diskv
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// memFS is a FileSystem held entirely in memory. It's safe for concurrent
+// use. Like the OS, open files refer to the underlying node, so a file
+// that's replaced by a rename can still be read through an open handle.
+type memFS struct {
+	mu    sync.RWMutex
+	nodes map[string]*memNode
+	temps int
+}
+
+type memNode struct {
+	mode     os.FileMode
+	modTime  time.Time
+	data     []byte
+	children map[string]struct{} // for directories
+}
+
+func newMemFS() *memFS {
+	fs := &memFS{nodes: map[string]*memNode{}}
+	for _, root := range []string{".", string(filepath.Separator)} {
+		fs.nodes[root] = &memNode{mode: os.ModeDir | 0777, modTime: time.Now(), children: map[string]struct{}{}}
+	}
+	return fs
+}
+
+func memErr(op, path string, err error) error {
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+// parentWithLock returns the directory node that will contain name.
+func (fs *memFS) parentWithLock(op, name string) (*memNode, error) {
+	parent, ok := fs.nodes[filepath.Dir(name)]
+	if !ok {
+		return nil, memErr(op, name, os.ErrNotExist)
+	}
+	if !parent.mode.IsDir() {
+		return nil, memErr(op, name, syscall.ENOTDIR)
+	}
+	return parent, nil
+}
+
+func (fs *memFS) createWithLock(op, name string, mode os.FileMode) (*memNode, error) {
+	parent, err := fs.parentWithLock(op, name)
+	if err != nil {
+		return nil, err
+	}
+	n := &memNode{mode: mode, modTime: time.Now()}
+	if mode.IsDir() {
+		n.children = map[string]struct{}{}
+	}
+	fs.nodes[name] = n
+	parent.children[filepath.Base(name)] = struct{}{}
+	return n, nil
+}
+
+func (fs *memFS) Open(name string) (File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (fs *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	n, ok := fs.nodes[name]
+	switch {
+	case ok && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, memErr("open", name, os.ErrExist)
+	case ok && n.mode.IsDir() && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		return nil, memErr("open", name, syscall.EISDIR)
+	case ok && flag&os.O_TRUNC != 0:
+		n.data, n.modTime = nil, time.Now()
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, memErr("open", name, os.ErrNotExist)
+	case !ok:
+		var err error
+		if n, err = fs.createWithLock("open", name, perm.Perm()); err != nil {
+			return nil, err
+		}
+	}
+	return &memFile{fs: fs, node: n, name: name, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (fs *memFS) TempFile(dir, pattern string) (File, error) {
+	fs.mu.Lock()
+	fs.temps++
+	name := filepath.Join(dir, fmt.Sprintf("%s%d", pattern, fs.temps))
+	fs.mu.Unlock()
+	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+func (fs *memFS) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	n, ok := fs.nodes[name]
+	if !ok {
+		return nil, memErr("stat", name, os.ErrNotExist)
+	}
+	return n.info(name), nil
+}
+
+func (fs *memFS) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+func (fs *memFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, ok := fs.nodes[name]
+	if !ok {
+		return memErr("remove", name, os.ErrNotExist)
+	}
+	if len(n.children) > 0 {
+		return memErr("remove", name, syscall.ENOTEMPTY)
+	}
+	fs.unlinkWithLock(name)
+	return nil
+}
+
+func (fs *memFS) unlinkWithLock(name string) {
+	delete(fs.nodes, name)
+	if parent, ok := fs.nodes[filepath.Dir(name)]; ok {
+		delete(parent.children, filepath.Base(name))
+	}
+}
+
+func (fs *memFS) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.nodes[path]; !ok {
+		return nil
+	}
+	for _, name := range fs.descendantsWithLock(path) {
+		delete(fs.nodes, name)
+	}
+	fs.unlinkWithLock(path)
+	return nil
+}
+
+// descendantsWithLock returns the names of every node below the given path.
+func (fs *memFS) descendantsWithLock(path string) []string {
+	prefix := path + string(filepath.Separator)
+	var names []string
+	for name := range fs.nodes {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (fs *memFS) Rename(oldpath, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	n, ok := fs.nodes[oldpath]
+	if !ok {
+		return memErr("rename", oldpath, os.ErrNotExist)
+	}
+	if dst, ok := fs.nodes[newpath]; ok && dst.mode.IsDir() {
+		return memErr("rename", newpath, os.ErrExist)
+	}
+	parent, err := fs.parentWithLock("rename", newpath)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range fs.descendantsWithLock(oldpath) {
+		fs.nodes[newpath+strings.TrimPrefix(name, oldpath)] = fs.nodes[name]
+		delete(fs.nodes, name)
+	}
+	fs.unlinkWithLock(oldpath)
+	fs.nodes[newpath] = n
+	parent.children[filepath.Base(newpath)] = struct{}{}
+	return nil
+}
+
+func (fs *memFS) Mkdir(name string, perm os.FileMode) error {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.nodes[name]; ok {
+		return memErr("mkdir", name, os.ErrExist)
+	}
+	_, err := fs.createWithLock("mkdir", name, os.ModeDir|perm.Perm())
+	return err
+}
+
+func (fs *memFS) MkdirAll(path string, perm os.FileMode) error {
+	path = filepath.Clean(path)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var todo []string
+	for p := path; ; p = filepath.Dir(p) {
+		if n, ok := fs.nodes[p]; ok {
+			if !n.mode.IsDir() {
+				return memErr("mkdir", p, syscall.ENOTDIR)
+			}
+			break
+		}
+		todo = append(todo, p)
+	}
+	for i := len(todo) - 1; i >= 0; i-- {
+		if _, err := fs.createWithLock("mkdir", todo[i], os.ModeDir|perm.Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *memFS) Chmod(name string, mode os.FileMode) error {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, ok := fs.nodes[name]
+	if !ok {
+		return memErr("chmod", name, os.ErrNotExist)
+	}
+	n.mode = n.mode&os.ModeType | mode.Perm()
+	return nil
+}
+
+func (fs *memFS) Chtimes(name string, atime, mtime time.Time) error {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, ok := fs.nodes[name]
+	if !ok {
+		return memErr("chtimes", name, os.ErrNotExist)
+	}
+	n.modTime = mtime
+	return nil
+}
+
+// memFile is an open file in a memFS.
+type memFile struct {
+	fs     *memFS
+	node   *memNode
+	name   string
+	offset int
+	append bool // every write goes to the end, as with O_APPEND
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	if f.offset >= len(f.node.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += n
+	return n, nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.append {
+		f.offset = len(f.node.data)
+	}
+	if end := f.offset + len(p); end > len(f.node.data) {
+		f.node.data = append(f.node.data, make([]byte, end-len(f.node.data))...)
+	}
+	n := copy(f.node.data[f.offset:], p)
+	f.offset += n
+	f.node.modTime = time.Now()
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if end := int(off) + len(p); end > len(f.node.data) {
+		f.node.data = append(f.node.data, make([]byte, end-len(f.node.data))...)
+	}
+	n := copy(f.node.data[off:], p)
+	f.node.modTime = time.Now()
+	return n, nil
+}
+
+func (f *memFile) Close() error { return nil }
+func (f *memFile) Name() string { return f.name }
+func (f *memFile) Sync() error  { return nil }
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	return f.node.info(f.name), nil
+}
+
+func (f *memFile) Readdirnames(n int) ([]string, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	if !f.node.mode.IsDir() {
+		return nil, memErr("readdirent", f.name, syscall.ENOTDIR)
+	}
+	names := make([]string, 0, len(f.node.children))
+	for name := range f.node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if n > 0 && n < len(names) {
+		names = names[:n]
+	}
+	return names, nil
+}
+
+// memFileInfo describes a memNode. Its Sys method returns the node.
+type memFileInfo struct {
+	name string
+	node *memNode
+	size int64
+	mode os.FileMode
+	mod  time.Time
+}
+
+func (n *memNode) info(name string) os.FileInfo {
+	return &memFileInfo{
+		name: filepath.Base(name),
+		node: n,
+		size: int64(len(n.data)),
+		mode: n.mode,
+		mod:  n.modTime,
+	}
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.mod }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return fi.node }
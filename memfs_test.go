@@ -0,0 +1,92 @@
+package diskv
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMemWriteReadErase(t *testing.T) {
+	d := NewMem(Options{
+		BasePath:     "mem-data",
+		Transform:    blockTransform(2),
+		TempDir:      "mem-data-temp",
+		CacheSizeMax: 1024,
+	})
+
+	for k, v := range keysTestData {
+		if err := d.WriteString(k, v); err != nil {
+			t.Fatalf("write %s: %s", k, err)
+		}
+	}
+	checkKeys(t, d.Keys(nil), keysTestData)
+	checkKeys(t, d.KeysPrefix("ab01", nil), filterPrefix(keysTestData, "ab01"))
+
+	for k, v := range keysTestData {
+		if got := d.ReadString(k); got != v {
+			t.Fatalf("read %s: expected %q, got %q", k, v, got)
+		}
+	}
+	if !d.isCached("ab01cd01") {
+		t.Fatalf("value not cached after read")
+	}
+
+	for k := range keysTestData {
+		if err := d.Erase(k); err != nil {
+			t.Fatalf("erase %s: %s", k, err)
+		}
+	}
+	if _, err := d.Read("ab01cd01"); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error, got %v", err)
+	}
+	if names, _ := readDirNames(d.fs, d.BasePath); len(names) != 0 {
+		t.Fatalf("directories not pruned: %v", names)
+	}
+
+	if _, err := os.Stat("mem-data"); !os.IsNotExist(err) {
+		t.Fatalf("NewMem touched the filesystem")
+	}
+}
+
+func TestMemStreams(t *testing.T) {
+	d := NewMem(Options{
+		BasePath:     "mem-data",
+		TempDir:      "mem-data-temp",
+		CacheSizeMax: 1024,
+	})
+
+	// With TempDir, a rewrite replaces the file, so an open stream keeps
+	// reading the old value, but must not cache it.
+	d.WriteString("a", "old")
+	rc, err := d.ReadStream("a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.WriteString("a", "new value")
+	if buf, _ := ioutil.ReadAll(rc); string(buf) != "old" {
+		t.Fatalf("open stream: expected 'old', got %q", buf)
+	}
+	if got := d.ReadString("a"); got != "new value" {
+		t.Fatalf("expected 'new value', got %q", got)
+	}
+
+	if err := d.WriteStream("b", bytes.NewReader(make([]byte, 100)), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.EraseAll(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Has("b") {
+		t.Fatalf("key present after EraseAll")
+	}
+}
+
+func TestMemIndependent(t *testing.T) {
+	a := NewMem(Options{BasePath: "mem-data"})
+	b := NewMem(Options{BasePath: "mem-data"})
+	a.WriteString("k", "v")
+	if b.Has("k") {
+		t.Fatalf("NewMem stores share data")
+	}
+}
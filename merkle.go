@@ -0,0 +1,301 @@
+package diskv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"sort"
+)
+
+var errNoMerkle = errors.New("Merkle tree not enabled")
+
+// merkleBuckets is the number of leaves of the Merkle tree. Keys are
+// assigned to buckets by hash, and each bucket hashes the keys in it.
+const merkleBuckets = 1024
+
+// MerkleProof proves that a key with a given value is in a store whose
+// Merkle tree has a given root hash. See Options.Merkle.
+type MerkleProof struct {
+	Key       string
+	ValueHash [sha256.Size]byte   // SHA-256 of the value
+	Bucket    [][sha256.Size]byte // leaf hashes of every key in the key's bucket, in key order
+	Siblings  [][sha256.Size]byte // sibling hashes from the bucket up to the root
+}
+
+// Verify reports whether the proof is valid for the given root hash.
+func (p MerkleProof) Verify(root [sha256.Size]byte) bool {
+	leaf := merkleLeaf(p.Key, p.ValueHash)
+	found := false
+	for _, h := range p.Bucket {
+		if h == leaf {
+			found = true
+			break
+		}
+	}
+	if !found || len(p.Siblings) != merkleDepth() {
+		return false
+	}
+
+	h := merkleBucketHash(p.Bucket)
+	i := merkleBucketOf(p.Key) + merkleBuckets
+	for _, sibling := range p.Siblings {
+		if i%2 == 0 {
+			h = merkleNode(h, sibling)
+		} else {
+			h = merkleNode(sibling, h)
+		}
+		i /= 2
+	}
+	return h == root
+}
+
+// RootHash returns the root hash of the store's Merkle tree, which covers
+// every key and value. Two stores have the same root hash if, and only if,
+// they hold the same keys with the same values, regardless of transform or
+// compression. It requires Options.Merkle.
+func (d *Diskv) RootHash() ([sha256.Size]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.buildMerkleWithLock(); err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return d.merkle.nodes[1], nil
+}
+
+// Prove returns a MerkleProof for the given key and its current value. It
+// requires Options.Merkle.
+func (d *Diskv) Prove(key string) (MerkleProof, error) {
+	key = d.normalizeKey(key)
+	if err := d.authorize(OpRead, key); err != nil {
+		return MerkleProof{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.buildMerkleWithLock(); err != nil {
+		return MerkleProof{}, err
+	}
+	b := merkleBucketOf(key)
+	valueHash, ok := d.merkle.buckets[b][key]
+	if !ok {
+		return MerkleProof{}, &os.PathError{Op: "prove", Path: key, Err: os.ErrNotExist}
+	}
+
+	p := MerkleProof{
+		Key:       key,
+		ValueHash: valueHash,
+		Bucket:    d.merkle.bucketLeaves(b),
+	}
+	for i := b + merkleBuckets; i > 1; i /= 2 {
+		p.Siblings = append(p.Siblings, d.merkle.nodes[i^1])
+	}
+	return p, nil
+}
+
+// merkleTree is a Merkle tree with a fixed number of buckets as leaves,
+// stored as a binary heap: nodes[1] is the root, and the node of bucket i
+// is nodes[merkleBuckets+i].
+type merkleTree struct {
+	buckets [merkleBuckets]map[string][sha256.Size]byte // key to value hash
+	nodes   [2 * merkleBuckets][sha256.Size]byte
+}
+
+func newMerkleTree() *merkleTree {
+	t := &merkleTree{}
+	for i := range t.buckets {
+		t.buckets[i] = map[string][sha256.Size]byte{}
+	}
+	empty := merkleBucketHash(nil)
+	for i := merkleBuckets; i < 2*merkleBuckets; i++ {
+		t.nodes[i] = empty
+	}
+	for i := merkleBuckets - 1; i >= 1; i-- {
+		t.nodes[i] = merkleNode(t.nodes[2*i], t.nodes[2*i+1])
+	}
+	return t
+}
+
+// set records the value hash of the key, or removes the key if valueHash
+// is nil, and updates the hashes above it.
+func (t *merkleTree) set(key string, valueHash *[sha256.Size]byte) {
+	b := merkleBucketOf(key)
+	if valueHash == nil {
+		if _, ok := t.buckets[b][key]; !ok {
+			return
+		}
+		delete(t.buckets[b], key)
+	} else {
+		t.buckets[b][key] = *valueHash
+	}
+
+	i := b + merkleBuckets
+	t.nodes[i] = merkleBucketHash(t.bucketLeaves(b))
+	for i /= 2; i >= 1; i /= 2 {
+		t.nodes[i] = merkleNode(t.nodes[2*i], t.nodes[2*i+1])
+	}
+}
+
+// bucketLeaves returns the leaf hashes of the keys in bucket b, in key
+// order.
+func (t *merkleTree) bucketLeaves(b int) [][sha256.Size]byte {
+	keys := make([]string, 0, len(t.buckets[b]))
+	for key := range t.buckets[b] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	leaves := make([][sha256.Size]byte, len(keys))
+	for i, key := range keys {
+		leaves[i] = merkleLeaf(key, t.buckets[b][key])
+	}
+	return leaves
+}
+
+// buildMerkleWithLock builds the Merkle tree, if it isn't built yet, by
+// reading and hashing every value. From then on, it's maintained as keys
+// are written and erased. Callers must hold d.mu.
+func (d *Diskv) buildMerkleWithLock() error {
+	if !d.Merkle {
+		return errNoMerkle
+	}
+	if d.merkle != nil {
+		return nil
+	}
+
+	var keys []string
+	if err := d.walkKeys(d.BasePath, "", func(key string, _ os.FileInfo) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	t := newMerkleTree()
+	for _, key := range keys {
+		valueHash, err := d.hashValueWithLock(key)
+		if err != nil {
+			return err
+		}
+		t.set(key, &valueHash)
+	}
+	d.merkle = t
+	return nil
+}
+
+// hashValueWithLock returns the SHA-256 of the value of the key, read from
+// disk. Callers must hold d.mu.
+func (d *Diskv) hashValueWithLock(key string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	rc, err := d.readWithRLock(d.transform(key), ReadOptions{NoFill: true, Priority: Background})
+	if err != nil {
+		return sum, err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// merkleHashWithLock returns the SHA-256 of the key's value recorded in the
+// Merkle tree, if it's built and has the key. Callers must hold d.mu, for
+// reading at least.
+func (d *Diskv) merkleHashWithLock(key string) ([sha256.Size]byte, bool) {
+	if d.merkle == nil {
+		return [sha256.Size]byte{}, false
+	}
+	sum, ok := d.merkle.buckets[merkleBucketOf(key)][key]
+	return sum, ok
+}
+
+// verifiedWithRLock wraps rc, as returned for the key along with err, so
+// that it verifies the value against its hash in the Merkle tree, if
+// opts.verify is set and it has one. Callers must hold d.mu, for reading
+// at least.
+func (d *Diskv) verifiedWithRLock(key string, rc io.ReadCloser, err error, opts ReadOptions) (io.ReadCloser, error) {
+	if err != nil || !opts.verify {
+		return rc, err
+	}
+	want, ok := d.merkleHashWithLock(key)
+	if !ok {
+		return rc, nil
+	}
+	return &hashVerifyingReader{ReadCloser: rc, h: sha256.New(), want: want}, nil
+}
+
+// hashVerifyingReader fails with ErrChecksumMismatch rather than return
+// io.EOF if the SHA-256 of what it read isn't want.
+type hashVerifyingReader struct {
+	io.ReadCloser
+	h    hash.Hash
+	want [sha256.Size]byte
+}
+
+func (r *hashVerifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(r.h.Sum(nil), r.want[:]) {
+		err = ErrChecksumMismatch
+	}
+	return n, err
+}
+
+// merkleSetWithLock updates the Merkle tree, if it's built, after the key
+// was written with a value with the given SHA-256, or erased if valueHash
+// is nil. Callers must hold d.mu.
+func (d *Diskv) merkleSetWithLock(key string, valueHash *[sha256.Size]byte) {
+	if d.merkle != nil {
+		d.merkle.set(key, valueHash)
+	}
+}
+
+func merkleDepth() int {
+	depth := 0
+	for n := merkleBuckets; n > 1; n /= 2 {
+		depth++
+	}
+	return depth
+}
+
+func merkleBucketOf(key string) int {
+	sum := sha256.Sum256([]byte(key))
+	return int(binary.BigEndian.Uint32(sum[:4]) % merkleBuckets)
+}
+
+// The leaf, bucket and node hashes are domain-separated by their first
+// byte, so that none can be passed off as another.
+
+func merkleLeaf(key string, valueHash [sha256.Size]byte) [sha256.Size]byte {
+	var buf bytes.Buffer
+	buf.WriteByte(0)
+	var n [binary.MaxVarintLen64]byte
+	buf.Write(n[:binary.PutUvarint(n[:], uint64(len(key)))])
+	buf.WriteString(key)
+	buf.Write(valueHash[:])
+	return sha256.Sum256(buf.Bytes())
+}
+
+func merkleBucketHash(leaves [][sha256.Size]byte) [sha256.Size]byte {
+	buf := make([]byte, 0, 1+len(leaves)*sha256.Size)
+	buf = append(buf, 1)
+	for _, leaf := range leaves {
+		buf = append(buf, leaf[:]...)
+	}
+	return sha256.Sum256(buf)
+}
+
+func merkleNode(left, right [sha256.Size]byte) [sha256.Size]byte {
+	buf := make([]byte, 0, 1+2*sha256.Size)
+	buf = append(buf, 2)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return sha256.Sum256(buf)
+}
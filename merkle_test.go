@@ -0,0 +1,85 @@
+package diskv
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMerkle(t *testing.T) {
+	primary := New(Options{
+		BasePath:    "test-data-primary",
+		Transform:   blockTransform(2),
+		Compression: NewGzipCompression(),
+		Merkle:      true,
+	})
+	defer primary.EraseAll()
+	replica := New(Options{
+		BasePath: "test-data-replica",
+		Merkle:   true,
+	})
+	defer replica.EraseAll()
+
+	// The primary's tree is built from existing values, the replica's is
+	// maintained as they're written.
+	for k, v := range keysTestData {
+		primary.WriteString(k, v)
+	}
+	if _, err := replica.RootHash(); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range keysTestData {
+		replica.WriteString(k, v)
+	}
+
+	root := mustRootHash(t, primary)
+	if have := mustRootHash(t, replica); have != root {
+		t.Fatal("root hashes differ for identical stores")
+	}
+
+	for k := range keysTestData {
+		p, err := replica.Prove(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !p.Verify(root) {
+			t.Fatalf("%q: proof doesn't verify", k)
+		}
+		p.ValueHash[0] ^= 1
+		if p.Verify(root) {
+			t.Fatalf("%q: proof of the wrong value verifies", k)
+		}
+	}
+	if _, err := replica.Prove("missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error, got %v", err)
+	}
+
+	replica.WriteString("xxxxxxxx", "changed")
+	if mustRootHash(t, replica) == root {
+		t.Fatal("root hash unchanged after write")
+	}
+	replica.WriteString("xxxxxxxx", keysTestData["xxxxxxxx"])
+	if mustRootHash(t, replica) != root {
+		t.Fatal("root hash differs after restoring value")
+	}
+
+	replica.Erase("ab01cd01")
+	if mustRootHash(t, replica) == root {
+		t.Fatal("root hash unchanged after erase")
+	}
+	replica.Clear()
+	if have, want := mustRootHash(t, replica), newMerkleTree().nodes[1]; have != want {
+		t.Fatal("root hash of cleared store isn't that of an empty store")
+	}
+
+	if _, err := New(Options{BasePath: "test-data-primary"}).RootHash(); err == nil {
+		t.Fatal("expected error without Merkle")
+	}
+}
+
+func mustRootHash(t *testing.T, d *Diskv) [32]byte {
+	root, err := d.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
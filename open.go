@@ -0,0 +1,122 @@
+package diskv
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ReadSeekCloser groups Read, Seek and Close. It's the same as
+// io.ReadSeekCloser, which needs Go 1.16, and so either can be used for the
+// other.
+type ReadSeekCloser interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// Open reads the key, and returns its value as a ReadSeekCloser, along with
+// its modification time, e.g. for http.ServeContent, which supports range
+// requests and conditional headers with them:
+//
+//	rsc, modTime, err := d.Open(key)
+//	...
+//	defer rsc.Close()
+//	http.ServeContent(w, r, key, modTime, rsc)
+//
+// A value in a file of its own is served straight from the file, unless
+// it's cached, or it's compressed, or ReadThrottle is set; it's not cached
+// by Open. Any other value is read into memory, like by Read, and served
+// from there. The caller must close the ReadSeekCloser. If the key doesn't
+// exist or has expired, Open returns an error satisfying os.IsNotExist. It's
+// authorized as OpRead.
+func (d *Diskv) Open(key string) (rsc ReadSeekCloser, modTime time.Time, err error) {
+	key = d.normalizeKey(key)
+	span := d.startSpan("Open", key)
+	defer func() {
+		if err == nil {
+			d.recordAccess(key)
+		}
+		span.End(err)
+	}()
+
+	if err := d.authorize(OpRead, key); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if val, ok := d.pendingValue(key); ok {
+		span.SetAttribute("pending", true)
+		return &bytesSeekCloser{bytes.NewReader(val)}, d.Clock.Now(), nil
+	}
+	if d.expired(key) {
+		return nil, time.Time{}, errExpired(key)
+	}
+
+	f, fi, err := d.openFile(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if f != nil {
+		span.SetAttribute("cache_hit", false)
+		atomic.AddUint64(&d.cacheMisses, 1)
+		return f, fi.ModTime(), nil
+	}
+
+	rc, err := d.readStream(key, false, ReadOptions{}, span)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rc.Close()
+	val, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	span.SetAttribute("bytes", int64(len(val)))
+	return &bytesSeekCloser{bytes.NewReader(val)}, fi.ModTime(), nil
+}
+
+// openFile stats the key, and if its value can be served straight from its
+// file, opens the file and returns it; otherwise it returns a nil
+// ReadSeekCloser, and the caller must read the value.
+func (d *Diskv) openFile(key string) (ReadSeekCloser, os.FileInfo, error) {
+	pathKey := d.transform(key)
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	fi, err := d.statWithRLock(pathKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch fi.(type) {
+	case *packFileInfo, *chunkFileInfo:
+		return nil, fi, nil
+	}
+	if _, ok := d.cacheLookup(key); ok || d.Compression != nil || d.readThrottle != nil {
+		return nil, fi, nil
+	}
+
+	f, err := d.fs.Open(d.completeFilename(pathKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	rsc, ok := f.(ReadSeekCloser)
+	if !ok {
+		f.Close() // error deliberately ignored
+		return nil, fi, nil
+	}
+	if fi, err = f.Stat(); err != nil {
+		f.Close() // error deliberately ignored
+		return nil, nil, err
+	}
+	return rsc, fi, nil
+}
+
+// bytesSeekCloser is a bytes.Reader with a no-op Close.
+type bytesSeekCloser struct {
+	*bytes.Reader
+}
+
+func (*bytesSeekCloser) Close() error { return nil }
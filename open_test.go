@@ -0,0 +1,92 @@
+package diskv
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOpenKey(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		opts Options
+	}{
+		{"file", Options{}},
+		{"cached", Options{CacheSizeMax: 1024}},
+		{"compressed", Options{Compression: NewGzipCompression()}},
+		{"packed", Options{PackThreshold: 64}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			c.opts.BasePath = "test-data"
+			d := New(c.opts)
+			defer d.EraseAll()
+
+			if err := d.Write("a", []byte("0123456789")); err != nil {
+				t.Fatalf("Write: %s", err)
+			}
+			if _, err := d.Read("a"); err != nil { // fill the cache, if any
+				t.Fatalf("Read: %s", err)
+			}
+			fi, err := d.Stat("a")
+			if err != nil {
+				t.Fatalf("Stat: %s", err)
+			}
+
+			rsc, modTime, err := d.Open("a")
+			if err != nil {
+				t.Fatalf("Open: %s", err)
+			}
+			defer rsc.Close()
+			if !modTime.Equal(fi.ModTime()) {
+				t.Errorf("modTime: want %s, have %s", fi.ModTime(), modTime)
+			}
+
+			req := httptest.NewRequest("GET", "/a", nil)
+			req.Header.Set("Range", "bytes=3-5")
+			rec := httptest.NewRecorder()
+			http.ServeContent(rec, req, "a", modTime, rsc)
+			if rec.Code != http.StatusPartialContent {
+				t.Fatalf("status: want %d, have %d", http.StatusPartialContent, rec.Code)
+			}
+			if body := rec.Body.String(); body != "345" {
+				t.Errorf("body: want %q, have %q", "345", body)
+			}
+
+			req = httptest.NewRequest("GET", "/a", nil)
+			req.Header.Set("If-Modified-Since", modTime.Add(time.Second).UTC().Format(http.TimeFormat))
+			rec = httptest.NewRecorder()
+			http.ServeContent(rec, req, "a", modTime, rsc)
+			if rec.Code != http.StatusNotModified {
+				t.Errorf("status: want %d, have %d", http.StatusNotModified, rec.Code)
+			}
+
+			if _, _, err := d.Open("missing"); !os.IsNotExist(err) {
+				t.Errorf("Open missing: want not-exist error, have %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenKeyPending(t *testing.T) {
+	d := New(Options{
+		BasePath:    "test-data",
+		AsyncWrites: true,
+	})
+	defer d.EraseAll()
+	defer d.Close()
+
+	if err := d.Write("a", []byte("pending")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	rsc, _, err := d.Open("a")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer rsc.Close()
+	if val, err := ioutil.ReadAll(rsc); err != nil || string(val) != "pending" {
+		t.Fatalf("ReadAll: have %q, %v", val, err)
+	}
+}
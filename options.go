@@ -0,0 +1,296 @@
+package diskv
+
+import (
+	"os"
+	"time"
+)
+
+// Option configures a Diskv created with NewWithOptions. Each Option sets one or more
+// fields of the Options struct, so new knobs can be added without callers
+// having to spell out a growing struct literal.
+type Option func(*Options)
+
+// NewWithOptions returns an initialized Diskv rooted at basePath, configured
+// with the given options. Like NewWithError, it validates the resulting
+// Options.
+func NewWithOptions(basePath string, opts ...Option) (*Diskv, error) {
+	o := Options{BasePath: basePath}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return NewWithError(o)
+}
+
+// WithTransform sets Options.Transform.
+func WithTransform(t TransformFunction) Option {
+	return func(o *Options) { o.Transform = t }
+}
+
+// WithAdvancedTransform sets Options.AdvancedTransform and its required
+// Options.InverseTransform.
+func WithAdvancedTransform(t AdvancedTransformFunction, inverse InverseTransformFunction) Option {
+	return func(o *Options) { o.AdvancedTransform, o.InverseTransform = t, inverse }
+}
+
+// WithNamedTransform sets Options.NamedTransform.
+func WithNamedTransform(t *NamedTransform) Option {
+	return func(o *Options) { o.NamedTransform = t }
+}
+
+// WithCacheSizeMax sets Options.CacheSizeMax, in bytes.
+func WithCacheSizeMax(max uint64) Option {
+	return func(o *Options) { o.CacheSizeMax = max }
+}
+
+// WithCacheEntryOverhead sets Options.CacheEntryOverhead and
+// Options.CacheCountKeys.
+func WithCacheEntryOverhead(overhead uint64, countKeys bool) Option {
+	return func(o *Options) { o.CacheEntryOverhead, o.CacheCountKeys = overhead, countKeys }
+}
+
+// WithCacheAdmission sets Options.CacheAdmission.
+func WithCacheAdmission(p AdmissionPolicy) Option {
+	return func(o *Options) { o.CacheAdmission = p }
+}
+
+// WithCacheMaxValueSize sets Options.CacheMaxValueSize, in bytes.
+func WithCacheMaxValueSize(max uint64) Option {
+	return func(o *Options) { o.CacheMaxValueSize = max }
+}
+
+// WithPrefetchConcurrency sets Options.PrefetchConcurrency.
+func WithPrefetchConcurrency(n int) Option {
+	return func(o *Options) { o.PrefetchConcurrency = n }
+}
+
+// WithSynchronousCache sets Options.SynchronousCache.
+func WithSynchronousCache() Option {
+	return func(o *Options) { o.SynchronousCache = true }
+}
+
+// WithWalkConcurrency sets Options.WalkConcurrency.
+func WithWalkConcurrency(n int) Option {
+	return func(o *Options) { o.WalkConcurrency = n }
+}
+
+// WithQuota adds a Quota for the given prefix to Options.Quotas.
+func WithQuota(prefix string, q Quota) Option {
+	return func(o *Options) {
+		if o.Quotas == nil {
+			o.Quotas = map[string]Quota{}
+		}
+		o.Quotas[prefix] = q
+	}
+}
+
+// WithThrottles sets Options.ReadThrottle and Options.WriteThrottle.
+func WithThrottles(read, write Throttle) Option {
+	return func(o *Options) { o.ReadThrottle, o.WriteThrottle = read, write }
+}
+
+// WithSyncPolicy sets Options.SyncPolicy.
+func WithSyncPolicy(p SyncPolicy) Option {
+	return func(o *Options) { o.SyncPolicy = p }
+}
+
+// WithTrackAccess sets Options.TrackAccess, and Options.AccessFlushInterval
+// to flush.
+func WithTrackAccess(flush time.Duration) Option {
+	return func(o *Options) { o.TrackAccess, o.AccessFlushInterval = true, flush }
+}
+
+// WithPathCheck sets Options.PathCheck, and Options.PathViolationHandler to
+// handler, which may be nil.
+func WithPathCheck(mode PathCheck, handler func(key, path string)) Option {
+	return func(o *Options) { o.PathCheck, o.PathViolationHandler = mode, handler }
+}
+
+// WithSymlinks sets Options.Symlinks.
+func WithSymlinks(p SymlinkPolicy) Option {
+	return func(o *Options) { o.Symlinks = p }
+}
+
+// WithStrictKeys sets Options.StrictKeys, and Options.ForeignFileHandler to
+// handler, which may be nil.
+func WithStrictKeys(handler func(path string, err error)) Option {
+	return func(o *Options) { o.StrictKeys, o.ForeignFileHandler = true, handler }
+}
+
+// WithIgnorePatterns appends to Options.IgnorePatterns.
+func WithIgnorePatterns(patterns ...string) Option {
+	return func(o *Options) { o.IgnorePatterns = append(o.IgnorePatterns, patterns...) }
+}
+
+// WithKeysBuffer sets Options.KeysBuffer.
+func WithKeysBuffer(n int) Option {
+	return func(o *Options) { o.KeysBuffer = n }
+}
+
+// WithSortedKeys sets Options.SortedKeys.
+func WithSortedKeys() Option {
+	return func(o *Options) { o.SortedKeys = true }
+}
+
+// WithSharedTombstones sets Options.SharedTombstones.
+func WithSharedTombstones() Option {
+	return func(o *Options) { o.SharedTombstones = true }
+}
+
+// WithCacheErrorHandler sets Options.CacheErrorHandler.
+func WithCacheErrorHandler(f func(key string, err error)) Option {
+	return func(o *Options) { o.CacheErrorHandler = f }
+}
+
+// WithZeroCopyReads sets Options.ZeroCopyReads.
+func WithZeroCopyReads() Option {
+	return func(o *Options) { o.ZeroCopyReads = true }
+}
+
+// WithPerms sets Options.PathPerm and Options.FilePerm.
+func WithPerms(pathPerm, filePerm os.FileMode) Option {
+	return func(o *Options) { o.PathPerm, o.FilePerm = pathPerm, filePerm }
+}
+
+// WithTempDir sets Options.TempDir, enabling atomic writes.
+func WithTempDir(dir string) Option {
+	return func(o *Options) { o.TempDir = dir }
+}
+
+// WithIndex sets Options.Index and Options.IndexLess.
+func WithIndex(index Index, less LessFunction) Option {
+	return func(o *Options) { o.Index, o.IndexLess = index, less }
+}
+
+// WithCompression sets Options.Compression.
+func WithCompression(c Compression) Option {
+	return func(o *Options) { o.Compression = c }
+}
+
+// WithTracer sets Options.Tracer.
+func WithTracer(t Tracer) Option {
+	return func(o *Options) { o.Tracer = t }
+}
+
+// WithSlowOps sets Options.SlowOpThreshold and Options.OnSlowOp.
+func WithSlowOps(threshold time.Duration, fn func(op SlowOp)) Option {
+	return func(o *Options) { o.SlowOpThreshold, o.OnSlowOp = threshold, fn }
+}
+
+// WithOnFileCreated sets Options.OnFileCreated.
+func WithOnFileCreated(f func(path string) error) Option {
+	return func(o *Options) { o.OnFileCreated = f }
+}
+
+// WithCaseInsensitive sets Options.CaseInsensitive.
+func WithCaseInsensitive() Option {
+	return func(o *Options) { o.CaseInsensitive = true }
+}
+
+// WithLimits sets Options.MaxKeyLen and Options.MaxValueSize.
+func WithLimits(maxKeyLen int, maxValueSize int64) Option {
+	return func(o *Options) { o.MaxKeyLen, o.MaxValueSize = maxKeyLen, maxValueSize }
+}
+
+// WithFileSuffix sets Options.FileSuffix.
+func WithFileSuffix(suffix string) Option {
+	return func(o *Options) { o.FileSuffix = suffix }
+}
+
+// WithFileSystem sets Options.FileSystem.
+func WithFileSystem(fs FileSystem) Option {
+	return func(o *Options) { o.FileSystem = fs }
+}
+
+// WithIOTimeout sets Options.IOTimeout.
+func WithIOTimeout(timeout time.Duration) Option {
+	return func(o *Options) { o.IOTimeout = timeout }
+}
+
+// WithMerkle sets Options.Merkle.
+func WithMerkle() Option {
+	return func(o *Options) { o.Merkle = true }
+}
+
+// WithJournal sets Options.Journal, Options.JournalSegmentSize and
+// Options.JournalSegments.
+func WithJournal(segmentSize int64, segments int) Option {
+	return func(o *Options) { o.Journal, o.JournalSegmentSize, o.JournalSegments = true, segmentSize, segments }
+}
+
+// WithPack sets Options.PackThreshold and Options.PackSegmentSize.
+func WithPack(threshold, segmentSize int64) Option {
+	return func(o *Options) { o.PackThreshold, o.PackSegmentSize = threshold, segmentSize }
+}
+
+// WithChunkSize sets Options.ChunkSize.
+func WithChunkSize(size int64) Option {
+	return func(o *Options) { o.ChunkSize = size }
+}
+
+// WithSecureErase sets Options.SecureErase.
+func WithSecureErase(passes int) Option {
+	return func(o *Options) { o.SecureErase = passes }
+}
+
+// WithSnapshotDir sets Options.SnapshotDir.
+func WithSnapshotDir(dir string) Option {
+	return func(o *Options) { o.SnapshotDir = dir }
+}
+
+// WithPersistIndex sets Options.PersistIndex, and Options.IndexSaveInterval
+// to interval.
+func WithPersistIndex(interval time.Duration) Option {
+	return func(o *Options) { o.PersistIndex, o.IndexSaveInterval = true, interval }
+}
+
+// WithBloomFilter sets Options.BloomFilter.
+func WithBloomFilter() Option {
+	return func(o *Options) { o.BloomFilter = true }
+}
+
+// WithRetention sets the retention policy for the prefix in
+// Options.Retention, and Options.RetentionInterval to interval.
+func WithRetention(prefix string, r Retention, interval time.Duration) Option {
+	return func(o *Options) {
+		if o.Retention == nil {
+			o.Retention = map[string]Retention{}
+		}
+		o.Retention[prefix] = r
+		o.RetentionInterval = interval
+	}
+}
+
+// WithMaintenance sets Options.Maintenance.
+func WithMaintenance(m Maintenance) Option {
+	return func(o *Options) { o.Maintenance = m }
+}
+
+// WithAsyncWrites sets Options.AsyncWrites, Options.AsyncWriteDelay to delay,
+// and Options.AsyncWriteErrorHandler to handler, which may be nil.
+func WithAsyncWrites(delay time.Duration, handler func(key string, err error)) Option {
+	return func(o *Options) { o.AsyncWrites, o.AsyncWriteDelay, o.AsyncWriteErrorHandler = true, delay, handler }
+}
+
+// WithWriteLog sets Options.WriteLogThreshold to threshold bytes,
+// Options.AsyncWriteDelay to delay, and Options.AsyncWriteErrorHandler to
+// handler, which may be nil.
+func WithWriteLog(threshold int64, delay time.Duration, handler func(key string, err error)) Option {
+	return func(o *Options) {
+		o.WriteLogThreshold, o.AsyncWriteDelay, o.AsyncWriteErrorHandler = threshold, delay, handler
+	}
+}
+
+// WithAuthorize sets Options.Authorize.
+func WithAuthorize(f func(op Operation, key string) error) Option {
+	return func(o *Options) { o.Authorize = f }
+}
+
+// WithClock sets Options.Clock.
+func WithClock(c Clock) Option {
+	return func(o *Options) { o.Clock = c }
+}
+
+// WithRand sets Options.Rand.
+func WithRand(r Rand) Option {
+	return func(o *Options) { o.Rand = r }
+}
@@ -0,0 +1,34 @@
+package diskv
+
+import (
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	d, err := NewWithOptions("test-data",
+		WithNamedTransform(BlockTransform(2)),
+		WithCacheSizeMax(1024),
+		WithIndex(&BTreeIndex{}, strLess),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	if d.BasePath != "test-data" || d.CacheSizeMax != 1024 {
+		t.Fatalf("options not applied: %+v", d.Options)
+	}
+	if err := d.WriteString("abcd", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if !d.isIndexed("abcd") {
+		t.Fatalf("key not indexed")
+	}
+	if !d.Has("abcd") {
+		t.Fatalf("key not written")
+	}
+
+	if _, err := NewWithOptions("test-data", WithCacheAdmission(NewSizeAdmission(1))); err == nil {
+		t.Fatalf("expected validation error")
+	}
+}
@@ -0,0 +1,698 @@
+package diskv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// packDirname is the directory, directly in the BasePath, which holds the
+// segment files of packed values. It's never walked for keys.
+const packDirname = ".diskv-pack"
+
+// defaultPackSegmentSize is used if PackSegmentSize is zero.
+const defaultPackSegmentSize = 64 << 20
+
+// packCompactRatio is the fraction of a sealed segment which must be garbage
+// before it's compacted.
+const packCompactRatio = 0.5
+
+// A packed record is a header, followed by the key and the value, which is
+// compressed if Compression is set. The header is the CRC-32 of everything
+// after it, a flags byte, the record's time in Unix nanoseconds, and the
+// lengths of the key and the value, all big-endian.
+const (
+	packHeaderSize = 4 + 1 + 8 + 4 + 4
+	packTombstone  = 1 << 0 // the key was erased
+)
+
+var errPackCorrupt = errors.New("corrupt packed record")
+
+// packLoc locates a packed value.
+type packLoc struct {
+	segment uint64
+	offset  int64 // of the value, within the segment
+	size    int64 // of the value, as stored
+	modTime time.Time
+}
+
+// recordSize returns the size of the key's record, header included.
+func (l packLoc) recordSize(key string) int64 {
+	return packHeaderSize + int64(len(key)) + l.size
+}
+
+// packSegment tracks how much of a segment file is still in use.
+type packSegment struct {
+	size int64 // of the file
+	live int64 // bytes of records which hold current values
+}
+
+// garbage returns the bytes of records which don't hold current values.
+func (s *packSegment) garbage() int64 { return s.size - s.live }
+
+// garbageRatio returns the fraction of the segment which is garbage.
+func (s *packSegment) garbageRatio() float64 {
+	if s.size <= 0 {
+		return 0
+	}
+	return float64(s.garbage()) / float64(s.size)
+}
+
+// packRecord is a decoded record.
+type packRecord struct {
+	flags   byte
+	modTime time.Time
+	key     string
+	val     []byte
+}
+
+// encodePackRecord returns the record of the key and its stored value.
+func encodePackRecord(key string, val []byte, flags byte, modTime time.Time) []byte {
+	buf := make([]byte, packHeaderSize+len(key)+len(val))
+	buf[4] = flags
+	binary.BigEndian.PutUint64(buf[5:], uint64(modTime.UnixNano()))
+	binary.BigEndian.PutUint32(buf[13:], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[17:], uint32(len(val)))
+	copy(buf[packHeaderSize:], key)
+	copy(buf[packHeaderSize+len(key):], val)
+	binary.BigEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// decodePackRecord decodes the record at the start of buf, and returns it
+// along with its size. The value aliases buf.
+func decodePackRecord(buf []byte) (packRecord, int, error) {
+	if len(buf) < packHeaderSize {
+		return packRecord{}, 0, errPackCorrupt
+	}
+	keyLen := int64(binary.BigEndian.Uint32(buf[13:]))
+	valLen := int64(binary.BigEndian.Uint32(buf[17:]))
+	n := packHeaderSize + keyLen + valLen
+	if n > int64(len(buf)) || crc32.ChecksumIEEE(buf[4:n]) != binary.BigEndian.Uint32(buf) {
+		return packRecord{}, 0, errPackCorrupt
+	}
+	return packRecord{
+		flags:   buf[4],
+		modTime: time.Unix(0, int64(binary.BigEndian.Uint64(buf[5:]))),
+		key:     string(buf[packHeaderSize : packHeaderSize+keyLen]),
+		val:     buf[packHeaderSize+keyLen : n],
+	}, int(n), nil
+}
+
+// packFilename returns the path of the given segment.
+func (d *Diskv) packFilename(segment uint64) string {
+	return filepath.Join(d.BasePath, packDirname, fmt.Sprintf("%020d", segment))
+}
+
+// packSegmentIDs returns the numbers of the existing segments, in order.
+func (d *Diskv) packSegmentIDs() ([]uint64, error) {
+	names, err := readDirNames(d.fs, filepath.Join(d.BasePath, packDirname))
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, name := range names {
+		if id, err := strconv.ParseUint(name, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// loadPack rebuilds the locations of packed values by reading every
+// segment. A corrupt record, e.g. one torn by a crash, ends its segment; if
+// it's the last one, values are then appended to a new one.
+func (d *Diskv) loadPack() {
+	d.packMu.Lock()
+	defer d.packMu.Unlock()
+
+	d.packIndex = map[string]packLoc{}
+	d.packSegments = map[uint64]*packSegment{}
+	d.packActive = 0
+
+	ids, err := d.packSegmentIDs()
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		buf, err := readFile(d.fs, d.packFilename(id))
+		if err != nil {
+			continue
+		}
+		d.packSegments[id] = &packSegment{size: int64(len(buf))}
+		d.packActive = id
+		for off := 0; off < len(buf); {
+			rec, n, err := decodePackRecord(buf[off:])
+			if err != nil {
+				d.packActive = id + 1 // don't append after the damage
+				break
+			}
+			if rec.flags&packTombstone != 0 {
+				delete(d.packIndex, rec.key)
+			} else {
+				d.packIndex[rec.key] = packLoc{
+					segment: id,
+					offset:  int64(off + n - len(rec.val)),
+					size:    int64(len(rec.val)),
+					modTime: rec.modTime,
+				}
+			}
+			off += n
+		}
+	}
+	for key, loc := range d.packIndex {
+		d.packSegments[loc.segment].live += loc.recordSize(key)
+	}
+}
+
+// resetPackWithLock forgets every packed value, after the segments were
+// removed along with everything else. Callers must hold d.mu.
+func (d *Diskv) resetPackWithLock() {
+	if d.PackThreshold <= 0 {
+		return
+	}
+	d.packMu.Lock()
+	defer d.packMu.Unlock()
+	d.packIndex = map[string]packLoc{}
+	d.packSegments = map[uint64]*packSegment{}
+	d.packActive = 0
+}
+
+// packAppendWithLock appends the records in buf to the active segment,
+// starting a new one first if it's full, and returns the segment and the
+// offset buf was written at, and whether a new segment was started.
+// Callers must hold d.mu and d.packMu.
+func (d *Diskv) packAppendWithLock(buf []byte, sync bool) (segment uint64, offset int64, rolled bool, err error) {
+	segmentSize := d.PackSegmentSize
+	if segmentSize <= 0 {
+		segmentSize = defaultPackSegmentSize
+	}
+	seg, ok := d.packSegments[d.packActive]
+	if d.packActive == 0 || (ok && seg.size >= segmentSize) {
+		d.packActive++
+	}
+	if seg, ok = d.packSegments[d.packActive]; !ok {
+		seg = &packSegment{}
+		d.packSegments[d.packActive] = seg
+		rolled = true
+	}
+
+	if err := d.fs.MkdirAll(filepath.Join(d.BasePath, packDirname), d.PathPerm); err != nil {
+		return 0, 0, false, err
+	}
+	filename := d.packFilename(d.packActive)
+	f, err := d.fs.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, d.FilePerm)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	n, err := f.Write(buf)
+	if err == nil && sync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if n > 0 {
+			seg.size = segmentSize // a partial record; start afresh
+		}
+		return 0, 0, false, err
+	}
+	if rolled && d.OnFileCreated != nil {
+		if err := d.OnFileCreated(filename); err != nil {
+			return 0, 0, false, fmt.Errorf("on file created: %s", err)
+		}
+	}
+	offset = seg.size
+	seg.size += int64(len(buf))
+	return d.packActive, offset, rolled, nil
+}
+
+// packSetWithLock records the key's new location, or with a nil loc, that
+// it's no longer packed. Callers must hold d.packMu.
+func (d *Diskv) packSetWithLock(key string, loc *packLoc) {
+	if old, ok := d.packIndex[key]; ok {
+		if seg, ok := d.packSegments[old.segment]; ok {
+			seg.live -= old.recordSize(key)
+		}
+		delete(d.packIndex, key)
+	}
+	if loc != nil {
+		d.packIndex[key] = *loc
+		d.packSegments[loc.segment].live += loc.recordSize(key)
+	}
+}
+
+// packStat returns the FileInfo of the key's packed value, if it has one.
+func (d *Diskv) packStat(key string) (os.FileInfo, bool) {
+	if d.PackThreshold <= 0 {
+		return nil, false
+	}
+	d.packMu.RLock()
+	defer d.packMu.RUnlock()
+	loc, ok := d.packIndex[key]
+	if !ok {
+		return nil, false
+	}
+	return &packFileInfo{key: key, loc: loc, mode: d.FilePerm}, true
+}
+
+// virtualStat returns the FileInfo of the key's value if it isn't in a file
+// of its own, i.e. if it's packed or chunked.
+func (d *Diskv) virtualStat(key string) (os.FileInfo, bool) {
+	if fi, ok := d.packStat(key); ok {
+		return fi, true
+	}
+	return d.chunkStat(key)
+}
+
+// storedSize returns the size of the key's data on disk, whether it's
+// packed, chunked or in a file of its own, and whether it exists.
+func (d *Diskv) storedSize(pathKey *PathKey) (int64, bool) {
+	if fi, ok := d.virtualStat(pathKey.originalKey); ok {
+		return fi.Size(), true
+	}
+	return d.fileSize(d.completeFilename(pathKey))
+}
+
+// compress returns the value as it's stored, after Compression, if any.
+func (d *Diskv) compress(val []byte) ([]byte, error) {
+	if d.Compression == nil {
+		return val, nil
+	}
+	var buf bytes.Buffer
+	wc, err := d.Compression.Writer(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("compression writer: %s", err)
+	}
+	if _, err := wc.Write(val); err != nil {
+		return nil, fmt.Errorf("compression write: %s", err)
+	}
+	if err := wc.Close(); err != nil {
+		return nil, fmt.Errorf("compression close: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writePackedWithLock packs the value, which is at most PackThreshold bytes,
+// and does everything writeStreamWithLock does after writing a file. If the
+// key had a file of its own, or a chunked value, it's removed. Callers must
+// hold d.mu.
+func (d *Diskv) writePackedWithLock(pathKey *PathKey, val []byte, opts WriteOptions) error {
+	key := pathKey.originalKey
+	stored, err := d.compress(val)
+	if err != nil {
+		return err
+	}
+
+	oldSize, exists := d.quotaBaseWithLock(pathKey)
+	qw, err := d.quotaWriterWithLock(key, oldSize, exists)
+	if err != nil {
+		return err
+	}
+	qw.w = ioutil.Discard
+	if _, err := qw.Write(stored); err != nil {
+		return err
+	}
+
+	var sum [sha256.Size]byte
+	if d.SnapshotDir != "" {
+		if sum, err = d.storeObject(bytes.NewReader(val)); err != nil {
+			return fmt.Errorf("store object: %s", err)
+		}
+	} else if d.merkle != nil || d.Journal {
+		sum = sha256.Sum256(val)
+	}
+
+	now := d.modTime(opts)
+	d.packMu.Lock()
+	segment, offset, rolled, err := d.packAppendWithLock(encodePackRecord(key, stored, 0, now), opts.Sync)
+	if err == nil {
+		d.packSetWithLock(key, &packLoc{
+			segment: segment,
+			offset:  offset + packHeaderSize + int64(len(key)),
+			size:    int64(len(stored)),
+			modTime: now,
+		})
+	}
+	d.packMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("pack: %s", err)
+	}
+
+	if err := d.removeKeyFileWithLock(pathKey); err != nil {
+		return err
+	}
+	if _, err := d.unchunkWithLock(key); err != nil {
+		return err
+	}
+
+	if d.Index != nil {
+		if ri, ok := d.Index.(RichIndex); ok {
+			ri.InsertEntry(IndexEntry{Key: key, Size: int64(len(stored)), ModTime: now})
+		} else {
+			d.Index.Insert(key)
+		}
+		d.indexChangedWithLock()
+	}
+
+	if len(d.quotas) > 0 {
+		keys := 1
+		if exists {
+			keys = 0
+		}
+		d.chargeQuotaWithLock(key, int64(len(stored))-oldSize, keys)
+		d.absorbReservedWithLock(key)
+	}
+
+	d.invalidateWithLock(key)
+	d.bloomAddWithLock(key)
+
+	if rolled {
+		d.compactPackWithLock(packCompactRatio) // errors deliberately ignored; retried next time
+	}
+
+	if d.merkle != nil || d.Journal {
+		d.merkleSetWithLock(key, &sum)
+		return d.journalWithLock(JournalWrite, key, sum[:])
+	}
+	return nil
+}
+
+// removeKeyFileWithLock removes the key's file, if it has one, after its
+// value was stored elsewhere. Callers must hold d.mu.
+func (d *Diskv) removeKeyFileWithLock(pathKey *PathKey) error {
+	filename := d.completeFilename(pathKey)
+	if fi, err := d.fs.Stat(filename); err == nil && !fi.IsDir() {
+		if err := d.fs.Remove(filename); err != nil {
+			return fmt.Errorf("remove superseded file: %s", err)
+		}
+		d.pruneDirsWithLock(pathKey.originalKey)
+	}
+	return nil
+}
+
+// unpackWithLock erases the key's packed value, if it has one, by appending
+// a tombstone, and reports whether it had one. Callers must hold d.mu.
+func (d *Diskv) unpackWithLock(key string) (bool, error) {
+	if d.PackThreshold <= 0 {
+		return false, nil
+	}
+	d.packMu.Lock()
+	defer d.packMu.Unlock()
+	if _, ok := d.packIndex[key]; !ok {
+		return false, nil
+	}
+	if _, _, _, err := d.packAppendWithLock(encodePackRecord(key, nil, packTombstone, d.Clock.Now()), false); err != nil {
+		return true, fmt.Errorf("pack: %s", err)
+	}
+	d.packSetWithLock(key, nil)
+	return true, nil
+}
+
+// readPackedWithRLock returns the key's packed value, as stored, if it has
+// one. Callers must hold at least a read lock on d.mu, so that the segment
+// isn't compacted away.
+func (d *Diskv) readPackedWithRLock(key string) ([]byte, *packFileInfo, error) {
+	fi, ok := d.packStat(key)
+	if !ok {
+		return nil, nil, nil
+	}
+	pfi := fi.(*packFileInfo)
+	f, err := d.fs.Open(d.packFilename(pfi.loc.segment))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	val := make([]byte, pfi.loc.size)
+	if ra, ok := f.(io.ReaderAt); ok {
+		_, err = ra.ReadAt(val, pfi.loc.offset)
+	} else if _, err = io.CopyN(ioutil.Discard, f, pfi.loc.offset); err == nil {
+		_, err = io.ReadFull(f, val)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("pack: %s", err)
+	}
+	return val, pfi, nil
+}
+
+// compactPackWithLock compacts every sealed segment which is at least ratio
+// garbage. Callers must hold d.mu.
+func (d *Diskv) compactPackWithLock(ratio float64) error {
+	d.packMu.Lock()
+	defer d.packMu.Unlock()
+
+	for _, id := range d.packSegmentsByGarbageWithLock() {
+		seg := d.packSegments[id]
+		if id >= d.packActive || seg.garbageRatio() < ratio {
+			continue
+		}
+		if err := d.compactSegmentWithLock(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactSegmentWithLock rewrites the current values in a sealed segment to
+// the active segment, and removes the sealed segment. Tombstones are
+// rewritten too, unless the segment is the oldest, as older segments may
+// still hold values they erase. Callers must hold d.mu and d.packMu.
+func (d *Diskv) compactSegmentWithLock(id uint64) error {
+	oldest := true
+	for other := range d.packSegments {
+		if other < id {
+			oldest = false
+			break
+		}
+	}
+
+	buf, err := readFile(d.fs, d.packFilename(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var (
+		out  []byte
+		keep = map[string]packLoc{} // offsets relative to out
+	)
+	for off := 0; off < len(buf); {
+		rec, n, err := decodePackRecord(buf[off:])
+		if err != nil {
+			break // the rest was never loaded either
+		}
+		loc, live := d.packIndex[rec.key]
+		switch {
+		case rec.flags&packTombstone != 0 && !live && !oldest:
+			out = append(out, buf[off:off+n]...)
+		case rec.flags&packTombstone == 0 && live && loc.segment == id && loc.offset == int64(off+n-len(rec.val)):
+			loc.offset = int64(len(out) + n - len(rec.val))
+			keep[rec.key] = loc
+			out = append(out, buf[off:off+n]...)
+		}
+		off += n
+	}
+
+	if len(out) > 0 {
+		segment, offset, _, err := d.packAppendWithLock(out, true)
+		if err != nil {
+			return err
+		}
+		for key, loc := range keep {
+			loc.segment, loc.offset = segment, offset+loc.offset
+			d.packSetWithLock(key, &loc)
+		}
+	}
+	if err := d.fs.Remove(d.packFilename(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(d.packSegments, id)
+	d.packCompactions++
+	return nil
+}
+
+// packSegmentsByGarbageWithLock returns the numbers of all segments, most
+// garbage first. Callers must hold d.packMu.
+func (d *Diskv) packSegmentsByGarbageWithLock() []uint64 {
+	ids := make([]uint64, 0, len(d.packSegments))
+	for id := range d.packSegments {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		gi, gj := d.packSegments[ids[i]].garbage(), d.packSegments[ids[j]].garbage()
+		if gi != gj {
+			return gi > gj
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// CompactionStats describes how much of the segments of packed values is
+// garbage, i.e. overwritten or erased values, and tombstones.
+type CompactionStats struct {
+	Segments     []SegmentStats // oldest first
+	Bytes        int64          // total size of the segments
+	GarbageBytes int64
+	GarbageRatio float64 // GarbageBytes / Bytes, or 0 if there are none
+	Compactions  int     // segments compacted since the store was created
+}
+
+// SegmentStats describes one segment of packed values.
+type SegmentStats struct {
+	ID           uint64
+	Bytes        int64
+	GarbageBytes int64
+	Active       bool // values are appended to it, so it isn't compacted automatically
+}
+
+// CompactionStats returns statistics about the garbage in the segments of
+// packed values, e.g. for monitoring, or to decide when to call Compact.
+// It's empty unless PackThreshold is set.
+func (d *Diskv) CompactionStats() CompactionStats {
+	d.packMu.RLock()
+	defer d.packMu.RUnlock()
+
+	stats := CompactionStats{Compactions: d.packCompactions}
+	for id, seg := range d.packSegments {
+		stats.Segments = append(stats.Segments, SegmentStats{
+			ID:           id,
+			Bytes:        seg.size,
+			GarbageBytes: seg.garbage(),
+			Active:       id == d.packActive,
+		})
+		stats.Bytes += seg.size
+		stats.GarbageBytes += seg.garbage()
+	}
+	sort.Slice(stats.Segments, func(i, j int) bool { return stats.Segments[i].ID < stats.Segments[j].ID })
+	if stats.Bytes > 0 {
+		stats.GarbageRatio = float64(stats.GarbageBytes) / float64(stats.Bytes)
+	}
+	return stats
+}
+
+// Compact compacts segments of packed values, most garbage first, until the
+// garbage ratio of all segments is at most targetGarbageRatio, or no
+// segment can be compacted further. Unlike automatic compaction, it
+// compacts the active segment too, if need be. Other operations proceed
+// between segments; if ctx is done, Compact stops there, and returns its
+// error. It's a no-op unless PackThreshold is set.
+func (d *Diskv) Compact(ctx context.Context, targetGarbageRatio float64) error {
+	if d.PackThreshold <= 0 {
+		return nil
+	}
+	tried := map[uint64]bool{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		done, err := d.compactOne(targetGarbageRatio, tried)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// compactOne compacts the segment with the most garbage, unless the garbage
+// ratio is already at most target, or every segment with garbage has been
+// tried, and reports whether it's done.
+func (d *Diskv) compactOne(target float64, tried map[uint64]bool) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.packMu.Lock()
+	defer d.packMu.Unlock()
+
+	var size, garbage int64
+	for _, seg := range d.packSegments {
+		size += seg.size
+		garbage += seg.garbage()
+	}
+	if size <= 0 || float64(garbage)/float64(size) <= target {
+		return true, nil
+	}
+
+	for _, id := range d.packSegmentsByGarbageWithLock() {
+		if tried[id] || d.packSegments[id].garbage() <= 0 {
+			continue
+		}
+		tried[id] = true
+		if id >= d.packActive {
+			d.packActive = id + 1 // seal it, so it can be compacted
+		}
+		return false, d.compactSegmentWithLock(id)
+	}
+	return true, nil
+}
+
+// walkPacked calls fn with every packed key with the given prefix, in order,
+// and returns the number of keys it was called with.
+func (d *Diskv) walkPacked(prefix string, fn keyFunc) (int, error) {
+	if d.PackThreshold <= 0 {
+		return 0, nil
+	}
+	d.packMu.RLock()
+	var infos []*packFileInfo
+	for key, loc := range d.packIndex {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, &packFileInfo{key: key, loc: loc, mode: d.FilePerm})
+		}
+	}
+	d.packMu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].key < infos[j].key })
+	n := 0
+	for _, fi := range infos {
+		if d.expired(fi.key) {
+			continue
+		}
+		if err := fn(fi.key, fi); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// packFileInfo is the FileInfo of a packed value. Its size is as stored,
+// i.e. after compression, if any.
+type packFileInfo struct {
+	key  string
+	loc  packLoc
+	mode os.FileMode
+}
+
+func (fi *packFileInfo) Name() string       { return fi.key }
+func (fi *packFileInfo) Size() int64        { return fi.loc.size }
+func (fi *packFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *packFileInfo) ModTime() time.Time { return fi.loc.modTime }
+func (fi *packFileInfo) IsDir() bool        { return false }
+func (fi *packFileInfo) Sys() interface{}   { return nil }
+
+// eofFunc calls fn once, when r reaches EOF.
+type eofFunc struct {
+	r  io.Reader
+	fn func()
+}
+
+func (e *eofFunc) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF && e.fn != nil {
+		e.fn()
+		e.fn = nil
+	}
+	return n, err
+}
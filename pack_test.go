@@ -0,0 +1,192 @@
+package diskv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPack(t *testing.T) {
+	for name, fs := range map[string]FileSystem{"os": OSFileSystem(), "mem": NewMemFileSystem()} {
+		t.Run(name, func(t *testing.T) {
+			opts := []Option{WithPack(16, 512), WithFileSystem(fs), WithCacheSizeMax(1024)}
+			d, err := NewWithOptions("test-data", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.EraseAll()
+
+			want := map[string]string{}
+			for i := 0; i < 50; i++ {
+				want[fmt.Sprintf("key%02d", i)] = fmt.Sprintf("small %d", i)
+			}
+			want["large"] = string(bytes.Repeat([]byte("x"), 100))
+			for k, v := range want {
+				if err := d.WriteString(k, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := fs.Stat(filepath.Join(d.BasePath, "key00")); !os.IsNotExist(err) {
+				t.Fatalf("small value stored in its own file: %v", err)
+			}
+			if _, err := fs.Stat(filepath.Join(d.BasePath, "large")); err != nil {
+				t.Fatalf("large value not stored in its own file: %v", err)
+			}
+
+			// Values move between the pack and files as they change size.
+			want["key01"] = string(bytes.Repeat([]byte("y"), 20))
+			want["large"] = "now small"
+			for _, k := range []string{"key01", "large"} {
+				if err := d.WriteString(k, want[k]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := fs.Stat(filepath.Join(d.BasePath, "large")); !os.IsNotExist(err) {
+				t.Fatalf("file of packed value not removed: %v", err)
+			}
+			if err := d.Erase("key02"); err != nil {
+				t.Fatal(err)
+			}
+			delete(want, "key02")
+			if d.Has("key02") {
+				t.Fatal("erased key still exists")
+			}
+
+			check := func(d *Diskv) {
+				t.Helper()
+				for k, v := range want {
+					if have, err := d.Read(k); err != nil || string(have) != v {
+						t.Fatalf("%s: want %q, have %q (%v)", k, v, have, err)
+					}
+					if have, err := d.Read(k); err != nil || string(have) != v { // cached
+						t.Fatalf("%s: want %q, have %q (%v)", k, v, have, err)
+					}
+				}
+				checkKeys(t, d.Keys(nil), want)
+				if count, _, _ := d.StatPrefix("key"); count != 49 {
+					t.Fatalf("expected 49 keys counted, got %d", count)
+				}
+				if fi, err := d.Stat("key03"); err != nil || fi.Size() != int64(len(want["key03"])) {
+					t.Fatalf("Stat: %v, %v", fi, err)
+				}
+			}
+			check(d)
+			d2, err := NewWithOptions("test-data", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(d2)
+
+			// Overwrites leave garbage, which compaction reclaims.
+			for i := 0; i < 500; i++ {
+				if err := d.WriteString("key03", fmt.Sprintf("v%d", i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want["key03"] = "v499"
+			ids, err := d.packSegmentIDs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ids) > 8 {
+				t.Fatalf("%d segments left after compaction", len(ids))
+			}
+			d3, err := NewWithOptions("test-data", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range want {
+				if have, err := d3.Read(k); err != nil || string(have) != v {
+					t.Fatalf("%s after compaction: want %q, have %q (%v)", k, v, have, err)
+				}
+			}
+			if d3.Has("key02") {
+				t.Fatal("erased key resurrected by compaction")
+			}
+		})
+	}
+}
+
+func TestPackTornRecord(t *testing.T) {
+	d, err := NewWithOptions("test-data", WithPack(16, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	if err := d.WriteString("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(d.packFilename(1), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(encodePackRecord("b", []byte("2"), 0, d.packIndex["a"].modTime)[:10])
+	f.Close()
+
+	d2, err := NewWithOptions("test-data", WithPack(16, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := d2.ReadString("a"); have != "1" {
+		t.Fatalf("want 1, have %q", have)
+	}
+	if err := d2.WriteString("c", "3"); err != nil {
+		t.Fatal(err)
+	}
+	d3, err := NewWithOptions("test-data", WithPack(16, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, d3.Keys(nil), map[string]string{"a": "1", "c": "3"})
+}
+
+func TestCompact(t *testing.T) {
+	d, err := NewWithOptions("test-data", WithPack(16, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 100; i++ {
+			if err := d.WriteString(fmt.Sprintf("key%02d", i), fmt.Sprintf("v%d", round)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	stats := d.CompactionStats()
+	if len(stats.Segments) != 1 || !stats.Segments[0].Active || stats.GarbageRatio < 0.7 {
+		t.Fatalf("unexpected stats before compaction: %+v", stats)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.Compact(ctx, 0.1); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if err := d.Compact(context.Background(), 0.1); err != nil {
+		t.Fatal(err)
+	}
+	stats = d.CompactionStats()
+	if stats.GarbageRatio > 0.1 || stats.Compactions != 1 {
+		t.Fatalf("unexpected stats after compaction: %+v", stats)
+	}
+
+	d2, err := NewWithOptions("test-data", WithPack(16, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if have := d2.ReadString(fmt.Sprintf("key%02d", i)); have != "v4" {
+			t.Fatalf("key%02d: want v4, have %q", i, have)
+		}
+	}
+	if stats := d2.CompactionStats(); stats.GarbageBytes != 0 {
+		t.Fatalf("unexpected garbage after reopening: %+v", stats)
+	}
+}
@@ -0,0 +1,137 @@
+package diskv
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// pinsFilename is the name of the file, directly in the BasePath, where the
+// pinned keys are persisted. It's never yielded as a key.
+const pinsFilename = ".diskv-pins"
+
+// Pin pins an existing key, so that it's never evicted: its value isn't
+// uncached to make room for others, and the key isn't erased by Retention or
+// PruneTimestamped, or demoted by Tiered. It can still be erased, which
+// unpins it, and it still expires with a TTL. Pins are persisted, so they
+// survive restarts. If the key doesn't exist or has expired, Pin returns an
+// error satisfying os.IsNotExist. It's authorized as OpWrite.
+func (d *Diskv) Pin(key string) error {
+	key = d.normalizeKey(key)
+	if err := d.authorize(OpWrite, key); err != nil {
+		return err
+	}
+	if d.expired(key) {
+		return errExpired(key)
+	}
+
+	d.mu.RLock()
+	_, err := d.statWithRLock(d.transform(key))
+	d.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return d.setPinned(key, true)
+}
+
+// Unpin unpins the key. It's not an error if it isn't pinned. It's
+// authorized as OpWrite.
+func (d *Diskv) Unpin(key string) error {
+	key = d.normalizeKey(key)
+	if err := d.authorize(OpWrite, key); err != nil {
+		return err
+	}
+	return d.setPinned(key, false)
+}
+
+// Pinned reports whether the key is pinned. It's authorized as OpStat.
+func (d *Diskv) Pinned(key string) bool {
+	key = d.normalizeKey(key)
+	if d.authorize(OpStat, key) != nil {
+		return false
+	}
+	return d.pinned(key)
+}
+
+// pinned reports whether the key is pinned.
+func (d *Diskv) pinned(key string) bool {
+	d.pinMu.RLock()
+	defer d.pinMu.RUnlock()
+	_, ok := d.pins[key]
+	return ok
+}
+
+// setPinned pins or unpins the key, and persists the pins if they changed.
+func (d *Diskv) setPinned(key string, pin bool) error {
+	d.pinMu.Lock()
+	defer d.pinMu.Unlock()
+	if _, ok := d.pins[key]; ok == pin {
+		return nil
+	}
+	if pin {
+		d.pins[key] = struct{}{}
+	} else {
+		delete(d.pins, key)
+	}
+	return d.savePinsWithLock()
+}
+
+// forgetPin unpins the key, which was erased, or every key, if all is set.
+// Errors persisting the pins are ignored, and retried at the next change.
+func (d *Diskv) forgetPin(key string, all bool) {
+	d.pinMu.Lock()
+	defer d.pinMu.Unlock()
+	if _, ok := d.pins[key]; !ok && !(all && len(d.pins) > 0) {
+		return
+	}
+	if all {
+		d.pins = map[string]struct{}{}
+	} else {
+		delete(d.pins, key)
+	}
+	d.savePinsWithLock() // error deliberately ignored
+}
+
+// loadPins reads the persisted pins from disk. A missing or corrupt file is
+// treated as no pins.
+func (d *Diskv) loadPins() {
+	d.pinMu.Lock()
+	defer d.pinMu.Unlock()
+
+	d.pins = map[string]struct{}{}
+	buf, err := readFile(d.fs, filepath.Join(d.BasePath, pinsFilename))
+	if err != nil {
+		return
+	}
+	var persisted []string
+	if err := json.Unmarshal(buf, &persisted); err != nil {
+		return
+	}
+	for _, key := range persisted {
+		d.pins[key] = struct{}{}
+	}
+}
+
+// savePinsWithLock atomically persists the pins to disk. Callers must hold
+// pinMu.
+func (d *Diskv) savePinsWithLock() error {
+	filename := filepath.Join(d.BasePath, pinsFilename)
+	if len(d.pins) <= 0 {
+		if err := d.fs.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	persisted := make([]string, 0, len(d.pins))
+	for key := range d.pins {
+		persisted = append(persisted, key)
+	}
+	sort.Strings(persisted)
+	buf, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	return d.writeInternalFile(filename, buf)
+}
@@ -0,0 +1,107 @@
+package diskv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPin(t *testing.T) {
+	d := New(Options{BasePath: "test-data"})
+	defer d.EraseAll()
+
+	if err := d.Pin("missing"); !os.IsNotExist(err) {
+		t.Fatalf("Pin missing: want not-exist error, have %v", err)
+	}
+	if err := d.Write("config", []byte("v")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := d.Pin("config"); err != nil {
+		t.Fatalf("Pin: %s", err)
+	}
+	if !d.Pinned("config") {
+		t.Fatalf("Pinned: want true")
+	}
+
+	// Pins are persisted.
+	d = New(Options{BasePath: "test-data"})
+	if !d.Pinned("config") {
+		t.Fatalf("Pinned: want true after reopening")
+	}
+	checkKeys(t, d.Keys(nil), map[string]string{"config": ""})
+
+	if err := d.Unpin("config"); err != nil {
+		t.Fatalf("Unpin: %s", err)
+	}
+	if d.Pinned("config") {
+		t.Fatalf("Pinned: want false after Unpin")
+	}
+
+	// Erasing a key unpins it.
+	if err := d.Pin("config"); err != nil {
+		t.Fatalf("Pin: %s", err)
+	}
+	if err := d.Erase("config"); err != nil {
+		t.Fatalf("Erase: %s", err)
+	}
+	if d.Pinned("config") {
+		t.Fatalf("Pinned: want false after Erase")
+	}
+}
+
+func TestPinnedCacheEviction(t *testing.T) {
+	d := New(Options{BasePath: "test-data", CacheSizeMax: 4})
+	defer d.EraseAll()
+
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		if err := d.Write(k, []byte("12")); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+	d.Read("a")
+	if err := d.Pin("a"); err != nil {
+		t.Fatalf("Pin: %s", err)
+	}
+	for _, k := range []string{"b", "c", "d", "e"} {
+		d.Read(k)
+		if !d.isCached("a") {
+			t.Fatalf("pinned value evicted to cache %s", k)
+		}
+	}
+
+	// With the cache full of pinned values, nothing else is cached.
+	if err := d.Pin("e"); err != nil {
+		t.Fatalf("Pin: %s", err)
+	}
+	d.Read("b")
+	if d.isCached("b") || !d.isCached("a") || !d.isCached("e") {
+		t.Fatalf("want only the pinned values cached")
+	}
+}
+
+func TestPinnedRetention(t *testing.T) {
+	d := New(Options{
+		BasePath:  "test-data",
+		Retention: map[string]Retention{"log-": {MaxKeys: 2}},
+	})
+	defer d.EraseAll()
+
+	for i, key := range []string{"log-1", "log-2", "log-3", "log-4"} {
+		d.WriteString(key, key)
+		age := time.Now().Add(-time.Duration(10-i) * time.Minute)
+		os.Chtimes(filepath.Join("test-data", key), age, age)
+	}
+	if err := d.Pin("log-1"); err != nil {
+		t.Fatalf("Pin: %s", err)
+	}
+
+	n, err := d.EnforceRetention()
+	if err != nil {
+		t.Fatalf("EnforceRetention: %s", err)
+	}
+	if n != 2 {
+		t.Fatalf("EnforceRetention: want 2 keys erased, have %d", n)
+	}
+	checkKeys(t, d.Keys(nil), map[string]string{"log-1": "", "log-4": ""})
+}
@@ -0,0 +1,115 @@
+package diskv
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// defaultPrefetchConcurrency is the PrefetchConcurrency if it's not set.
+const defaultPrefetchConcurrency = 4
+
+// Prefetching is a prefetch started by Prefetch.
+type Prefetching struct {
+	cancel  chan struct{}
+	once    sync.Once
+	done    chan struct{}
+	fetched int // guarded by done
+}
+
+// Prefetch reads the keys into the cache in the background, at Background
+// priority, e.g. the next page of results, so that reads of them soon after
+// are served from memory. Without a cache, it still warms the operating
+// system's page cache. Keys which are already cached, which don't exist, or
+// which aren't authorized for OpRead are skipped. It returns immediately.
+// Across every Prefetch of the store, at most PrefetchConcurrency keys are
+// read at once. Close cancels every Prefetch, and waits for it; after Close,
+// Prefetch reads nothing. Like WarmCache, prefetching doesn't count as
+// access.
+func (d *Diskv) Prefetch(keys []string) *Prefetching {
+	keys = append([]string(nil), keys...)
+	p := &Prefetching{
+		cancel: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	started := d.runInBackground("prefetch", func(stop <-chan struct{}) {
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			fetched int
+		)
+		defer func() {
+			wg.Wait()
+			p.fetched = fetched
+			close(p.done)
+		}()
+		for _, key := range keys {
+			select {
+			case <-p.cancel:
+				return // even if a slot is free
+			case <-stop:
+				return
+			default:
+			}
+			select {
+			case d.prefetchSlots <- struct{}{}:
+			case <-p.cancel:
+				return
+			case <-stop:
+				return
+			}
+			key := key
+			wg.Add(1)
+			goLabeled("prefetch", func() {
+				defer wg.Done()
+				defer func() { <-d.prefetchSlots }()
+				if d.prefetch(key) {
+					mu.Lock()
+					fetched++
+					mu.Unlock()
+				}
+			})
+		}
+	})
+	if !started {
+		close(p.done) // nothing fetched
+	}
+	return p
+}
+
+// Cancel stops prefetching keys which haven't started being read, and waits
+// for those which have. It's safe to call more than once.
+func (p *Prefetching) Cancel() {
+	p.once.Do(func() { close(p.cancel) })
+	<-p.done
+}
+
+// Wait waits for the prefetch to finish, or be canceled, and returns the
+// number of keys it read.
+func (p *Prefetching) Wait() int {
+	<-p.done
+	return p.fetched
+}
+
+// prefetch reads the key in full, filling the cache, unless it's cached
+// already, and reports whether it did.
+func (d *Diskv) prefetch(key string) bool {
+	key = d.normalizeKey(key)
+	if d.authorize(OpRead, key) != nil || d.expired(key) {
+		return false
+	}
+	d.mu.RLock()
+	_, cached := d.cacheLookup(key)
+	d.mu.RUnlock()
+	if cached {
+		return false
+	}
+
+	rc, err := d.readStream(key, false, ReadOptions{Priority: Background}, nopSpan{})
+	if err != nil {
+		return false
+	}
+	defer rc.Close()
+	_, err = io.Copy(ioutil.Discard, rc)
+	return err == nil
+}
@@ -0,0 +1,82 @@
+package diskv
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPrefetch(t *testing.T) {
+	d := NewMem(Options{
+		CacheSizeMax:        1024,
+		PrefetchConcurrency: 2,
+	})
+	defer d.Close()
+
+	keys := []string{}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := d.Write(key, []byte(key)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		keys = append(keys, key)
+	}
+	if _, err := d.Read("key0"); err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+
+	p := d.Prefetch(append(keys, "missing"))
+	if n := p.Wait(); n != 9 {
+		t.Errorf("want 9 keys prefetched, have %d", n)
+	}
+	for _, key := range keys {
+		if !d.isCached(key) {
+			t.Errorf("%s not cached", key)
+		}
+	}
+	p.Cancel() // no-op once finished
+}
+
+func TestPrefetchCancel(t *testing.T) {
+	d := NewMem(Options{
+		CacheSizeMax:        1024,
+		PrefetchConcurrency: 1,
+	})
+	defer d.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := d.Write(key, []byte(key)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+
+	// With the only slot taken, no key can start being read until the
+	// prefetch is canceled, and then none does.
+	d.prefetchSlots <- struct{}{}
+	p := d.Prefetch([]string{"a", "b", "c"})
+	p.once.Do(func() { close(p.cancel) })
+	<-d.prefetchSlots
+	if n := p.Wait(); n != 0 {
+		t.Errorf("want no keys prefetched after Cancel, have %d", n)
+	}
+	p.Cancel() // no-op once canceled
+
+	p = d.Prefetch([]string{"a"})
+	d.Close()
+	p.Wait() // Close waits for it
+}
+
+func TestPrefetchAfterClose(t *testing.T) {
+	d := NewMem(Options{BasePath: "/prefetch", CacheSizeMax: 1024})
+	if err := d.Write("a", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.Prefetch([]string{"a"}).Wait(); n != 0 {
+		t.Errorf("prefetched %d keys after Close", n)
+	}
+	if d.isCached("a") {
+		t.Error("a cached after Close")
+	}
+}
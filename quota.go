@@ -0,0 +1,175 @@
+package diskv
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Quota limits the keys with a given prefix, e.g. those of one tenant.
+type Quota struct {
+	MaxKeys  int   // if positive, the maximum number of keys
+	MaxBytes int64 // if positive, the maximum total size of their files on disk
+}
+
+// Usage is the number of keys with a given prefix, and the total size of
+// their files on disk, which is after compression, if any.
+type Usage struct {
+	Keys  int
+	Bytes int64
+}
+
+// QuotaError is returned by writes which would exceed a Quota. Nothing is
+// written, except that a failed overwrite without a TempDir leaves the key
+// erased, like any other failed write.
+type QuotaError struct {
+	Prefix string
+	Quota  Quota
+	Usage  Usage // before the write
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded for prefix %q (%d keys, %d bytes in use)", e.Prefix, e.Usage.Keys, e.Usage.Bytes)
+}
+
+// IsQuotaExceeded reports whether err is a *QuotaError.
+func IsQuotaExceeded(err error) bool {
+	_, ok := err.(*QuotaError)
+	return ok
+}
+
+// quotaState is a Quota, and the usage it's enforced against.
+type quotaState struct {
+	quota Quota
+	usage Usage
+}
+
+// SetQuota sets the Quota of the keys with the given prefix, replacing any
+// previous Quota for the same prefix; the zero Quota removes it. A key
+// counts against the Quota of every prefix it has. The prefix's current
+// usage is computed with StatPrefix, during which writes are blocked.
+func (d *Diskv) SetQuota(prefix string, q Quota) error {
+	prefix = d.normalizeKey(prefix)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if q == (Quota{}) {
+		delete(d.quotas, prefix)
+		return nil
+	}
+
+	count, bytes, err := d.statPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	if d.quotas == nil {
+		d.quotas = map[string]*quotaState{}
+	}
+	d.quotas[prefix] = &quotaState{quota: q, usage: Usage{Keys: count, Bytes: bytes}}
+	return nil
+}
+
+// QuotaUsage returns the Quota set for the given prefix, and its current
+// usage. If no Quota is set for the prefix, ok is false; use StatPrefix
+// instead.
+func (d *Diskv) QuotaUsage(prefix string) (q Quota, u Usage, ok bool) {
+	prefix = d.normalizeKey(prefix)
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	s, ok := d.quotas[prefix]
+	if !ok {
+		return Quota{}, Usage{}, false
+	}
+	return s.quota, s.usage, true
+}
+
+// initQuotas sets every Quota in Options.Quotas. Errors are ignored, which
+// leaves the affected usage at zero.
+func (d *Diskv) initQuotas() {
+	for prefix, q := range d.Quotas {
+		d.SetQuota(prefix, q) // errors ignored
+	}
+}
+
+// quotaWriterWithLock checks whether key may be written, given the size of
+// its current file, if it exists. It returns a quotaWriter, yet without a
+// Writer, which enforces the tightest MaxBytes which applies to key.
+// Callers must hold d.mu.
+func (d *Diskv) quotaWriterWithLock(key string, oldSize int64, exists bool) (*quotaWriter, error) {
+	qw := &quotaWriter{n: -1}
+	for prefix, s := range d.quotas {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		exceeded := &QuotaError{Prefix: prefix, Quota: s.quota, Usage: s.usage}
+		if !exists && s.quota.MaxKeys > 0 && s.usage.Keys+1 > s.quota.MaxKeys {
+			return nil, exceeded
+		}
+		if s.quota.MaxBytes > 0 {
+			remaining := s.quota.MaxBytes - (s.usage.Bytes - oldSize)
+			if remaining < 0 {
+				remaining = 0
+			}
+			if qw.n < 0 || remaining < qw.n {
+				qw.n, qw.err = remaining, exceeded
+			}
+		}
+	}
+	return qw, nil
+}
+
+// chargeQuotaWithLock adds bytes and keys, either of which may be negative,
+// to the usage of every Quota which applies to key. Callers must hold d.mu.
+func (d *Diskv) chargeQuotaWithLock(key string, bytes int64, keys int) {
+	for prefix, s := range d.quotas {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		s.usage.Bytes += bytes
+		s.usage.Keys += keys
+		if s.usage.Bytes < 0 {
+			s.usage.Bytes = 0
+		}
+		if s.usage.Keys < 0 {
+			s.usage.Keys = 0
+		}
+	}
+}
+
+// resetQuotasWithLock zeroes the usage of every Quota, after the store has
+// been emptied. Callers must hold d.mu.
+func (d *Diskv) resetQuotasWithLock() {
+	for _, s := range d.quotas {
+		s.usage = Usage{}
+	}
+}
+
+// fileSize returns the size of the named file, and whether it exists.
+func (d *Diskv) fileSize(filename string) (int64, bool) {
+	fi, err := d.fs.Stat(filename)
+	if err != nil || fi.IsDir() {
+		return 0, false
+	}
+	return fi.Size(), true
+}
+
+// quotaWriter writes to w, but fails with err once more than n bytes have
+// been written, unless n is negative. It counts the bytes written.
+type quotaWriter struct {
+	w       io.Writer
+	n       int64
+	err     error
+	written int64
+}
+
+func (qw *quotaWriter) Write(p []byte) (int, error) {
+	if qw.n >= 0 && qw.written+int64(len(p)) > qw.n {
+		return 0, qw.err
+	}
+	n, err := qw.w.Write(p)
+	qw.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,103 @@
+package diskv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	d := New(Options{
+		BasePath:  "test-data",
+		Transform: blockTransform(2),
+		TempDir:   "test-data-temp",
+		Quotas: map[string]Quota{
+			"ab": {MaxKeys: 2},
+			"ef": {MaxBytes: 10},
+		},
+	})
+	defer d.EraseAll()
+
+	// MaxKeys
+	for _, key := range []string{"ab01", "ab02"} {
+		if err := d.WriteString(key, "1234567890"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.WriteString("ab01", "1"); err != nil {
+		t.Fatalf("overwrite: %s", err)
+	}
+	err := d.WriteString("ab03", "1")
+	if qe, ok := err.(*QuotaError); !ok || qe.Prefix != "ab" || qe.Usage.Keys != 2 {
+		t.Fatalf("expected quota error for ab, got %v", err)
+	}
+	if d.Has("ab03") {
+		t.Fatal("ab03 written despite quota")
+	}
+	if _, u, _ := d.QuotaUsage("ab"); u.Keys != 2 || u.Bytes != 11 {
+		t.Fatalf("bad usage: %+v", u)
+	}
+
+	// MaxBytes
+	if err := d.WriteString("ef01", "123456"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteString("ef02", "12345"); !IsQuotaExceeded(err) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	if d.Has("ef02") {
+		t.Fatal("ef02 written despite quota")
+	}
+	if err := d.WriteString("ef01", "1234567890"); err != nil {
+		t.Fatalf("overwrite within quota: %s", err)
+	}
+	if err := d.Erase("ef01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, u, _ := d.QuotaUsage("ef"); u != (Usage{}) {
+		t.Fatalf("bad usage after erase: %+v", u)
+	}
+	if err := d.WriteStream("ef02", strings.NewReader("12345"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Usage is recomputed for a new store, and for new quotas.
+	d2 := New(Options{
+		BasePath:  "test-data",
+		Transform: blockTransform(2),
+		Quotas:    map[string]Quota{"ab": {MaxKeys: 2}},
+	})
+	if _, u, ok := d2.QuotaUsage("ab"); !ok || u.Keys != 2 || u.Bytes != 11 {
+		t.Fatalf("bad recomputed usage: %+v", u)
+	}
+	if err := d2.SetQuota("", Quota{MaxKeys: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if _, u, _ := d2.QuotaUsage(""); u.Keys != 3 {
+		t.Fatalf("bad usage for new quota: %+v", u)
+	}
+	d2.SetQuota("", Quota{})
+	if _, _, ok := d2.QuotaUsage(""); ok {
+		t.Fatal("quota not removed")
+	}
+}
+
+func TestQuotaCompressed(t *testing.T) {
+	d := New(Options{
+		BasePath:    "test-data",
+		Compression: NewGzipCompression(),
+		Quotas:      map[string]Quota{"": {MaxBytes: 100}},
+	})
+	defer d.EraseAll()
+
+	// Compressible values count at their size on disk.
+	if err := d.Write("a", bytes.Repeat([]byte{'a'}, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("b", genValue(1000)); !IsQuotaExceeded(err) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	if d.Has("b") {
+		t.Fatal("b written despite quota")
+	}
+}
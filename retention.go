@@ -0,0 +1,121 @@
+package diskv
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Retention is a retention policy for the keys with a prefix; see
+// Options.Retention. Zero fields impose no limit.
+type Retention struct {
+	MaxKeys int           // keep at most MaxKeys keys, erasing the oldest
+	MaxAge  time.Duration // erase keys last written longer ago than MaxAge
+}
+
+// EnforceRetention erases the keys which the Retention policies don't
+// allow, and returns the number of keys erased. Pinned keys are never
+// erased, but count toward MaxKeys. Keys are ordered by the modification
+// times of their files, which are taken from the Index if it's a RichIndex,
+// and from the files themselves otherwise. With RetentionInterval, it's
+// called periodically in the background.
+func (d *Diskv) EnforceRetention() (int, error) {
+	if len(d.Retention) <= 0 {
+		return 0, nil
+	}
+
+	var all []IndexEntry // oldest first, from the RichIndex
+	if ri, ok := d.Index.(RichIndex); ok {
+		all = ri.Oldest(int(^uint(0) >> 1))
+	}
+
+	n := 0
+	now := d.Clock.Now()
+	for prefix, r := range d.Retention {
+		prefix = d.normalizeKey(prefix)
+		entries, err := d.retentionEntries(prefix, all)
+		if err != nil {
+			return n, err
+		}
+
+		entries, pinned := d.unpinnedEntries(entries)
+
+		var erase []IndexEntry
+		for len(entries) > 0 && r.MaxAge > 0 && now.Sub(entries[0].ModTime) > r.MaxAge {
+			erase, entries = append(erase, entries[0]), entries[1:]
+		}
+		if r.MaxKeys > 0 {
+			keep := r.MaxKeys - pinned
+			if keep < 0 {
+				keep = 0
+			}
+			if len(entries) > keep {
+				erase = append(erase, entries[:len(entries)-keep]...)
+			}
+		}
+
+		for _, e := range erase {
+			if err := d.erase(e.Key); err == nil {
+				n++
+			} else if !os.IsNotExist(err) {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// unpinnedEntries returns the entries of the keys which aren't pinned, and
+// the number of those which are.
+func (d *Diskv) unpinnedEntries(entries []IndexEntry) ([]IndexEntry, int) {
+	unpinned := entries[:0]
+	for _, e := range entries {
+		if !d.pinned(e.Key) {
+			unpinned = append(unpinned, e)
+		}
+	}
+	return unpinned, len(entries) - len(unpinned)
+}
+
+// retentionEntries returns the entries of the keys with the prefix, oldest
+// first: from all, if it's not nil, or by walking the store otherwise.
+func (d *Diskv) retentionEntries(prefix string, all []IndexEntry) ([]IndexEntry, error) {
+	var entries []IndexEntry
+	if all != nil {
+		for _, e := range all {
+			if strings.HasPrefix(e.Key, prefix) {
+				entries = append(entries, e)
+			}
+		}
+		return entries, nil
+	}
+
+	err := d.walkKeys(d.prefixPath(prefix), prefix, func(key string, info os.FileInfo) error {
+		entries = append(entries, IndexEntry{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].ModTime.Equal(entries[j].ModTime) {
+			return entries[i].ModTime.Before(entries[j].ModTime)
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+// startRetention calls EnforceRetention every RetentionInterval, until
+// Close.
+func (d *Diskv) startRetention() {
+	if len(d.Retention) <= 0 || d.RetentionInterval <= 0 {
+		return
+	}
+	d.runInBackground("retention", func(stop <-chan struct{}) {
+		for d.sleep(d.RetentionInterval, stop) {
+			d.EnforceRetention() // errors deliberately ignored; retried next time
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package diskv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	for _, index := range []Index{nil, &RichBTreeIndex{}} {
+		opts := Options{
+			BasePath: "test-data",
+			Retention: map[string]Retention{
+				"log-":   {MaxKeys: 2},
+				"cache-": {MaxAge: time.Hour},
+			},
+		}
+		if index != nil {
+			opts.Index, opts.IndexLess = index, strLess
+		}
+		d := New(opts)
+
+		// Files are aged before writing the next, so that the index's
+		// modification times match.
+		for i, key := range []string{"log-1", "log-2", "log-3", "cache-1", "cache-2", "other"} {
+			d.WriteString(key, key)
+			age := time.Now().Add(-time.Duration(10-i) * time.Minute)
+			if key == "cache-1" || key == "other" {
+				age = age.Add(-2 * time.Hour)
+			}
+			os.Chtimes(filepath.Join("test-data", key), age, age)
+			if index != nil {
+				index.(RichIndex).InsertEntry(IndexEntry{Key: key, ModTime: age})
+			}
+		}
+
+		n, err := d.EnforceRetention()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Errorf("index %T: want 2 keys erased, have %d", index, n)
+		}
+		checkKeys(t, d.Keys(nil), map[string]string{"log-2": "", "log-3": "", "cache-2": "", "other": ""})
+		d.EraseAll()
+	}
+}
+
+func TestRetentionInterval(t *testing.T) {
+	d := New(Options{
+		BasePath:          "test-data",
+		Retention:         map[string]Retention{"": {MaxKeys: 1}},
+		RetentionInterval: 10 * time.Millisecond,
+	})
+	defer d.EraseAll()
+
+	d.WriteString("a", "1")
+	d.WriteString("b", "2")
+	old := time.Now().Add(-time.Minute)
+	os.Chtimes(filepath.Join("test-data", "a"), old, old)
+
+	time.Sleep(50 * time.Millisecond)
+	d.Close()
+	checkKeys(t, d.Keys(nil), map[string]string{"b": ""})
+}
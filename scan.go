@@ -0,0 +1,89 @@
+package diskv
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Scan returns a channel that yields every key with the given prefix whose
+// value pred returns true for, in undefined order. It's like reading each
+// key from KeysPrefix, but the values are streamed to pred during the walk,
+// which holds no lock while pred runs, so it doesn't contend with other
+// operations like a Read per key would. Cached values are used, and values
+// read from disk aren't cached. Keys erased during the scan are skipped.
+//
+// Errors reading a value are returned to pred by its reader. If pred
+// returns an error, the scan stops, and the channel is closed. As with
+// Keys, a consumer which stops reading before the channel is closed must
+// close cancel. It's authorized as OpRead of the prefix.
+func (d *Diskv) Scan(prefix string, pred func(key string, r io.Reader) (bool, error), cancel <-chan struct{}) (<-chan string, error) {
+	prefix = d.normalizeKey(prefix)
+	if err := d.authorize(OpRead, prefix); err != nil {
+		return nil, err
+	}
+
+	span := d.startSpan("Scan", prefix)
+	c := make(chan string, d.KeysBuffer)
+	goLabeled("scan", func() {
+		var (
+			n    = 0
+			send = d.sender(c, cancel, &n)
+		)
+		err := d.walkKeys(d.prefixPath(prefix), prefix, func(key string, info os.FileInfo) error {
+			ok, err := d.scanValue(key, pred)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+			return send(key, info)
+		})
+		close(c)
+		span.SetAttribute("keys", n)
+		span.End(err)
+	})
+	return c, nil
+}
+
+// scanValue calls pred with a reader of the key's value. It's not an error,
+// and pred isn't called, if the key no longer exists.
+func (d *Diskv) scanValue(key string, pred func(key string, r io.Reader) (bool, error)) (bool, error) {
+	if val, ok := d.pendingValue(key); ok {
+		return pred(key, bytes.NewReader(val))
+	}
+
+	d.checkTombstones()
+	d.mu.RLock()
+	var (
+		rc  io.ReadCloser
+		err error
+	)
+	if val, ok := d.cacheLookup(key); ok {
+		rc = ioutil.NopCloser(bytes.NewReader(val))
+		if d.Compression != nil {
+			rc, err = d.Compression.Reader(bytes.NewReader(val))
+		}
+	} else {
+		rc, err = d.readWithRLock(d.transform(key), ReadOptions{NoFill: true})
+	}
+	d.mu.RUnlock()
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		rc = ioutil.NopCloser(&errReader{err})
+	}
+	defer rc.Close()
+
+	return pred(key, rc)
+}
+
+// errReader fails every read with err.
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,72 @@
+package diskv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	d, err := NewWithOptions("test-data", WithCacheSizeMax(1024), WithPack(8, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+
+	want := map[string]string{}
+	for i := 0; i < 20; i++ {
+		key, val := fmt.Sprintf("key%02d", i), fmt.Sprintf("value %d", i)
+		if i%3 == 0 {
+			val += " needle"
+			want[key] = val
+		}
+		if err := d.WriteString(key, val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.WriteString("other", "needle"); err != nil {
+		t.Fatal(err)
+	}
+	d.Read("key03") // cached
+
+	grep := func(key string, r io.Reader) (bool, error) {
+		val, err := ioutil.ReadAll(r)
+		return bytes.Contains(val, []byte("needle")), err
+	}
+	c, err := d.Scan("key", grep, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, c, want)
+
+	// Predicates needn't read the whole value.
+	c, err = d.Scan("", func(key string, r io.Reader) (bool, error) {
+		b := make([]byte, 1)
+		_, err := r.Read(b)
+		return b[0] == 'n', err
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, c, map[string]string{"other": "needle"})
+
+	// An error stops the scan.
+	errStop := errors.New("stop")
+	calls := 0
+	c, err = d.Scan("", func(key string, r io.Reader) (bool, error) {
+		calls++
+		return true, errStop
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range c {
+		t.Fatal("key yielded despite the error")
+	}
+	if calls != 1 {
+		t.Fatalf("pred called %d times after its error", calls)
+	}
+}
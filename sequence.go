@@ -0,0 +1,105 @@
+package diskv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// seqDirname is the name of the directory, directly in the BasePath, which
+// holds a file per sequence of NextSequence, with its last value.
+const seqDirname = ".diskv-sequences"
+
+// NextSequence increments the named sequence, and returns its new value,
+// starting from 1. Sequences let applications generate ordered, unique keys
+// without coordinating with anything else. Each is persisted to a file of
+// its own, which is synced, and then atomically replaced, before the value
+// is returned, so a value is never returned twice, even across a crash,
+// though one which failed to be returned may be skipped.
+//
+// Names must be non-empty, must not start with '.', and must not contain a
+// path separator. Sequences are kept by Clear; EraseAll removes them, but
+// within the process they carry on from where they were.
+func (d *Diskv) NextSequence(name string) (uint64, error) {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return 0, fmt.Errorf("sequence %q: invalid name", name)
+	}
+
+	d.seqMu.Lock()
+	defer d.seqMu.Unlock()
+
+	last, ok := d.seqs[name]
+	if !ok {
+		var err error
+		if last, err = d.loadSequence(name); err != nil {
+			return 0, fmt.Errorf("sequence %q: %s", name, err)
+		}
+	}
+	if last == ^uint64(0) {
+		return 0, fmt.Errorf("sequence %q: exhausted", name)
+	}
+	next := last + 1
+	if err := d.saveSequence(name, next); err != nil {
+		return 0, fmt.Errorf("sequence %q: %s", name, err)
+	}
+	if d.seqs == nil {
+		d.seqs = map[string]uint64{}
+	}
+	d.seqs[name] = next
+	return next, nil
+}
+
+// loadSequence returns the last value of the named sequence, as persisted,
+// or 0 if it's never been incremented. Callers must hold d.seqMu.
+func (d *Diskv) loadSequence(name string) (uint64, error) {
+	buf, err := readFile(d.fs, filepath.Join(d.BasePath, seqDirname, name))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+	if err != nil {
+		return 0, errors.New("corrupt sequence file")
+	}
+	return n, nil
+}
+
+// saveSequence durably replaces the last value of the named sequence: the
+// value is synced before it's renamed into place, and the directory after,
+// so that the sequence can't go back after a crash. Callers must hold
+// d.seqMu.
+func (d *Diskv) saveSequence(name string, n uint64) error {
+	dir := filepath.Join(d.BasePath, seqDirname)
+	if _, err := d.fs.Stat(dir); os.IsNotExist(err) {
+		if err := d.fs.MkdirAll(dir, d.PathPerm); err != nil {
+			return err
+		}
+		if err := syncDir(d.fs, d.BasePath); err != nil {
+			return err
+		}
+	}
+	tmp := filepath.Join(dir, "."+name+".tmp")
+	f, err := d.fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, d.FilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write([]byte(strconv.FormatUint(n, 10) + "\n")); err != nil {
+		f.Close() // error deliberately ignored
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close() // error deliberately ignored
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := d.fs.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return syncDir(d.fs, dir)
+}
@@ -0,0 +1,138 @@
+package diskv
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestNextSequence(t *testing.T) {
+	d := New(Options{BasePath: "test-data"})
+	defer d.EraseAll()
+
+	for want := uint64(1); want <= 3; want++ {
+		n, err := d.NextSequence("orders")
+		if err != nil {
+			t.Fatalf("NextSequence: %s", err)
+		}
+		if n != want {
+			t.Fatalf("NextSequence: want %d, have %d", want, n)
+		}
+	}
+	if n, err := d.NextSequence("users"); err != nil || n != 1 {
+		t.Fatalf("NextSequence users: want 1, have %d, %v", n, err)
+	}
+
+	// Sequences survive reopening, and Clear, and aren't keys.
+	if err := d.Write("k", []byte("v")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := d.Clear(); err != nil {
+		t.Fatalf("Clear: %s", err)
+	}
+	checkKeys(t, d.Keys(nil), map[string]string{})
+	d2 := New(Options{BasePath: "test-data"})
+	if n, err := d2.NextSequence("orders"); err != nil || n != 4 {
+		t.Fatalf("NextSequence after reopening: want 4, have %d, %v", n, err)
+	}
+
+	for _, name := range []string{"", ".hidden", "a/b"} {
+		if _, err := d.NextSequence(name); err == nil {
+			t.Errorf("NextSequence(%q): want error", name)
+		}
+	}
+}
+
+func TestNextSequenceConcurrent(t *testing.T) {
+	d := NewMem(Options{})
+
+	const goroutines, each = 8, 25
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = map[uint64]bool{}
+	)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < each; j++ {
+				n, err := d.NextSequence("ids")
+				if err != nil {
+					t.Errorf("NextSequence: %s", err)
+					return
+				}
+				mu.Lock()
+				if seen[n] {
+					t.Errorf("NextSequence: %d returned twice", n)
+				}
+				seen[n] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != goroutines*each {
+		t.Fatalf("NextSequence: want %d values, have %d", goroutines*each, len(seen))
+	}
+}
+
+// dirSyncFS records the directories that are synced.
+type dirSyncFS struct {
+	FileSystem
+	mu     sync.Mutex
+	synced []string
+}
+
+type dirSyncFile struct {
+	File
+	fs   *dirSyncFS
+	name string
+}
+
+func (fs *dirSyncFS) Open(name string) (File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return f, err
+	}
+	return &dirSyncFile{File: f, fs: fs, name: name}, nil
+}
+
+func (f *dirSyncFile) Sync() error {
+	if fi, err := f.Stat(); err == nil && fi.IsDir() {
+		f.fs.mu.Lock()
+		f.fs.synced = append(f.fs.synced, filepath.Clean(f.name))
+		f.fs.mu.Unlock()
+	}
+	return f.File.Sync()
+}
+
+func TestNextSequenceSyncsDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("directories aren't synced on Windows")
+	}
+	fs := &dirSyncFS{FileSystem: NewMemFileSystem()}
+	d := New(Options{BasePath: "/seq", FileSystem: fs})
+	for i := 0; i < 2; i++ {
+		if _, err := d.NextSequence("orders"); err != nil {
+			t.Fatalf("NextSequence: %s", err)
+		}
+	}
+	// The base path once, when the sequence directory is created, and the
+	// sequence directory after every rename.
+	dir := filepath.Join("/seq", seqDirname)
+	want := []string{filepath.Clean("/seq"), dir, dir}
+	if len(fs.synced) != len(want) {
+		t.Fatalf("synced directories: want %v, have %v", want, fs.synced)
+	}
+	for i := range want {
+		if fs.synced[i] != want[i] {
+			t.Fatalf("synced directories: want %v, have %v", want, fs.synced)
+		}
+	}
+	if _, err := fs.Stat(filepath.Join(dir, "orders")); os.IsNotExist(err) {
+		t.Fatalf("sequence file missing")
+	}
+}
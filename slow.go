@@ -0,0 +1,104 @@
+package diskv
+
+import (
+	"time"
+)
+
+// SlowOp describes a Read, Write or Erase which took longer than
+// Options.SlowOpThreshold; see Options.OnSlowOp.
+type SlowOp struct {
+	Op       string // "Read", "ReadStream", "Open", "Write" or "Erase"
+	Key      string // hashed, with SlowOpHashKeys
+	Bytes    int64  // read or written, if known
+	Duration time.Duration
+	Err      error
+
+	// Phases breaks Duration down by what the operation was doing, as far
+	// as it's known: "throttle", waiting for ReadThrottle or WriteThrottle;
+	// "lock", waiting for other operations; "mkdir", creating directories;
+	// "open", creating or opening the file; "copy", copying the value;
+	// "sync", syncing the file; "rename", moving the file into place;
+	// "remove", removing the value; and "prune", pruning directories.
+	Phases map[string]time.Duration
+}
+
+// slowOps are the operations whose spans are timed for OnSlowOp.
+var slowOps = map[string]bool{"Read": true, "ReadStream": true, "Open": true, "Write": true, "Erase": true}
+
+// phaseTimer attributes the time an operation spends to its phases. Its
+// methods do nothing on a nil *phaseTimer.
+type phaseTimer struct {
+	last   time.Time
+	phases map[string]time.Duration
+}
+
+// mark attributes the time since the previous mark, or since the operation
+// began, to the phase.
+func (p *phaseTimer) mark(phase string) {
+	if p == nil {
+		return
+	}
+	now := time.Now()
+	p.phases[phase] += now.Sub(p.last)
+	p.last = now
+}
+
+// slowSpan times an operation, and reports it to OnSlowOp if it's slow. It
+// passes everything on to the Tracer's span.
+type slowSpan struct {
+	next  Span
+	d     *Diskv
+	op    string
+	key   string
+	begin time.Time
+	bytes int64
+	timer *phaseTimer
+}
+
+func (d *Diskv) newSlowSpan(next Span, op, key string) *slowSpan {
+	now := time.Now()
+	return &slowSpan{
+		next:  next,
+		d:     d,
+		op:    op,
+		key:   key,
+		begin: now,
+		timer: &phaseTimer{last: now, phases: map[string]time.Duration{}},
+	}
+}
+
+func (s *slowSpan) SetAttribute(name string, value interface{}) {
+	if n, ok := value.(int64); ok && name == "bytes" {
+		s.bytes = n
+	}
+	s.next.SetAttribute(name, value)
+}
+
+func (s *slowSpan) End(err error) {
+	s.next.End(err)
+	took := time.Since(s.begin)
+	if took < s.d.SlowOpThreshold {
+		return
+	}
+	key := s.key
+	if s.d.SlowOpHashKeys {
+		key = hashKey(key)
+	}
+	s.d.OnSlowOp(SlowOp{
+		Op:       s.op,
+		Key:      key,
+		Bytes:    s.bytes,
+		Duration: took,
+		Err:      err,
+		Phases:   s.timer.phases,
+	})
+}
+
+// phases returns the phaseTimer of the span, if it's timed for OnSlowOp,
+// and nil otherwise.
+func phases(span Span) *phaseTimer {
+	if s, ok := span.(*slowSpan); ok {
+		return s.timer
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package diskv
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSlowOps(t *testing.T) {
+	var ops []SlowOp
+	d := New(Options{
+		BasePath:        "test-data",
+		Transform:       blockTransform(2),
+		SlowOpThreshold: time.Nanosecond,
+		OnSlowOp:        func(op SlowOp) { ops = append(ops, op) },
+	})
+	defer d.EraseAll()
+
+	if err := d.WriteWith("abcd", bytes.NewReader([]byte("value")), WriteOptions{Sync: true}); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if _, err := d.Read("abcd"); err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+	if err := d.Erase("abcd"); err != nil {
+		t.Fatalf("Erase: %s", err)
+	}
+	d.Keys(nil) // not reported
+
+	want := []struct {
+		op     string
+		phases []string
+	}{
+		{"Write", []string{"throttle", "lock", "mkdir", "open", "copy", "sync", "rename"}},
+		{"Read", []string{"lock", "open", "copy"}},
+		{"Erase", []string{"lock", "remove", "prune"}},
+	}
+	if len(ops) != len(want) {
+		t.Fatalf("want %d slow ops, have %d: %+v", len(want), len(ops), ops)
+	}
+	for i, w := range want {
+		op := ops[i]
+		if op.Op != w.op || op.Key != "abcd" || op.Duration <= 0 || op.Err != nil {
+			t.Errorf("%s: have %+v", w.op, op)
+		}
+		for _, phase := range w.phases {
+			if _, ok := op.Phases[phase]; !ok {
+				t.Errorf("%s: no %s phase in %v", w.op, phase, op.Phases)
+			}
+		}
+	}
+	if ops[0].Bytes != 5 || ops[1].Bytes != 5 {
+		t.Errorf("bytes: want 5, have %d and %d", ops[0].Bytes, ops[1].Bytes)
+	}
+}
+
+func TestSlowOpsThreshold(t *testing.T) {
+	var ops []SlowOp
+	d := New(Options{
+		BasePath:        "test-data",
+		SlowOpThreshold: time.Hour,
+		OnSlowOp:        func(op SlowOp) { ops = append(ops, op) },
+		SlowOpHashKeys:  true,
+	})
+	defer d.EraseAll()
+
+	if err := d.Write("a", []byte("v")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if len(ops) != 0 {
+		t.Fatalf("want no slow ops, have %+v", ops)
+	}
+
+	d.SlowOpThreshold = time.Nanosecond
+	if _, err := d.Read("a"); err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+	if len(ops) != 1 || ops[0].Key != hashKey("a") {
+		t.Fatalf("want one slow op with a hashed key, have %+v", ops)
+	}
+}
@@ -0,0 +1,268 @@
+package diskv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var errNoSnapshotDir = errors.New("snapshots require Journal and SnapshotDir")
+
+// SnapshotInfo describes a snapshot taken by Snapshot.
+type SnapshotInfo struct {
+	Seq  uint64    // of the last journal entry it includes
+	Time time.Time // when it was taken
+}
+
+// snapshot is the persisted form of a snapshot: the value hash of every key,
+// with the values themselves in the object directory.
+type snapshot struct {
+	SnapshotInfo
+	Keys map[string]string `json:"keys"` // key to hex SHA-256
+}
+
+// Snapshot records the current state of the store in SnapshotDir. As every
+// written value is kept in SnapshotDir too, a snapshot only costs reading
+// the values once, and storing a list of their hashes. Snapshots bound how
+// much of the journal RestoreToTime needs; they must be taken before the
+// journal entries after them are dropped by rotation.
+func (d *Diskv) Snapshot() (SnapshotInfo, error) {
+	if !d.Journal || d.SnapshotDir == "" {
+		return SnapshotInfo{}, errNoSnapshotDir
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, err := d.currentStateWithLock()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	s := snapshot{
+		SnapshotInfo: SnapshotInfo{Seq: d.journalSeq, Time: d.Clock.Now()},
+		Keys:         state,
+	}
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	dir := filepath.Join(d.SnapshotDir, "snapshots")
+	if err := d.fs.MkdirAll(dir, d.PathPerm); err != nil {
+		return SnapshotInfo{}, err
+	}
+	filename := filepath.Join(dir, fmt.Sprintf("%020d", s.Seq))
+	if err := writeFile(d.fs, filename+".tmp", buf, d.FilePerm); err != nil {
+		return SnapshotInfo{}, err
+	}
+	if err := d.fs.Rename(filename+".tmp", filename); err != nil {
+		return SnapshotInfo{}, err
+	}
+	return s.SnapshotInfo, nil
+}
+
+// Snapshots returns the snapshots in SnapshotDir, oldest first.
+func (d *Diskv) Snapshots() ([]SnapshotInfo, error) {
+	if !d.Journal || d.SnapshotDir == "" {
+		return nil, errNoSnapshotDir
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	snapshots, err := d.readSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]SnapshotInfo, len(snapshots))
+	for i, s := range snapshots {
+		infos[i] = s.SnapshotInfo
+	}
+	return infos, nil
+}
+
+// RestoreToTime returns the store to its state at the given time: the keys
+// and values it held then, without TTLs. It starts from the latest snapshot
+// taken at or before t, or from an empty store if the journal goes back to
+// its first entry, and replays the journal up to t. The restore is itself
+// journaled, as a clear followed by writes, so it can be undone.
+func (d *Diskv) RestoreToTime(t time.Time) error {
+	if !d.Journal || d.SnapshotDir == "" {
+		return errNoSnapshotDir
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	snapshots, err := d.readSnapshots()
+	if err != nil {
+		return err
+	}
+	base := snapshot{Keys: map[string]string{}}
+	for _, s := range snapshots {
+		if !s.Time.After(t) {
+			base = s
+		}
+	}
+
+	entries, err := d.tailJournalWithLock(base.Seq)
+	if err != nil {
+		return err
+	}
+	state := base.Keys
+	for _, e := range entries {
+		if e.Time.After(t) {
+			break
+		}
+		switch e.Op {
+		case JournalWrite:
+			state[e.Key] = hex.EncodeToString(e.Hash)
+		case JournalErase:
+			delete(state, e.Key)
+		case JournalClear:
+			state = map[string]string{}
+		}
+	}
+
+	// Check every value is available before touching the store.
+	for key, sum := range state {
+		if _, err := d.fs.Stat(d.objectFilename(sum)); err != nil {
+			return fmt.Errorf("restore %q: %s", key, err)
+		}
+	}
+
+	if err := d.clearWithLock(); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := d.restoreKeyWithLock(key, state[key]); err != nil {
+			return fmt.Errorf("restore %q: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// restoreKeyWithLock writes the object with the given hash to the key.
+// Callers must hold d.mu.
+func (d *Diskv) restoreKeyWithLock(key, sum string) error {
+	f, err := d.fs.Open(d.objectFilename(sum))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.writeStreamWithLock(d.transform(key), f, WriteOptions{})
+}
+
+// currentStateWithLock returns the hash of the value of every key, and
+// ensures the values are in the object directory. Callers must hold d.mu.
+func (d *Diskv) currentStateWithLock() (map[string]string, error) {
+	var keys []string
+	if err := d.walkKeys(d.BasePath, "", func(key string, _ os.FileInfo) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	state := make(map[string]string, len(keys))
+	for _, key := range keys {
+		rc, err := d.readWithRLock(d.transform(key), ReadOptions{NoFill: true, Priority: Background})
+		if err != nil {
+			return nil, err
+		}
+		sum, err := d.storeObject(rc)
+		rc.Close() // error deliberately ignored
+		if err != nil {
+			return nil, err
+		}
+		state[key] = hex.EncodeToString(sum[:])
+	}
+	return state, nil
+}
+
+// storeObject copies the reader into the object directory, and returns its
+// hash.
+func (d *Diskv) storeObject(r io.Reader) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := d.createObjectFile()
+	if err != nil {
+		return sum, err
+	}
+	defer d.discardObjectFile(f)
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(h, f), r); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, d.commitObjectFile(f, sum[:])
+}
+
+// createObjectFile creates a temporary file in the object directory, for a
+// value whose hash isn't known yet.
+func (d *Diskv) createObjectFile() (File, error) {
+	dir := filepath.Join(d.SnapshotDir, "objects")
+	if err := d.fs.MkdirAll(dir, d.PathPerm); err != nil {
+		return nil, err
+	}
+	return d.fs.TempFile(dir, ".tmp")
+}
+
+// commitObjectFile closes the temporary file, and renames it after the hash
+// of its contents.
+func (d *Diskv) commitObjectFile(f File, sum []byte) error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return d.fs.Rename(f.Name(), d.objectFilename(hex.EncodeToString(sum)))
+}
+
+// discardObjectFile removes the temporary file, unless it was committed.
+func (d *Diskv) discardObjectFile(f File) {
+	f.Close()             // error deliberately ignored
+	d.fs.Remove(f.Name()) // error deliberately ignored
+}
+
+func (d *Diskv) objectFilename(sum string) string {
+	return filepath.Join(d.SnapshotDir, "objects", sum)
+}
+
+// readSnapshots returns the snapshots in SnapshotDir, oldest first.
+func (d *Diskv) readSnapshots() ([]snapshot, error) {
+	dir := filepath.Join(d.SnapshotDir, "snapshots")
+	names, err := readDirNames(d.fs, dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []snapshot
+	for _, name := range names {
+		if _, err := strconv.ParseUint(name, 10, 64); err != nil {
+			continue
+		}
+		buf, err := readFile(d.fs, filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var s snapshot
+		if err := json.Unmarshal(buf, &s); err != nil {
+			return nil, fmt.Errorf("snapshot %s: %s", name, err)
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, nil
+}
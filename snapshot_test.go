@@ -0,0 +1,74 @@
+package diskv
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRestoreToTime(t *testing.T) {
+	d, err := NewWithError(Options{
+		BasePath:    "test-data",
+		Transform:   blockTransform(2),
+		Compression: NewZlibCompression(),
+		Journal:     true,
+		SnapshotDir: "test-data-snapshots",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-data-snapshots")
+	defer d.EraseAll()
+
+	mark := func() time.Time {
+		time.Sleep(2 * time.Millisecond)
+		now := time.Now()
+		time.Sleep(2 * time.Millisecond)
+		return now
+	}
+
+	t0 := mark()
+	d.WriteString("ab01cd01", "one")
+	d.WriteString("ab01cd02", "two")
+	if _, err := d.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	d.WriteString("ab01cd03", "three")
+	t1 := mark()
+	d.WriteString("ab01cd01", "uno")
+	d.Erase("ab01cd02")
+	t2 := mark()
+	d.Clear()
+	d.WriteString("xxxxxxxx", "ex")
+
+	for _, tc := range []struct {
+		t    time.Time
+		want map[string]string
+	}{
+		{t1, map[string]string{"ab01cd01": "one", "ab01cd02": "two", "ab01cd03": "three"}},
+		{t2, map[string]string{"ab01cd01": "uno", "ab01cd03": "three"}},
+		{t0, map[string]string{}},
+		{time.Now(), map[string]string{"xxxxxxxx": "ex"}},
+	} {
+		if err := d.RestoreToTime(tc.t); err != nil {
+			t.Fatal(err)
+		}
+		checkKeys(t, d.Keys(nil), tc.want)
+		for k, v := range tc.want {
+			if have := d.ReadString(k); have != v {
+				t.Errorf("%q: want %q, have %q", k, v, have)
+			}
+		}
+	}
+
+	if infos, err := d.Snapshots(); err != nil || len(infos) != 1 || infos[0].Seq != 2 {
+		t.Fatalf("want 1 snapshot at seq 2, have %v, %v", infos, err)
+	}
+
+	if _, err := NewWithError(Options{BasePath: "test-data", SnapshotDir: "test-data/snapshots", Journal: true}); err == nil {
+		t.Fatal("expected error for SnapshotDir inside BasePath")
+	}
+	if _, err := NewWithError(Options{BasePath: "test-data", SnapshotDir: "test-data-snapshots"}); err == nil {
+		t.Fatal("expected error for SnapshotDir without Journal")
+	}
+}
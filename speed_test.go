@@ -2,6 +2,8 @@ package diskv
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"testing"
 )
@@ -149,3 +151,41 @@ func BenchmarkRead__4KB_WithCache(b *testing.B) {
 func BenchmarkRead_10KB_WithCache(b *testing.B) {
 	benchRead(b, 10240, keyCount*4096*2)
 }
+
+func benchReadStream(b *testing.B, size int) {
+	b.StopTimer()
+	d := New(Options{
+		BasePath:     "speed-test",
+		CacheSizeMax: uint64(keyCount * size * 2),
+	})
+	defer d.EraseAll()
+
+	keys := genKeys()
+	value := genValue(size)
+	d.load(keys, value)
+	for _, key := range keys {
+		d.Read(key) // fill the cache
+	}
+	shuffle(keys)
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		rc, err := d.ReadStream(keys[i%len(keys)], false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, rc)
+		rc.Close()
+	}
+	b.StopTimer()
+}
+
+func BenchmarkReadStream__32B_WithCache(b *testing.B) {
+	benchReadStream(b, 32)
+}
+
+func BenchmarkReadStream__4KB_WithCache(b *testing.B) {
+	benchReadStream(b, 4096)
+}
@@ -0,0 +1,80 @@
+package diskv
+
+import (
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the state of a store; see Diskv.Stats.
+type Stats struct {
+	Keys  int   // as counted by StatPrefix
+	Bytes int64 // on disk, after compression, if any
+
+	CacheKeys   int
+	CacheBytes  uint64 // as counted against CacheSizeMax
+	CacheHits   uint64 // reads served from the cache, since the store was created
+	CacheMisses uint64 // reads served from disk, since the store was created
+
+	IndexKeys int // -1 without an Index, or if it has no Len method
+
+	PendingWrites int // values buffered by AsyncWrites
+	PendingBytes  int64
+
+	ExpiringKeys int    // keys written with a TTL, which may have elapsed
+	JournalSeq   uint64 // see Journal
+	Generation   uint64 // see Diskv.Generation
+
+	Compaction  CompactionStats // see PackThreshold
+	ChunkedKeys int             // see ChunkSize
+
+	Tasks []TaskStats // of Maintenance.Tasks, in order
+}
+
+// Stats returns a snapshot of the state of the store in a single call, e.g.
+// for dashboards and debugging. It counts the keys with StatPrefix, which
+// walks the store; everything else is tracked in memory. The figures are
+// gathered one after another, so under concurrent use, they may not quite
+// agree with each other.
+func (d *Diskv) Stats() (Stats, error) {
+	keys, bytes, err := d.statPrefix("")
+	if err != nil {
+		return Stats{}, err
+	}
+	s := Stats{
+		Keys:        keys,
+		Bytes:       bytes,
+		CacheHits:   atomic.LoadUint64(&d.cacheHits),
+		CacheMisses: atomic.LoadUint64(&d.cacheMisses),
+		IndexKeys:   -1,
+		JournalSeq:  d.JournalSeq(),
+		Generation:  d.Generation(),
+		Compaction:  d.CompactionStats(),
+	}
+
+	d.cacheMu.RLock()
+	s.CacheKeys, s.CacheBytes = len(d.cache), d.cacheSize
+	d.cacheMu.RUnlock()
+
+	if l, ok := d.Index.(interface{ Len() int }); ok {
+		s.IndexKeys = l.Len()
+	}
+
+	d.pendingMu.Lock()
+	s.PendingWrites = len(d.pending)
+	for _, p := range d.pending {
+		s.PendingBytes += int64(len(p.val))
+	}
+	d.pendingMu.Unlock()
+
+	d.expiryMu.RLock()
+	s.ExpiringKeys = len(d.expiry)
+	d.expiryMu.RUnlock()
+
+	d.chunkMu.RLock()
+	s.ChunkedKeys = len(d.chunked)
+	d.chunkMu.RUnlock()
+
+	d.tasksMu.Lock()
+	s.Tasks = append([]TaskStats(nil), d.tasks...)
+	d.tasksMu.Unlock()
+	return s, nil
+}
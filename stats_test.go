@@ -0,0 +1,65 @@
+package diskv
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	errTask := errors.New("task failed")
+	d, err := NewWithOptions("test-data",
+		WithCacheSizeMax(1024),
+		WithIndex(&BTreeIndex{}, strLess),
+		WithAsyncWrites(time.Hour, nil),
+		WithMaintenance(Maintenance{Tasks: []MaintenanceTask{
+			{Name: "fail", Interval: time.Millisecond, Run: func(*Diskv) error { return errTask }},
+			{Name: "never"},
+		}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.EraseAll()
+	defer d.Close()
+
+	for _, k := range []string{"a", "b"} {
+		if err := d.WriteStream(k, strings.NewReader("12345"), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.WriteWith("c", strings.NewReader("x"), WriteOptions{TTL: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("pending", []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	d.Read("a") // miss
+	d.Read("a") // hit
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s, err := d.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Tasks[0].Runs == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		if s.Keys != 3 || s.Bytes != 11 || s.IndexKeys != 3 || s.ExpiringKeys != 1 {
+			t.Errorf("unexpected key stats: %+v", s)
+		}
+		if s.CacheKeys != 1 || s.CacheBytes != 5 || s.CacheHits != 1 || s.CacheMisses != 1 {
+			t.Errorf("unexpected cache stats: %+v", s)
+		}
+		if s.PendingWrites != 1 || s.PendingBytes != 3 {
+			t.Errorf("unexpected pending stats: %+v", s)
+		}
+		if len(s.Tasks) != 2 || s.Tasks[0].Name != "fail" || s.Tasks[0].LastError != errTask || s.Tasks[1].Runs != 0 {
+			t.Errorf("unexpected task stats: %+v", s.Tasks)
+		}
+		break
+	}
+}
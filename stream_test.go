@@ -3,6 +3,9 @@ package diskv
 import (
 	"bytes"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -115,3 +118,148 @@ func TestReadStreamDirect(t *testing.T) {
 		t.Errorf("expected %q, got %q", string(val1), string(val))
 	}
 }
+
+func TestReadStreamWith(t *testing.T) {
+	d := New(Options{
+		BasePath:         "test-data",
+		CacheSizeMax:     1024,
+		SynchronousCache: true,
+	})
+	defer d.EraseAll()
+
+	if err := d.Write("a", []byte("a1b2c3")); err != nil {
+		t.Fatal(err)
+	}
+	read := func(opts ReadStreamOptions) {
+		rc, err := d.ReadStreamWith("a", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if val, err := ioutil.ReadAll(rc); err != nil || string(val) != "a1b2c3" {
+			t.Fatalf("%+v: read %q, %v", opts, val, err)
+		}
+	}
+
+	read(ReadStreamOptions{NoFill: true})
+	if d.isCached("a") {
+		t.Fatal("NoFill: cached")
+	}
+	read(ReadStreamOptions{})
+	if !d.isCached("a") {
+		t.Fatal("not cached")
+	}
+	read(ReadStreamOptions{Direct: true, NoFill: true})
+	if d.isCached("a") {
+		t.Fatal("Direct: still cached")
+	}
+}
+
+func TestReadStreamVerifyChecksum(t *testing.T) {
+	d := New(Options{BasePath: "test-data", Merkle: true})
+	defer d.EraseAll()
+
+	if err := d.Write("a", []byte("a1b2c3")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.RootHash(); err != nil { // builds the tree
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(d.BasePath, "a"), []byte("a1b2c4"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, verify := range []bool{false, true} {
+		rc, err := d.ReadStreamWith("a", ReadStreamOptions{VerifyChecksum: verify})
+		if err != nil {
+			t.Fatal(err)
+		}
+		val, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(val) != "a1b2c4" {
+			t.Errorf("verify %v: read %q", verify, val)
+		}
+		if want := map[bool]error{false: nil, true: ErrChecksumMismatch}[verify]; err != want {
+			t.Errorf("verify %v: want %v, have %v", verify, want, err)
+		}
+	}
+}
+
+func TestWriteStreamN(t *testing.T) {
+	d := New(Options{
+		BasePath:     "test-data",
+		Compression:  NewChecksumCompression(nil),
+		MaxValueSize: 8,
+	})
+	defer d.EraseAll()
+
+	n, err := d.WriteStreamN("a", bytes.NewBufferString("a1b2c3"), false)
+	if err != nil || n != 6 {
+		t.Fatalf("WriteStreamN: want 6 bytes, have %d, %v", n, err)
+	}
+	n, err = d.WriteWithN("b", bytes.NewBufferString("a1b2c3d4e5"), WriteOptions{})
+	if err != ErrValueTooLarge || n != 0 {
+		t.Fatalf("WriteWithN: want 0 bytes and %v, have %d, %v", ErrValueTooLarge, n, err)
+	}
+}
+
+func TestReadStreamCachedAllocs(t *testing.T) {
+	d := NewMem(Options{BasePath: "/allocs", CacheSizeMax: 1024})
+	if err := d.Write("k", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Read("k"); err != nil { // fill the cache
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 16)
+	allocs := testing.AllocsPerRun(100, func() {
+		rc, err := d.ReadStream("k", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := rc.Read(buf); string(buf[:n]) != "value" {
+			t.Fatalf("want value, have %q", buf[:n])
+		}
+		rc.Close()
+	})
+	if allocs > 1 {
+		t.Errorf("cache hit: want only the reader's handle allocated, have %v allocations", allocs)
+	}
+}
+
+func TestReadStreamCachedClose(t *testing.T) {
+	d := NewMem(Options{BasePath: "/double-close", CacheSizeMax: 1024})
+	for _, key := range []string{"a", "b"} {
+		if err := d.Write(key, []byte(strings.Repeat(key, 5))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Read(key); err != nil { // fill the cache
+			t.Fatal(err)
+		}
+	}
+
+	ra, err := d.ReadStream("a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ra.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another read may take the pooled reader ra had, but ra can't reach it.
+	rb, err := d.ReadStream("b", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rb.Close()
+	if n, err := ra.Read(make([]byte, 8)); n != 0 || err != os.ErrClosed {
+		t.Errorf("read after Close: want 0, %v, have %d, %v", os.ErrClosed, n, err)
+	}
+	if err := ra.Close(); err != os.ErrClosed {
+		t.Errorf("second Close: want %v, have %v", os.ErrClosed, err)
+	}
+	if val, err := ioutil.ReadAll(rb); err != nil || string(val) != "bbbbb" {
+		t.Errorf("want bbbbb, have %q, %v", val, err)
+	}
+}
@@ -0,0 +1,74 @@
+package diskv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// errUndecodable is reported for files InverseTransform yields no key for.
+var errUndecodable = errors.New("file name can't be inverse-transformed")
+
+// ForeignFile is a file in the store which isn't where the transform would
+// store any key, and Err describes why.
+type ForeignFile struct {
+	Path string
+	Err  error
+}
+
+// CheckFiles walks the whole store, and returns every file which fails the
+// checks of StrictKeys, whether or not it's set. Files matching
+// IgnorePatterns, and diskv's own files, are skipped. Run it after changing
+// the transform of an existing store, to find files it can't account for.
+func (d *Diskv) CheckFiles() ([]ForeignFile, error) {
+	var foreign []ForeignFile
+	err := walk(d.fs, d.BasePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == d.BasePath && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		relPath, _ := filepath.Rel(d.BasePath, path)
+		if relPath == "." {
+			return nil
+		}
+		if d.ignored(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || isInternalFile(relPath) {
+			return nil
+		}
+
+		if err := d.checkKeyPath(d.inverseTransformPath(relPath), path); err != nil {
+			foreign = append(foreign, ForeignFile{Path: path, Err: err})
+		}
+		return nil
+	})
+	return foreign, err
+}
+
+// checkKeyPath returns an error unless the key, as yielded by
+// InverseTransform for the file at path, is stored at path.
+func (d *Diskv) checkKeyPath(key, path string) error {
+	if key == "" {
+		return errUndecodable
+	}
+	if want := d.completeFilename(d.transform(key)); filepath.Clean(want) != filepath.Clean(path) {
+		return fmt.Errorf("key %q belongs at %s", key, want)
+	}
+	return nil
+}
+
+// foreignFile reports a file which failed the checks of StrictKeys to the
+// ForeignFileHandler, if one is set.
+func (d *Diskv) foreignFile(path string, err error) {
+	if d.ForeignFileHandler != nil {
+		d.ForeignFileHandler(path, err)
+	}
+}
@@ -0,0 +1,64 @@
+package diskv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestStrictKeys(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		reported []string
+	)
+	d := New(Options{
+		BasePath:       "test-data",
+		NamedTransform: HashPathTransform("sha1", 1, 2),
+		StrictKeys:     true,
+		ForeignFileHandler: func(path string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			rel, _ := filepath.Rel("test-data", path)
+			reported = append(reported, rel)
+		},
+		IgnorePatterns: []string{"*.ignored"},
+	})
+	defer d.EraseAll()
+
+	for k, v := range keysTestData {
+		d.Write(k, []byte(v))
+	}
+	foreign := []string{
+		"not-base64!",                       // undecodable
+		filepath.Join("00", "YWJj"),         // "abc", in the wrong directory
+		filepath.Join("zz", "x", "YWJj"),    // too deep
+		filepath.Join("00", "file.ignored"), // ignored, not reported
+	}
+	for _, name := range foreign {
+		filename := filepath.Join(d.BasePath, name)
+		os.MkdirAll(filepath.Dir(filename), 0777)
+		if err := ioutil.WriteFile(filename, []byte("foreign"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkKeys(t, d.Keys(nil), keysTestData)
+	sort.Strings(reported)
+	want := foreign[:3]
+	sort.Strings(want)
+	if !reflect.DeepEqual(want, reported) {
+		t.Fatalf("expected %v reported, got %v", want, reported)
+	}
+
+	checked, err := d.CheckFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checked) != 3 {
+		t.Fatalf("expected 3 foreign files, got %v", checked)
+	}
+}
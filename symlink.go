@@ -0,0 +1,73 @@
+package diskv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// SymlinkPolicy determines how symbolic links below BasePath are treated.
+// BasePath itself may always be a symbolic link.
+type SymlinkPolicy int
+
+const (
+	// FollowSymlinks, the default, reads through symbolic links. Keys
+	// yields a symbolic link to a file as a key, but never descends into a
+	// symbolically linked directory, so nothing is counted twice.
+	FollowSymlinks SymlinkPolicy = iota
+
+	// SkipSymlinks treats symbolic links, and everything reached through
+	// them, as nonexistent, so nothing outside BasePath can be read.
+	SkipSymlinks
+
+	// RejectSymlinks makes reads through symbolic links fail with
+	// ErrSymlink, and walks which find one stop with ErrSymlink.
+	RejectSymlinks
+)
+
+// ErrSymlink is returned with RejectSymlinks for keys stored at or below a
+// symbolic link.
+var ErrSymlink = errors.New("symbolic link in key path")
+
+// checkSymlinks returns an error if the Symlinks policy forbids reading the
+// given key, because its file or one of its directories is a symbolic link.
+// Other errors are left for the read itself to find.
+func (d *Diskv) checkSymlinks(pathKey *PathKey) error {
+	if d.Symlinks == FollowSymlinks {
+		return nil
+	}
+
+	parts := append(append([]string{d.BasePath}, pathKey.Path...), pathKey.FileName+d.FileSuffix)
+	for i := 2; i <= len(parts); i++ {
+		fi, err := d.fs.Lstat(filepath.Join(parts[:i]...))
+		if err != nil {
+			return nil
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return d.symlinkError(filepath.Join(parts...))
+		}
+	}
+	return nil
+}
+
+// symlinkError returns the error for a symbolic link found at or above the
+// given path, according to the Symlinks policy.
+func (d *Diskv) symlinkError(path string) error {
+	if d.Symlinks == RejectSymlinks {
+		return ErrSymlink
+	}
+	return &os.PathError{Op: "read", Path: path, Err: os.ErrNotExist}
+}
+
+// walkSymlink decides what a walk does with the symbolic link at path: it
+// returns skip if the link isn't a key, or an error to stop the walk.
+func (d *Diskv) walkSymlink(path string) (skip bool, err error) {
+	switch d.Symlinks {
+	case SkipSymlinks:
+		return true, nil
+	case RejectSymlinks:
+		return true, ErrSymlink
+	}
+	fi, err := d.fs.Stat(path)
+	return err != nil || fi.IsDir(), nil // dangling, or a directory
+}
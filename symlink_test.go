@@ -0,0 +1,80 @@
+package diskv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSymlinks(t *testing.T) {
+	outside, err := filepath.Abs("test-data-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	os.MkdirAll(outside, 0777)
+	for _, name := range []string{"secret", "inner"} {
+		if err := ioutil.WriteFile(filepath.Join(outside, name), []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Keys starting with "in" are stored in the directory "dir".
+	transform := func(s string) []string {
+		if strings.HasPrefix(s, "in") {
+			return []string{"dir"}
+		}
+		return []string{}
+	}
+	setup := func(policy SymlinkPolicy) *Diskv {
+		d := New(Options{
+			BasePath:  "test-data",
+			Transform: transform,
+			Symlinks:  policy,
+		})
+		d.EraseAll()
+		d.WriteString("a", "1")
+		for link, target := range map[string]string{
+			"secret":   filepath.Join(outside, "secret"),
+			"dir":      outside,
+			"dangling": "missing",
+		} {
+			if err := os.Symlink(target, filepath.Join("test-data", link)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return d
+	}
+
+	d := setup(FollowSymlinks)
+	checkKeys(t, d.Keys(nil), map[string]string{"a": "1", "secret": "secret"})
+	for _, key := range []string{"secret", "inner"} {
+		if got := d.ReadString(key); got != key {
+			t.Errorf("FollowSymlinks: %s: expected %q, got %q", key, key, got)
+		}
+	}
+
+	d = setup(SkipSymlinks)
+	checkKeys(t, d.Keys(nil), map[string]string{"a": "1"})
+	for _, key := range []string{"secret", "inner"} {
+		if _, err := d.Read(key); !os.IsNotExist(err) {
+			t.Errorf("SkipSymlinks: %s: expected not-exist error, got %v", key, err)
+		}
+		if d.Has(key) {
+			t.Errorf("SkipSymlinks: %s: Has reported true", key)
+		}
+	}
+
+	d = setup(RejectSymlinks)
+	for _, key := range []string{"secret", "inner"} {
+		if _, err := d.Read(key); err != ErrSymlink {
+			t.Errorf("RejectSymlinks: %s: expected ErrSymlink, got %v", key, err)
+		}
+	}
+	if _, _, err := d.StatPrefix(""); err != ErrSymlink {
+		t.Errorf("RejectSymlinks: StatPrefix: expected ErrSymlink, got %v", err)
+	}
+	d.EraseAll()
+}
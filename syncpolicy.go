@@ -0,0 +1,286 @@
+package diskv
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// SyncMode is how writes with WriteOptions.Sync are made durable; see
+// SyncPolicy.
+type SyncMode int
+
+const (
+	// SyncEach syncs each write's file before the write returns, while it
+	// holds the store's lock. It's the default.
+	SyncEach SyncMode = iota
+
+	// SyncGroup defers syncing until the write has released the store's
+	// lock. Writes then wait for a group commit, which syncs the files of
+	// every write waiting for it; while one group commits, the next one
+	// forms. A write still doesn't return until its file is synced, but a
+	// crash may leave its value truncated, rather than as it was before.
+	SyncGroup
+
+	// SyncNone doesn't sync writes at all, except for one in every
+	// syncProbeEvery, to keep measuring the device.
+	SyncNone
+)
+
+func (m SyncMode) String() string {
+	switch m {
+	case SyncEach:
+		return "each"
+	case SyncGroup:
+		return "group"
+	case SyncNone:
+		return "none"
+	}
+	return "unknown"
+}
+
+// SyncPolicy adapts how writes with WriteOptions.Sync are made durable to the
+// observed latency of syncs, to keep their p99 near a target. Packed and
+// chunked values, and other writes which don't go through Write or
+// WriteWith, are always synced as usual. The zero value is disabled: every
+// write is synced as in SyncEach.
+type SyncPolicy struct {
+	// If TargetP99 is positive, the policy is enabled. The latency of every
+	// sync is recorded in a histogram, and while its p99 exceeds
+	// TargetP99, writes use SyncGroup; once it falls below half the
+	// target, they're back to SyncEach.
+	TargetP99 time.Duration
+
+	// If NoSyncAbove is positive, and the p99 exceeds it, writes use
+	// SyncNone, until it falls below half of it. Zero never gives up on
+	// durability.
+	NoSyncAbove time.Duration
+
+	// Samples is roughly how many of the latest syncs the histogram
+	// reflects (default 256); older ones decay. The mode is reconsidered
+	// at most every Samples/8 syncs, so that it doesn't flap.
+	Samples int
+
+	// If OnDecision is set, it's called synchronously whenever the mode
+	// changes, possibly while the store's lock is held, so it should be
+	// quick, and mustn't use the store.
+	OnDecision func(SyncDecision)
+}
+
+// SyncDecision is a choice of SyncMode made by a SyncPolicy.
+type SyncDecision struct {
+	Time     time.Time // by Options.Clock
+	From, To SyncMode
+	P99      time.Duration // of the syncs recorded, to within a factor of two
+	Samples  int           // recorded, after decay
+}
+
+const (
+	defaultSyncSamples = 256
+	syncProbeEvery     = 16
+	syncBuckets        = 32 // of powers of two microseconds
+)
+
+// syncTuner enforces a SyncPolicy. A nil *syncTuner always chooses
+// SyncEach.
+type syncTuner struct {
+	policy SyncPolicy
+	clock  Clock
+
+	mu       sync.Mutex
+	hist     [syncBuckets]float64
+	total    float64
+	since    int // samples since the last decision
+	writes   int // in SyncNone, for probes
+	decision SyncDecision
+
+	groupMu    sync.Mutex
+	groupCond  *sync.Cond
+	pending    *syncGroup // forming
+	committing bool
+}
+
+// syncGroup is the files of the writes waiting for one group commit.
+type syncGroup struct {
+	paths     []string
+	committed bool
+	err       error
+}
+
+func newSyncTuner(p SyncPolicy, clock Clock) *syncTuner {
+	if p.TargetP99 <= 0 {
+		return nil
+	}
+	if p.Samples <= 0 {
+		p.Samples = defaultSyncSamples
+	}
+	t := &syncTuner{
+		policy:   p,
+		clock:    clock,
+		decision: SyncDecision{Time: clock.Now()},
+	}
+	t.groupCond = sync.NewCond(&t.groupMu)
+	return t
+}
+
+// SyncDecision returns the latest decision of the SyncPolicy, or the
+// initial one, from SyncEach to SyncEach, if it hasn't changed the mode yet.
+// Without a SyncPolicy, it returns the zero SyncDecision.
+func (d *Diskv) SyncDecision() SyncDecision {
+	t := d.syncTuner
+	if t == nil {
+		return SyncDecision{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.decision
+}
+
+// mode returns how the next write with WriteOptions.Sync is to be made
+// durable.
+func (t *syncTuner) mode() SyncMode {
+	if t == nil {
+		return SyncEach
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := t.decision.To
+	if m == SyncNone {
+		if t.writes++; t.writes%syncProbeEvery == 0 {
+			return SyncEach
+		}
+	}
+	return m
+}
+
+// sync syncs f, and records how long it took.
+func (t *syncTuner) sync(f File) error {
+	begin := time.Now()
+	err := f.Sync()
+	if t != nil && err == nil {
+		t.observe(time.Since(begin))
+	}
+	return err
+}
+
+// observe records the latency of a sync, and reconsiders the mode.
+func (t *syncTuner) observe(took time.Duration) {
+	i, us := 0, took/time.Microsecond
+	for us > 1 && i < syncBuckets-1 {
+		us >>= 1
+		i++
+	}
+
+	t.mu.Lock()
+	t.hist[i]++
+	t.total++
+	if t.total >= float64(2*t.policy.Samples) {
+		for i := range t.hist {
+			t.hist[i] /= 2
+		}
+		t.total /= 2
+	}
+	t.since++
+	if t.since < t.policy.Samples/8 {
+		t.mu.Unlock()
+		return
+	}
+
+	p99 := t.p99Locked()
+	from := t.decision.To
+	to := from
+	switch {
+	case t.policy.NoSyncAbove > 0 && p99 > t.policy.NoSyncAbove:
+		to = SyncNone
+	case from == SyncEach && p99 > t.policy.TargetP99:
+		to = SyncGroup
+	default:
+		if to == SyncNone && p99 <= t.policy.NoSyncAbove/2 {
+			to = SyncGroup
+		}
+		if to == SyncGroup && p99 <= t.policy.TargetP99/2 {
+			to = SyncEach
+		}
+	}
+	if to == from {
+		t.mu.Unlock()
+		return
+	}
+	t.since = 0
+	t.decision = SyncDecision{
+		Time:    t.clock.Now(),
+		From:    from,
+		To:      to,
+		P99:     p99,
+		Samples: int(t.total),
+	}
+	decision := t.decision
+	t.mu.Unlock()
+
+	if t.policy.OnDecision != nil {
+		t.policy.OnDecision(decision)
+	}
+}
+
+// p99Locked returns the upper bound of the histogram bucket which holds the
+// 99th percentile.
+func (t *syncTuner) p99Locked() time.Duration {
+	want, sum := 0.99*t.total, 0.0
+	for i, n := range t.hist {
+		if sum += n; sum >= want {
+			return time.Duration(2<<uint(i)) * time.Microsecond
+		}
+	}
+	return time.Duration(2<<uint(syncBuckets-1)) * time.Microsecond
+}
+
+// groupSync joins the group commit which will sync the named file, and
+// waits for it. If no group is committing, the caller commits its own. A
+// file which no longer exists, because it was erased or moved since it was
+// written, is skipped. If any sync in the group fails, the whole group
+// fails.
+func (t *syncTuner) groupSync(fs FileSystem, path string) error {
+	t.groupMu.Lock()
+	defer t.groupMu.Unlock()
+	if t.pending == nil {
+		t.pending = &syncGroup{}
+	}
+	g := t.pending
+	g.paths = append(g.paths, path)
+	for t.committing && !g.committed {
+		t.groupCond.Wait()
+	}
+	if g.committed {
+		return g.err
+	}
+
+	t.committing = true
+	t.pending = nil
+	t.groupMu.Unlock()
+	err := t.commit(fs, g.paths)
+	t.groupMu.Lock()
+	g.committed, g.err = true, err
+	t.committing = false
+	t.groupCond.Broadcast()
+	return err
+}
+
+// commit syncs the named files.
+func (t *syncTuner) commit(fs FileSystem, paths []string) error {
+	for _, path := range paths {
+		f, err := fs.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		err = t.sync(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package diskv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowSyncFS makes every sync take delay nanoseconds, and counts them.
+type slowSyncFS struct {
+	FileSystem
+	delay int64 // atomic
+	syncs int64 // atomic
+}
+
+type slowSyncFile struct {
+	File
+	fs *slowSyncFS
+}
+
+func (fs *slowSyncFS) wrap(f File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &slowSyncFile{File: f, fs: fs}, nil
+}
+
+func (fs *slowSyncFS) Open(name string) (File, error) {
+	return fs.wrap(fs.FileSystem.Open(name))
+}
+
+func (fs *slowSyncFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return fs.wrap(fs.FileSystem.OpenFile(name, flag, perm))
+}
+
+func (f *slowSyncFile) Sync() error {
+	atomic.AddInt64(&f.fs.syncs, 1)
+	time.Sleep(time.Duration(atomic.LoadInt64(&f.fs.delay)))
+	return f.File.Sync()
+}
+
+func writeSynced(t *testing.T, d *Diskv, key string) {
+	t.Helper()
+	if err := d.WriteWith(key, bytes.NewReader([]byte(key)), WriteOptions{Sync: true}); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+}
+
+func TestSyncPolicy(t *testing.T) {
+	fs := &slowSyncFS{FileSystem: NewMemFileSystem()}
+	var (
+		mu        sync.Mutex
+		decisions []SyncDecision
+	)
+	d := New(Options{
+		BasePath:   "/sync",
+		FileSystem: fs,
+		SyncPolicy: SyncPolicy{
+			TargetP99: time.Millisecond,
+			Samples:   16,
+			OnDecision: func(dec SyncDecision) {
+				mu.Lock()
+				decisions = append(decisions, dec)
+				mu.Unlock()
+			},
+		},
+	})
+
+	for i := 0; i < 4; i++ {
+		writeSynced(t, d, fmt.Sprintf("fast-%d", i))
+	}
+	if dec := d.SyncDecision(); dec.To != SyncEach {
+		t.Fatalf("fast syncs: want %s, have %+v", SyncEach, dec)
+	}
+
+	atomic.StoreInt64(&fs.delay, int64(5*time.Millisecond))
+	for i := 0; i < 4; i++ {
+		writeSynced(t, d, fmt.Sprintf("slow-%d", i))
+	}
+	dec := d.SyncDecision()
+	if dec.From != SyncEach || dec.To != SyncGroup || dec.P99 <= time.Millisecond {
+		t.Fatalf("slow syncs: want %s to %s, have %+v", SyncEach, SyncGroup, dec)
+	}
+
+	// Group commits still sync every write before it returns.
+	before := atomic.LoadInt64(&fs.syncs)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			writeSynced(t, d, fmt.Sprintf("group-%d", i))
+		}(i)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt64(&fs.syncs) - before; n != 8 {
+		t.Errorf("group commits: want 8 syncs, have %d", n)
+	}
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("group-%d", i)
+		if val, err := d.Read(key); err != nil || string(val) != key {
+			t.Errorf("Read(%s): %q, %v", key, val, err)
+		}
+	}
+
+	atomic.StoreInt64(&fs.delay, 0)
+	for i := 0; i < 1000 && d.SyncDecision().To != SyncEach; i++ {
+		writeSynced(t, d, "recover")
+	}
+	if dec := d.SyncDecision(); dec.From != SyncGroup || dec.To != SyncEach {
+		t.Fatalf("recovered: want %s to %s, have %+v", SyncGroup, SyncEach, dec)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(decisions) != 2 {
+		t.Errorf("OnDecision: want 2 decisions, have %+v", decisions)
+	}
+}
+
+func TestSyncPolicyNoSync(t *testing.T) {
+	fs := &slowSyncFS{FileSystem: NewMemFileSystem()}
+	d := New(Options{
+		BasePath:   "/sync",
+		FileSystem: fs,
+		SyncPolicy: SyncPolicy{
+			TargetP99:   time.Millisecond,
+			NoSyncAbove: 2 * time.Millisecond,
+			Samples:     16,
+		},
+	})
+	atomic.StoreInt64(&fs.delay, int64(5*time.Millisecond))
+	for i := 0; i < 2; i++ {
+		writeSynced(t, d, "slow")
+	}
+	if dec := d.SyncDecision(); dec.To != SyncNone {
+		t.Fatalf("want %s, have %+v", SyncNone, dec)
+	}
+
+	// Only probes are synced.
+	before := atomic.LoadInt64(&fs.syncs)
+	for i := 0; i < syncProbeEvery; i++ {
+		writeSynced(t, d, "unsynced")
+	}
+	if n := atomic.LoadInt64(&fs.syncs) - before; n != 1 {
+		t.Errorf("want 1 probe, have %d syncs", n)
+	}
+}
+
+func TestSyncPolicyDisabled(t *testing.T) {
+	fs := &slowSyncFS{FileSystem: NewMemFileSystem()}
+	d := New(Options{BasePath: "/sync", FileSystem: fs})
+	writeSynced(t, d, "a")
+	if err := d.Write("b", []byte("b")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if n := atomic.LoadInt64(&fs.syncs); n != 1 {
+		t.Errorf("want 1 sync, have %d", n)
+	}
+	if dec := d.SyncDecision(); dec != (SyncDecision{}) {
+		t.Errorf("want no decision, have %+v", dec)
+	}
+}
@@ -0,0 +1,138 @@
+package diskv
+
+import (
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Throttle limits the rate of IO operations, and of the bytes they transfer,
+// with token buckets which allow bursts of up to one second's worth of IO.
+// The zero value is unlimited.
+type Throttle struct {
+	OpsPerSecond   float64 // if positive, the maximum rate of operations
+	BytesPerSecond float64 // if positive, the maximum rate of bytes
+}
+
+// Priority is the class of an operation. Throttles favor Foreground
+// operations over Background ones.
+type Priority int
+
+const (
+	// Foreground operations, e.g. serving requests, are throttled as usual.
+	// It's the default.
+	Foreground Priority = iota
+
+	// Background operations, e.g. bulk jobs, only use the throughput which
+	// Foreground operations leave unused: they wait until the throttle has
+	// tokens to spare, and may starve while Foreground operations use all
+	// of them.
+	Background
+)
+
+// throttle enforces a Throttle. A nil *throttle is unlimited.
+type throttle struct {
+	ops   *tokenBucket
+	bytes *tokenBucket
+}
+
+func newThrottle(t Throttle) *throttle {
+	if t.OpsPerSecond <= 0 && t.BytesPerSecond <= 0 {
+		return nil
+	}
+	return &throttle{
+		ops:   newTokenBucket(t.OpsPerSecond),
+		bytes: newTokenBucket(t.BytesPerSecond),
+	}
+}
+
+// waitOp blocks until another operation with priority p may start.
+func (t *throttle) waitOp(p Priority) {
+	if t != nil {
+		t.ops.wait(1, p)
+	}
+}
+
+// waitBytes blocks until n more bytes may be transferred with priority p.
+func (t *throttle) waitBytes(n int64, p Priority) {
+	if t != nil && n > 0 {
+		t.bytes.wait(float64(n), p)
+	}
+}
+
+// tokenBucket is a token bucket which is refilled at rate tokens per
+// second, up to one second's worth. A nil *tokenBucket is unlimited.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// wait takes n tokens, and blocks until the bucket is no longer in debt.
+// Foreground waiters are served in order, and n may exceed the bucket's
+// capacity. Background waiters first wait until the bucket holds n tokens,
+// or is full, so they never put it into debt on top of Foreground waiters.
+func (b *tokenBucket) wait(n float64, p Priority) {
+	if b == nil {
+		return
+	}
+
+	need := math.Min(n, b.rate)
+	b.mu.Lock()
+	for {
+		b.refillWithLock()
+		if p == Foreground || b.tokens >= need {
+			break
+		}
+		wait := time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		time.Sleep(wait)
+		b.mu.Lock()
+	}
+	b.tokens -= n
+	debt := -b.tokens
+	b.mu.Unlock()
+
+	if debt > 0 {
+		time.Sleep(time.Duration(debt / b.rate * float64(time.Second)))
+	}
+}
+
+// refillWithLock adds the tokens accrued since the last refill. Callers must
+// hold b.mu.
+func (b *tokenBucket) refillWithLock() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// throttledReader reads from r, charging the first Read as an operation,
+// and every Read for the bytes it returns.
+type throttledReader struct {
+	r        io.Reader
+	t        *throttle
+	priority Priority
+	started  bool
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if !tr.started {
+		tr.started = true
+		tr.t.waitOp(tr.priority)
+	}
+	n, err := tr.r.Read(p)
+	tr.t.waitBytes(int64(n), tr.priority)
+	return n, err
+}
@@ -0,0 +1,92 @@
+package diskv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWriteThrottle(t *testing.T) {
+	d := New(Options{
+		BasePath:      "test-data",
+		WriteThrottle: Throttle{OpsPerSecond: 20},
+	})
+	defer d.EraseAll()
+
+	// The first 20 writes are a burst, the next 5 take 50ms each.
+	began := time.Now()
+	for i := 0; i < 25; i++ {
+		if err := d.WriteString("a", "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if took := time.Since(began); took < 200*time.Millisecond {
+		t.Fatalf("25 writes at 20 ops/s took only %s", took)
+	}
+}
+
+func TestReadThrottle(t *testing.T) {
+	d := New(Options{
+		BasePath:     "test-data",
+		CacheSizeMax: 4096,
+		ReadThrottle: Throttle{BytesPerSecond: 1000},
+	})
+	defer d.EraseAll()
+
+	if err := d.Write("a", make([]byte, 1500)); err != nil {
+		t.Fatal(err)
+	}
+
+	began := time.Now()
+	if _, err := d.Read("a"); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(began); took < 400*time.Millisecond {
+		t.Fatalf("reading 1500 bytes at 1000 bytes/s took only %s", took)
+	}
+
+	// The value is now cached, which isn't throttled.
+	began = time.Now()
+	if _, err := d.Read("a"); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(began); took > 100*time.Millisecond {
+		t.Fatalf("cached read took %s", took)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(100)
+	began := time.Now()
+	b.wait(100, Foreground) // burst
+	if took := time.Since(began); took > 50*time.Millisecond {
+		t.Fatalf("burst took %s", took)
+	}
+	b.wait(20, Foreground)
+	if took := time.Since(began); took < 150*time.Millisecond {
+		t.Fatalf("20 tokens in debt at 100/s took only %s", took)
+	}
+	var unlimited *tokenBucket
+	unlimited.wait(1e9, Background)
+}
+
+func TestTokenBucketPriority(t *testing.T) {
+	b := newTokenBucket(100)
+	b.wait(100, Foreground) // drain the burst
+
+	began := time.Now()
+	done := make(chan time.Duration)
+	go func() {
+		b.wait(50, Background)
+		done <- time.Since(began)
+	}()
+	time.Sleep(10 * time.Millisecond) // let the background waiter start
+
+	b.wait(20, Foreground)
+	if took := time.Since(began); took > 400*time.Millisecond {
+		t.Fatalf("foreground waiter took %s", took)
+	}
+	// The background waiter needs the 20 tokens of debt repaid, and 50 more.
+	if took := <-done; took < 600*time.Millisecond {
+		t.Fatalf("background waiter took only %s", took)
+	}
+}
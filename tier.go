@@ -0,0 +1,287 @@
+package diskv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TierPolicy controls when Tiered demotes keys from its hot tier to its cold
+// one. Zero fields impose no limit.
+type TierPolicy struct {
+	// Keys which haven't been used for longer than MaxAge are demoted. A
+	// key is used when it's written, or promoted, or, with TrackAccess set
+	// on the hot tier, read.
+	MaxAge time.Duration
+
+	// While the values in the hot tier take up more than MaxBytes on disk,
+	// its least recently used keys are demoted.
+	MaxBytes int64
+
+	// If Interval is positive, Demote is called every Interval in the
+	// background, until the Tiered is closed.
+	Interval time.Duration
+}
+
+// Tiered is a pair of stores used as one: a hot tier, e.g. on a local NVMe
+// disk, and a cold tier, e.g. on a network mount. Keys are written to the
+// hot tier, promoted back to it when they're read from the cold tier, and
+// demoted to the cold tier by the TierPolicy. Both tiers may be used
+// directly, e.g. for their Stats, but keys should only be written and
+// erased through the Tiered.
+type Tiered struct {
+	Hot, Cold *Diskv
+	Policy    TierPolicy
+
+	// moveMu is held exclusively while a key moves between the tiers, and
+	// shared by writes and erases, so that neither can be lost by a move.
+	moveMu sync.RWMutex
+}
+
+// NewTiered opens the hot and cold tiers with the given Options, which must
+// have different BasePaths, and starts demoting keys every Policy.Interval,
+// if it's set.
+func NewTiered(hot, cold Options, policy TierPolicy) (*Tiered, error) {
+	if filepath.Clean(hot.BasePath) == filepath.Clean(cold.BasePath) {
+		return nil, errors.New("tiers must have different BasePaths")
+	}
+	h, err := NewWithError(hot)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewWithError(cold)
+	if err != nil {
+		h.Close() // error deliberately ignored
+		return nil, err
+	}
+
+	t := &Tiered{Hot: h, Cold: c, Policy: policy}
+	if policy.Interval > 0 {
+		h.runInBackground("tier-demote", func(stop <-chan struct{}) {
+			for h.sleep(policy.Interval, stop) {
+				t.Demote() // errors deliberately ignored; retried next time
+			}
+		})
+	}
+	return t, nil
+}
+
+// Close stops demoting keys in the background, and closes both tiers.
+func (t *Tiered) Close() error {
+	err := t.Hot.Close()
+	if coldErr := t.Cold.Close(); err == nil {
+		err = coldErr
+	}
+	return err
+}
+
+// Write writes the value to the hot tier, and erases any stale copy of the
+// key from the cold tier.
+func (t *Tiered) Write(key string, val []byte) error {
+	return t.WriteWith(key, bytes.NewReader(val), WriteOptions{})
+}
+
+// WriteWith is like Write, but it streams the value from r, as directed by
+// the given WriteOptions.
+func (t *Tiered) WriteWith(key string, r io.Reader, opts WriteOptions) error {
+	t.moveMu.RLock()
+	defer t.moveMu.RUnlock()
+	if err := t.Hot.WriteWith(key, r, opts); err != nil {
+		return err
+	}
+	return t.Cold.EraseQuiet(key)
+}
+
+// Read reads the key from the hot tier, or else from the cold tier, in
+// which case the key is promoted to the hot tier, keeping any TTL. A failed
+// promotion doesn't fail the read; the key stays in the cold tier.
+func (t *Tiered) Read(key string) ([]byte, error) {
+	t.moveMu.RLock()
+	val, err := t.Hot.Read(key)
+	cold := os.IsNotExist(err)
+	if cold {
+		val, err = t.Cold.Read(key)
+	}
+	t.moveMu.RUnlock()
+	if err != nil {
+		return val, err
+	}
+
+	if cold {
+		t.promote(key, val) // error deliberately ignored
+	}
+	return val, nil
+}
+
+// promote moves the key, whose value was just read from the cold tier, to
+// the hot tier, unless it's been written or erased since.
+func (t *Tiered) promote(key string, val []byte) error {
+	t.moveMu.Lock()
+	defer t.moveMu.Unlock()
+	if t.Hot.Has(key) {
+		return nil // written since
+	}
+	ttl, ok := t.Cold.remainingTTL(t.Cold.normalizeKey(key))
+	if !ok {
+		return nil // expired since
+	}
+	cur, err := t.Cold.ReadWith(key, ReadOptions{NoFill: true})
+	if err != nil || !bytes.Equal(cur, val) {
+		return err // erased or rewritten since
+	}
+	if err := t.Hot.WriteWith(key, bytes.NewReader(val), WriteOptions{TTL: ttl}); err != nil {
+		return err
+	}
+	return t.Cold.EraseQuiet(key)
+}
+
+// Erase erases the key from both tiers. It fails with an error satisfying
+// os.IsNotExist only if neither tier has the key.
+func (t *Tiered) Erase(key string) error {
+	t.moveMu.RLock()
+	defer t.moveMu.RUnlock()
+	hotErr := t.Hot.Erase(key)
+	coldErr := t.Cold.Erase(key)
+	switch {
+	case hotErr != nil && !os.IsNotExist(hotErr):
+		return hotErr
+	case coldErr != nil && !os.IsNotExist(coldErr):
+		return coldErr
+	case hotErr != nil && coldErr != nil:
+		return hotErr
+	}
+	return nil
+}
+
+// Has returns true if either tier has the key.
+func (t *Tiered) Has(key string) bool {
+	t.moveMu.RLock()
+	defer t.moveMu.RUnlock()
+	return t.Hot.Has(key) || t.Cold.Has(key)
+}
+
+// KeysPrefix returns a channel that yields every key with the given prefix
+// in either tier once: first those in the hot tier, and then those only in
+// the cold tier. A key which moves between the tiers during the walk may be
+// missed or yielded twice. See Keys about cancel and slow consumers.
+func (t *Tiered) KeysPrefix(prefix string, cancel <-chan struct{}) <-chan string {
+	c := make(chan string, t.Hot.KeysBuffer)
+	goLabeled("tier-keys", func() {
+		defer close(c)
+		send := func(key string) bool {
+			select {
+			case c <- key:
+				return true
+			case <-cancel:
+				return false
+			}
+		}
+
+		inner := make(chan struct{})
+		defer close(inner)
+		for key := range t.Hot.KeysPrefix(prefix, inner) {
+			if !send(key) {
+				return
+			}
+		}
+		for key := range t.Cold.KeysPrefix(prefix, inner) {
+			if t.Hot.Has(key) {
+				continue
+			}
+			if !send(key) {
+				return
+			}
+		}
+	})
+	return c
+}
+
+// Demote moves the keys the TierPolicy doesn't allow in the hot tier to the
+// cold tier, keeping their modification times and TTLs, and returns the
+// number of keys moved. Keys pinned in the hot tier, and keys which are
+// written during a move, stay in the hot tier.
+func (t *Tiered) Demote() (int, error) {
+	if t.Policy.MaxAge <= 0 && t.Policy.MaxBytes <= 0 {
+		return 0, nil
+	}
+
+	type candidate struct {
+		key     string
+		size    int64
+		lastUse time.Time
+	}
+	var (
+		all   []candidate
+		total int64
+	)
+	err := t.Hot.walkKeys(t.Hot.BasePath, "", func(key string, info os.FileInfo) error {
+		total += info.Size()
+		if !t.Hot.pinned(key) {
+			all = append(all, candidate{key, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if t.Hot.TrackAccess {
+		t.Hot.accessMu.Lock()
+		for i, c := range all {
+			if s, ok := t.Hot.access[c.key]; ok && s.LastAccess.After(c.lastUse) {
+				all[i].lastUse = s.LastAccess
+			}
+		}
+		t.Hot.accessMu.Unlock()
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].lastUse.Before(all[j].lastUse) })
+
+	n := 0
+	now := t.Hot.Clock.Now()
+	for _, c := range all {
+		tooOld := t.Policy.MaxAge > 0 && now.Sub(c.lastUse) > t.Policy.MaxAge
+		tooBig := t.Policy.MaxBytes > 0 && total > t.Policy.MaxBytes
+		if !tooOld && !tooBig {
+			break // oldest first, so no later key is either
+		}
+		moved, err := t.demote(c.key)
+		if err != nil {
+			return n, err
+		}
+		if moved {
+			n++
+			total -= c.size
+		}
+	}
+	return n, nil
+}
+
+// demote moves the key from the hot tier to the cold tier, and reports
+// whether it did. It's not an error if the key no longer exists.
+func (t *Tiered) demote(key string) (bool, error) {
+	t.moveMu.Lock()
+	defer t.moveMu.Unlock()
+
+	pathKey := t.Hot.transform(key)
+	t.Hot.mu.RLock()
+	info, err := t.Hot.statWithRLock(pathKey)
+	t.Hot.mu.RUnlock()
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	moved, err := t.Hot.cloneKey(t.Cold, key, info)
+	if err != nil || !moved {
+		return false, err
+	}
+	if err := t.Hot.erase(key); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
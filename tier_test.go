@@ -0,0 +1,103 @@
+package diskv
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTiered(t *testing.T, policy TierPolicy) *Tiered {
+	tiered, err := NewTiered(
+		Options{BasePath: "test-data-hot", TrackAccess: true},
+		Options{BasePath: "test-data-cold"},
+		policy,
+	)
+	if err != nil {
+		t.Fatalf("NewTiered: %s", err)
+	}
+	return tiered
+}
+
+func TestTiered(t *testing.T) {
+	tiered := newTestTiered(t, TierPolicy{MaxBytes: 10})
+	defer tiered.Cold.EraseAll()
+	defer tiered.Hot.EraseAll()
+	defer tiered.Close()
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := tiered.Write(k, []byte("12345")); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond) // distinct modification times
+	}
+
+	n, err := tiered.Demote()
+	if err != nil {
+		t.Fatalf("Demote: %s", err)
+	}
+	if n != 1 {
+		t.Fatalf("Demote: want 1 key demoted, have %d", n)
+	}
+	if tiered.Hot.Has("a") || !tiered.Cold.Has("a") {
+		t.Fatalf("a: want demoted, the least recently used")
+	}
+	checkKeys(t, tiered.KeysPrefix("", nil), map[string]string{"a": "", "b": "", "c": ""})
+
+	// Reading a cold key promotes it.
+	if val, err := tiered.Read("a"); err != nil || string(val) != "12345" {
+		t.Fatalf("Read: have %q, %v", val, err)
+	}
+	if !tiered.Hot.Has("a") || tiered.Cold.Has("a") {
+		t.Fatalf("a: want promoted")
+	}
+
+	// Writing a key replaces any cold copy.
+	if _, err := tiered.Demote(); err != nil {
+		t.Fatalf("Demote: %s", err)
+	}
+	if !tiered.Cold.Has("b") {
+		t.Fatalf("b: want demoted")
+	}
+	if err := tiered.Write("b", []byte("new")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if tiered.Cold.Has("b") {
+		t.Fatalf("b: stale copy left in the cold tier")
+	}
+
+	if err := tiered.Erase("a"); err != nil {
+		t.Fatalf("Erase: %s", err)
+	}
+	if tiered.Has("a") {
+		t.Fatalf("a: want erased")
+	}
+	if err := tiered.Erase("a"); !os.IsNotExist(err) {
+		t.Fatalf("Erase: want not-exist error, have %v", err)
+	}
+}
+
+func TestTieredMaxAge(t *testing.T) {
+	tiered := newTestTiered(t, TierPolicy{MaxAge: 50 * time.Millisecond})
+	defer tiered.Cold.EraseAll()
+	defer tiered.Hot.EraseAll()
+	defer tiered.Close()
+
+	if err := tiered.WriteWith("old", strings.NewReader("v"), WriteOptions{TTL: time.Hour}); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := tiered.Write("new", []byte("v")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+
+	if n, err := tiered.Demote(); err != nil || n != 1 {
+		t.Fatalf("Demote: want 1 key demoted, have %d, %v", n, err)
+	}
+	if !tiered.Cold.Has("old") || !tiered.Hot.Has("new") {
+		t.Fatalf("want old demoted, and new kept")
+	}
+	if ttl, ok := tiered.Cold.remainingTTL("old"); !ok || ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("TTL not kept: %s", ttl)
+	}
+}
@@ -0,0 +1,252 @@
+package diskv
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// timeoutFS is a FileSystem which fails operations, on it and on its files,
+// which take longer than timeout; see IOTimeout. Operations which time out
+// are abandoned rather than canceled: each runs in a goroutine of its own,
+// which lingers until the underlying operation returns. Files opened by
+// abandoned operations are closed, and temporary files removed, when they
+// finally are.
+type timeoutFS struct {
+	fs      FileSystem
+	timeout time.Duration
+}
+
+// newTimeoutFS wraps fs, unless the timeout isn't positive.
+func newTimeoutFS(fs FileSystem, timeout time.Duration) FileSystem {
+	if timeout <= 0 {
+		return fs
+	}
+	return &timeoutFS{fs: fs, timeout: timeout}
+}
+
+// runWithin runs fn, and waits at most timeout for it to return its error.
+// If it times out, the error is ErrIOTimeout in an *os.PathError with op
+// and path, and abandon, if it's set, is called once fn does return, unless
+// fn failed.
+func runWithin(timeout time.Duration, op, path string, fn func() error, abandon func()) error {
+	var (
+		mu      sync.Mutex
+		gaveUp  bool
+		done    = make(chan error, 1)
+		expired = make(chan struct{})
+	)
+	goLabeled("io", func() {
+		err := fn()
+		mu.Lock()
+		defer mu.Unlock()
+		if gaveUp {
+			if err == nil && abandon != nil {
+				abandon()
+			}
+			return
+		}
+		done <- err
+	})
+
+	timer := time.AfterFunc(timeout, func() { close(expired) })
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	select {
+	case err := <-done:
+		return err // just in time
+	default:
+		gaveUp = true
+		return &os.PathError{Op: op, Path: path, Err: ErrIOTimeout}
+	}
+}
+
+// isIOTimeout returns true if the error is a timeout reported by
+// runWithin.
+func isIOTimeout(err error) bool {
+	pe, ok := err.(*os.PathError)
+	return ok && pe.Err == ErrIOTimeout
+}
+
+func (t *timeoutFS) do(op, path string, fn func() error) error {
+	return runWithin(t.timeout, op, path, fn, nil)
+}
+
+func (t *timeoutFS) open(op, name string, open func() (File, error)) (File, error) {
+	var f File
+	err := runWithin(t.timeout, op, name, func() error {
+		var err error
+		f, err = open()
+		return err
+	}, func() {
+		f.Close() // error deliberately ignored
+		if op == "tempfile" {
+			t.fs.Remove(f.Name()) // error deliberately ignored
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newTimeoutFile(f, t.timeout), nil
+}
+
+func (t *timeoutFS) Open(name string) (File, error) {
+	return t.open("open", name, func() (File, error) { return t.fs.Open(name) })
+}
+
+func (t *timeoutFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return t.open("open", name, func() (File, error) { return t.fs.OpenFile(name, flag, perm) })
+}
+
+func (t *timeoutFS) TempFile(dir, pattern string) (File, error) {
+	return t.open("tempfile", dir, func() (File, error) { return t.fs.TempFile(dir, pattern) })
+}
+
+func (t *timeoutFS) Stat(name string) (os.FileInfo, error) {
+	var res os.FileInfo
+	err := t.do("stat", name, func() (err error) { res, err = t.fs.Stat(name); return err })
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (t *timeoutFS) Lstat(name string) (os.FileInfo, error) {
+	var res os.FileInfo
+	err := t.do("lstat", name, func() (err error) { res, err = t.fs.Lstat(name); return err })
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (t *timeoutFS) Remove(name string) error {
+	return t.do("remove", name, func() error { return t.fs.Remove(name) })
+}
+
+func (t *timeoutFS) RemoveAll(path string) error {
+	return t.do("removeall", path, func() error { return t.fs.RemoveAll(path) })
+}
+
+func (t *timeoutFS) Rename(oldpath, newpath string) error {
+	return t.do("rename", oldpath, func() error { return t.fs.Rename(oldpath, newpath) })
+}
+
+func (t *timeoutFS) Mkdir(name string, perm os.FileMode) error {
+	return t.do("mkdir", name, func() error { return t.fs.Mkdir(name, perm) })
+}
+
+func (t *timeoutFS) MkdirAll(path string, perm os.FileMode) error {
+	return t.do("mkdir", path, func() error { return t.fs.MkdirAll(path, perm) })
+}
+
+func (t *timeoutFS) Chmod(name string, mode os.FileMode) error {
+	return t.do("chmod", name, func() error { return t.fs.Chmod(name, mode) })
+}
+
+func (t *timeoutFS) Chtimes(name string, atime, mtime time.Time) error {
+	return t.do("chtimes", name, func() error { return chtimes(t.fs, name, mtime) })
+}
+
+// timeoutFile is a File of a timeoutFS. Reads and writes go through buffers
+// of its own, so that abandoned ones never touch the caller's.
+type timeoutFile struct {
+	f       File
+	timeout time.Duration
+}
+
+// timeoutFileAt is a timeoutFile whose underlying File is also an
+// io.ReaderAt and io.WriterAt, as the built-in FileSystems' are.
+type timeoutFileAt struct {
+	*timeoutFile
+}
+
+func newTimeoutFile(f File, timeout time.Duration) File {
+	tf := &timeoutFile{f: f, timeout: timeout}
+	if _, ok := f.(io.ReaderAt); ok {
+		if _, ok := f.(io.WriterAt); ok {
+			return timeoutFileAt{tf}
+		}
+	}
+	return tf
+}
+
+func (t *timeoutFile) do(op string, fn func() error) error {
+	return runWithin(t.timeout, op, t.f.Name(), fn, nil)
+}
+
+func (t *timeoutFile) Read(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	var n int
+	err := t.do("read", func() (err error) { n, err = t.f.Read(buf); return err })
+	if isIOTimeout(err) {
+		return 0, err
+	}
+	return copy(p, buf[:n]), err
+}
+
+func (t *timeoutFile) Write(p []byte) (int, error) {
+	buf := append([]byte(nil), p...)
+	var n int
+	err := t.do("write", func() (err error) { n, err = t.f.Write(buf); return err })
+	if isIOTimeout(err) {
+		return 0, err
+	}
+	return n, err
+}
+
+func (t *timeoutFile) Close() error {
+	return t.do("close", t.f.Close)
+}
+
+func (t *timeoutFile) Name() string { return t.f.Name() }
+
+func (t *timeoutFile) Stat() (os.FileInfo, error) {
+	var res os.FileInfo
+	err := t.do("stat", func() (err error) { res, err = t.f.Stat(); return err })
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (t *timeoutFile) Sync() error {
+	return t.do("sync", t.f.Sync)
+}
+
+func (t *timeoutFile) Readdirnames(n int) ([]string, error) {
+	var names []string
+	err := t.do("readdirent", func() (err error) { names, err = t.f.Readdirnames(n); return err })
+	if isIOTimeout(err) {
+		return nil, err
+	}
+	return names, err
+}
+
+func (t timeoutFileAt) ReadAt(p []byte, off int64) (int, error) {
+	buf := make([]byte, len(p))
+	var n int
+	err := t.do("read", func() (err error) { n, err = t.f.(io.ReaderAt).ReadAt(buf, off); return err })
+	if isIOTimeout(err) {
+		return 0, err
+	}
+	return copy(p, buf[:n]), err
+}
+
+func (t timeoutFileAt) WriteAt(p []byte, off int64) (int, error) {
+	buf := append([]byte(nil), p...)
+	var n int
+	err := t.do("write", func() (err error) { n, err = t.f.(io.WriterAt).WriteAt(buf, off); return err })
+	if isIOTimeout(err) {
+		return 0, err
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package diskv
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+// hangingFS blocks opening files for reading until hang is closed.
+type hangingFS struct {
+	FileSystem
+	hang chan struct{}
+}
+
+func (fs *hangingFS) Open(name string) (File, error) {
+	<-fs.hang
+	return fs.FileSystem.Open(name)
+}
+
+func TestIOTimeout(t *testing.T) {
+	fs := &hangingFS{FileSystem: NewMemFileSystem(), hang: make(chan struct{})}
+	d := New(Options{
+		BasePath:   "/timeout",
+		FileSystem: fs,
+		IOTimeout:  10 * time.Millisecond,
+	})
+
+	if err := d.Write("a", []byte("1234")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	_, err := d.Read("a")
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != ErrIOTimeout {
+		t.Fatalf("Read: want ErrIOTimeout, have %v", err)
+	}
+
+	// The hung read doesn't hold the lock.
+	if err := d.Write("b", []byte("5678")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+
+	close(fs.hang)
+	val, err := d.Read("a")
+	if err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+	if !bytes.Equal(val, []byte("1234")) {
+		t.Fatalf("Read: want 1234, have %q", val)
+	}
+}
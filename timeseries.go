@@ -0,0 +1,95 @@
+package diskv
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// timestampLayout formats the timestamps of WriteTimestamped keys. It's all
+// digits, fixed-width and UTC, so that the keys sort in time order, and are
+// unaffected by CaseInsensitive.
+const timestampLayout = "20060102150405.000000000"
+
+// TimestampKey returns the key which WriteTimestamped writes for the prefix
+// and time: the prefix followed by the time in UTC, to the nanosecond, such
+// that the keys with the prefix sort in time order.
+func TimestampKey(prefix string, t time.Time) string {
+	return prefix + t.UTC().Format(timestampLayout)
+}
+
+// parseTimestampKey returns the time of a key returned by TimestampKey for
+// the prefix, and whether it is one.
+func parseTimestampKey(prefix, key string) (time.Time, bool) {
+	if !strings.HasPrefix(key, prefix) || len(key)-len(prefix) != len(timestampLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(timestampLayout, key[len(prefix):])
+	return t, err == nil
+}
+
+// WriteTimestamped writes the value to the key for the prefix and time, as
+// returned by TimestampKey, and returns the key. It's for time series: the
+// keys with the prefix can then be listed by KeysBetween, and pruned by
+// PruneTimestamped. A value written with the same prefix and time replaces
+// the previous one. The time's year must be between 0 and 9999.
+func (d *Diskv) WriteTimestamped(prefix string, t time.Time, val []byte) (string, error) {
+	if y := t.UTC().Year(); y < 0 || y > 9999 {
+		return "", fmt.Errorf("timestamp %s out of range", t)
+	}
+	key := TimestampKey(d.normalizeKey(prefix), t)
+	return key, d.Write(key, val)
+}
+
+// KeysBetween is like KeysPrefix, but it yields only the keys written by
+// WriteTimestamped with the prefix and a time in [from, to), in time order if
+// SortedKeys is set. The walk is limited to the keys which share the
+// timestamps' common prefix, so narrow ranges are cheap. It's authorized as
+// OpList of that prefix.
+func (d *Diskv) KeysBetween(prefix string, from, to time.Time, cancel <-chan struct{}) <-chan string {
+	prefix = d.normalizeKey(prefix)
+	lo, hi := TimestampKey(prefix, from), TimestampKey(prefix, to)
+	if lo >= hi {
+		return closedKeys()
+	}
+	common := len(prefix)
+	for common < len(lo) && common < len(hi) && lo[common] == hi[common] {
+		common++
+	}
+	return d.keysMatching(lo[:common], func(key string) bool {
+		_, ok := parseTimestampKey(prefix, key)
+		return ok && key >= lo && key < hi
+	}, cancel)
+}
+
+// PruneTimestamped erases the keys written by WriteTimestamped with the
+// prefix whose times are more than maxAge before now, by the Clock, and
+// returns the number of keys erased. Unlike Retention, it goes by the times
+// in the keys, rather than by modification times. Pinned keys are kept.
+// It's authorized as OpErase of the prefix.
+func (d *Diskv) PruneTimestamped(prefix string, maxAge time.Duration) (int, error) {
+	prefix = d.normalizeKey(prefix)
+	if err := d.authorize(OpErase, prefix); err != nil {
+		return 0, err
+	}
+
+	cutoff := TimestampKey(prefix, d.Clock.Now().Add(-maxAge))
+	var keys []string
+	for key := range d.keysPrefix(prefix, func(key string) bool {
+		_, ok := parseTimestampKey(prefix, key)
+		return ok && key < cutoff && !d.pinned(key)
+	}, nil) {
+		keys = append(keys, key)
+	}
+
+	n := 0
+	for _, key := range keys {
+		if err := d.erase(key); err == nil {
+			n++
+		} else if !os.IsNotExist(err) {
+			return n, err
+		}
+	}
+	return n, nil
+}
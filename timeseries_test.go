@@ -0,0 +1,60 @@
+package diskv
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimestamped(t *testing.T) {
+	clock := newFakeClock()
+	d := New(Options{
+		BasePath:   "test-data",
+		SortedKeys: true,
+		Clock:      clock,
+	})
+	defer d.EraseAll()
+
+	base := clock.Now()
+	var keys []string
+	for i := 0; i < 5; i++ {
+		key, err := d.WriteTimestamped("cpu-", base.Add(time.Duration(i)*time.Hour), []byte{byte(i)})
+		if err != nil {
+			t.Fatalf("WriteTimestamped: %s", err)
+		}
+		keys = append(keys, key)
+	}
+	if want := "cpu-20200101020000.000000000"; keys[2] != want {
+		t.Fatalf("key: want %q, have %q", want, keys[2])
+	}
+	if err := d.Write("cpu-other", []byte("x")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+
+	between := func(from, to time.Time) []string {
+		var have []string
+		for key := range d.KeysBetween("cpu-", from, to, nil) {
+			have = append(have, key)
+		}
+		return have
+	}
+	if have := between(base.Add(time.Hour), base.Add(3*time.Hour)); !reflect.DeepEqual(have, keys[1:3]) {
+		t.Errorf("KeysBetween: want %v, have %v", keys[1:3], have)
+	}
+	if have := between(base.Add(-time.Hour), base.Add(24*time.Hour)); !reflect.DeepEqual(have, keys) {
+		t.Errorf("KeysBetween: want %v, have %v", keys, have)
+	}
+	if have := between(base.Add(time.Hour), base.Add(time.Hour)); len(have) != 0 {
+		t.Errorf("KeysBetween empty range: have %v", have)
+	}
+
+	clock.Advance(4*time.Hour + time.Minute)
+	n, err := d.PruneTimestamped("cpu-", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("PruneTimestamped: %s", err)
+	}
+	if n != 3 {
+		t.Errorf("PruneTimestamped: want 3 erased, have %d", n)
+	}
+	checkKeys(t, d.Keys(nil), map[string]string{keys[3]: "", keys[4]: "", "cpu-other": ""})
+}
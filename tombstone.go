@@ -0,0 +1,214 @@
+package diskv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	tombstonesFilename = ".diskv-tombstones"
+
+	// Once the tombstone file grows beyond tombstonesMaxSize, it's replaced
+	// by an empty one, with a new epoch. Every process then empties its
+	// cache, since it may have missed tombstones in the old one.
+	tombstonesMaxSize = 1 << 20
+
+	tombstoneAll = "*" // the line written by Clear
+)
+
+// tombstones tracks how much of the shared tombstone file this process has
+// read; see Options.SharedTombstones. The file is a line with its epoch,
+// followed by one line per erase: the erased key, quoted, or tombstoneAll.
+type tombstones struct {
+	mu     sync.Mutex
+	epoch  string // of the file when it was last read, or "" if there was none
+	offset int64  // of the first line not yet read
+}
+
+func (d *Diskv) tombstonesPath() string {
+	return filepath.Join(d.BasePath, tombstonesFilename)
+}
+
+// loadTombstones skips the tombstones already in the file when the store is
+// created, since nothing is cached yet.
+func (d *Diskv) loadTombstones() {
+	if !d.SharedTombstones {
+		return
+	}
+	d.tombstones = &tombstones{}
+	epoch, _, _, end, err := d.readTombstones(0)
+	if err == nil {
+		d.tombstones.epoch, d.tombstones.offset = epoch, end
+	}
+}
+
+// tombstoneWithLock appends a line, either a quoted key or tombstoneAll, to
+// the shared tombstone file, creating it if need be, and replaces the file
+// with an empty one if it's grown too large. Callers must hold d.mu.
+func (d *Diskv) tombstoneWithLock(line string) error {
+	if d.tombstones == nil {
+		return nil
+	}
+
+	if err := d.fs.MkdirAll(d.BasePath, d.PathPerm); err != nil {
+		return fmt.Errorf("tombstone: %s", err)
+	}
+	f, err := d.fs.OpenFile(d.tombstonesPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, d.FilePerm)
+	if err != nil {
+		return fmt.Errorf("tombstone: %s", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close() // error deliberately ignored
+		return fmt.Errorf("tombstone: %s", err)
+	}
+	// The epoch is written along with the first tombstone, so that a single
+	// append adds both. If two processes race to create the file, the
+	// second epoch reads as garbage, which empties every cache.
+	buf := line + "\n"
+	if info.Size() == 0 {
+		buf = newTombstoneEpoch() + "\n" + buf
+	}
+	if _, err := f.Write([]byte(buf)); err != nil {
+		f.Close() // error deliberately ignored
+		return fmt.Errorf("tombstone: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("tombstone: %s", err)
+	}
+
+	if info.Size()+int64(len(buf)) > tombstonesMaxSize {
+		tmp, err := d.fs.TempFile(d.BasePath, tombstonesFilename+"-")
+		if err != nil {
+			return fmt.Errorf("tombstone: %s", err)
+		}
+		_, err = tmp.Write([]byte(newTombstoneEpoch() + "\n"))
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = d.fs.Rename(tmp.Name(), d.tombstonesPath())
+		}
+		if err != nil {
+			d.fs.Remove(tmp.Name()) // error deliberately ignored
+			return fmt.Errorf("tombstone: %s", err)
+		}
+	}
+	return nil
+}
+
+func newTombstoneEpoch() string {
+	return fmt.Sprintf("%x-%x", os.Getpid(), time.Now().UnixNano())
+}
+
+// checkTombstones evicts the keys which other processes have erased since
+// it was last called from the cache. It's called before the cache is
+// consulted, and costs a Stat of the tombstone file when nothing has
+// changed. Callers mustn't hold d.mu.
+func (d *Diskv) checkTombstones() {
+	t := d.tombstones
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	var (
+		keys []string
+		all  bool
+	)
+	info, err := d.fs.Stat(d.tombstonesPath())
+	switch {
+	case os.IsNotExist(err):
+		// Removed, e.g. by EraseAll.
+		all = t.epoch != ""
+		t.epoch, t.offset = "", 0
+	case err != nil:
+		// error deliberately ignored; checked again next time
+	case info.Size() != t.offset:
+		offset := t.offset
+		if info.Size() < offset {
+			offset = 0 // truncated, so replaced
+		}
+		var (
+			epoch string
+			end   int64
+		)
+		epoch, keys, all, end, err = d.readTombstones(offset)
+		if err == nil && epoch != t.epoch && offset != 0 {
+			epoch, keys, all, end, err = d.readTombstones(0)
+		}
+		if err != nil {
+			keys, all = nil, false
+			break // checked again next time
+		}
+		if t.epoch != "" && (epoch != t.epoch || offset != t.offset) {
+			// Replaced, so tombstones may have been missed.
+			all = true
+		}
+		t.epoch, t.offset = epoch, end
+	}
+	t.mu.Unlock()
+
+	if !all && len(keys) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if all {
+		d.resetCacheWithLock()
+		return
+	}
+	for _, key := range keys {
+		d.bustCacheWithLock(key)
+	}
+}
+
+// readTombstones reads the tombstone file, and returns its epoch, and the
+// keys in its complete lines from offset on, or whether any of them is
+// tombstoneAll, along with the offset after the last of them. Lines which
+// are neither, like a second epoch, count as tombstoneAll.
+func (d *Diskv) readTombstones(offset int64) (epoch string, keys []string, all bool, end int64, err error) {
+	f, err := d.fs.Open(d.tombstonesPath())
+	if err != nil {
+		return "", nil, false, 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if epoch, err = r.ReadString('\n'); err != nil {
+		return "", nil, false, 0, err // incomplete, so there's nothing to read yet
+	}
+	epoch = epoch[:len(epoch)-1]
+	if start := int64(len(epoch)) + 1; offset < start {
+		offset = start
+	} else if _, err := r.Discard(int(offset - start)); err == io.EOF {
+		return epoch, nil, false, offset, nil // replaced, by a shorter file
+	} else if err != nil {
+		return "", nil, false, 0, err
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", nil, false, 0, err
+	}
+	rest = rest[:bytes.LastIndexByte(rest, '\n')+1]
+
+	for _, line := range bytes.Split(rest, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if key, err := strconv.Unquote(string(line)); err == nil && line[0] == '"' {
+			keys = append(keys, key)
+		} else {
+			all = true
+		}
+	}
+	return epoch, keys, all, offset + int64(len(rest)), nil
+}
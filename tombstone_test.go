@@ -0,0 +1,137 @@
+package diskv
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// TestSharedTombstonesHelper isn't a real test: it's run in a child process
+// by TestSharedTombstones, to erase keys from the store there.
+func TestSharedTombstonesHelper(t *testing.T) {
+	basePath := os.Getenv("DISKV_TOMBSTONES_BASEPATH")
+	if basePath == "" {
+		return
+	}
+	d := New(Options{BasePath: basePath, CacheSizeMax: 1024, SharedTombstones: true})
+	switch key := os.Getenv("DISKV_TOMBSTONES_ERASE"); key {
+	case "":
+		if err := d.Clear(); err != nil {
+			t.Fatalf("Clear: %s", err)
+		}
+	default:
+		if err := d.Erase(key); err != nil {
+			t.Fatalf("Erase: %s", err)
+		}
+	}
+}
+
+// eraseInChild erases the key, or clears the store if it's empty, in a
+// child process.
+func eraseInChild(t *testing.T, basePath, key string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSharedTombstonesHelper$")
+	cmd.Env = append(os.Environ(), "DISKV_TOMBSTONES_BASEPATH="+basePath, "DISKV_TOMBSTONES_ERASE="+key)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("child process: %s\n%s", err, out)
+	}
+}
+
+func TestSharedTombstones(t *testing.T) {
+	opts := Options{BasePath: "test-data", CacheSizeMax: 1024, SynchronousCache: true}
+	plain := New(opts)
+	defer plain.EraseAll()
+	opts.SharedTombstones = true
+	d := New(opts)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := d.Write(key, []byte(key)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		for _, s := range []*Diskv{d, plain} {
+			if _, err := s.Read(key); err != nil {
+				t.Fatalf("Read: %s", err)
+			}
+		}
+	}
+
+	eraseInChild(t, d.BasePath, "a")
+	if _, err := plain.Read("a"); err != nil {
+		t.Errorf("without SharedTombstones: want the stale cached value, have %v", err)
+	}
+	if _, err := d.Read("a"); !os.IsNotExist(err) {
+		t.Errorf("Read(a): want not-exist, have %v", err)
+	}
+	if d.Has("a") {
+		t.Error("Has(a): want false")
+	}
+	if !d.isCached("b") {
+		t.Error("b was evicted, but wasn't erased")
+	}
+
+	eraseInChild(t, d.BasePath, "")
+	for _, key := range []string{"b", "c"} {
+		if d.Has(key) {
+			t.Errorf("Has(%s) after Clear: want false", key)
+		}
+		if _, err := d.Read(key); !os.IsNotExist(err) {
+			t.Errorf("Read(%s) after Clear: want not-exist, have %v", key, err)
+		}
+	}
+
+	// The store's own erases don't evict anything else.
+	if err := d.Write("d", []byte("d")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	d.Read("d") // error deliberately ignored
+	if err := d.Write("e", []byte("e")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := d.Erase("e"); err != nil {
+		t.Fatalf("Erase: %s", err)
+	}
+	d.Has("d")
+	if !d.isCached("d") {
+		t.Error("d was evicted, but wasn't erased")
+	}
+}
+
+func TestSharedTombstonesEraseAll(t *testing.T) {
+	d := New(Options{BasePath: "test-data", CacheSizeMax: 1024, SharedTombstones: true})
+	defer d.EraseAll()
+	if err := d.Write("a", []byte("a")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := d.Erase("a"); err != nil { // creates the tombstone file
+		t.Fatalf("Erase: %s", err)
+	}
+	if err := d.Write("b", []byte("b")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	d.Read("b") // error deliberately ignored
+
+	// As if another process had called EraseAll.
+	other := New(Options{BasePath: "test-data"})
+	if err := other.EraseAll(); err != nil {
+		t.Fatalf("EraseAll: %s", err)
+	}
+	if d.Has("b") {
+		t.Error("Has(b): want false")
+	}
+}
+
+func TestSharedTombstonesZeroCopy(t *testing.T) {
+	d := New(Options{BasePath: "test-data", CacheSizeMax: 1024, ZeroCopyReads: true, SharedTombstones: true})
+	defer d.EraseAll()
+	if err := d.Write("k", []byte("v")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if _, err := d.Read("k"); err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+
+	eraseInChild(t, d.BasePath, "k")
+	if val, err := d.Read("k"); !os.IsNotExist(err) {
+		t.Errorf("Read(k): want not-exist, have %q (%v)", val, err)
+	}
+}
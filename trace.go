@@ -0,0 +1,79 @@
+package diskv
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+)
+
+// Tracer is an interface that Diskv uses to report the IO operations it
+// performs, so that slow operations can show up in e.g. distributed traces.
+// StartSpan is called at the beginning of every Read, Write, Erase and Keys
+// operation, with the operation name and the key (or prefix) involved. The
+// returned Span is ended exactly once, when the operation completes. You may
+// implement Tracer on your own type, typically as a thin adapter to your
+// tracing library of choice.
+type Tracer interface {
+	StartSpan(op, key string) Span
+}
+
+// Span represents a single traced operation. Diskv annotates spans with the
+// attributes "bytes" (int64), "cache_hit" (bool), and "keys" (int), as
+// appropriate for the operation.
+type Span interface {
+	SetAttribute(name string, value interface{})
+	End(err error)
+}
+
+// NewHashingTracer returns a Tracer which replaces keys with a hex-encoded
+// SHA1 hash of the key before passing them to the wrapped Tracer. Use it when
+// keys may contain sensitive data that shouldn't leave the process.
+func NewHashingTracer(t Tracer) Tracer {
+	return &hashingTracer{t}
+}
+
+type hashingTracer struct {
+	next Tracer
+}
+
+func (t *hashingTracer) StartSpan(op, key string) Span {
+	return t.next.StartSpan(op, hashKey(key))
+}
+
+// hashKey returns the hex-encoded SHA1 hash of the key.
+func hashKey(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// nopSpan is used when no Tracer is configured.
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(string, interface{}) {}
+func (nopSpan) End(error)                        {}
+
+// startSpan starts a span for the given operation, or returns a no-op span
+// if no Tracer is configured. With OnSlowOp, the span also times the
+// operation.
+func (d *Diskv) startSpan(op, key string) Span {
+	var span Span = nopSpan{}
+	if d.Tracer != nil {
+		span = d.Tracer.StartSpan(op, key)
+	}
+	if d.SlowOpThreshold > 0 && d.OnSlowOp != nil && slowOps[op] {
+		span = d.newSlowSpan(span, op, key)
+	}
+	return span
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,97 @@
+package diskv
+
+import (
+	"sync"
+	"testing"
+)
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) StartSpan(op, key string) Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &recordingSpan{op: op, key: key, attrs: map[string]interface{}{}}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+func (t *recordingTracer) find(op string) *recordingSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.op == op {
+			return s
+		}
+	}
+	return nil
+}
+
+type recordingSpan struct {
+	mu    sync.Mutex
+	op    string
+	key   string
+	attrs map[string]interface{}
+	ended bool
+	err   error
+}
+
+func (s *recordingSpan) SetAttribute(name string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[name] = value
+}
+
+func (s *recordingSpan) End(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended, s.err = true, err
+}
+
+func TestTracer(t *testing.T) {
+	tr := &recordingTracer{}
+	d := New(Options{
+		BasePath:     "test-data",
+		CacheSizeMax: 1024,
+		Tracer:       tr,
+	})
+	defer d.EraseAll()
+
+	if err := d.Write("a", []byte("123")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Read("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Erase("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := tr.find("Write")
+	if w == nil || !w.ended || w.key != "a" || w.attrs["bytes"] != int64(3) {
+		t.Fatalf("bad Write span: %+v", w)
+	}
+	r := tr.find("Read")
+	if r == nil || !r.ended || r.attrs["bytes"] != int64(3) || r.attrs["cache_hit"] != false {
+		t.Fatalf("bad Read span: %+v", r)
+	}
+	if e := tr.find("Erase"); e == nil || !e.ended || e.err != nil {
+		t.Fatalf("bad Erase span: %+v", e)
+	}
+}
+
+func TestHashingTracer(t *testing.T) {
+	tr := &recordingTracer{}
+	d := New(Options{
+		BasePath: "test-data",
+		Tracer:   NewHashingTracer(tr),
+	})
+	defer d.EraseAll()
+
+	d.Write("secret", []byte("1"))
+	if w := tr.find("Write"); w == nil || w.key == "secret" || len(w.key) != 40 {
+		t.Fatalf("key not hashed: %+v", w)
+	}
+}
@@ -0,0 +1,248 @@
+package diskv
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// NamedTransform is a transform and its inverse, identified by a name that
+// includes its parameters, e.g. "block:2". Because the name fully describes
+// the transform, it can be recorded (see Manifest) and later reconstructed
+// with ParseTransform, e.g. by the diskv command or before a Migrate.
+//
+// To use a NamedTransform, set it as Options.NamedTransform.
+type NamedTransform struct {
+	Name             string
+	Transform        AdvancedTransformFunction
+	InverseTransform InverseTransformFunction
+}
+
+// keyFileNameInverse is the inverse of every built-in transform, as they all
+// use the key itself as the file name.
+func keyFileNameInverse(pathKey *PathKey) string { return pathKey.FileName }
+
+// FlatTransform returns a NamedTransform which stores all files directly in
+// the BasePath. Its name is "flat".
+func FlatTransform() *NamedTransform {
+	return &NamedTransform{
+		Name:             "flat",
+		Transform:        defaultAdvancedTransform,
+		InverseTransform: keyFileNameInverse,
+	}
+}
+
+// BlockTransform returns a NamedTransform which splits the key into blocks
+// of n characters, with each block naming a directory. For example, with
+// n=2, "abcde" is stored in <basedir>/ab/cd/abcde. Its name is "block:<n>".
+func BlockTransform(n int) *NamedTransform {
+	return &NamedTransform{
+		Name: fmt.Sprintf("block:%d", n),
+		Transform: func(key string) *PathKey {
+			path := make([]string, len(key)/n)
+			for i := range path {
+				path[i] = key[i*n : (i+1)*n]
+			}
+			return &PathKey{Path: path, FileName: key}
+		},
+		InverseTransform: keyFileNameInverse,
+	}
+}
+
+// PrefixTransform returns a NamedTransform which uses at most depth
+// directories, each named by the next width characters of the key. For
+// example, with depth=2 and width=1, "abcde" is stored in
+// <basedir>/a/b/abcde. Its name is "prefix:<depth>:<width>".
+func PrefixTransform(depth, width int) *NamedTransform {
+	return &NamedTransform{
+		Name: fmt.Sprintf("prefix:%d:%d", depth, width),
+		Transform: func(key string) *PathKey {
+			path := []string{}
+			for i := 0; i < depth && (i+1)*width <= len(key); i++ {
+				path = append(path, key[i*width:(i+1)*width])
+			}
+			return &PathKey{Path: path, FileName: key}
+		},
+		InverseTransform: keyFileNameInverse,
+	}
+}
+
+// SequentialTransform returns a NamedTransform for monotonically increasing
+// keys, like timestamps or zero-padded sequence numbers. Everything but the
+// last n characters of the key is split into blocks of n characters, aligned
+// to the end of the key, with each block naming a directory. For example,
+// with n=3, "1234567" is stored in <basedir>/1/234/1234567. Consecutive keys
+// then share a directory, which lets writes skip checking that it exists,
+// and no directory has more than 10^n entries for numeric keys. Its name is
+// "sequential:<n>".
+func SequentialTransform(n int) *NamedTransform {
+	return &NamedTransform{
+		Name: fmt.Sprintf("sequential:%d", n),
+		Transform: func(key string) *PathKey {
+			path := []string{}
+			head := len(key) - n
+			if head > 0 {
+				first := head % n
+				if first > 0 {
+					path = append(path, key[:first])
+				}
+				for i := first; i < head; i += n {
+					path = append(path, key[i:i+n])
+				}
+			}
+			return &PathKey{Path: path, FileName: key}
+		},
+		InverseTransform: keyFileNameInverse,
+	}
+}
+
+var hashes = map[string]func() hash.Hash{
+	"fnv":    func() hash.Hash { return fnv.New64a() },
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// HashTransform returns a NamedTransform which hashes the key with the named
+// hash ("fnv", "md5", "sha1" or "sha256"), and uses depth directories, each
+// named by the next two hex characters of the hash. The directory tree has a
+// fixed shape regardless of the shape of the keys. Its name is
+// "hash:<hash>:<depth>". HashTransform panics if the hash is unknown.
+func HashTransform(hashName string, depth int) *NamedTransform {
+	newHash, ok := hashes[hashName]
+	if !ok {
+		panic(fmt.Sprintf("unknown hash %q", hashName))
+	}
+	return &NamedTransform{
+		Name: fmt.Sprintf("hash:%s:%d", hashName, depth),
+		Transform: func(key string) *PathKey {
+			h := newHash()
+			h.Write([]byte(key))
+			sum := hex.EncodeToString(h.Sum(nil))
+			path := make([]string, 0, depth)
+			for i := 0; i < depth && 2*(i+1) <= len(sum); i++ {
+				path = append(path, sum[2*i:2*(i+1)])
+			}
+			return &PathKey{Path: path, FileName: key}
+		},
+		InverseTransform: keyFileNameInverse,
+	}
+}
+
+// HashPathTransform returns a NamedTransform which bounds directory fan-out
+// for arbitrary keys. The key is hashed with the named hash (see
+// HashTransform), and stored depth directories deep, each directory named by
+// the next width hex characters of the hash; so no directory has more than
+// 16^width subdirectories. The file name is the URL-safe base64 encoding of
+// the key, so keys may contain any character, including path separators, and
+// the inverse recovers the original key. Files that aren't validly encoded
+// are skipped by Keys. Its name is "hashpath:<hash>:<depth>:<width>".
+// HashPathTransform panics if the hash is unknown.
+func HashPathTransform(hashName string, depth, width int) *NamedTransform {
+	newHash, ok := hashes[hashName]
+	if !ok {
+		panic(fmt.Sprintf("unknown hash %q", hashName))
+	}
+	return &NamedTransform{
+		Name: fmt.Sprintf("hashpath:%s:%d:%d", hashName, depth, width),
+		Transform: func(key string) *PathKey {
+			h := newHash()
+			h.Write([]byte(key))
+			sum := hex.EncodeToString(h.Sum(nil))
+			path := make([]string, 0, depth)
+			for i := 0; i < depth && width*(i+1) <= len(sum); i++ {
+				path = append(path, sum[width*i:width*(i+1)])
+			}
+			return &PathKey{Path: path, FileName: base64.RawURLEncoding.EncodeToString([]byte(key))}
+		},
+		InverseTransform: func(pathKey *PathKey) string {
+			key, err := base64.RawURLEncoding.DecodeString(pathKey.FileName)
+			if err != nil {
+				return ""
+			}
+			return string(key)
+		},
+	}
+}
+
+// ParseTransform reconstructs a built-in NamedTransform from its name.
+func ParseTransform(name string) (*NamedTransform, error) {
+	fields := strings.Split(name, ":")
+	ints := func(n int) ([]int, error) {
+		if len(fields) != n+1 {
+			return nil, fmt.Errorf("transform %q: expected %d parameters", name, n)
+		}
+		out := make([]int, n)
+		for i := range out {
+			v, err := strconv.Atoi(fields[i+1])
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("transform %q: bad parameter %q", name, fields[i+1])
+			}
+			out[i] = v
+		}
+		return out, nil
+	}
+
+	switch fields[0] {
+	case "flat":
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("transform %q: expected no parameters", name)
+		}
+		return FlatTransform(), nil
+	case "block":
+		p, err := ints(1)
+		if err != nil {
+			return nil, err
+		}
+		return BlockTransform(p[0]), nil
+	case "prefix":
+		p, err := ints(2)
+		if err != nil {
+			return nil, err
+		}
+		return PrefixTransform(p[0], p[1]), nil
+	case "sequential":
+		p, err := ints(1)
+		if err != nil {
+			return nil, err
+		}
+		return SequentialTransform(p[0]), nil
+	case "hash":
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("transform %q: expected 2 parameters", name)
+		}
+		hashName := fields[1]
+		if _, ok := hashes[hashName]; !ok {
+			return nil, fmt.Errorf("transform %q: unknown hash %q", name, hashName)
+		}
+		fields = []string{fields[0], fields[2]}
+		p, err := ints(1)
+		if err != nil {
+			return nil, err
+		}
+		return HashTransform(hashName, p[0]), nil
+	case "hashpath":
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("transform %q: expected 3 parameters", name)
+		}
+		hashName := fields[1]
+		if _, ok := hashes[hashName]; !ok {
+			return nil, fmt.Errorf("transform %q: unknown hash %q", name, hashName)
+		}
+		fields = []string{fields[0], fields[2], fields[3]}
+		p, err := ints(2)
+		if err != nil {
+			return nil, err
+		}
+		return HashPathTransform(hashName, p[0], p[1]), nil
+	default:
+		return nil, fmt.Errorf("unknown transform %q", name)
+	}
+}
@@ -0,0 +1,200 @@
+package diskv
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestNamedTransforms(t *testing.T) {
+	for _, tc := range []struct {
+		transform *NamedTransform
+		name      string
+		key       string
+		path      []string
+	}{
+		{FlatTransform(), "flat", "abcde", []string{}},
+		{BlockTransform(2), "block:2", "abcde", []string{"ab", "cd"}},
+		{PrefixTransform(2, 1), "prefix:2:1", "abcde", []string{"a", "b"}},
+		{PrefixTransform(3, 2), "prefix:3:2", "abc", []string{"ab"}},
+		{HashTransform("md5", 2), "hash:md5:2", "abcde", []string{"ab", "56"}},
+		{SequentialTransform(3), "sequential:3", "1234567", []string{"1", "234"}},
+		{SequentialTransform(3), "sequential:3", "123456", []string{"123"}},
+		{SequentialTransform(3), "sequential:3", "123", []string{}},
+		{SequentialTransform(3), "sequential:3", "12", []string{}},
+	} {
+		if tc.transform.Name != tc.name {
+			t.Errorf("%s: got name %q", tc.name, tc.transform.Name)
+		}
+		parsed, err := ParseTransform(tc.name)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		for _, tr := range []*NamedTransform{tc.transform, parsed} {
+			pk := tr.Transform(tc.key)
+			if !reflect.DeepEqual(pk.Path, tc.path) || pk.FileName != tc.key {
+				t.Errorf("%s: %q => %v/%s, expected %v", tc.name, tc.key, pk.Path, pk.FileName, tc.path)
+			}
+			if got := tr.InverseTransform(pk); got != tc.key {
+				t.Errorf("%s: inverse: expected %q, got %q", tc.name, tc.key, got)
+			}
+		}
+	}
+}
+
+func TestHashPathTransform(t *testing.T) {
+	d := New(Options{
+		BasePath:       "test-data",
+		NamedTransform: HashPathTransform("sha1", 2, 1),
+	})
+	defer d.EraseAll()
+
+	data := map[string]string{
+		"a/b/c":               "1",
+		"../escape":           "2",
+		"plain":               "3",
+		"with spaces & stuff": "4",
+	}
+	for k, v := range data {
+		if err := d.WriteString(k, v); err != nil {
+			t.Fatalf("%s: %s", k, err)
+		}
+	}
+	checkKeys(t, d.Keys(nil), data)
+
+	pk := d.NamedTransform.Transform("a/b/c")
+	if len(pk.Path) != 2 || len(pk.Path[0]) != 1 || len(pk.Path[1]) != 1 {
+		t.Fatalf("unexpected path %v", pk.Path)
+	}
+	if _, err := ParseTransform("hashpath:sha1:2:1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseTransformErrors(t *testing.T) {
+	for _, name := range []string{"", "flat:1", "block", "block:x", "block:0", "prefix:1", "hash:nope:2", "hash:md5", "hashpath:sha1:2", "bogus"} {
+		if _, err := ParseTransform(name); err == nil {
+			t.Errorf("%q: expected error", name)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	d := New(Options{
+		BasePath:       "test-data",
+		NamedTransform: BlockTransform(2),
+	})
+	defer d.EraseAll()
+
+	if err := d.WriteManifest(); err != nil {
+		t.Fatal(err)
+	}
+	d.Write("abcd", []byte("1"))
+
+	m, err := ReadManifest(d.BasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Transform != "block:2" {
+		t.Fatalf("expected transform block:2, got %q", m.Transform)
+	}
+	checkKeys(t, d.Keys(nil), map[string]string{"abcd": "1"})
+
+	if err := d.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadManifest(d.BasePath); err != nil {
+		t.Fatalf("manifest removed by Clear: %s", err)
+	}
+}
+
+func TestManifestLayout(t *testing.T) {
+	src := New(Options{BasePath: "test-data", NamedTransform: SequentialTransform(1)})
+	defer src.EraseAll()
+	if err := src.WriteString("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.WriteString("ab", "2"); err != ErrKeyPathConflict {
+		t.Fatalf("expected ErrKeyPathConflict, got %v", err)
+	}
+
+	dst, err := NewWithError(Options{
+		BasePath:       "test-data-2",
+		NamedTransform: SequentialTransform(1),
+		FileSuffix:     DataFileSuffix,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.EraseAll()
+	if _, err := Migrate(src, dst, BulkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.WriteManifest(); err != nil {
+		t.Fatal(err)
+	}
+	// With the suffix, "ab" can live alongside "a", in the directory "a".
+	if err := dst.WriteString("ab", "2"); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, dst.Keys(nil), map[string]string{"a": "1", "ab": "2"})
+
+	m, err := ReadManifest(dst.BasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != LayoutSuffixed || m.FileSuffix != DataFileSuffix {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	if _, err := NewWithError(Options{BasePath: dst.BasePath, NamedTransform: SequentialTransform(1)}); err == nil {
+		t.Fatal("expected an error opening without the manifest's FileSuffix")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dst.BasePath, ManifestFilename), []byte(`{"version":99}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadManifest(dst.BasePath); err == nil {
+		t.Fatal("expected an error for an unsupported layout version")
+	}
+}
+
+func TestSequentialWrites(t *testing.T) {
+	d := New(Options{
+		BasePath:       "test-data",
+		NamedTransform: SequentialTransform(2),
+	})
+	defer d.EraseAll()
+
+	for i := 1000; i < 1300; i++ {
+		key := strconv.Itoa(i)
+		if err := d.WriteString(key, key); err != nil {
+			t.Fatalf("%s: %s", key, err)
+		}
+	}
+	if got := d.ReadString("1234"); got != "1234" {
+		t.Fatalf("expected 1234, got %q", got)
+	}
+
+	// Erasing the last key in the remembered directory prunes it, and
+	// another handle may remove it behind our back; writes recover.
+	for i := 1200; i < 1300; i++ {
+		d.Erase(strconv.Itoa(i))
+	}
+	if err := d.WriteString("1299", "x"); err != nil {
+		t.Fatal(err)
+	}
+	other := New(Options{
+		BasePath:       "test-data",
+		NamedTransform: SequentialTransform(2),
+	})
+	other.Erase("1299")
+	if err := d.WriteString("1298", "x"); err != nil {
+		t.Fatalf("write after directory removed elsewhere: %s", err)
+	}
+	if got := d.ReadString("1298"); got != "x" {
+		t.Fatalf("expected x, got %q", got)
+	}
+}
@@ -0,0 +1,324 @@
+package diskv
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// expiryFilename is the name of the file, directly in the BasePath, where
+// the expiry times of keys written with a TTL are persisted. It's never
+// yielded as a key.
+const expiryFilename = ".diskv-expiry"
+
+// expiryLogFilename is the name of the file, directly in the BasePath, to
+// which changes to the expiry times are appended, until it's compacted into
+// the expiry file. It's never yielded as a key.
+const expiryLogFilename = ".diskv-expiry-log"
+
+// expiryRecord is one line of the expiry log: the key's new expiry time, in
+// nanoseconds since the epoch, or 0 if it was cleared.
+type expiryRecord struct {
+	Key string `json:"k"`
+	T   int64  `json:"t,omitempty"`
+}
+
+// loadExpiry reads persisted expiry times from disk: the expiry file, and
+// then the changes in the expiry log. A missing or corrupt expiry file is
+// treated as no expiry times, and a corrupt line of the log, e.g. one torn
+// by a crash, is ignored.
+func (d *Diskv) loadExpiry() {
+	d.expiryMu.Lock()
+	defer d.expiryMu.Unlock()
+
+	d.expiry = map[string]time.Time{}
+	d.expiryLogged = 0
+	if buf, err := readFile(d.fs, filepath.Join(d.BasePath, expiryFilename)); err == nil {
+		var persisted map[string]int64
+		if err := json.Unmarshal(buf, &persisted); err == nil {
+			for key, ns := range persisted {
+				d.expiry[key] = time.Unix(0, ns)
+			}
+		}
+	}
+	if buf, err := readFile(d.fs, filepath.Join(d.BasePath, expiryLogFilename)); err == nil {
+		s := bufio.NewScanner(bytes.NewReader(buf))
+		s.Buffer(nil, len(buf)+1)
+		for s.Scan() {
+			var r expiryRecord
+			if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+				continue
+			}
+			if r.T == 0 {
+				delete(d.expiry, r.Key)
+			} else {
+				d.expiry[r.Key] = time.Unix(0, r.T)
+			}
+			d.expiryLogged++
+		}
+	}
+	d.rebuildExpiryHeapWithLock()
+}
+
+// saveExpiryWithLock atomically persists the expiry times to the expiry
+// file, and then removes the expiry log, whose changes it includes. Callers
+// must hold expiryMu.
+func (d *Diskv) saveExpiryWithLock() error {
+	filename := filepath.Join(d.BasePath, expiryFilename)
+	if len(d.expiry) <= 0 {
+		if err := d.fs.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		persisted := make(map[string]int64, len(d.expiry))
+		for key, t := range d.expiry {
+			persisted[key] = t.UnixNano()
+		}
+		buf, err := json.Marshal(persisted)
+		if err != nil {
+			return err
+		}
+		if err := d.writeInternalFile(filename, buf); err != nil {
+			return err
+		}
+	}
+
+	// Replaying the log over the new expiry file, after a crash right
+	// here, yields the same expiry times, so there's no need for both to
+	// change at once.
+	if err := d.fs.Remove(filepath.Join(d.BasePath, expiryLogFilename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	d.expiryLogged = 0
+	return nil
+}
+
+// logExpiryWithLock appends the key's new expiry time, which is zero if it
+// was cleared, to the expiry log. Once the log holds many more changes than
+// there are expiry times, it's compacted into the expiry file, so that the
+// cost of persisting a change doesn't grow with the number of keys. Callers
+// must hold expiryMu.
+func (d *Diskv) logExpiryWithLock(key string, t time.Time) error {
+	r := expiryRecord{Key: key}
+	if !t.IsZero() {
+		r.T = t.UnixNano()
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := d.fs.MkdirAll(d.BasePath, d.PathPerm); err != nil {
+		return err
+	}
+	f, err := d.fs.OpenFile(filepath.Join(d.BasePath, expiryLogFilename), os.O_WRONLY|os.O_CREATE|os.O_APPEND, d.FilePerm)
+	if err != nil {
+		return fmt.Errorf("expiry log: %s", err)
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("expiry log: %s", err)
+	}
+
+	if d.expiryLogged++; d.expiryLogged > 2*len(d.expiry)+64 {
+		return d.saveExpiryWithLock()
+	}
+	return nil
+}
+
+// setExpiry records (or, with a zero TTL, clears) the expiry time of the
+// given key, and persists it if it changed.
+func (d *Diskv) setExpiry(key string, ttl time.Duration) error {
+	d.expiryMu.Lock()
+	defer d.expiryMu.Unlock()
+
+	var t time.Time
+	if ttl <= 0 {
+		if _, ok := d.expiry[key]; !ok {
+			return nil
+		}
+		delete(d.expiry, key) // its heap entry is now stale
+	} else {
+		t = d.Clock.Now().Add(ttl)
+		d.expiry[key] = t
+		heap.Push(&d.expiryHeap, expiryEntry{key: key, t: t})
+	}
+	if len(d.expiryHeap) > 2*len(d.expiry)+64 {
+		d.rebuildExpiryHeapWithLock()
+	}
+	return d.logExpiryWithLock(key, t)
+}
+
+// resetExpiry forgets all expiry times.
+func (d *Diskv) resetExpiry() {
+	d.expiryMu.Lock()
+	defer d.expiryMu.Unlock()
+	d.expiry = map[string]time.Time{}
+	d.expiryHeap = nil
+	d.expiryLogged = 0
+}
+
+// expired returns true if the given key was written with a TTL that has
+// since elapsed.
+func (d *Diskv) expired(key string) bool {
+	d.expiryMu.RLock()
+	defer d.expiryMu.RUnlock()
+	t, ok := d.expiry[key]
+	return ok && !d.Clock.Now().Before(t)
+}
+
+// remainingTTL returns the time left before the key expires, or 0 if it has
+// no TTL, and false if it has expired.
+func (d *Diskv) remainingTTL(key string) (time.Duration, bool) {
+	d.expiryMu.RLock()
+	expiry, ok := d.expiry[key]
+	d.expiryMu.RUnlock()
+	if !ok {
+		return 0, true
+	}
+	ttl := expiry.Sub(d.Clock.Now())
+	return ttl, ttl > 0
+}
+
+// errExpired returns the error reads of an expired key return. It satisfies
+// os.IsNotExist.
+func errExpired(key string) error {
+	return &os.PathError{Op: "read", Path: key, Err: os.ErrNotExist}
+}
+
+// Expire sets the TTL of an existing key, from now, without rewriting its
+// value. A zero TTL clears its expiry. If the key doesn't exist or has
+// expired, Expire returns an error satisfying os.IsNotExist.
+func (d *Diskv) Expire(key string, ttl time.Duration) error {
+	key = d.normalizeKey(key)
+	if err := d.authorize(OpWrite, key); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.expired(key) {
+		return errExpired(key)
+	}
+	if _, ok := d.virtualStat(key); ok {
+		return d.setExpiry(key, ttl)
+	}
+	fi, err := d.fs.Stat(d.completeFilename(d.transform(key)))
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return os.ErrNotExist
+	}
+	return d.setExpiry(key, ttl)
+}
+
+// PurgeExpired erases every key written with a TTL that has since elapsed,
+// and returns the number of keys erased. Expired keys are never returned by
+// reads or Keys, but they stay on disk until they're purged. Expiry times
+// are kept in order, so PurgeExpired only visits expired keys; call it
+// whenever NextExpiry has passed. Likewise, changes to them are logged, so
+// a write with a TTL doesn't cost more the more keys have one.
+func (d *Diskv) PurgeExpired() (int, error) {
+	now := d.Clock.Now()
+	d.expiryMu.Lock()
+	var keys []string
+	for len(d.expiryHeap) > 0 && !now.Before(d.expiryHeap[0].t) {
+		e := heap.Pop(&d.expiryHeap).(expiryEntry)
+		if t, ok := d.expiry[e.key]; ok && t.Equal(e.t) {
+			keys = append(keys, e.key)
+		}
+	}
+	d.expiryMu.Unlock()
+
+	n := 0
+	for _, key := range keys {
+		erased, err := d.eraseExpired(key)
+		if err != nil {
+			d.expiryMu.Lock()
+			d.rebuildExpiryHeapWithLock() // restore the remaining keys
+			d.expiryMu.Unlock()
+			return n, err
+		}
+		if erased {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// NextExpiry returns the earliest expiry time of any key, which may have
+// passed already. If no key has an expiry time, ok is false.
+func (d *Diskv) NextExpiry() (t time.Time, ok bool) {
+	d.expiryMu.Lock()
+	defer d.expiryMu.Unlock()
+	for len(d.expiryHeap) > 0 {
+		e := d.expiryHeap[0]
+		if t, ok := d.expiry[e.key]; ok && t.Equal(e.t) {
+			return e.t, true
+		}
+		heap.Pop(&d.expiryHeap) // stale
+	}
+	return time.Time{}, false
+}
+
+// eraseExpired erases the key if it's still expired, i.e. it hasn't been
+// rewritten since it was found to be, and reports whether it was erased.
+func (d *Diskv) eraseExpired(key string) (bool, error) {
+	span := d.startSpan("Erase", key)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	phases(span).mark("lock")
+
+	if !d.expired(key) {
+		span.End(nil)
+		return false, nil
+	}
+	err := d.eraseWithLock(key, phases(span))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	span.End(err)
+	return err == nil, err
+}
+
+// rebuildExpiryHeapWithLock rebuilds the heap of expiry times from the map,
+// dropping stale entries. Callers must hold expiryMu.
+func (d *Diskv) rebuildExpiryHeapWithLock() {
+	d.expiryHeap = make(expiryHeap, 0, len(d.expiry))
+	for key, t := range d.expiry {
+		d.expiryHeap = append(d.expiryHeap, expiryEntry{key: key, t: t})
+	}
+	heap.Init(&d.expiryHeap)
+}
+
+// expiryEntry is an expiry time in the expiryHeap. It's stale if the key's
+// expiry time has since changed or been cleared.
+type expiryEntry struct {
+	key string
+	t   time.Time
+}
+
+// expiryHeap is a min-heap of expiry times, which implements
+// container/heap.Interface.
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].t.Before(h[j].t) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryEntry)) }
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
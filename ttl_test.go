@@ -0,0 +1,233 @@
+package diskv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteWithTTL(t *testing.T) {
+	d := New(Options{
+		BasePath:     "test-data",
+		CacheSizeMax: 1024,
+	})
+	defer d.EraseAll()
+
+	if err := d.WriteWith("a", bytes.NewReader([]byte("1")), WriteOptions{TTL: 50 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	d.WriteString("b", "2")
+	if _, err := d.Read("a"); err != nil {
+		t.Fatalf("read before expiry: %s", err)
+	}
+
+	// Expiry times survive a restart.
+	d = New(Options{
+		BasePath:     "test-data",
+		CacheSizeMax: 1024,
+	})
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := d.Read("a"); !os.IsNotExist(err) {
+		t.Fatalf("read after expiry: expected not-exist error, got %v", err)
+	}
+	if d.Has("a") {
+		t.Fatalf("Has: expired key reported present")
+	}
+	checkKeys(t, d.Keys(nil), map[string]string{"b": "2"})
+
+	n, err := d.PurgeExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 key purged, got %d", n)
+	}
+	if _, err := os.Stat(d.completeFilename(d.transform("a"))); !os.IsNotExist(err) {
+		t.Fatalf("expired key still on disk")
+	}
+}
+
+func TestWriteWithFilePerm(t *testing.T) {
+	d := New(Options{
+		BasePath: "test-data",
+	})
+	defer d.EraseAll()
+
+	d.WriteString("a", "1")
+	if err := d.WriteWith("a", bytes.NewReader([]byte("2")), WriteOptions{FilePerm: 0600, Sync: true}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(d.completeFilename(d.transform("a")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode 0600, got %s", fi.Mode().Perm())
+	}
+}
+
+func TestExpiryOrder(t *testing.T) {
+	d := New(Options{
+		BasePath: "test-data",
+	})
+	defer d.EraseAll()
+
+	if _, ok := d.NextExpiry(); ok {
+		t.Fatal("NextExpiry with no TTLs")
+	}
+
+	write := func(key string, ttl time.Duration) {
+		if err := d.WriteWith(key, bytes.NewReader([]byte(key)), WriteOptions{TTL: ttl}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a", time.Hour)
+	write("b", 20*time.Millisecond)
+	write("c", 10*time.Millisecond)
+	write("c", time.Hour) // rewritten, so its earlier expiry time is stale
+	write("d", 10*time.Millisecond)
+	write("d", 0)
+
+	next, ok := d.NextExpiry()
+	if !ok || time.Until(next) > 20*time.Millisecond {
+		t.Fatalf("expected b's expiry time next, got %s", next)
+	}
+
+	// The expiry order survives a restart.
+	d = New(Options{
+		BasePath: "test-data",
+	})
+	time.Sleep(30 * time.Millisecond)
+	n, err := d.PurgeExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 key purged, got %d", n)
+	}
+	checkKeys(t, d.Keys(nil), map[string]string{"a": "a", "c": "c", "d": "d"})
+	if next, _ := d.NextExpiry(); time.Until(next) < 50*time.Minute {
+		t.Fatalf("expected a's or c's expiry time next, got %s", next)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	d := New(Options{BasePath: "test-data"})
+	defer d.EraseAll()
+
+	if err := d.Expire("a", time.Hour); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error, got %v", err)
+	}
+	d.WriteString("a", "1")
+	if err := d.Expire("a", 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Has("a") {
+		t.Fatal("key expired early")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if d.Has("a") {
+		t.Fatal("key didn't expire")
+	}
+	if err := d.Expire("a", 0); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error for expired key, got %v", err)
+	}
+}
+
+func TestExpiryLog(t *testing.T) {
+	fs := NewMemFileSystem()
+	opts := Options{BasePath: "/ttl", FileSystem: fs}
+	d := New(opts)
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprint(i % 50)
+		if err := d.WriteWith(key, bytes.NewReader([]byte("v")), WriteOptions{TTL: time.Duration(i+1) * time.Hour}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.WriteString("0", "no ttl"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log is compacted long before it holds every change.
+	if d.expiryLogged > 2*50+64 {
+		t.Errorf("%d changes in the log, for 49 expiry times", d.expiryLogged)
+	}
+	want := map[string]int64{}
+	for key, t := range d.expiry {
+		want[key] = t.UnixNano()
+	}
+
+	// Expiry times, including cleared ones, survive a restart, whether or
+	// not the log was compacted since.
+	d = New(opts)
+	have := map[string]int64{}
+	for key, t := range d.expiry {
+		have[key] = t.UnixNano()
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("after restart: want %v, have %v", want, have)
+	}
+	if _, ok := d.expiry["0"]; ok {
+		t.Error("cleared expiry time restored")
+	}
+}
+
+// expiryWritesFS counts the bytes written to the expiry file and log.
+type expiryWritesFS struct {
+	FileSystem
+	n int64
+}
+
+type expiryWritesFile struct {
+	File
+	fs *expiryWritesFS
+}
+
+func (fs *expiryWritesFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := fs.FileSystem.OpenFile(name, flag, perm)
+	if err != nil || !strings.HasPrefix(filepath.Base(name), expiryFilename) {
+		return f, err
+	}
+	return &expiryWritesFile{File: f, fs: fs}, nil
+}
+
+func (f *expiryWritesFile) Write(p []byte) (int, error) {
+	f.fs.n += int64(len(p))
+	return f.File.Write(p)
+}
+
+func TestExpiryWriteCost(t *testing.T) {
+	fs := &expiryWritesFS{FileSystem: NewMemFileSystem()}
+	d := New(Options{BasePath: "/ttl", FileSystem: fs})
+	const n = 4000
+	for i := 0; i < n; i++ {
+		if err := d.WriteWith(fmt.Sprint(i), bytes.NewReader([]byte("v")), WriteOptions{TTL: time.Hour}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Rewriting every expiry time on every write would be ~50MB.
+	if perWrite := fs.n / n; perWrite > 200 {
+		t.Errorf("%d bytes of expiry times written per TTL write", perWrite)
+	}
+}
+
+func BenchmarkWriteWithTTL(b *testing.B) {
+	for _, keys := range []int{100, 10000} {
+		b.Run(fmt.Sprint(keys, "keys"), func(b *testing.B) {
+			d := NewMem(Options{BasePath: "/ttl"})
+			for i := 0; i < keys; i++ {
+				d.WriteWith(fmt.Sprint(i), bytes.NewReader([]byte("v")), WriteOptions{TTL: time.Hour})
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				d.WriteWith(fmt.Sprint(i%keys), bytes.NewReader([]byte("v")), WriteOptions{TTL: time.Hour})
+			}
+		})
+	}
+}
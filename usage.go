@@ -0,0 +1,69 @@
+package diskv
+
+import (
+	"os"
+)
+
+// StatPrefix returns the number of keys with the given prefix, and the total
+// size of their files on disk, which is after compression, if any. It walks
+// only the part of the store that can contain such keys, and honors
+// WalkConcurrency. The empty prefix gives the totals for the whole store.
+func (d *Diskv) StatPrefix(prefix string) (count int, bytes int64, err error) {
+	prefix = d.normalizeKey(prefix)
+	if err := d.authorize(OpStat, prefix); err != nil {
+		return 0, 0, err
+	}
+	return d.statPrefix(prefix)
+}
+
+// statPrefix is StatPrefix, without authorization, for a normalized prefix.
+func (d *Diskv) statPrefix(prefix string) (count int, bytes int64, err error) {
+	err = d.walkKeys(d.prefixPath(prefix), prefix, func(key string, info os.FileInfo) error {
+		count++
+		bytes += info.Size()
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, 0, nil // no keys with this prefix were ever written
+	}
+	return count, bytes, err
+}
+
+// Stat returns the FileInfo of the key's file on disk. Its size is after
+// compression, if any. If the key doesn't exist or has expired, Stat returns
+// an error satisfying os.IsNotExist.
+func (d *Diskv) Stat(key string) (os.FileInfo, error) {
+	key = d.normalizeKey(key)
+	if err := d.authorize(OpStat, key); err != nil {
+		return nil, err
+	}
+	if d.expired(key) {
+		return nil, errExpired(key)
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.statWithRLock(d.transform(key))
+}
+
+// statWithRLock is Stat, without authorization or expiry. Callers must hold
+// d.mu, for reading at least.
+func (d *Diskv) statWithRLock(pathKey *PathKey) (os.FileInfo, error) {
+	if err := d.checkPath(pathKey); err != nil {
+		return nil, err
+	}
+	if err := d.checkSymlinks(pathKey); err != nil {
+		return nil, err
+	}
+	if fi, ok := d.virtualStat(pathKey.originalKey); ok {
+		return fi, nil
+	}
+	fi, err := d.fs.Stat(d.completeFilename(pathKey))
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, os.ErrNotExist
+	}
+	return fi, nil
+}
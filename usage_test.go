@@ -0,0 +1,60 @@
+package diskv
+
+import (
+	"os"
+	"testing"
+)
+
+func TestStatPrefix(t *testing.T) {
+	for _, concurrency := range []int{0, 4} {
+		d := New(Options{
+			BasePath:        "test-data",
+			Transform:       blockTransform(2),
+			WalkConcurrency: concurrency,
+		})
+		defer d.EraseAll()
+
+		for k, v := range keysTestData {
+			d.WriteString(k, v)
+		}
+
+		for _, prefix := range prefixes {
+			var wantCount int
+			var wantBytes int64
+			for _, v := range filterPrefix(keysTestData, prefix) {
+				wantCount++
+				wantBytes += int64(len(v))
+			}
+			count, bytes, err := d.StatPrefix(prefix)
+			if err != nil {
+				t.Fatalf("%q: %s", prefix, err)
+			}
+			if count != wantCount || bytes != wantBytes {
+				t.Errorf("%q: want %d keys, %d bytes, have %d keys, %d bytes", prefix, wantCount, wantBytes, count, bytes)
+			}
+		}
+		d.EraseAll()
+	}
+}
+
+func TestStat(t *testing.T) {
+	d := New(Options{
+		BasePath:  "test-data",
+		Transform: blockTransform(2),
+	})
+	defer d.EraseAll()
+
+	d.WriteString("ab01cd01", "hello")
+	fi, err := d.Stat("ab01cd01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 5 {
+		t.Fatalf("want size 5, have %d", fi.Size())
+	}
+	for _, key := range []string{"ab01cd02", "ab01"} { // missing, directory
+		if _, err := d.Stat(key); !os.IsNotExist(err) {
+			t.Fatalf("%q: expected not-exist error, got %v", key, err)
+		}
+	}
+}
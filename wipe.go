@@ -0,0 +1,179 @@
+package diskv
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// wipeBufferSize is how much of a file is overwritten per write.
+const wipeBufferSize = 64 * 1024
+
+var errWipeUnsupported = errors.New("file doesn't implement io.WriterAt")
+
+// wipeValueWithLock overwrites the key's stored value, wherever it is, per
+// SecureErase. It's not an error if the key doesn't exist. Callers must
+// hold d.mu.
+func (d *Diskv) wipeValueWithLock(key string, pathKey *PathKey) error {
+	if d.SecureErase <= 0 {
+		return nil
+	}
+	if err := d.wipePackedWithLock(key); err != nil {
+		return fmt.Errorf("secure erase: %s", err)
+	}
+	if fi, ok := d.chunkStat(key); ok {
+		for i := 0; i < fi.(*chunkFileInfo).m.Chunks; i++ {
+			if err := d.wipeFile(filepath.Join(d.chunkDir(key), chunkName(i))); err != nil {
+				return fmt.Errorf("secure erase: %s", err)
+			}
+		}
+		return nil
+	}
+	err := d.wipeFile(d.completeFilename(pathKey))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("secure erase: %s", err)
+	}
+	return nil
+}
+
+// wipePackedWithLock overwrites the key's packed value, if it has one, in
+// its segment. Callers must hold d.mu.
+func (d *Diskv) wipePackedWithLock(key string) error {
+	if d.PackThreshold <= 0 {
+		return nil
+	}
+	d.packMu.Lock()
+	defer d.packMu.Unlock()
+	loc, ok := d.packIndex[key]
+	if !ok {
+		return nil
+	}
+	return d.wipeRange(d.packFilename(loc.segment), loc.offset, loc.size)
+}
+
+// wipeTree overwrites every regular file below dir, except for the entries
+// of dir itself which keep returns true for. It's not an error if dir
+// doesn't exist.
+func (d *Diskv) wipeTree(dir string, keep func(name string) bool) error {
+	if d.SecureErase <= 0 {
+		return nil
+	}
+	names, err := readDirNames(d.fs, dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("secure erase: %s", err)
+	}
+	for _, name := range names {
+		if keep != nil && keep(name) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		fi, err := d.fs.Lstat(path)
+		if err != nil {
+			return fmt.Errorf("secure erase: %s", err)
+		}
+		switch {
+		case fi.IsDir():
+			if err := d.wipeTree(path, nil); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			if err := d.wipeRange(path, 0, fi.Size()); err != nil {
+				return fmt.Errorf("secure erase: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// wipeFile overwrites the whole of the named file.
+func (d *Diskv) wipeFile(filename string) error {
+	fi, err := d.fs.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	return d.wipeRange(filename, 0, fi.Size())
+}
+
+// wipeRange overwrites size bytes of the named file, from offset, once per
+// pass of SecureErase, syncing after each. Overwriting from a non-zero
+// offset requires the file to implement io.WriterAt.
+func (d *Diskv) wipeRange(filename string, offset, size int64) error {
+	buf := make([]byte, wipeBufferSize)
+	if size < int64(len(buf)) {
+		buf = buf[:size]
+	}
+	for pass := 0; pass < d.SecureErase; pass++ {
+		if err := wipePattern(buf, pass); err != nil {
+			return err
+		}
+		f, err := d.fs.OpenFile(filename, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		err = wipeWrite(f, buf, offset, size)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+	return nil
+}
+
+// wipeWrite writes buf repeatedly over size bytes of f, from offset.
+func wipeWrite(f File, buf []byte, offset, size int64) error {
+	wa, ok := f.(io.WriterAt)
+	if !ok && offset != 0 {
+		return errWipeUnsupported
+	}
+	for done := int64(0); done < size; {
+		p := buf
+		if rest := size - done; rest < int64(len(p)) {
+			p = p[:rest]
+		}
+		var n int
+		var err error
+		if ok {
+			n, err = wa.WriteAt(p, offset+done)
+		} else {
+			n, err = f.Write(p)
+		}
+		if err != nil {
+			return err
+		}
+		done += int64(n)
+	}
+	return nil
+}
+
+// wipePattern fills buf with the given pass's pattern: zeros, ones or
+// random bytes, in turn.
+func wipePattern(buf []byte, pass int) error {
+	switch pass % 3 {
+	case 0:
+		for i := range buf {
+			buf[i] = 0x00
+		}
+	case 1:
+		for i := range buf {
+			buf[i] = 0xff
+		}
+	default:
+		if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
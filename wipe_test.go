@@ -0,0 +1,93 @@
+package diskv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// recordingFS records the last contents of every file removed.
+type recordingFS struct {
+	FileSystem
+	removed map[string][]byte
+}
+
+func (fs *recordingFS) record(name string) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(f)
+	fs.removed[filepath.Base(name)] = buf.Bytes()
+}
+
+func (fs *recordingFS) Remove(name string) error {
+	fs.record(name)
+	return fs.FileSystem.Remove(name)
+}
+
+func TestSecureErase(t *testing.T) {
+	for name, fs := range map[string]FileSystem{"os": OSFileSystem(), "mem": NewMemFileSystem()} {
+		t.Run(name, func(t *testing.T) {
+			rfs := &recordingFS{FileSystem: fs, removed: map[string][]byte{}}
+			d, err := NewWithOptions("test-data", WithFileSystem(rfs), WithSecureErase(2), WithPack(16, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.EraseAll()
+
+			secret := bytes.Repeat([]byte("secret"), 100)
+			if err := d.Write("file", secret); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.WriteString("packed", "secret"); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.WriteString("kept", "kept"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := d.Erase("file"); err != nil {
+				t.Fatal(err)
+			}
+			if have, ok := rfs.removed["file"]; !ok || len(have) != len(secret) || bytes.Contains(have, []byte("secret")) {
+				t.Fatalf("file not wiped before removal: %q", have)
+			}
+			if err := d.Erase("packed"); err != nil {
+				t.Fatal(err)
+			}
+			segment, err := readAll(fs, d.packFilename(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(segment, []byte("secret")) {
+				t.Fatalf("packed value not wiped: %q", segment)
+			}
+			if have := d.ReadString("kept"); have != "kept" {
+				t.Fatalf("wipe damaged another value: %q", have)
+			}
+
+			if err := d.Erase("missing"); !os.IsNotExist(err) {
+				t.Fatalf("want a not-exist error, have %v", err)
+			}
+		})
+	}
+
+	if _, err := NewWithOptions("test-data", WithSecureErase(1), WithJournal(0, 0), WithSnapshotDir("test-snapshots")); err == nil {
+		t.Fatal("expected SecureErase with SnapshotDir to be rejected")
+	}
+}
+
+func readAll(fs FileSystem, name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(f)
+	return buf.Bytes(), err
+}
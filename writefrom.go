@@ -0,0 +1,65 @@
+package diskv
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// writeFromBufferSize is the size of the reads WriteFrom makes from its
+// io.ReaderAt: large, so that each is a single pread of many pages.
+const writeFromBufferSize = 1 << 20
+
+// writeFromBuffers are the buffers of WriteFrom, reused across writes.
+var writeFromBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, writeFromBufferSize)
+		return &buf
+	},
+}
+
+// WriteFrom writes the first size bytes of ra under the key, like
+// WriteStream without sync. It's meant for ingesting values from mmap'd
+// files or block devices: the value is copied with positional reads of
+// large, reused buffers, rather than through the intermediate buffers of
+// io.Copy. If ra has fewer than size bytes, the write fails with
+// io.ErrUnexpectedEOF, and nothing is written.
+func (d *Diskv) WriteFrom(key string, ra io.ReaderAt, size int64) error {
+	if size < 0 {
+		return errors.New("negative size")
+	}
+	if d.MaxValueSize > 0 && size > d.MaxValueSize {
+		return ErrValueTooLarge
+	}
+	bufp := writeFromBuffers.Get().(*[]byte)
+	defer writeFromBuffers.Put(bufp)
+	_, err := d.WriteWithN(key, &readerAtReader{ra: ra, size: size}, WriteOptions{copyBuffer: *bufp})
+	return err
+}
+
+// readerAtReader reads the first size bytes of ra, with a ReadAt per Read.
+// Unlike io.SectionReader, it fails with io.ErrUnexpectedEOF if ra ends
+// early.
+type readerAtReader struct {
+	ra   io.ReaderAt
+	off  int64
+	size int64
+}
+
+func (r *readerAtReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if rem := r.size - r.off; int64(len(p)) > rem {
+		p = p[:rem]
+	}
+	n, err := r.ra.ReadAt(p, r.off)
+	r.off += int64(n)
+	if err == io.EOF {
+		if r.off < r.size {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
@@ -0,0 +1,49 @@
+package diskv
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// recordingReaderAt records the length of each ReadAt.
+type recordingReaderAt struct {
+	io.ReaderAt
+	reads []int
+}
+
+func (r *recordingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.reads = append(r.reads, len(p))
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+func TestWriteFrom(t *testing.T) {
+	d := NewMem(Options{BasePath: "/from"})
+	val := bytes.Repeat([]byte("0123456789"), 300000)
+	ra := &recordingReaderAt{ReaderAt: bytes.NewReader(val)}
+
+	if err := d.WriteFrom("k", ra, int64(len(val))-10); err != nil {
+		t.Fatal(err)
+	}
+	if have, err := d.Read("k"); err != nil || !bytes.Equal(have, val[:len(val)-10]) {
+		t.Fatalf("Read: have %d bytes (%v), want %d", len(have), err, len(val)-10)
+	}
+	if len(ra.reads) != 3 || ra.reads[0] != writeFromBufferSize {
+		t.Errorf("want 3 reads of up to %d bytes, have %v", writeFromBufferSize, ra.reads)
+	}
+
+	// A ReaderAt which ends early fails the write.
+	if err := d.WriteFrom("short", bytes.NewReader(val), int64(len(val))+1); err == nil {
+		t.Error("want error writing past the end")
+	}
+	if d.Has("short") {
+		t.Error("short value written")
+	}
+
+	if err := d.WriteFrom("empty", bytes.NewReader(nil), 0); err != nil {
+		t.Fatal(err)
+	}
+	if have, err := d.Read("empty"); err != nil || len(have) != 0 {
+		t.Errorf("Read: have %q (%v), want empty", have, err)
+	}
+}
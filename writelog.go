@@ -0,0 +1,375 @@
+package diskv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// writeLogFilename is the name of the write-ahead log of small values,
+// directly in the BasePath; see WriteLogThreshold. It's never yielded as a
+// key.
+const writeLogFilename = ".diskv-writelog"
+
+// writeLogRecord is one line of the write log: a value written to it, or a
+// note that the key's earlier records were superseded by a write or erase
+// which didn't go through it, so that they mustn't be replayed.
+type writeLogRecord struct {
+	Key        string `json:"key"`
+	Value      []byte `json:"value,omitempty"`
+	Superseded bool   `json:"superseded,omitempty"`
+}
+
+// writeLog is the state of the write log. Writes which arrive while a batch
+// is being appended form the next batch, which is appended, and synced,
+// once the current one is done, by the first of its writers.
+type writeLog struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	forming   *writeLogBatch
+	appending bool
+	keys      map[string]bool // with records in the log
+
+	// reserved is what's charged against Quotas for the key's values in
+	// the log which aren't in its file yet, until a write of the key
+	// absorbs it, or the values are dropped. It's guarded by d.mu.
+	reserved map[string]Usage
+
+	fileMu sync.Mutex // serializes appends to the file
+}
+
+// writeLogBatch is the records of the writes waiting for one append.
+type writeLogBatch struct {
+	keys     []string
+	vals     [][]byte
+	buf      bytes.Buffer
+	appended bool
+	err      error
+}
+
+func newWriteLog() *writeLog {
+	l := &writeLog{keys: map[string]bool{}, reserved: map[string]Usage{}}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// writeLogged checks the write, as far as writing the value to its file
+// would, and charges it against any Quotas. It then appends a copy of the
+// value to the write log, along with the values of any other writes waiting
+// at the same time. Once the log is synced, it buffers the value until the
+// next flush, which it schedules if necessary, like writeAsync.
+func (d *Diskv) writeLogged(key string, val []byte) (err error) {
+	key = d.normalizeKey(key)
+	span := d.startSpan("Write", key)
+	defer func() {
+		span.SetAttribute("bytes", int64(len(val)))
+		span.SetAttribute("logged", true)
+		span.End(err)
+	}()
+
+	pathKey, err := d.checkWriteKey(key)
+	if err != nil {
+		return err
+	}
+	if d.MaxValueSize > 0 && int64(len(val)) > d.MaxValueSize {
+		return ErrValueTooLarge
+	}
+	val = append([]byte(nil), val...)
+	line, err := json.Marshal(writeLogRecord{Key: key, Value: val})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	charged, err := d.reserveLoggedWithLock(pathKey, val)
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = d.appendLogged(key, val, line); err != nil {
+		d.mu.Lock()
+		d.unreserveWithLock(key, charged)
+		d.mu.Unlock()
+	}
+	return err
+}
+
+// appendLogged appends the record of the value to the write log, in a batch
+// with the records of any other writes waiting at the same time, and once
+// the log is synced, buffers the value.
+func (d *Diskv) appendLogged(key string, val, line []byte) (err error) {
+	l := d.writeLog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.forming == nil {
+		l.forming = &writeLogBatch{}
+	}
+	b := l.forming
+	b.keys = append(b.keys, key)
+	b.vals = append(b.vals, val)
+	b.buf.Write(line)
+	b.buf.WriteByte('\n')
+	for l.appending && !b.appended {
+		l.cond.Wait()
+	}
+	if b.appended {
+		return b.err
+	}
+
+	l.appending = true
+	l.forming = nil
+	l.mu.Unlock()
+	err = d.appendWriteLog(b.buf.Bytes(), true)
+	l.mu.Lock()
+	if err == nil {
+		// Buffered in order, and before the next batch is appended, so
+		// that the last write of a key wins, in the log and out of it.
+		for i, key := range b.keys {
+			l.keys[key] = true
+			d.addPending(key, &pendingWrite{val: b.vals[i], logged: true})
+		}
+	}
+	b.appended, b.err = true, err
+	l.appending = false
+	l.cond.Broadcast()
+	return err
+}
+
+// reserveLoggedWithLock fails the write of val to the key if writing it to
+// its file would fail for a reason which doesn't depend on the write
+// itself: because the key's path is taken, or because it would exceed a
+// Quota. Otherwise, it charges the value against the key's quotas, so that
+// other writes count it as if it were in its file already, and returns what
+// it charged. Callers must hold d.mu.
+func (d *Diskv) reserveLoggedWithLock(pathKey *PathKey, val []byte) (Usage, error) {
+	if err := d.keyPathError(pathKey); err != nil {
+		return Usage{}, err
+	}
+	if len(d.quotas) <= 0 {
+		return Usage{}, nil
+	}
+
+	key := pathKey.originalKey
+	stored, err := d.compress(val)
+	if err != nil {
+		return Usage{}, err
+	}
+	oldSize, exists := d.quotaBaseWithLock(pathKey)
+	qw, err := d.quotaWriterWithLock(key, oldSize, exists)
+	if err != nil {
+		return Usage{}, err
+	}
+	if qw.n >= 0 && int64(len(stored)) > qw.n {
+		return Usage{}, qw.err
+	}
+
+	charged := Usage{Bytes: int64(len(stored)) - oldSize}
+	if !exists {
+		charged.Keys = 1
+	}
+	d.chargeQuotaWithLock(key, charged.Bytes, charged.Keys)
+	r := d.writeLog.reserved[key]
+	d.writeLog.reserved[key] = Usage{Keys: r.Keys + charged.Keys, Bytes: r.Bytes + charged.Bytes}
+	return charged, nil
+}
+
+// unreserveWithLock refunds what was charged against the key's quotas for
+// a value which was dropped from the write log, or never made it into it.
+// Callers must hold d.mu.
+func (d *Diskv) unreserveWithLock(key string, charged Usage) {
+	if charged == (Usage{}) {
+		return
+	}
+	d.chargeQuotaWithLock(key, -charged.Bytes, -charged.Keys)
+	if r, ok := d.writeLog.reserved[key]; ok {
+		r.Bytes -= charged.Bytes
+		r.Keys -= charged.Keys
+		if r == (Usage{}) {
+			delete(d.writeLog.reserved, key)
+		} else {
+			d.writeLog.reserved[key] = r
+		}
+	}
+}
+
+// quotaBaseWithLock returns the size of the key's data on disk, and whether
+// it exists, for charging a write of the key against its quotas, counting
+// its values in the write log as if they were in its file. Callers must
+// hold d.mu.
+func (d *Diskv) quotaBaseWithLock(pathKey *PathKey) (int64, bool) {
+	size, exists := d.storedSize(pathKey)
+	if l := d.writeLog; l != nil {
+		if r, ok := l.reserved[pathKey.originalKey]; ok {
+			size += r.Bytes
+			exists = exists || r.Keys > 0
+		}
+	}
+	return size, exists
+}
+
+// absorbReservedWithLock forgets what was charged for the key's values in
+// the write log, once a write of the key has charged for its own value in
+// their stead. Callers must hold d.mu.
+func (d *Diskv) absorbReservedWithLock(key string) {
+	if l := d.writeLog; l != nil {
+		delete(l.reserved, key)
+	}
+}
+
+// dropReservedWithLock refunds what was charged for the key's values in the
+// write log, once they've been dropped. Callers must hold d.mu.
+func (d *Diskv) dropReservedWithLock(key string) {
+	if l := d.writeLog; l != nil {
+		d.unreserveWithLock(key, l.reserved[key])
+	}
+}
+
+// appendWriteLog appends the records to the write log, and syncs it if sync
+// is set.
+func (d *Diskv) appendWriteLog(buf []byte, sync bool) error {
+	l := d.writeLog
+	l.fileMu.Lock()
+	defer l.fileMu.Unlock()
+
+	if err := d.fs.MkdirAll(d.BasePath, d.PathPerm); err != nil {
+		return fmt.Errorf("write log: %s", err)
+	}
+	f, err := d.fs.OpenFile(d.writeLogPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, d.FilePerm)
+	if err != nil {
+		return fmt.Errorf("write log: %s", err)
+	}
+	if _, err = f.Write(buf); err == nil && sync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write log: %s", err)
+	}
+	return nil
+}
+
+// supersedeLoggedWithLock records in the write log that the key's records
+// are superseded, if it has any, after it was written or erased other than
+// through the log. The record isn't synced, as the write or erase which
+// superseded them isn't necessarily synced either. It's best effort: if it
+// fails, replaying the log after a crash may restore the logged value.
+// Callers must hold d.mu.
+func (d *Diskv) supersedeLoggedWithLock(key string) {
+	l := d.writeLog
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.keys[key] {
+		return
+	}
+	delete(l.keys, key)
+	line, err := json.Marshal(writeLogRecord{Key: key, Superseded: true})
+	if err != nil {
+		return
+	}
+	d.appendWriteLog(append(line, '\n'), false) // error deliberately ignored
+}
+
+// truncateWriteLog removes the write log, if there is one, and every value
+// in it is in its file, and no batch is being appended.
+func (d *Diskv) truncateWriteLog() error {
+	l := d.writeLog
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.appending || l.forming != nil {
+		return nil
+	}
+
+	d.pendingMu.Lock()
+	for _, p := range d.pending {
+		if p.logged {
+			d.pendingMu.Unlock()
+			return nil // flushed next time
+		}
+	}
+	d.pendingMu.Unlock()
+
+	l.fileMu.Lock()
+	defer l.fileMu.Unlock()
+	if err := d.fs.Remove(d.writeLogPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("write log: %s", err)
+	}
+	l.keys = map[string]bool{}
+	return nil
+}
+
+// forgetWriteLog forgets the records in the write log, after it was removed
+// along with everything else, and what was charged for them. Callers must
+// hold d.mu.
+func (d *Diskv) forgetWriteLog() {
+	l := d.writeLog
+	if l == nil {
+		return
+	}
+	l.reserved = map[string]Usage{}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.keys = map[string]bool{}
+}
+
+// replayWriteLogWithLock writes the values in a write log left behind by a
+// crash to their files, syncing each, and then removes the log. Records
+// which were superseded, and an incomplete last line, are ignored. If it
+// fails, the log is kept, to be replayed again. Callers must hold d.mu.
+func (d *Diskv) replayWriteLogWithLock() error {
+	buf, err := readFile(d.fs, d.writeLogPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("write log: %s", err)
+	}
+
+	var (
+		order []string
+		vals  = map[string][]byte{}
+	)
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	s.Buffer(nil, len(buf)+1)
+	for s.Scan() {
+		var r writeLogRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			continue
+		}
+		if _, ok := vals[r.Key]; !ok {
+			order = append(order, r.Key)
+		}
+		if r.Superseded {
+			vals[r.Key] = nil
+		} else {
+			vals[r.Key] = append([]byte{}, r.Value...)
+		}
+	}
+	for _, key := range order {
+		val := vals[key]
+		if val == nil {
+			continue
+		}
+		if err := d.writeStreamWithLock(d.transform(key), bytes.NewReader(val), WriteOptions{Sync: true}); err != nil {
+			return fmt.Errorf("write log: %s: %s", key, err)
+		}
+	}
+	if err := d.fs.Remove(d.writeLogPath()); err != nil {
+		return fmt.Errorf("write log: %s", err)
+	}
+	return nil
+}
+
+func (d *Diskv) writeLogPath() string {
+	return filepath.Join(d.BasePath, writeLogFilename)
+}
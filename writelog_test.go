@@ -0,0 +1,150 @@
+package diskv
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteLogBatches(t *testing.T) {
+	fs := &slowSyncFS{FileSystem: NewMemFileSystem(), delay: int64(5 * time.Millisecond)}
+	d, err := NewWithOptions("/log", WithFileSystem(fs), WithWriteLog(16, time.Hour, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := d.Write(fmt.Sprint(i), []byte(fmt.Sprint(i))); err != nil {
+				t.Errorf("Write: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if syncs := atomic.LoadInt64(&fs.syncs); syncs >= 20 {
+		t.Errorf("want fewer syncs than writes, have %d", syncs)
+	}
+	if _, err := fs.Stat(d.completeFilename(d.transform("0"))); err == nil {
+		t.Errorf("logged value written to its file before the flush")
+	}
+	if val, err := d.Read("7"); err != nil || string(val) != "7" {
+		t.Errorf("Read: want 7, have %q (%v)", val, err)
+	}
+
+	// Larger values aren't logged.
+	if err := d.Write("large", bytes.Repeat([]byte("x"), 17)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(d.completeFilename(d.transform("large"))); err != nil {
+		t.Errorf("large value not written to its file: %s", err)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(d.writeLogPath()); err == nil {
+		t.Errorf("write log not removed by Close")
+	}
+	for i := 0; i < 20; i++ {
+		buf, err := readFile(fs, d.completeFilename(d.transform(fmt.Sprint(i))))
+		if err != nil || string(buf) != fmt.Sprint(i) {
+			t.Errorf("%d on disk: have %q (%v)", i, buf, err)
+		}
+	}
+}
+
+func TestWriteLogReplay(t *testing.T) {
+	fs := NewMemFileSystem()
+	opts := Options{
+		BasePath:          "/log",
+		FileSystem:        fs,
+		WriteLogThreshold: 16,
+		AsyncWriteDelay:   time.Hour,
+	}
+	d := New(opts)
+	for _, kv := range [][2]string{{"a", "1"}, {"b", "1"}, {"a", "2"}, {"c", "1"}} {
+		if err := d.Write(kv[0], []byte(kv[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Writes which don't go through the log supersede it.
+	if err := d.WriteStream("b", bytes.NewReader([]byte("sync")), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("c", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteStream("c", bytes.NewReader([]byte("sync")), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("d", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash, without flushing, and recover.
+	d = New(opts)
+	if _, err := fs.Stat(d.writeLogPath()); err == nil {
+		t.Errorf("write log not removed after replay")
+	}
+	checkKeys(t, d.Keys(nil), map[string]string{"a": "2", "b": "sync", "c": "sync", "d": "1"})
+}
+
+func TestWriteLogIncompatibleWithAsyncWrites(t *testing.T) {
+	if _, err := NewWithOptions("test-data", WithWriteLog(16, 0, nil), WithAsyncWrites(0, nil)); err == nil {
+		t.Fatal("expected validation error")
+	}
+}
+
+func TestWriteLogFailsLikeSyncWrites(t *testing.T) {
+	fs := NewMemFileSystem()
+	d := New(Options{
+		BasePath:          "/log",
+		FileSystem:        fs,
+		WriteLogThreshold: 16,
+		AsyncWriteDelay:   time.Hour,
+		Quotas:            map[string]Quota{"t": {MaxKeys: 1}},
+	})
+
+	if err := d.Write("ta", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("ta", []byte("2")); err != nil {
+		t.Fatalf("overwriting a logged key: %s", err)
+	}
+	if err := d.Write("tb", []byte("1")); !IsQuotaExceeded(err) {
+		t.Fatalf("want QuotaError, have %v", err)
+	}
+	if err := d.Erase("ta"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("tb", []byte("1")); err != nil {
+		t.Fatalf("after erasing the logged key: %s", err)
+	}
+
+	// A key whose path is a directory.
+	if err := fs.MkdirAll(d.completeFilename(d.transform("dir")), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("dir", []byte("1")); err != ErrKeyIsDirectory {
+		t.Fatalf("want ErrKeyIsDirectory, have %v", err)
+	}
+
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, u, _ := d.QuotaUsage("t"); u != (Usage{Keys: 1, Bytes: 1}) {
+		t.Errorf("usage after Flush: want 1 key of 1 byte, have %+v", u)
+	}
+	if val, err := d.Read("tb"); err != nil || string(val) != "1" {
+		t.Errorf("Read: want 1, have %q (%v)", val, err)
+	}
+}